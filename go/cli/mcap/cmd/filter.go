@@ -8,10 +8,13 @@ import (
 	"math"
 	"os"
 	"regexp"
+	"strconv"
+	"time"
 
 	"github.com/foxglove/mcap/go/cli/mcap/utils"
 	"github.com/foxglove/mcap/go/mcap"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 type filterFlags struct {
@@ -20,6 +23,8 @@ type filterFlags struct {
 	excludeTopics      []string
 	start              uint64
 	end                uint64
+	startNanos         string
+	endNanos           string
 	includeMetadata    bool
 	includeAttachments bool
 	outputCompression  string
@@ -51,24 +56,37 @@ func buildFilterOptions(flags filterFlags) (*filterOpts, error) {
 	} else {
 		opts.end = flags.end * 1e9
 	}
+	if flags.startNanos != "" {
+		if flags.start != 0 {
+			return nil, errors.New("can only use one of --start and --start-secs")
+		}
+		start, err := parseTimestamp(flags.startNanos)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --start: %w", err)
+		}
+		opts.start = start
+	}
+	if flags.endNanos != "" {
+		if flags.end != 0 {
+			return nil, errors.New("can only use one of --end and --end-secs")
+		}
+		end, err := parseTimestamp(flags.endNanos)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --end: %w", err)
+		}
+		opts.end = end
+	}
 	if len(flags.includeTopics) > 0 && len(flags.excludeTopics) > 0 {
 		return nil, errors.New("can only use one of --include-topic-regex and --exclude-topic-regex")
 	}
-	if flags.end < flags.start {
+	if opts.end < opts.start {
 		return nil, errors.New("invalid time range query, end-time is before start-time")
 	}
-	opts.compressionFormat = mcap.CompressionNone
-	switch flags.outputCompression {
-	case "zstd":
-		opts.compressionFormat = mcap.CompressionZSTD
-	case "lz4":
-		opts.compressionFormat = mcap.CompressionLZ4
-	case "none":
-	case "":
-		opts.compressionFormat = mcap.CompressionNone
-	default:
-		return nil, fmt.Errorf("unrecognized compression format '%s': valid options are 'lz4', 'zstd', or 'none'", flags.outputCompression)
+	compressionFormat, err := mcap.ParseCompressionFormat(flags.outputCompression)
+	if err != nil {
+		return nil, err
 	}
+	opts.compressionFormat = compressionFormat
 
 	includeTopics, err := compileMatchers(flags.includeTopics)
 	if err != nil {
@@ -85,6 +103,28 @@ func buildFilterOptions(flags filterFlags) (*filterOpts, error) {
 	return opts, nil
 }
 
+// parseTimestamp parses a log time given in nanoseconds, either as an integer
+// or in floating point notation such as 1.5e9, or as an RFC3339 date.
+func parseTimestamp(s string) (uint64, error) {
+	if nanos, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return nanos, nil
+	}
+	if nanos, err := strconv.ParseFloat(s, 64); err == nil {
+		if nanos < 0 || nanos >= math.MaxUint64 || nanos != math.Trunc(nanos) {
+			return 0, fmt.Errorf("%s is not a whole number of nanoseconds", s)
+		}
+		return uint64(nanos), nil
+	}
+	date, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, fmt.Errorf("%s is neither nanoseconds nor an RFC3339 date", s)
+	}
+	if date.Before(time.Unix(0, 0)) {
+		return 0, fmt.Errorf("%s is before the unix epoch", s)
+	}
+	return uint64(date.UnixNano()), nil
+}
+
 func run(filterOptions *filterOpts, args []string) {
 	var reader io.Reader
 	if len(args) == 0 {
@@ -179,10 +219,16 @@ func filter(
 	}
 
 	var numMessages, numAttachments, numMetadata uint64
+	var numDiscarded uint64
 
 	lexer, err := mcap.NewLexer(r, &mcap.LexerOptions{
 		ValidateChunkCRCs: true,
 		EmitInvalidChunks: opts.recover,
+		Recover:           opts.recover,
+		OnRecover: func(recovery mcap.Recovery) {
+			numDiscarded++
+			fmt.Fprintf(os.Stderr, "Discarding input at offset %d: %s\n", recovery.Offset, recovery.Err)
+		},
 		AttachmentCallback: func(ar *mcap.AttachmentReader) error {
 			if !opts.includeAttachments {
 				return nil
@@ -220,6 +266,9 @@ func filter(
 		}
 		if opts.recover {
 			fmt.Printf("Recovered %d messages, %d attachments, and %d metadata records.\n", numMessages, numAttachments, numMetadata)
+			if numDiscarded > 0 {
+				fmt.Printf("Dropped %d corrupt or truncated records or chunks.\n", numDiscarded)
+			}
 		}
 	}()
 
@@ -359,14 +408,27 @@ func init() {
 			Long: `This subcommand filters an MCAP by topic and time range to a new file.
 When multiple regexes are used, topics that match any regex are included (or excluded).
 
+The time range may be given in seconds with --start-secs and --end-secs, or
+with --start and --end as nanoseconds (such as 1e9) or RFC3339 dates.
+
 usage:
-  mcap filter in.mcap -o out.mcap -y /diagnostics -y /tf -y /camera_(front|back)`,
+  mcap filter in.mcap -o out.mcap -y /diagnostics -y /tf -y /camera_(front|back)
+  mcap filter in.mcap -o out.mcap --include-topic /camera.* --start 1e9 --end 2e9`,
 		}
 		output := filterCmd.PersistentFlags().StringP("output", "o", "", "output filename")
 		includeTopics := filterCmd.PersistentFlags().StringArrayP("include-topic-regex", "y", []string{}, "messages with topic names matching this regex will be included, can be supplied multiple times")
 		excludeTopics := filterCmd.PersistentFlags().StringArrayP("exclude-topic-regex", "n", []string{}, "messages with topic names matching this regex will be excluded, can be supplied multiple times")
 		start := filterCmd.PersistentFlags().Uint64P("start-secs", "s", 0, "messages with log times after or equal to this timestamp will be included.")
 		end := filterCmd.PersistentFlags().Uint64P("end-secs", "e", 0, "messages with log times before timestamp will be included.")
+		startNanos := filterCmd.PersistentFlags().String("start", "", "messages with log times after or equal to this time, in nanoseconds or RFC3339, will be included.")
+		endNanos := filterCmd.PersistentFlags().String("end", "", "messages with log times before this time, in nanoseconds or RFC3339, will be included.")
+		// accept --include-topic and --exclude-topic as shorthands for the regex flags.
+		filterCmd.SetGlobalNormalizationFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
+			if name == "include-topic" || name == "exclude-topic" {
+				name += "-regex"
+			}
+			return pflag.NormalizedName(name)
+		})
 		chunkSize := filterCmd.PersistentFlags().Int64P("chunk-size", "", 4*1024*1024, "chunk size of output file")
 		includeMetadata := filterCmd.PersistentFlags().Bool("include-metadata", false, "whether to include metadata in the output bag")
 		includeAttachments := filterCmd.PersistentFlags().Bool("include-attachments", false, "whether to include attachments in the output mcap")
@@ -378,6 +440,8 @@ usage:
 				excludeTopics:      *excludeTopics,
 				start:              *start,
 				end:                *end,
+				startNanos:         *startNanos,
+				endNanos:           *endNanos,
 				chunkSize:          *chunkSize,
 				includeMetadata:    *includeMetadata,
 				includeAttachments: *includeAttachments,
@@ -396,6 +460,9 @@ usage:
 			Use:   "recover [file]",
 			Short: "Recover data from a potentially corrupt MCAP file",
 			Long: `This subcommand reads a potentially corrupt MCAP file and copies data to a new file.
+Chunks that cannot be read are skipped, as are bytes that do not begin a valid record, and a
+file cut short ends at the last complete record. The output is written with fresh chunks,
+indexes, and summary section.
 
 usage:
   mcap recover in.mcap -o out.mcap`,
@@ -2,11 +2,13 @@ package cmd
 
 import (
 	"bytes"
+	"encoding/binary"
 	"io"
 	"regexp"
 	"testing"
 
 	"github.com/foxglove/mcap/go/mcap"
+	"github.com/foxglove/mcap/go/mcap/readopts"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -310,6 +312,39 @@ func TestRecover(t *testing.T) {
 		}, messageCounter, 0.0)
 	})
 
+	t.Run("recover data around unrecognized bytes", func(t *testing.T) {
+		writeBuf := bytes.Buffer{}
+		inputBuf := bytes.Buffer{}
+		writeFilterTestInput(t, &inputBuf)
+		input := inputBuf.Bytes()
+		headerEnd := 8 + 9 + binary.LittleEndian.Uint64(input[9:17])
+		readBuf := bytes.Buffer{}
+		readBuf.Write(input[:headerEnd])
+		readBuf.Write(make([]byte, 20))
+		readBuf.Write(input[headerEnd:])
+
+		assert.Nil(t, filter(&readBuf, &writeBuf, &filterOpts{
+			end:                1000,
+			recover:            true,
+			includeAttachments: true,
+			includeMetadata:    true,
+		}))
+		reader, err := mcap.NewReader(bytes.NewReader(writeBuf.Bytes()))
+		assert.Nil(t, err)
+		defer reader.Close()
+		it, err := reader.Messages(readopts.UsingIndex(false))
+		assert.Nil(t, err)
+		messageCounter := make(map[uint16]int)
+		assert.Nil(t, mcap.Range(it, func(_ *mcap.Schema, channel *mcap.Channel, _ *mcap.Message) error {
+			messageCounter[channel.ID]++
+			return nil
+		}))
+		assert.Equal(t, map[uint16]int{1: 100, 2: 100, 3: 100}, messageCounter)
+		attachments, err := reader.Attachments()
+		assert.Nil(t, err)
+		assert.Len(t, attachments, 1)
+	})
+
 	t.Run("recover data from chunk with invalid crc", func(t *testing.T) {
 		writeBuf := bytes.Buffer{}
 		readBuf := bytes.Buffer{}
@@ -360,3 +395,56 @@ func TestRecover(t *testing.T) {
 		}, messageCounter, 0.0)
 	})
 }
+
+func TestParseTimestamp(t *testing.T) {
+	cases := []struct {
+		assertion string
+		input     string
+		expected  uint64
+		err       bool
+	}{
+		{"integer nanoseconds", "1500000000", 1500000000, false},
+		{"exponent notation", "1.5e9", 1500000000, false},
+		{"RFC3339 date", "1970-01-01T00:00:01.5Z", 1500000000, false},
+		{"fractional nanoseconds", "1.5", 0, true},
+		{"negative", "-1", 0, true},
+		{"before epoch", "1969-12-31T23:59:59Z", 0, true},
+		{"garbage", "yesterday", 0, true},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			nanos, err := parseTimestamp(c.input)
+			if c.err {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, c.expected, nanos)
+		})
+	}
+}
+
+func TestBuildFilterOptionsNanos(t *testing.T) {
+	opts, err := buildFilterOptions(filterFlags{
+		startNanos:        "1e9",
+		endNanos:          "2e9",
+		outputCompression: "zstd",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1e9), opts.start)
+	assert.Equal(t, uint64(2e9), opts.end)
+
+	_, err = buildFilterOptions(filterFlags{
+		start:             1,
+		startNanos:        "1e9",
+		outputCompression: "zstd",
+	})
+	assert.NotNil(t, err)
+
+	_, err = buildFilterOptions(filterFlags{
+		startNanos:        "2e9",
+		endNanos:          "1e9",
+		outputCompression: "zstd",
+	})
+	assert.NotNil(t, err)
+}
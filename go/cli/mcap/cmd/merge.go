@@ -24,7 +24,7 @@ var (
 )
 
 type mergeOpts struct {
-	compression string
+	compression mcap.CompressionFormat
 	chunkSize   int64
 	includeCRC  bool
 	chunked     bool
@@ -151,11 +151,62 @@ func outputProfile(profiles []string) string {
 	return firstProfile
 }
 
+// copyAttachmentsAndMetadata copies the attachment and metadata records of an
+// input, read from offset, to the output. Chunks are skipped without being
+// decompressed.
+func copyAttachmentsAndMetadata(w *mcap.Writer, rs io.ReadSeeker, offset int64) error {
+	if _, err := rs.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek to start of input: %w", err)
+	}
+	lexer, err := mcap.NewLexer(rs, &mcap.LexerOptions{
+		EmitChunks: true,
+		AttachmentCallback: func(ar *mcap.AttachmentReader) error {
+			return w.WriteAttachment(&mcap.Attachment{
+				LogTime:    ar.LogTime,
+				CreateTime: ar.CreateTime,
+				Name:       ar.Name,
+				MediaType:  ar.MediaType,
+				DataSize:   ar.DataSize,
+				Data:       ar.Data(),
+			})
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer lexer.Close()
+	for {
+		token, data, err := lexer.Next(nil)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		switch token {
+		case mcap.TokenMetadata:
+			metadata, err := mcap.ParseMetadata(data)
+			if err != nil {
+				return fmt.Errorf("failed to parse metadata: %w", err)
+			}
+			if err := w.WriteMetadata(metadata); err != nil {
+				return fmt.Errorf("failed to write metadata: %w", err)
+			}
+		case mcap.TokenDataEnd, mcap.TokenFooter:
+			return nil
+		}
+	}
+}
+
+// mergeInputs writes the messages of the inputs to w in log time order, along
+// with the attachments and metadata of each input. Attachments and metadata
+// can only be copied from inputs that implement io.Seeker, since they are read
+// in a second pass over the input.
 func (m *mcapMerger) mergeInputs(w io.Writer, inputs []namedReader) error {
 	writer, err := mcap.NewWriter(w, &mcap.WriterOptions{
 		Chunked:     m.opts.chunked,
 		ChunkSize:   m.opts.chunkSize,
-		Compression: mcap.CompressionFormat(m.opts.compression),
+		Compression: m.opts.compression,
 		IncludeCRC:  m.opts.includeCRC,
 	})
 	if err != nil {
@@ -166,6 +217,7 @@ func (m *mcapMerger) mergeInputs(w io.Writer, inputs []namedReader) error {
 	}
 
 	iterators := make([]mcap.MessageIterator, len(inputs))
+	startOffsets := make([]int64, len(inputs))
 	profiles := make([]string, len(inputs))
 	pq := utils.NewPriorityQueue(nil)
 
@@ -181,6 +233,12 @@ func (m *mcapMerger) mergeInputs(w io.Writer, inputs []namedReader) error {
 	// renumbered IDs, and load the message (with renumbered IDs) into the
 	// priority queue.
 	for inputID, input := range inputs {
+		if rs, ok := input.reader.(io.ReadSeeker); ok {
+			startOffsets[inputID], err = rs.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return fmt.Errorf("failed to get offset of %s: %w", input.name, err)
+			}
+		}
 		reader, err := mcap.NewReader(input.reader)
 		if err != nil {
 			return fmt.Errorf("failed to open reader on %s: %w", input.name, err)
@@ -268,6 +326,15 @@ func (m *mcapMerger) mergeInputs(w io.Writer, inputs []namedReader) error {
 		}
 		heap.Push(pq, utils.NewTaggedMessage(msg.InputID, newMessage))
 	}
+	for inputID, input := range inputs {
+		rs, ok := input.reader.(io.ReadSeeker)
+		if !ok {
+			continue
+		}
+		if err := copyAttachmentsAndMetadata(writer, rs, startOffsets[inputID]); err != nil {
+			return fmt.Errorf("failed to copy attachments and metadata from %s: %w", input.name, err)
+		}
+	}
 	return writer.Close()
 }
 
@@ -280,6 +347,11 @@ type namedReader struct {
 var mergeCmd = &cobra.Command{
 	Use:   "merge file1.mcap [file2.mcap] [file3.mcap]...",
 	Short: "Merge a selection of MCAP files by record timestamp",
+	Long: `Merge a selection of MCAP files by record timestamp.
+
+Messages are interleaved in log time order, with schema and channel IDs
+renumbered to avoid collisions between inputs. Attachments and metadata
+records of every input are copied to the output.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if mergeOutputFile == "" && !utils.StdoutRedirected() {
 			die(PleaseRedirect)
@@ -293,8 +365,12 @@ var mergeCmd = &cobra.Command{
 			defer f.Close()
 			readers = append(readers, namedReader{name: arg, reader: f})
 		}
+		compression, err := mcap.ParseCompressionFormat(mergeCompression)
+		if err != nil {
+			die(err.Error())
+		}
 		opts := mergeOpts{
-			compression: mergeCompression,
+			compression: compression,
 			chunkSize:   mergeChunkSize,
 			includeCRC:  mergeIncludeCRC,
 			chunked:     mergeChunked,
@@ -311,7 +387,7 @@ var mergeCmd = &cobra.Command{
 			defer f.Close()
 			writer = f
 		}
-		err := merger.mergeInputs(writer, readers)
+		err = merger.mergeInputs(writer, readers)
 		if err != nil {
 			die(err.Error())
 		}
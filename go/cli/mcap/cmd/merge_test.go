@@ -335,3 +335,51 @@ func TestSameSchemasNotDuplicated(t *testing.T) {
 	assert.Equal(t, 2, len(schemas))
 	assert.Equal(t, schemaNames, []string{"SchemaA", "SchemaB"})
 }
+
+func TestAttachmentsAndMetadataMerged(t *testing.T) {
+	inputs := []namedReader{}
+	for i, topic := range []string{"/foo", "/bar"} {
+		buf := &bytes.Buffer{}
+		writer, err := mcap.NewWriter(buf, &mcap.WriterOptions{Chunked: true})
+		assert.Nil(t, err)
+		assert.Nil(t, writer.WriteHeader(&mcap.Header{}))
+		assert.Nil(t, writer.WriteChannel(&mcap.Channel{ID: 1, Topic: topic}))
+		assert.Nil(t, writer.WriteMessage(&mcap.Message{ChannelID: 1, LogTime: uint64(i)}))
+		assert.Nil(t, writer.WriteAttachment(&mcap.Attachment{
+			Name:     fmt.Sprintf("attachment%d", i),
+			DataSize: 3,
+			Data:     bytes.NewReader([]byte{1, 2, 3}),
+		}))
+		assert.Nil(t, writer.WriteMetadata(&mcap.Metadata{
+			Name:     fmt.Sprintf("metadata%d", i),
+			Metadata: map[string]string{"topic": topic},
+		}))
+		assert.Nil(t, writer.Close())
+		inputs = append(inputs, namedReader{topic, bytes.NewReader(buf.Bytes())})
+	}
+	merger := newMCAPMerger(mergeOpts{chunked: true})
+	output := &bytes.Buffer{}
+	assert.Nil(t, merger.mergeInputs(output, inputs))
+
+	reader, err := mcap.NewReader(bytes.NewReader(output.Bytes()))
+	assert.Nil(t, err)
+	defer reader.Close()
+	attachments, err := reader.Attachments()
+	assert.Nil(t, err)
+	assert.Len(t, attachments, 2)
+	assert.Equal(t, "attachment0", attachments[0].Name)
+	assert.Equal(t, "attachment1", attachments[1].Name)
+	metadata, err := reader.MetadataEntries()
+	assert.Nil(t, err)
+	assert.Len(t, metadata, 2)
+	assert.Equal(t, "metadata0", metadata[0].Name)
+	assert.Equal(t, "metadata1", metadata[1].Name)
+	it, err := reader.Messages()
+	assert.Nil(t, err)
+	messages := 0
+	assert.Nil(t, mcap.Range(it, func(*mcap.Schema, *mcap.Channel, *mcap.Message) error {
+		messages++
+		return nil
+	}))
+	assert.Equal(t, 2, messages)
+}
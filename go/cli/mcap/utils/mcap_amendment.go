@@ -11,11 +11,11 @@ import (
 )
 
 const (
-	SizeRecordLength = 8
-	SizeOpcode       = 1
-	SizeDataEnd      = 4
-	SizeFooter       = 8 + 8 + 4
-	SizeMagic        = 8
+	SizeRecordLength = mcap.SizeRecordLength
+	SizeOpcode       = mcap.SizeOpcode
+	SizeDataEnd      = mcap.SizeDataEnd
+	SizeFooter       = mcap.SizeFooter
+	SizeMagic        = mcap.SizeMagic
 )
 
 // AmendMCAP adds attachment and metadata records to the end of the
@@ -0,0 +1,204 @@
+package mcap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNoSummarySection is returned by PrepareForAppend for a file without a
+// summary section, whose data section end cannot be located from the footer.
+var ErrNoSummarySection = errors.New("file has no summary section")
+
+// AppendState describes an existing MCAP file prepared for appending by
+// PrepareForAppend. It is passed to NewAppendWriter to resume writing the
+// file.
+type AppendState struct {
+	// DataEndOffset is the offset of the data end record, where appended
+	// records begin.
+	DataEndOffset uint64
+	// DataSectionCRC is the CRC recorded in the data end record, or zero if
+	// the file has none.
+	DataSectionCRC uint32
+	// Schemas and Channels are those repeated in the summary section. Files
+	// written with SkipRepeatedSchemas or SkipRepeatedChannelInfos do not
+	// record them, in which case they must be written again before use.
+	Schemas  []*Schema
+	Channels []*Channel
+	// Statistics is the statistics record of the summary section, or nil if
+	// there is none.
+	Statistics        *Statistics
+	ChunkIndexes      []*ChunkIndex
+	AttachmentIndexes []*AttachmentIndex
+	MetadataIndexes   []*MetadataIndex
+}
+
+// PrepareForAppend reads the footer and summary section of an MCAP file and
+// positions rw at the start of the data end record, so that new records
+// written there extend the data section. If rw has a Truncate method, as
+// *os.File does, the data end record and everything following it are
+// removed; otherwise the caller must ensure the rewritten end of the file is
+// not followed by stale data. The returned state is passed to NewAppendWriter,
+// which regenerates the summary section on Close. The file must have a
+// summary section, or ErrNoSummarySection is returned.
+func PrepareForAppend(rw io.ReadWriteSeeker) (*AppendState, error) {
+	footer, err := ReadFooter(rw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read footer: %w", err)
+	}
+	if footer.SummaryStart == 0 {
+		return nil, ErrNoSummarySection
+	}
+	if footer.SummaryStart < SizeMagic+SizeRecordPrefix+SizeDataEnd {
+		return nil, fmt.Errorf("invalid summary start %d", footer.SummaryStart)
+	}
+	dataEndOffset := footer.SummaryStart - SizeRecordPrefix - SizeDataEnd
+	dataEnd, err := readAppendDataEnd(rw, dataEndOffset)
+	if err != nil {
+		return nil, err
+	}
+	state := &AppendState{
+		DataEndOffset:  dataEndOffset,
+		DataSectionCRC: dataEnd.DataSectionCRC,
+	}
+	if err := state.readSummary(rw); err != nil {
+		return nil, err
+	}
+	if truncater, ok := rw.(interface{ Truncate(int64) error }); ok {
+		if err := truncater.Truncate(int64(dataEndOffset)); err != nil {
+			return nil, fmt.Errorf("failed to truncate file: %w", err)
+		}
+	}
+	if _, err := rw.Seek(int64(dataEndOffset), io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek to data end: %w", err)
+	}
+	return state, nil
+}
+
+// readAppendDataEnd reads the data end record at offset.
+func readAppendDataEnd(rs io.ReadSeeker, offset uint64) (*DataEnd, error) {
+	if _, err := rs.Seek(int64(offset), io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek to data end: %w", err)
+	}
+	buf := make([]byte, SizeRecordPrefix+SizeDataEnd)
+	if _, err := io.ReadFull(rs, buf); err != nil {
+		return nil, fmt.Errorf("failed to read data end: %w", err)
+	}
+	if opcode := OpCode(buf[0]); opcode != OpDataEnd {
+		return nil, fmt.Errorf("expected data end before summary section, found %s", opcode)
+	}
+	dataEnd, err := ParseDataEnd(buf[SizeRecordPrefix:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse data end: %w", err)
+	}
+	return dataEnd, nil
+}
+
+// readSummary reads the summary section from the current position of rs.
+func (s *AppendState) readSummary(rs io.ReadSeeker) error {
+	lexer, err := NewLexer(bufio.NewReader(rs), &LexerOptions{SkipMagic: true})
+	if err != nil {
+		return err
+	}
+	defer lexer.Close()
+	for {
+		tokenType, record, err := lexer.Next(nil)
+		if err != nil {
+			return fmt.Errorf("failed to read summary: %w", err)
+		}
+		switch tokenType {
+		case TokenSchema:
+			schema, err := ParseSchema(record)
+			if err != nil {
+				return fmt.Errorf("failed to parse schema: %w", err)
+			}
+			s.Schemas = append(s.Schemas, schema)
+		case TokenChannel:
+			channel, err := ParseChannel(record)
+			if err != nil {
+				return fmt.Errorf("failed to parse channel: %w", err)
+			}
+			s.Channels = append(s.Channels, channel)
+		case TokenStatistics:
+			s.Statistics, err = ParseStatistics(record)
+			if err != nil {
+				return fmt.Errorf("failed to parse statistics: %w", err)
+			}
+		case TokenChunkIndex:
+			idx, err := ParseChunkIndex(record)
+			if err != nil {
+				return fmt.Errorf("failed to parse chunk index: %w", err)
+			}
+			s.ChunkIndexes = append(s.ChunkIndexes, idx)
+		case TokenAttachmentIndex:
+			idx, err := ParseAttachmentIndex(record)
+			if err != nil {
+				return fmt.Errorf("failed to parse attachment index: %w", err)
+			}
+			s.AttachmentIndexes = append(s.AttachmentIndexes, idx)
+		case TokenMetadataIndex:
+			idx, err := ParseMetadataIndex(record)
+			if err != nil {
+				return fmt.Errorf("failed to parse metadata index: %w", err)
+			}
+			s.MetadataIndexes = append(s.MetadataIndexes, idx)
+		case TokenFooter:
+			return nil
+		}
+	}
+}
+
+// NewAppendWriter returns a writer that continues an MCAP file prepared by
+// PrepareForAppend, writing to w from the start of the file's data end
+// record. The writer knows the schemas, channels and indexes of the existing
+// file, so messages may be written on existing channels without redeclaring
+// them, and the summary section written on Close covers both the existing and
+// the appended records. No header is written. Statistics continue from those
+// of the existing file; if it has none, they count only what the summary
+// section and the appended records show. If opts.IncludeCRC is set, the data
+// section CRC continues from that of the existing file, unless the file has
+// none, in which case none is written.
+func NewAppendWriter(w io.Writer, state *AppendState, opts *WriterOptions) (*Writer, error) {
+	appendOpts := *opts
+	appendOpts.SkipMagic = true
+	writer, err := NewWriter(w, &appendOpts)
+	if err != nil {
+		return nil, err
+	}
+	writer.w.resume(state.DataEndOffset, state.DataSectionCRC)
+	writer.omitDataSectionCRC = state.DataSectionCRC == 0
+	for _, schema := range state.Schemas {
+		writer.dedupSchema(schema)
+		if _, ok := writer.schemas[schema.ID]; !ok {
+			writer.schemaIDs = append(writer.schemaIDs, schema.ID)
+			writer.schemas[schema.ID] = schema
+			writer.stats.ObserveSchema(schema.ID)
+		}
+	}
+	for _, channel := range state.Channels {
+		writer.dedupChannel(channel, channel.SchemaID, makePrefixedMap(channel.Metadata))
+		if _, ok := writer.channels[channel.ID]; !ok {
+			writer.channelIDs = append(writer.channelIDs, channel.ID)
+			writer.channels[channel.ID] = channel
+			writer.stats.ObserveChannel(channel.ID)
+		}
+	}
+	writer.ChunkIndexes = append(writer.ChunkIndexes, state.ChunkIndexes...)
+	writer.AttachmentIndexes = append(writer.AttachmentIndexes, state.AttachmentIndexes...)
+	writer.MetadataIndexes = append(writer.MetadataIndexes, state.MetadataIndexes...)
+	if state.Statistics != nil {
+		writer.stats.resume(state.Statistics)
+	} else {
+		for _, idx := range state.ChunkIndexes {
+			writer.stats.ObserveChunk(idx.MessageStartTime, idx.MessageEndTime)
+		}
+		for range state.AttachmentIndexes {
+			writer.stats.ObserveAttachment()
+		}
+		for range state.MetadataIndexes {
+			writer.stats.ObserveMetadata()
+		}
+	}
+	return writer, nil
+}
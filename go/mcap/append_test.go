@@ -0,0 +1,122 @@
+package mcap
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrepareForAppend(t *testing.T) {
+	opts := &WriterOptions{
+		Chunked:     true,
+		ChunkSize:   256,
+		Compression: CompressionZSTD,
+		IncludeCRC:  true,
+	}
+	path := filepath.Join(t.TempDir(), "append.mcap")
+	f, err := os.Create(path)
+	assert.Nil(t, err)
+	writer, err := NewWriter(f, opts)
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{Profile: "test"}))
+	assert.Nil(t, writer.WriteSchema(&Schema{ID: 1, Name: "schema", Encoding: "jsonschema"}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/first"}))
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, LogTime: uint64(i), Data: []byte("first")}))
+	}
+	assert.Nil(t, writer.WriteMetadata(&Metadata{Name: "before"}))
+	assert.Nil(t, writer.Close())
+	originalChunks := len(writer.ChunkIndexes)
+
+	state, err := PrepareForAppend(f)
+	assert.Nil(t, err)
+	assert.Len(t, state.Schemas, 1)
+	assert.Len(t, state.Channels, 1)
+	assert.Len(t, state.ChunkIndexes, originalChunks)
+	assert.Len(t, state.MetadataIndexes, 1)
+	assert.Equal(t, uint64(50), state.Statistics.MessageCount)
+	info, err := f.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(state.DataEndOffset), info.Size())
+
+	writer, err = NewAppendWriter(f, state, opts)
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 2, SchemaID: 1, Topic: "/second"}))
+	for i := 50; i < 100; i++ {
+		assert.Nil(t, writer.WriteMessage(&Message{ChannelID: uint16(1 + i%2), LogTime: uint64(i), Data: []byte("second")}))
+	}
+	assert.Nil(t, writer.Close())
+	assert.Nil(t, f.Close())
+
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	issues, err := Validate(bytes.NewReader(data), ValidateOptions{CrossCheckStatistics: true})
+	assert.Nil(t, err)
+	assert.Empty(t, issues)
+
+	reader, err := NewReader(bytes.NewReader(data))
+	assert.Nil(t, err)
+	assert.Nil(t, reader.ValidateSummaryCRC())
+	readerInfo, err := reader.Info()
+	assert.Nil(t, err)
+	assert.Equal(t, "test", readerInfo.Header.Profile)
+	assert.Equal(t, uint64(100), readerInfo.Statistics.MessageCount)
+	assert.Equal(t, uint64(75), readerInfo.Statistics.ChannelMessageCounts[1])
+	assert.Equal(t, uint64(25), readerInfo.Statistics.ChannelMessageCounts[2])
+	assert.Equal(t, uint32(2), readerInfo.Statistics.ChannelCount)
+	assert.Equal(t, uint32(1), readerInfo.Statistics.MetadataCount)
+	assert.Equal(t, uint64(99), readerInfo.Statistics.MessageEndTime)
+	assert.Greater(t, len(readerInfo.ChunkIndexes), originalChunks)
+
+	// the data section CRC covers both the original and appended records
+	footer, err := ReadFooter(bytes.NewReader(data))
+	assert.Nil(t, err)
+	dataEndOffset := footer.SummaryStart - SizeRecordPrefix - SizeDataEnd
+	dataEnd, err := ParseDataEnd(data[dataEndOffset+SizeRecordPrefix : footer.SummaryStart])
+	assert.Nil(t, err)
+	assert.Equal(t, crc32.ChecksumIEEE(data[:dataEndOffset]), dataEnd.DataSectionCRC)
+
+	it, err := reader.Messages()
+	assert.Nil(t, err)
+	var logTimes []uint64
+	for {
+		_, _, message, err := it.Next(nil)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.Nil(t, err)
+		logTimes = append(logTimes, message.LogTime)
+	}
+	assert.Len(t, logTimes, 100)
+	for i, logTime := range logTimes {
+		assert.Equal(t, uint64(i), logTime)
+	}
+}
+
+func TestPrepareForAppendWithoutSummary(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{
+		SkipStatistics:           true,
+		SkipRepeatedSchemas:      true,
+		SkipRepeatedChannelInfos: true,
+		SkipMetadataIndex:        true,
+		SkipAttachmentIndex:      true,
+		SkipChunkIndex:           true,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.Close())
+	path := filepath.Join(t.TempDir(), "append.mcap")
+	assert.Nil(t, os.WriteFile(path, buf.Bytes(), 0o600))
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	assert.Nil(t, err)
+	defer f.Close()
+	_, err = PrepareForAppend(f)
+	assert.ErrorIs(t, err, ErrNoSummarySection)
+}
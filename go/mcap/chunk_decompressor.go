@@ -0,0 +1,62 @@
+package mcap
+
+import (
+	"fmt"
+)
+
+// chunkDecompressor decompresses the records of fully materialized chunks,
+// reusing its decoders across calls.
+type chunkDecompressor struct {
+	decoders map[CompressionFormat]ResettableReader
+}
+
+// decompress returns the decompressed records of the chunk. For uncompressed
+// chunks the records are returned without copying.
+func (d *chunkDecompressor) decompress(chunk *Chunk) ([]byte, error) {
+	compression := CompressionFormat(chunk.Compression)
+	if compression == CompressionNone {
+		return chunk.Records, nil
+	}
+	decoder, err := d.decoder(compression)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := makeSafe(chunk.UncompressedSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate chunk buffer: %w", err)
+	}
+	data, err := decodeAll(decoder, chunk.Records, buf[:0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s chunk: %w", compression, err)
+	}
+	return data, nil
+}
+
+// decoder returns the decompressor's decoder for compression, creating it
+// with the registered factory on first use.
+func (d *chunkDecompressor) decoder(compression CompressionFormat) (ResettableReader, error) {
+	if decoder, ok := d.decoders[compression]; ok {
+		return decoder, nil
+	}
+	factory, ok := lookupDecoderFactory(compression)
+	if !ok {
+		return nil, fmt.Errorf("unsupported compression %s", compression)
+	}
+	decoder, err := factory.newDecoder()
+	if err != nil {
+		return nil, fmt.Errorf("failed to instantiate %s decoder: %w", compression, err)
+	}
+	if d.decoders == nil {
+		d.decoders = make(map[CompressionFormat]ResettableReader)
+	}
+	d.decoders[compression] = decoder
+	return decoder, nil
+}
+
+// Close releases the decoders held by the decompressor.
+func (d *chunkDecompressor) Close() {
+	for _, decoder := range d.decoders {
+		closeDecoder(decoder)
+	}
+	d.decoders = nil
+}
@@ -0,0 +1,127 @@
+package mcap
+
+import (
+	"io"
+)
+
+// chunkPrefetcher reads chunks ahead of an indexed message iterator, in the
+// order the iterator will load them, and decompresses them concurrently. The
+// file is only read from the goroutine calling get, so it is never accessed
+// concurrently; only decompression happens in the background.
+type chunkPrefetcher struct {
+	rs      io.ReadSeeker
+	order   []*ChunkIndex
+	next    int
+	pending []*prefetchedChunk
+	workers int
+	// decompressors holds the decompressors not in use by a pending chunk.
+	decompressors chan *chunkDecompressor
+}
+
+// prefetchedChunk is a chunk read by the prefetcher. Its data and err are set
+// once done is closed.
+type prefetchedChunk struct {
+	chunkIndex           *ChunkIndex
+	chunkAndMessageIndex []byte
+	data                 []byte
+	err                  error
+	done                 chan struct{}
+}
+
+// newChunkPrefetcher returns a prefetcher for the chunks in h, which must
+// hold only the chunks of a freshly parsed summary section, decompressing up
+// to workers chunks at a time.
+func newChunkPrefetcher(rs io.ReadSeeker, h *rangeIndexHeap, workers int) (*chunkPrefetcher, error) {
+	// the chunks are loaded in the order they leave the iterator's heap, so
+	// popping a copy of it gives the order to read them in.
+	ordered := rangeIndexHeap{
+		indices: append([]rangeIndex(nil), h.indices...),
+		order:   h.order,
+	}
+	order := make([]*ChunkIndex, 0, ordered.Len())
+	for ordered.Len() > 0 {
+		ri, err := ordered.HeapPop()
+		if err != nil {
+			return nil, err
+		}
+		order = append(order, ri.chunkIndex)
+	}
+	decompressors := make(chan *chunkDecompressor, workers)
+	for i := 0; i < workers; i++ {
+		decompressors <- &chunkDecompressor{}
+	}
+	return &chunkPrefetcher{
+		rs:            rs,
+		order:         order,
+		workers:       workers,
+		decompressors: decompressors,
+	}, nil
+}
+
+// get returns the chunk record of a chunk index with the message index
+// records that follow it, and the decompressed records of the chunk.
+func (p *chunkPrefetcher) get(chunkIndex *ChunkIndex) ([]byte, []byte, error) {
+	if err := p.fill(); err != nil {
+		return nil, nil, err
+	}
+	for i, chunk := range p.pending {
+		if chunk.chunkIndex == chunkIndex {
+			p.pending = append(p.pending[:i], p.pending[i+1:]...)
+			<-chunk.done
+			return chunk.chunkAndMessageIndex, chunk.data, chunk.err
+		}
+	}
+	// the chunk was not expected yet, so read it now.
+	chunkAndMessageIndex, err := readChunk(p.rs, chunkIndex, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	decompressor := <-p.decompressors
+	defer func() { p.decompressors <- decompressor }()
+	data, err := decompressChunk(decompressor, chunkIndex, chunkAndMessageIndex)
+	if err != nil {
+		return nil, nil, err
+	}
+	return chunkAndMessageIndex, data, nil
+}
+
+// fill reads the next chunks until as many are pending as there are workers,
+// starting the decompression of each.
+func (p *chunkPrefetcher) fill() error {
+	for len(p.pending) < p.workers && p.next < len(p.order) {
+		chunkIndex := p.order[p.next]
+		p.next++
+		chunkAndMessageIndex, err := readChunk(p.rs, chunkIndex, nil)
+		if err != nil {
+			return err
+		}
+		chunk := &prefetchedChunk{
+			chunkIndex:           chunkIndex,
+			chunkAndMessageIndex: chunkAndMessageIndex,
+			done:                 make(chan struct{}),
+		}
+		p.pending = append(p.pending, chunk)
+		// each pending chunk holds at most one decompressor, so one is free.
+		decompressor := <-p.decompressors
+		go func() {
+			chunk.data, chunk.err = decompressChunk(decompressor, chunk.chunkIndex, chunk.chunkAndMessageIndex)
+			p.decompressors <- decompressor
+			close(chunk.done)
+		}()
+	}
+	return nil
+}
+
+// close waits for the decompression of pending chunks to finish, then
+// releases the decoders of every worker's decompressor.
+func (p *chunkPrefetcher) close() {
+	for _, chunk := range p.pending {
+		<-chunk.done
+	}
+	p.pending = nil
+	for i := 0; i < p.workers; i++ {
+		decompressor := <-p.decompressors
+		decompressor.Close()
+		p.decompressors <- decompressor
+	}
+}
@@ -0,0 +1,161 @@
+package mcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ChunkScannerOptions holds options for a ChunkScanner.
+type ChunkScannerOptions struct {
+	// ChannelIDs decompresses each chunk to collect the IDs of the channels
+	// its messages are on. Without it chunks are never decompressed.
+	ChannelIDs bool
+	// ValidateChunkCRCs validates the CRC of each chunk decompressed to
+	// collect channel IDs.
+	ValidateChunkCRCs bool
+}
+
+// ChunkSummary describes a chunk found by a ChunkScanner.
+type ChunkSummary struct {
+	// Offset is the file offset of the chunk record's opcode, and Length the
+	// length of the record including its opcode and length prefix, as in a
+	// ChunkIndex.
+	Offset uint64
+	Length uint64
+	// Record is the raw content of the chunk record, following the opcode and
+	// length prefix. It is only valid until the next call to Next.
+	Record           []byte
+	MessageStartTime uint64
+	MessageEndTime   uint64
+	Compression      CompressionFormat
+	CompressedSize   uint64
+	UncompressedSize uint64
+	// ChannelIDs holds the sorted IDs of the channels of the chunk's messages,
+	// if ChunkScannerOptions.ChannelIDs is set.
+	ChannelIDs []uint16
+}
+
+// ChunkScanner reads the chunks of an MCAP file's data section, returning the
+// raw bytes of each with a summary of its contents. It is intended for tools
+// that reconstruct the chunk indexes of files that lost their summary
+// section.
+type ChunkScanner struct {
+	lexer        *Lexer
+	opts         ChunkScannerOptions
+	buf          []byte
+	decompressor chunkDecompressor
+}
+
+// NewChunkScanner returns a ChunkScanner reading from the start of an MCAP
+// file.
+func NewChunkScanner(r io.Reader, opts *ChunkScannerOptions) (*ChunkScanner, error) {
+	lexer, err := NewLexer(r, &LexerOptions{
+		EmitChunks: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	scanner := &ChunkScanner{lexer: lexer}
+	if opts != nil {
+		scanner.opts = *opts
+	}
+	return scanner, nil
+}
+
+// Next returns the next chunk in the file. It returns io.EOF after the last
+// chunk of the data section.
+func (s *ChunkScanner) Next() (*ChunkSummary, error) {
+	for {
+		tokenType, record, offset, err := s.lexer.NextWithOffset(s.buf)
+		if err != nil {
+			return nil, err
+		}
+		if len(record) > len(s.buf) {
+			s.buf = record
+		}
+		switch tokenType {
+		case TokenChunk:
+			return s.summarize(record, offset)
+		case TokenDataEnd, TokenFooter:
+			return nil, io.EOF
+		}
+	}
+}
+
+func (s *ChunkScanner) summarize(record []byte, offset uint64) (*ChunkSummary, error) {
+	chunk, err := ParseChunk(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse chunk at offset %d: %w", offset, err)
+	}
+	summary := &ChunkSummary{
+		Offset:           offset,
+		Length:           1 + 8 + uint64(len(record)),
+		Record:           record,
+		MessageStartTime: chunk.MessageStartTime,
+		MessageEndTime:   chunk.MessageEndTime,
+		Compression:      CompressionFormat(chunk.Compression),
+		CompressedSize:   uint64(len(chunk.Records)),
+		UncompressedSize: chunk.UncompressedSize,
+	}
+	if !s.opts.ChannelIDs {
+		return summary, nil
+	}
+	data, err := s.decompressor.decompress(chunk)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress chunk at offset %d: %w", offset, err)
+	}
+	if s.opts.ValidateChunkCRCs && chunk.UncompressedCRC != 0 {
+		if crc := s.lexer.checksum(data); crc != chunk.UncompressedCRC {
+			return nil, &errInvalidChunkCrc{expected: chunk.UncompressedCRC, actual: crc}
+		}
+	}
+	summary.ChannelIDs, err = messageChannelIDs(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk at offset %d: %w", offset, err)
+	}
+	return summary, nil
+}
+
+// messageChannelIDs returns the sorted, distinct channel IDs of the message
+// records in a chunk's decompressed records.
+func messageChannelIDs(data []byte) ([]uint16, error) {
+	seen := make(map[uint16]bool)
+	ids := []uint16{}
+	offset := uint64(0)
+	for offset < uint64(len(data)) {
+		if uint64(len(data))-offset < 9 {
+			return nil, &ErrTruncatedRecord{opcode: OpCode(data[offset]), actualLen: len(data) - int(offset)}
+		}
+		opcode := OpCode(data[offset])
+		recordLen := binary.LittleEndian.Uint64(data[offset+1:])
+		offset += 9
+		if recordLen > uint64(len(data))-offset {
+			return nil, &ErrTruncatedRecord{
+				opcode:      opcode,
+				actualLen:   len(data) - int(offset),
+				expectedLen: recordLen,
+			}
+		}
+		if opcode == OpMessage {
+			if recordLen < 2 {
+				return nil, fmt.Errorf("message record length %d: %w", recordLen, ErrRecordTooShort)
+			}
+			id := binary.LittleEndian.Uint16(data[offset:])
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		offset += recordLen
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// Close the scanner and release its resources.
+func (s *ChunkScanner) Close() {
+	s.lexer.Close()
+	s.decompressor.Close()
+}
@@ -0,0 +1,74 @@
+package mcap
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunkScanner(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{
+		Chunked:     true,
+		ChunkSize:   256,
+		Compression: CompressionZSTD,
+		IncludeCRC:  true,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	for id := uint16(1); id <= 3; id++ {
+		assert.Nil(t, writer.WriteChannel(&Channel{ID: id, Topic: "/foo"}))
+	}
+	for i := 0; i < 30; i++ {
+		// channel 3 only appears in the first few messages
+		channelID := uint16(1 + i%2)
+		if i < 3 {
+			channelID = 3
+		}
+		assert.Nil(t, writer.WriteMessage(&Message{ChannelID: channelID, LogTime: uint64(i), Data: make([]byte, 20)}))
+	}
+	assert.Nil(t, writer.Close())
+	reader, err := NewReader(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	info, err := reader.Info()
+	assert.Nil(t, err)
+	assert.Greater(t, len(info.ChunkIndexes), 1)
+
+	for _, channelIDs := range []bool{false, true} {
+		scanner, err := NewChunkScanner(bytes.NewReader(buf.Bytes()), &ChunkScannerOptions{
+			ChannelIDs:        channelIDs,
+			ValidateChunkCRCs: true,
+		})
+		assert.Nil(t, err)
+		for i, chunkIndex := range info.ChunkIndexes {
+			summary, err := scanner.Next()
+			assert.Nil(t, err)
+			assert.Equal(t, chunkIndex.ChunkStartOffset, summary.Offset)
+			assert.Equal(t, chunkIndex.ChunkLength, summary.Length)
+			assert.Equal(t, chunkIndex.MessageStartTime, summary.MessageStartTime)
+			assert.Equal(t, chunkIndex.MessageEndTime, summary.MessageEndTime)
+			assert.Equal(t, chunkIndex.Compression, summary.Compression)
+			assert.Equal(t, chunkIndex.CompressedSize, summary.CompressedSize)
+			assert.Equal(t, chunkIndex.UncompressedSize, summary.UncompressedSize)
+			start := summary.Offset + 9
+			assert.Equal(t, buf.Bytes()[start:start+uint64(len(summary.Record))], summary.Record)
+			if !channelIDs {
+				assert.Nil(t, summary.ChannelIDs)
+				continue
+			}
+			var expected []uint16
+			for id := uint16(1); id <= 3; id++ {
+				if _, ok := chunkIndex.MessageIndexOffsets[id]; ok {
+					expected = append(expected, id)
+				}
+			}
+			assert.Equal(t, expected, summary.ChannelIDs, "chunk %d", i)
+		}
+		_, err = scanner.Next()
+		assert.True(t, errors.Is(err, io.EOF))
+		scanner.Close()
+	}
+}
@@ -0,0 +1,27 @@
+package mcap
+
+import (
+	"context"
+	"io"
+)
+
+// lexerContext holds the context of an in-progress call to NextContext. It is
+// stored in an atomic.Value, since chunk decompressors may read from their
+// input on background goroutines.
+type lexerContext struct {
+	ctx context.Context
+}
+
+// contextReader wraps a reader, failing reads with the error of the lexer's
+// current context once it is done.
+type contextReader struct {
+	l *Lexer
+	r io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.l.contextErr(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,32 @@
+package mcap
+
+import (
+	"errors"
+	"io"
+)
+
+// countingReader wraps a reader, counting the bytes read through it and
+// retaining the first read error other than io.EOF.
+type countingReader struct {
+	r     io.Reader
+	count uint64
+	err   error
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.count += uint64(n)
+	if err != nil && !errors.Is(err, io.EOF) && c.err == nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// Count returns the number of bytes read.
+func (c *countingReader) Count() uint64 {
+	return c.count
+}
+
+func newCountingReader(r io.Reader) *countingReader {
+	return &countingReader{r: r}
+}
@@ -1,32 +1,36 @@
 package mcap
 
 import (
-	"hash"
 	"hash/crc32"
 	"io"
 )
 
 type crcWriter struct {
 	w   io.Writer
-	crc hash.Hash32
+	crc uint32
 }
 
 func (w *crcWriter) Write(p []byte) (int, error) {
-	_, _ = w.crc.Write(p)
+	w.crc = crc32.Update(w.crc, crc32.IEEETable, p)
 	return w.w.Write(p)
 }
 
 func (w *crcWriter) Checksum() uint32 {
-	return w.crc.Sum32()
+	return w.crc
 }
 
 func (w *crcWriter) Reset() {
-	w.crc = crc32.NewIEEE()
+	w.crc = 0
+}
+
+// Seed continues the checksum from crc, as if the data it was computed over
+// had already been written.
+func (w *crcWriter) Seed(crc uint32) {
+	w.crc = crc
 }
 
 func newCRCWriter(w io.Writer) *crcWriter {
 	return &crcWriter{
-		w:   w,
-		crc: crc32.NewIEEE(),
+		w: w,
 	}
 }
@@ -0,0 +1,178 @@
+package mcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// CopyDataSection copies the records of src's data section to dst verbatim,
+// without decompressing chunks. src must be positioned at the start of the
+// file. The leading magic and header are consumed but not copied, and copying
+// stops at the DataEnd record, which is not copied, so that neither the
+// summary section nor the footer reaches dst. Files without a DataEnd record
+// are copied up to the footer, or to the end of input if src ends cleanly at a
+// record boundary. It returns the number of bytes written.
+func CopyDataSection(dst io.Writer, src io.Reader) (int64, error) {
+	if err := validateMagic(src); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 9)
+	if _, err := io.ReadFull(src, buf); err != nil {
+		return 0, fmt.Errorf("failed to read header opcode and length: %w", err)
+	}
+	if opcode := OpCode(buf[0]); opcode != OpHeader {
+		return 0, fmt.Errorf("expected first record in MCAP to be a Header, found %s", opcode)
+	}
+	if err := skipReader(src, int64(binary.LittleEndian.Uint64(buf[1:]))); err != nil {
+		return 0, fmt.Errorf("failed to skip header: %w", err)
+	}
+	var written int64
+	for {
+		n, err := io.ReadFull(src, buf)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return written, nil
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return written, &ErrTruncatedRecord{opcode: OpCode(buf[0]), actualLen: n}
+			}
+			return written, fmt.Errorf("failed to read record opcode and length: %w", err)
+		}
+		opcode := OpCode(buf[0])
+		if opcode == OpDataEnd || opcode == OpFooter {
+			return written, nil
+		}
+		recordLen := binary.LittleEndian.Uint64(buf[1:])
+		if recordLen > math.MaxInt64 {
+			return written, fmt.Errorf("%s record length %d: %w", opcode, recordLen, ErrLengthOutOfRange)
+		}
+		n, err = dst.Write(buf)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		copied, err := io.CopyN(dst, src, int64(recordLen))
+		written += copied
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return written, &ErrTruncatedRecord{
+					opcode:      opcode,
+					actualLen:   int(copied),
+					expectedLen: recordLen,
+				}
+			}
+			return written, err
+		}
+	}
+}
+
+// dataSectionReader reads the records of a data section with chunks expanded.
+type dataSectionReader struct {
+	lexer *Lexer
+	// out holds the bytes of records read from the lexer but not yet returned.
+	out     bytes.Buffer
+	buf     []byte
+	started bool
+	err     error
+}
+
+// DataSectionReader returns a reader of the records in r's data section, with
+// chunks decompressed and replaced by the records they contain, as if the file
+// had been written without chunking or compression. Each record is delivered
+// whole, as its opcode, length and content. r must be positioned at the start
+// of the file. As with CopyDataSection, the header is consumed but not
+// delivered, and reading ends at the DataEnd record. Message indexes, which
+// refer to chunk contents, are dropped. Attachments are delivered in full and
+// are buffered in memory to do so. Close releases the decompressors.
+func DataSectionReader(r io.Reader) (io.ReadCloser, error) {
+	d := &dataSectionReader{}
+	lexer, err := NewLexer(r, &LexerOptions{
+		EmitUnknownRecords: true,
+		AttachmentCallback: d.writeAttachment,
+	})
+	if err != nil {
+		return nil, err
+	}
+	d.lexer = lexer
+	return d, nil
+}
+
+func (d *dataSectionReader) Read(p []byte) (int, error) {
+	for d.out.Len() == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		d.err = d.readRecord()
+	}
+	return d.out.Read(p)
+}
+
+// readRecord reads the next record from the lexer into out.
+func (d *dataSectionReader) readRecord() error {
+	tokenType, record, err := d.lexer.Next(d.buf)
+	if err != nil {
+		return err
+	}
+	if len(record) > len(d.buf) {
+		d.buf = record
+	}
+	if !d.started {
+		d.started = true
+		if tokenType != TokenHeader {
+			return fmt.Errorf("expected first record in MCAP to be a Header, found %s", tokenType)
+		}
+		return nil
+	}
+	switch tokenType {
+	case TokenDataEnd, TokenFooter:
+		return io.EOF
+	case TokenMessageIndex:
+		return nil
+	}
+	var prefix [SizeRecordPrefix]byte
+	prefix[0] = byte(d.lexer.LastOpcode())
+	putUint64(prefix[SizeOpcode:], uint64(len(record)))
+	d.out.Write(prefix[:])
+	d.out.Write(record)
+	return nil
+}
+
+// writeAttachment reassembles an attachment record into out.
+func (d *dataSectionReader) writeAttachment(ar *AttachmentReader) error {
+	fields := make([]byte, SizeRecordPrefix+8+8+4+len(ar.Name)+4+len(ar.MediaType)+8)
+	recordLen := uint64(len(fields)-SizeRecordPrefix) + ar.DataSize + 4
+	fields[0] = byte(OpAttachment)
+	offset := SizeOpcode
+	offset += putUint64(fields[offset:], recordLen)
+	offset += putUint64(fields[offset:], ar.LogTime)
+	offset += putUint64(fields[offset:], ar.CreateTime)
+	offset += putPrefixedString(fields[offset:], ar.Name)
+	offset += putPrefixedString(fields[offset:], ar.MediaType)
+	putUint64(fields[offset:], ar.DataSize)
+	d.out.Write(fields)
+	copied, err := io.Copy(&d.out, ar.Data())
+	if err != nil {
+		return err
+	}
+	if uint64(copied) != ar.DataSize {
+		return fmt.Errorf("attachment data ended after %d of %d bytes: %w", copied, ar.DataSize, io.ErrUnexpectedEOF)
+	}
+	crc, err := ar.ParsedCRC()
+	if err != nil {
+		return err
+	}
+	var crcBytes [4]byte
+	putUint32(crcBytes[:], crc)
+	d.out.Write(crcBytes[:])
+	return nil
+}
+
+// Close releases the resources of the lexer.
+func (d *dataSectionReader) Close() error {
+	d.lexer.Close()
+	return nil
+}
@@ -0,0 +1,156 @@
+package mcap
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func dataEnd() []byte {
+	return flatten([]byte{byte(OpDataEnd)}, encodedUint64(4), encodedUint32(0))
+}
+
+func TestCopyDataSection(t *testing.T) {
+	dataChunk := chunk(t, CompressionZSTD, true, channelRecord(1, 0), messageRecord(1, 0))
+	cases := []struct {
+		assertion string
+		input     []byte
+		output    []byte
+		err       error
+	}{
+		{
+			"stops at data end",
+			file(header(), dataChunk, attachment(), dataEnd(), channelRecord(1, 0), footer()),
+			flatten(dataChunk, attachment()),
+			nil,
+		},
+		{
+			"stops at footer without data end",
+			file(header(), channelRecord(1, 0), messageRecord(1, 0), footer()),
+			flatten(channelRecord(1, 0), messageRecord(1, 0)),
+			nil,
+		},
+		{
+			"empty data section",
+			file(header(), dataEnd(), footer()),
+			nil,
+			nil,
+		},
+		{
+			"truncated record",
+			flatten(Magic, header(), channelRecord(1, 0), messageRecord(1, 0)[:12]),
+			flatten(channelRecord(1, 0), messageRecord(1, 0)[:12]),
+			&ErrTruncatedRecord{},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			n, err := CopyDataSection(buf, bytes.NewReader(c.input))
+			if c.err != nil {
+				assert.IsType(t, c.err, err)
+			} else {
+				assert.Nil(t, err)
+			}
+			assert.Equal(t, int64(len(c.output)), n)
+			assert.Equal(t, len(c.output), buf.Len())
+			assert.True(t, bytes.Equal(c.output, buf.Bytes()))
+		})
+	}
+}
+
+func TestDataSectionReader(t *testing.T) {
+	records := [][]byte{channelRecord(1, 0), messageRecord(1, 0), messageRecord(1, 1)}
+	messageIndex := flatten([]byte{byte(OpMessageIndex)}, encodedUint64(6), encodedUint16(1), encodedUint32(0))
+	unknown := flatten([]byte{0x80}, encodedUint64(3), []byte("abc"))
+	cases := []struct {
+		assertion string
+		input     []byte
+		output    []byte
+	}{
+		{
+			"zstd chunk",
+			file(header(), chunk(t, CompressionZSTD, true, records...), messageIndex, dataEnd(), footer()),
+			flatten(records...),
+		},
+		{
+			"lz4 chunk",
+			file(header(), chunk(t, CompressionLZ4, true, records...), messageIndex, dataEnd(), footer()),
+			flatten(records...),
+		},
+		{
+			"uncompressed chunk",
+			file(header(), chunk(t, CompressionNone, false, records...), messageIndex, dataEnd(), footer()),
+			flatten(records...),
+		},
+		{
+			"records outside chunks",
+			file(header(), schemaRecord(1, "a"), chunk(t, CompressionZSTD, true, records...), attachment(),
+				unknown, record(OpMetadata), dataEnd(), channelRecord(2, 0), footer()),
+			flatten(schemaRecord(1, "a"), flatten(records...), attachment(), unknown, record(OpMetadata)),
+		},
+		{
+			"stops at footer without data end",
+			file(header(), channelRecord(1, 0), messageRecord(1, 0), footer()),
+			flatten(channelRecord(1, 0), messageRecord(1, 0)),
+		},
+		{
+			"empty data section",
+			file(header(), dataEnd(), footer()),
+			[]byte{},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			r, err := DataSectionReader(bytes.NewReader(c.input))
+			assert.Nil(t, err)
+			defer r.Close()
+			output, err := io.ReadAll(r)
+			assert.Nil(t, err)
+			assert.Equal(t, c.output, output)
+		})
+	}
+	t.Run("truncated record", func(t *testing.T) {
+		r, err := DataSectionReader(bytes.NewReader(flatten(Magic, header(), channelRecord(1, 0), messageRecord(1, 0)[:12])))
+		assert.Nil(t, err)
+		defer r.Close()
+		output, err := io.ReadAll(r)
+		var truncated *ErrTruncatedRecord
+		assert.ErrorAs(t, err, &truncated)
+		assert.Equal(t, channelRecord(1, 0), output)
+	})
+	t.Run("header not first", func(t *testing.T) {
+		r, err := DataSectionReader(bytes.NewReader(file(channelRecord(1, 0), dataEnd(), footer())))
+		assert.Nil(t, err)
+		defer r.Close()
+		_, err = io.ReadAll(r)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "expected first record in MCAP to be a Header")
+		}
+	})
+	t.Run("attachment data", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		writer, err := NewWriter(buf, &WriterOptions{Chunked: true, Compression: CompressionZSTD, IncludeCRC: true})
+		assert.Nil(t, err)
+		assert.Nil(t, writer.WriteHeader(&Header{}))
+		assert.Nil(t, writer.WriteAttachment(&Attachment{
+			Name:      "a.txt",
+			MediaType: "text/plain",
+			LogTime:   10,
+			DataSize:  5,
+			Data:      bytes.NewReader([]byte("hello")),
+		}))
+		assert.Nil(t, writer.Close())
+		r, err := DataSectionReader(bytes.NewReader(buf.Bytes()))
+		assert.Nil(t, err)
+		defer r.Close()
+		output, err := io.ReadAll(r)
+		assert.Nil(t, err)
+
+		// the reassembled record is identical to the original.
+		idx := writer.AttachmentIndexes[0]
+		assert.Equal(t, buf.Bytes()[idx.Offset:idx.Offset+idx.Length], output)
+	})
+}
@@ -0,0 +1,48 @@
+package mcap
+
+import (
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// DecoderPool holds lz4 and zstd chunk decoders for reuse across lexers. A
+// lexer given a pool through LexerOptions.DecoderPool takes a decoder from it
+// on reading its first chunk of each compression format, and returns the
+// decoder on Close, so that short-lived lexers in a busy service do not each
+// allocate their own. The zero value is ready to use, and a pool may be shared
+// by lexers on many goroutines.
+//
+// Only zstd decoders with default options are pooled, so that pooled decoders
+// are interchangeable. Lexers setting ZSTDConcurrency other than zero or one,
+// ZSTDMaxMemory, or ZSTDDictionaries allocate and close their own.
+type DecoderPool struct {
+	lz4  sync.Pool
+	zstd sync.Pool
+}
+
+func (p *DecoderPool) getLZ4() *lz4.Reader {
+	if r, ok := p.lz4.Get().(*lz4.Reader); ok {
+		return r
+	}
+	return nil
+}
+
+func (p *DecoderPool) putLZ4(r *lz4.Reader) {
+	r.Reset(nil)
+	p.lz4.Put(r)
+}
+
+func (p *DecoderPool) getZSTD() *zstd.Decoder {
+	if d, ok := p.zstd.Get().(*zstd.Decoder); ok {
+		return d
+	}
+	return nil
+}
+
+func (p *DecoderPool) putZSTD(d *zstd.Decoder) {
+	// release the lexer's input without closing the decoder
+	_ = d.Reset(nil)
+	p.zstd.Put(d)
+}
@@ -0,0 +1,141 @@
+package mcap
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// DecoderFactory returns a new decoder for chunk records compressed in some
+// format. A decoder is reused across chunks by calling Reset with the
+// compressed records of each. If it implements io.Closer, or has a Close method
+// without a result, it is closed when its lexer or reader is done with it.
+type DecoderFactory func() (ResettableReader, error)
+
+// decoderFactory creates the decoders for a chunk compression format.
+type decoderFactory struct {
+	newDecoder DecoderFactory
+	// setLexerDecoder, if set, is used by lexers in place of newDecoder. It
+	// sets the lexer's reader to decode the chunk records read from r,
+	// allowing built-in formats to honor lexer options such as decoder pools
+	// and zstd dictionaries.
+	setLexerDecoder func(l *Lexer, r io.Reader, compressedSize, uncompressedSize uint64) error
+}
+
+var (
+	decoderFactoriesMtx sync.RWMutex
+	decoderFactories    = map[CompressionFormat]decoderFactory{
+		CompressionNone: {
+			newDecoder: func() (ResettableReader, error) {
+				return &noneDecoder{}, nil
+			},
+			setLexerDecoder: func(l *Lexer, r io.Reader, _, _ uint64) error {
+				l.reader = r
+				return nil
+			},
+		},
+		CompressionZSTD: {
+			newDecoder: func() (ResettableReader, error) {
+				return zstd.NewReader(nil)
+			},
+			setLexerDecoder: func(l *Lexer, r io.Reader, _, _ uint64) error {
+				return l.setZSTDDecoder(r)
+			},
+		},
+		CompressionLZ4: {
+			newDecoder: func() (ResettableReader, error) {
+				return &lz4Decoder{lz4.NewReader(nil)}, nil
+			},
+			setLexerDecoder: func(l *Lexer, r io.Reader, compressedSize, uncompressedSize uint64) error {
+				if l.allowLegacyLZ4 {
+					return l.setLegacyCompatibleLZ4Decoder(r, compressedSize, uncompressedSize)
+				}
+				l.setLZ4Decoder(r)
+				return nil
+			},
+		},
+	}
+)
+
+// RegisterDecoder registers a decoder factory for chunks with the given
+// compression, replacing any factory previously registered for it, including
+// the built-in ones for CompressionNone, CompressionLZ4 and CompressionZSTD.
+// Registered decoders are used by lexers and readers alike; decompressors
+// supplied in LexerOptions take precedence over them.
+func RegisterDecoder(compression CompressionFormat, factory DecoderFactory) {
+	decoderFactoriesMtx.Lock()
+	defer decoderFactoriesMtx.Unlock()
+	decoderFactories[compression] = decoderFactory{newDecoder: factory}
+}
+
+// lookupDecoderFactory returns the decoder factory registered for
+// compression.
+func lookupDecoderFactory(compression CompressionFormat) (decoderFactory, bool) {
+	decoderFactoriesMtx.RLock()
+	defer decoderFactoriesMtx.RUnlock()
+	factory, ok := decoderFactories[compression]
+	return factory, ok
+}
+
+// closeDecoder closes a decoder created by a DecoderFactory, if it can be
+// closed.
+func closeDecoder(decoder ResettableReader) {
+	switch closer := decoder.(type) {
+	case io.Closer:
+		_ = closer.Close()
+	case interface{ Close() }:
+		closer.Close()
+	}
+}
+
+// noneDecoder reads uncompressed chunk records.
+type noneDecoder struct {
+	r io.Reader
+}
+
+func (d *noneDecoder) Read(p []byte) (int, error) {
+	if d.r == nil {
+		return 0, io.EOF
+	}
+	return d.r.Read(p)
+}
+
+func (d *noneDecoder) Reset(r io.Reader) error {
+	d.r = r
+	return nil
+}
+
+// lz4Decoder adapts an lz4.Reader to ResettableReader.
+type lz4Decoder struct {
+	*lz4.Reader
+}
+
+func (d *lz4Decoder) Reset(r io.Reader) error {
+	d.Reader.Reset(r)
+	return nil
+}
+
+// allDecoder is implemented by decoders, such as the zstd decoder, that can
+// decode a whole chunk in one call, appending the result to dst.
+type allDecoder interface {
+	DecodeAll(input, dst []byte) ([]byte, error)
+}
+
+// decodeAll decodes the whole of input with decoder, appending the result to
+// dst.
+func decodeAll(decoder ResettableReader, input, dst []byte) ([]byte, error) {
+	if d, ok := decoder.(allDecoder); ok {
+		return d.DecodeAll(input, dst)
+	}
+	if err := decoder.Reset(bytes.NewReader(input)); err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(dst)
+	if _, err := buf.ReadFrom(decoder); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,156 @@
+package mcap
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/foxglove/mcap/go/mcap/readopts"
+	"github.com/stretchr/testify/assert"
+)
+
+// xorWriter and xorReader implement a stand-in compression format that XORs
+// each byte with a constant.
+type xorWriter struct {
+	w io.Writer
+}
+
+func (x *xorWriter) Write(p []byte) (int, error) {
+	out := make([]byte, len(p))
+	for i, b := range p {
+		out[i] = b ^ 0x5a
+	}
+	return x.w.Write(out)
+}
+
+func (x *xorWriter) Close() error { return nil }
+
+func (x *xorWriter) Reset(w io.Writer) { x.w = w }
+
+type xorReader struct {
+	r      io.Reader
+	closed bool
+}
+
+func (x *xorReader) Read(p []byte) (int, error) {
+	n, err := x.r.Read(p)
+	for i := range p[:n] {
+		p[i] ^= 0x5a
+	}
+	return n, err
+}
+
+func (x *xorReader) Reset(r io.Reader) error {
+	x.r = r
+	return nil
+}
+
+func (x *xorReader) Close() error {
+	x.closed = true
+	return nil
+}
+
+func TestRegisterDecoder(t *testing.T) {
+	const compression = CompressionFormat("xz")
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{
+		Chunked:    true,
+		ChunkSize:  128,
+		IncludeCRC: true,
+		Compressor: NewCustomCompressor(compression, &xorWriter{}),
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.WriteSchema(&Schema{ID: 1, Name: "schema", Encoding: "jsonschema"}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/topic"}))
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, LogTime: uint64(i), Data: []byte("hello")}))
+	}
+	assert.Nil(t, writer.Close())
+	assert.Greater(t, len(writer.ChunkIndexes), 1)
+
+	lexer, err := NewLexer(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	defer lexer.Close()
+	_, _, err = lexer.Next(nil)
+	assert.Nil(t, err)
+	_, _, err = lexer.Next(nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unsupported compression: xz")
+	}
+
+	var decodersMtx sync.Mutex
+	var decoders []*xorReader
+	RegisterDecoder(compression, func() (ResettableReader, error) {
+		decodersMtx.Lock()
+		defer decodersMtx.Unlock()
+		decoder := &xorReader{}
+		decoders = append(decoders, decoder)
+		return decoder, nil
+	})
+	defer func() {
+		decoderFactoriesMtx.Lock()
+		delete(decoderFactories, compression)
+		decoderFactoriesMtx.Unlock()
+	}()
+
+	t.Run("lexer", func(t *testing.T) {
+		for _, validateCRC := range []bool{false, true} {
+			created := len(decoders)
+			assert.Equal(t, 20, countMessages(t, buf.Bytes(), &LexerOptions{ValidateChunkCRCs: validateCRC}))
+			// one decoder is created, reused across chunks, and closed with
+			// the lexer
+			assert.Len(t, decoders, created+1)
+			assert.True(t, decoders[created].closed)
+		}
+	})
+	t.Run("indexed reader", func(t *testing.T) {
+		for _, workers := range []int{0, 2} {
+			created := len(decoders)
+			reader, err := NewReader(bytes.NewReader(buf.Bytes()))
+			assert.Nil(t, err)
+			it, err := reader.Messages(readopts.UsingIndex(true), readopts.DecompressionWorkers(workers))
+			assert.Nil(t, err)
+			count := 0
+			for {
+				_, _, message, err := it.Next(nil)
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				assert.Nil(t, err)
+				assert.Equal(t, "hello", string(message.Data))
+				count++
+			}
+			assert.Equal(t, 20, count)
+			// the decoders are closed once the iterator reaches the end
+			assert.Greater(t, len(decoders), created)
+			for _, decoder := range decoders[created:] {
+				assert.True(t, decoder.closed)
+			}
+			reader.Close()
+		}
+	})
+	t.Run("indexed reader closed before the end", func(t *testing.T) {
+		for _, workers := range []int{0, 2} {
+			created := len(decoders)
+			reader, err := NewReader(bytes.NewReader(buf.Bytes()))
+			assert.Nil(t, err)
+			it, err := reader.Messages(readopts.UsingIndex(true), readopts.DecompressionWorkers(workers))
+			assert.Nil(t, err)
+			_, _, _, err = it.Next(nil)
+			assert.Nil(t, err)
+			reader.Close()
+			assert.Greater(t, len(decoders), created)
+			for _, decoder := range decoders[created:] {
+				assert.True(t, decoder.closed)
+			}
+		}
+	})
+	t.Run("validate", func(t *testing.T) {
+		issues, err := Validate(bytes.NewReader(buf.Bytes()), ValidateOptions{})
+		assert.Nil(t, err)
+		assert.Empty(t, issues)
+	})
+}
@@ -0,0 +1,143 @@
+package mcap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// FileProfile describes the layout of an MCAP file, as read by
+// ReadFileProfile.
+type FileProfile struct {
+	// Profile and Library are the fields of the header.
+	Profile string
+	Library string
+	// HasSummary reports whether the file has a summary section.
+	HasSummary bool
+	// HasSummaryOffsets reports whether the file has a summary offset
+	// section, allowing groups of summary records to be read individually.
+	HasSummaryOffsets bool
+	// HasStatistics reports whether the summary section holds a Statistics
+	// record.
+	HasStatistics bool
+	// HasChunkIndex reports whether the summary section holds ChunkIndex
+	// records, allowing messages to be read through the index.
+	HasChunkIndex bool
+	// IsChunked reports whether the data section holds chunks. It is true if
+	// the file has chunk indexes, and otherwise is inferred from the first
+	// message or chunk in the data section.
+	IsChunked bool
+}
+
+// ReadFileProfile reads the header and footer of an MCAP file, and the
+// summary offset section if there is one, to describe the layout of the file
+// without reading the data section in full. Callers can use it to decide
+// whether a file may be read through its index or must be read in a single
+// pass. Without a summary offset section, the summary section is read in
+// full. If the file has no chunk indexes, the data section is read up to its
+// first message or chunk to determine whether it is chunked.
+func ReadFileProfile(rs io.ReadSeeker) (*FileProfile, error) {
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek to start of file: %w", err)
+	}
+	header, err := ReadHeader(rs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	footer, err := ReadFooter(rs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read footer: %w", err)
+	}
+	profile := &FileProfile{
+		Profile:           header.Profile,
+		Library:           header.Library,
+		HasSummary:        footer.SummaryStart != 0,
+		HasSummaryOffsets: footer.SummaryOffsetStart != 0,
+	}
+	if profile.HasSummary || profile.HasSummaryOffsets {
+		opcodes, err := summaryOpcodes(rs, footer)
+		if err != nil {
+			return nil, err
+		}
+		profile.HasStatistics = opcodes[OpStatistics]
+		profile.HasChunkIndex = opcodes[OpChunkIndex]
+	}
+	profile.IsChunked = profile.HasChunkIndex
+	if !profile.IsChunked {
+		profile.IsChunked, err = startsWithChunk(rs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return profile, nil
+}
+
+// summaryOpcodes returns the opcodes of the records in the summary section,
+// read from the summary offset section if there is one.
+func summaryOpcodes(rs io.ReadSeeker, footer *Footer) (map[OpCode]bool, error) {
+	start := footer.SummaryStart
+	if footer.SummaryOffsetStart != 0 {
+		start = footer.SummaryOffsetStart
+	}
+	if _, err := rs.Seek(int64(start), io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek to summary: %w", err)
+	}
+	lexer, err := NewLexer(bufio.NewReader(rs), &LexerOptions{SkipMagic: true, EmitChunks: true})
+	if err != nil {
+		return nil, err
+	}
+	defer lexer.Close()
+	opcodes := make(map[OpCode]bool)
+	for {
+		tokenType, record, err := lexer.Next(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read summary: %w", err)
+		}
+		switch tokenType {
+		case TokenFooter:
+			return opcodes, nil
+		case TokenSummaryOffset:
+			if footer.SummaryOffsetStart == 0 {
+				continue
+			}
+			offset, err := ParseSummaryOffset(record)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse summary offset: %w", err)
+			}
+			opcodes[offset.GroupOpcode] = true
+		case TokenStatistics:
+			opcodes[OpStatistics] = true
+		case TokenChunkIndex:
+			opcodes[OpChunkIndex] = true
+		}
+	}
+}
+
+// startsWithChunk reports whether the data section holds a chunk before any
+// message outside of a chunk.
+func startsWithChunk(rs io.ReadSeeker) (bool, error) {
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return false, fmt.Errorf("failed to seek to start of file: %w", err)
+	}
+	lexer, err := NewLexer(rs, &LexerOptions{EmitChunks: true})
+	if err != nil {
+		return false, err
+	}
+	defer lexer.Close()
+	for {
+		tokenType, _, err := lexer.Next(nil)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return false, nil
+			}
+			return false, fmt.Errorf("failed to read data section: %w", err)
+		}
+		switch tokenType {
+		case TokenChunk:
+			return true, nil
+		case TokenMessage, TokenDataEnd, TokenFooter:
+			return false, nil
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package mcap
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadFileProfile(t *testing.T) {
+	noSummary := WriterOptions{
+		SkipStatistics:           true,
+		SkipRepeatedSchemas:      true,
+		SkipRepeatedChannelInfos: true,
+		SkipAttachmentIndex:      true,
+		SkipMetadataIndex:        true,
+		SkipChunkIndex:           true,
+		SkipSummaryOffsets:       true,
+	}
+	chunkedNoSummary := noSummary
+	chunkedNoSummary.Chunked = true
+	cases := []struct {
+		assertion string
+		opts      WriterOptions
+		expected  FileProfile
+	}{
+		{
+			"chunked",
+			WriterOptions{Chunked: true},
+			FileProfile{
+				HasSummary:        true,
+				HasSummaryOffsets: true,
+				HasStatistics:     true,
+				HasChunkIndex:     true,
+				IsChunked:         true,
+			},
+		},
+		{
+			"unchunked",
+			WriterOptions{},
+			FileProfile{
+				HasSummary:        true,
+				HasSummaryOffsets: true,
+				HasStatistics:     true,
+			},
+		},
+		{
+			"chunked without chunk index",
+			WriterOptions{Chunked: true, SkipChunkIndex: true},
+			FileProfile{
+				HasSummary:        true,
+				HasSummaryOffsets: true,
+				HasStatistics:     true,
+				IsChunked:         true,
+			},
+		},
+		{
+			"without summary offsets",
+			WriterOptions{Chunked: true, SkipSummaryOffsets: true},
+			FileProfile{
+				HasSummary:    true,
+				HasStatistics: true,
+				HasChunkIndex: true,
+				IsChunked:     true,
+			},
+		},
+		{
+			"without statistics",
+			WriterOptions{Chunked: true, SkipStatistics: true},
+			FileProfile{
+				HasSummary:        true,
+				HasSummaryOffsets: true,
+				HasChunkIndex:     true,
+				IsChunked:         true,
+			},
+		},
+		{
+			"without summary",
+			noSummary,
+			FileProfile{},
+		},
+		{
+			"chunked without summary",
+			chunkedNoSummary,
+			FileProfile{IsChunked: true},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			opts := c.opts
+			writer, err := NewWriter(buf, &opts)
+			assert.Nil(t, err)
+			assert.Nil(t, writer.WriteHeader(&Header{Profile: "ros1", Library: "test"}))
+			assert.Nil(t, writer.WriteSchema(&Schema{ID: 1}))
+			assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/foo"}))
+			assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, Data: []byte("hello")}))
+			assert.Nil(t, writer.Close())
+
+			profile, err := ReadFileProfile(bytes.NewReader(buf.Bytes()))
+			assert.Nil(t, err)
+			expected := c.expected
+			expected.Profile = "ros1"
+			expected.Library = "mcap go " + Version + "; test"
+			assert.Equal(t, &expected, profile)
+		})
+	}
+	t.Run("no messages", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		opts := chunkedNoSummary
+		writer, err := NewWriter(buf, &opts)
+		assert.Nil(t, err)
+		assert.Nil(t, writer.WriteHeader(&Header{}))
+		assert.Nil(t, writer.Close())
+		profile, err := ReadFileProfile(bytes.NewReader(buf.Bytes()))
+		assert.Nil(t, err)
+		assert.Equal(t, &FileProfile{Library: "mcap go " + Version}, profile)
+	})
+	t.Run("bad magic", func(t *testing.T) {
+		_, err := ReadFileProfile(bytes.NewReader([]byte("not an mcap file")))
+		assert.Error(t, err)
+	})
+}
@@ -2,13 +2,10 @@ package mcap
 
 import (
 	"bufio"
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
-
-	"github.com/klauspost/compress/zstd"
-	"github.com/pierrec/lz4/v4"
+	"sort"
 )
 
 const (
@@ -22,8 +19,12 @@ type indexedMessageIterator struct {
 	lexer  *Lexer
 	rs     io.ReadSeeker
 	topics map[string]bool
-	start  uint64
-	end    uint64
+	// channelIDs, if set, restricts iteration to the given channels.
+	channelIDs map[uint16]bool
+	start      uint64
+	end        uint64
+	// skipSchemaData leaves the Data of parsed schemas nil.
+	skipSchemaData bool
 
 	channels          map[uint16]*Channel
 	schemas           map[uint16]*Schema
@@ -35,9 +36,15 @@ type indexedMessageIterator struct {
 
 	indexHeap rangeIndexHeap
 
-	zstdDecoder           *zstd.Decoder
-	lz4Reader             *lz4.Reader
+	decompressor chunkDecompressor
+	// decompressionWorkers, if greater than one, is the number of chunks
+	// read ahead and decompressed concurrently by the prefetcher.
+	decompressionWorkers  int
+	prefetcher            *chunkPrefetcher
 	hasReadSummarySection bool
+	// onClose, if set, is called when the iterator releases its
+	// decompressors.
+	onClose func()
 
 	compressedChunkAndMessageIndex []byte
 }
@@ -46,23 +53,10 @@ type indexedMessageIterator struct {
 // related fields of the structure. It must be called prior to any of the other
 // access methods.
 func (it *indexedMessageIterator) parseSummarySection() error {
-	_, err := it.rs.Seek(-8-4-8-8, io.SeekEnd) // magic, plus 20 bytes footer
+	footer, err := ReadFooter(it.rs)
 	if err != nil {
 		return err
 	}
-	buf := make([]byte, 8+20)
-	_, err = io.ReadFull(it.rs, buf)
-	if err != nil {
-		return fmt.Errorf("read error: %w", err)
-	}
-	magic := buf[20:]
-	if !bytes.Equal(magic, Magic) {
-		return fmt.Errorf("not an MCAP file")
-	}
-	footer, err := ParseFooter(buf[:20])
-	if err != nil {
-		return fmt.Errorf("failed to parse footer: %w", err)
-	}
 	it.footer = footer
 
 	// scan the whole summary section
@@ -90,7 +84,7 @@ func (it *indexedMessageIterator) parseSummarySection() error {
 		}
 		switch tokenType {
 		case TokenSchema:
-			schema, err := ParseSchema(record)
+			schema, err := parseSchema(record, it.skipSchemaData)
 			if err != nil {
 				return fmt.Errorf("failed to parse schema: %w", err)
 			}
@@ -100,7 +94,8 @@ func (it *indexedMessageIterator) parseSummarySection() error {
 			if err != nil {
 				return fmt.Errorf("failed to parse channel info: %w", err)
 			}
-			if len(it.topics) == 0 || it.topics[channelInfo.Topic] {
+			if (len(it.topics) == 0 || it.topics[channelInfo.Topic]) &&
+				(it.channelIDs == nil || it.channelIDs[channelInfo.ID]) {
 				it.channels[channelInfo.ID] = channelInfo
 			}
 		case TokenAttachmentIndex:
@@ -149,100 +144,180 @@ func (it *indexedMessageIterator) parseSummarySection() error {
 }
 
 func (it *indexedMessageIterator) loadChunk(chunkIndex *ChunkIndex) error {
-	_, err := it.rs.Seek(int64(chunkIndex.ChunkStartOffset), io.SeekStart)
-	if err != nil {
-		return err
-	}
-
-	compressedChunkLength := chunkIndex.ChunkLength + chunkIndex.MessageIndexLength
-	if len(it.compressedChunkAndMessageIndex) < int(compressedChunkLength) {
-		newSize := int(float64(compressedChunkLength) * chunkBufferGrowthMultiple)
-		it.compressedChunkAndMessageIndex = make([]byte, newSize)
-	}
-	_, err = io.ReadFull(it.rs, it.compressedChunkAndMessageIndex[:compressedChunkLength])
-	if err != nil {
-		return fmt.Errorf("failed to read chunk data: %w", err)
-	}
-	parsedChunk, err := ParseChunk(it.compressedChunkAndMessageIndex[9:chunkIndex.ChunkLength])
-	if err != nil {
-		return fmt.Errorf("failed to parse chunk: %w", err)
-	}
-	// decompress the chunk data
-	var chunkData []byte
-	switch CompressionFormat(parsedChunk.Compression) {
-	case CompressionNone:
-		chunkData = parsedChunk.Records
-	case CompressionZSTD:
-		if it.zstdDecoder == nil {
-			it.zstdDecoder, err = zstd.NewReader(nil)
-			if err != nil {
-				return fmt.Errorf("failed to instantiate zstd decoder: %w", err)
-			}
-		}
-		chunkData = make([]byte, 0, parsedChunk.UncompressedSize)
-		chunkData, err = it.zstdDecoder.DecodeAll(parsedChunk.Records, chunkData)
+	var chunkAndMessageIndex, chunkData []byte
+	var err error
+	if it.prefetcher != nil {
+		chunkAndMessageIndex, chunkData, err = it.prefetcher.get(chunkIndex)
 		if err != nil {
-			return fmt.Errorf("failed to decode chunk data: %w", err)
+			return err
 		}
-	case CompressionLZ4:
-		if it.lz4Reader == nil {
-			it.lz4Reader = lz4.NewReader(bytes.NewReader(parsedChunk.Records))
-		} else {
-			it.lz4Reader.Reset(bytes.NewReader(parsedChunk.Records))
+	} else {
+		chunkAndMessageIndex, err = readChunk(it.rs, chunkIndex, it.compressedChunkAndMessageIndex)
+		if err != nil {
+			return err
 		}
-		chunkData = make([]byte, parsedChunk.UncompressedSize)
-		_, err = io.ReadFull(it.lz4Reader, chunkData)
+		it.compressedChunkAndMessageIndex = chunkAndMessageIndex
+		chunkData, err = decompressChunk(&it.decompressor, chunkIndex, chunkAndMessageIndex)
 		if err != nil {
-			return fmt.Errorf("failed to decompress lz4 chunk: %w", err)
+			return err
 		}
-	default:
-		return fmt.Errorf("unsupported compression %s", parsedChunk.Compression)
 	}
 	// use the message index to find the messages we want from the chunk
-	messageIndexSection := it.compressedChunkAndMessageIndex[chunkIndex.ChunkLength:compressedChunkLength]
-	var recordLen uint64
+	messageIndexSection := chunkAndMessageIndex[chunkIndex.ChunkLength:]
+	if it.channelIDs != nil {
+		// when reading specific channels, go straight to their message indexes
+		// rather than parsing those of every channel in the chunk.
+		sectionStart := chunkIndex.ChunkStartOffset + chunkIndex.ChunkLength
+		for channelID := range it.channels {
+			indexOffset, ok := chunkIndex.MessageIndexOffsets[channelID]
+			if !ok {
+				continue
+			}
+			if indexOffset < sectionStart || indexOffset-sectionStart >= uint64(len(messageIndexSection)) {
+				return fmt.Errorf("message index offset %d for channel %d is outside message index section", indexOffset, channelID)
+			}
+			if _, err := it.pushMessageIndex(chunkIndex, chunkData, messageIndexSection, int(indexOffset-sectionStart)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 	offset := 0
 	for offset < len(messageIndexSection) {
-		if op := OpCode(messageIndexSection[offset]); op != OpMessageIndex {
-			return fmt.Errorf("unexpected token %s in message index section", op)
-		}
-		offset++
-		recordLen, offset, err = getUint64(messageIndexSection, offset)
+		offset, err = it.pushMessageIndex(chunkIndex, chunkData, messageIndexSection, offset)
 		if err != nil {
-			return fmt.Errorf("failed to get message index record length: %w", err)
+			return err
 		}
-		messageIndex, err := ParseMessageIndex(messageIndexSection[offset : uint64(offset)+recordLen])
-		if err != nil {
-			return fmt.Errorf("failed to parse message index: %w", err)
-		}
-		offset += int(recordLen)
-		// skip message indexes for channels we don't need
-		if _, ok := it.channels[messageIndex.ChannelID]; !ok {
-			continue
+	}
+	return nil
+}
+
+// readChunk reads the chunk record of a chunk index, with the message index
+// records that follow it, into buf if its capacity is large enough, or
+// otherwise into a new buffer with room to grow.
+func readChunk(rs io.ReadSeeker, chunkIndex *ChunkIndex, buf []byte) ([]byte, error) {
+	_, err := rs.Seek(int64(chunkIndex.ChunkStartOffset), io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	compressedChunkLength := chunkIndex.ChunkLength + chunkIndex.MessageIndexLength
+	if uint64(cap(buf)) < compressedChunkLength {
+		newSize := int(float64(compressedChunkLength) * chunkBufferGrowthMultiple)
+		buf = make([]byte, newSize)
+	}
+	buf = buf[:compressedChunkLength]
+	_, err = io.ReadFull(rs, buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk data: %w", err)
+	}
+	return buf, nil
+}
+
+// decompressChunk parses the chunk record read by readChunk and returns its
+// decompressed records.
+func decompressChunk(decompressor *chunkDecompressor, chunkIndex *ChunkIndex, chunkAndMessageIndex []byte) ([]byte, error) {
+	parsedChunk, err := ParseChunk(chunkAndMessageIndex[9:chunkIndex.ChunkLength])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse chunk: %w", err)
+	}
+	return decompressor.decompress(parsedChunk)
+}
+
+// pushMessageIndex parses the message index record at offset in the message
+// index section of a chunk and, if the iterator reads its channel, pushes its
+// entries in the requested time range to the heap. It returns the offset of
+// the following record.
+func (it *indexedMessageIterator) pushMessageIndex(
+	chunkIndex *ChunkIndex,
+	chunkData []byte,
+	messageIndexSection []byte,
+	offset int,
+) (int, error) {
+	if op := OpCode(messageIndexSection[offset]); op != OpMessageIndex {
+		return 0, fmt.Errorf("unexpected token %s in message index section", op)
+	}
+	offset++
+	recordLen, offset, err := getUint64(messageIndexSection, offset)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get message index record length: %w", err)
+	}
+	if recordLen > uint64(len(messageIndexSection)-offset) {
+		return 0, fmt.Errorf("message index record length %d: %w", recordLen, ErrLengthOutOfRange)
+	}
+	messageIndex, err := ParseMessageIndex(messageIndexSection[offset : uint64(offset)+recordLen])
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse message index: %w", err)
+	}
+	offset += int(recordLen)
+	// skip message indexes for channels we don't need
+	if _, ok := it.channels[messageIndex.ChannelID]; !ok {
+		return offset, nil
+	}
+	// push any message index entries in the requested time range to the heap
+	// to read. Entries are usually in log time order, in which case the start
+	// of the range is found with a binary search and the scan stops at the
+	// end of the range. If an entry out of order turns up along the way, the
+	// whole index is scanned instead.
+	records := messageIndex.Records
+	first, sorted := seekWithinChunk(records, it.start)
+	last := first
+	for sorted && last < len(records) && records[last].Timestamp < it.end {
+		if last > first && records[last].Timestamp < records[last-1].Timestamp {
+			sorted = false
 		}
-		// push any message index entries in the requested time range to the heap to read.
-		for i := range messageIndex.Records {
-			timestamp := messageIndex.Records[i].Timestamp
-			if timestamp >= it.start && timestamp < it.end {
-				if err := it.indexHeap.HeapPush(rangeIndex{
-					chunkIndex:        chunkIndex,
-					messageIndexEntry: &messageIndex.Records[i],
-					buf:               chunkData,
-				}); err != nil {
-					return err
-				}
+		last++
+	}
+	if !sorted {
+		first, last = 0, len(records)
+	}
+	for i := first; i < last; i++ {
+		timestamp := records[i].Timestamp
+		if timestamp >= it.start && timestamp < it.end {
+			if err := it.indexHeap.HeapPush(rangeIndex{
+				chunkIndex:        chunkIndex,
+				messageIndexEntry: &records[i],
+				buf:               chunkData,
+			}); err != nil {
+				return 0, err
 			}
 		}
 	}
-	return nil
+	return offset, nil
 }
 
 func (it *indexedMessageIterator) Next(_ []byte) (*Schema, *Channel, *Message, error) {
+	schema, channel, message, err := it.next()
+	if err != nil {
+		it.close()
+	}
+	return schema, channel, message, err
+}
+
+// close releases the decoders of the iterator's decompressors. It is called
+// when iteration ends, and by Reader.Close for iterators left unfinished.
+func (it *indexedMessageIterator) close() {
+	if it.prefetcher != nil {
+		it.prefetcher.close()
+		it.prefetcher = nil
+	}
+	it.decompressor.Close()
+	if it.onClose != nil {
+		it.onClose()
+	}
+}
+
+func (it *indexedMessageIterator) next() (*Schema, *Channel, *Message, error) {
 	if !it.hasReadSummarySection {
 		err := it.parseSummarySection()
 		if err != nil {
 			return nil, nil, nil, err
 		}
+		if it.decompressionWorkers > 1 {
+			it.prefetcher, err = newChunkPrefetcher(it.rs, &it.indexHeap, it.decompressionWorkers)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+		}
 	}
 	for it.indexHeap.Len() > 0 {
 		ri, err := it.indexHeap.HeapPop()
@@ -269,3 +344,21 @@ func (it *indexedMessageIterator) Next(_ []byte) (*Schema, *Channel, *Message, e
 	}
 	return nil, nil, nil, io.EOF
 }
+
+// seekWithinChunk returns the position of the first entry of a message index
+// logged at or after target, or len(records) if there is none, by binary
+// search on the assumption that the entries are sorted by log time. It
+// returns false if any entry the search visits is out of order with its
+// neighbours, in which case the position is meaningless.
+func seekWithinChunk(records []MessageIndexEntry, target uint64) (int, bool) {
+	sorted := true
+	position := sort.Search(len(records), func(i int) bool {
+		timestamp := records[i].Timestamp
+		if i > 0 && records[i-1].Timestamp > timestamp ||
+			i < len(records)-1 && records[i+1].Timestamp < timestamp {
+			sorted = false
+		}
+		return timestamp >= target
+	})
+	return position, sorted
+}
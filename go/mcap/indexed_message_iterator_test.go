@@ -0,0 +1,161 @@
+package mcap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/foxglove/mcap/go/mcap/readopts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeekWithinChunk(t *testing.T) {
+	records := []MessageIndexEntry{
+		{Timestamp: 10, Offset: 0},
+		{Timestamp: 20, Offset: 30},
+		{Timestamp: 20, Offset: 60},
+		{Timestamp: 30, Offset: 90},
+	}
+	cases := []struct {
+		assertion string
+		target    uint64
+		expected  int
+	}{
+		{"before first", 0, 0},
+		{"at first", 10, 0},
+		{"between entries", 15, 1},
+		{"first of duplicates", 20, 1},
+		{"at last", 30, 3},
+		{"after last", 31, 4},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			position, sorted := seekWithinChunk(records, c.target)
+			assert.True(t, sorted)
+			assert.Equal(t, c.expected, position)
+		})
+	}
+	t.Run("empty", func(t *testing.T) {
+		position, sorted := seekWithinChunk(nil, 10)
+		assert.True(t, sorted)
+		assert.Equal(t, 0, position)
+	})
+	t.Run("out of order", func(t *testing.T) {
+		_, sorted := seekWithinChunk([]MessageIndexEntry{
+			{Timestamp: 10},
+			{Timestamp: 40},
+			{Timestamp: 20},
+			{Timestamp: 30},
+		}, 25)
+		assert.False(t, sorted)
+	})
+}
+
+func TestIndexedReadTimeRangeWithinChunk(t *testing.T) {
+	cases := []struct {
+		assertion string
+		logTimes  []uint64
+	}{
+		{"messages in log time order", []uint64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
+		{"messages out of log time order", []uint64{10, 3, 7, 1, 9, 2, 8, 4, 6, 5}},
+		{"one message out of log time order", []uint64{1, 2, 3, 5, 4, 6, 7, 8, 9, 10}},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			writer, err := NewWriter(buf, &WriterOptions{
+				Chunked:   true,
+				ChunkSize: 1024 * 1024,
+			})
+			assert.Nil(t, err)
+			assert.Nil(t, writer.WriteHeader(&Header{}))
+			assert.Nil(t, writer.WriteSchema(&Schema{ID: 1}))
+			assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/foo"}))
+			for _, logTime := range c.logTimes {
+				assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, LogTime: logTime}))
+			}
+			assert.Nil(t, writer.Close())
+
+			reader, err := NewReader(bytes.NewReader(buf.Bytes()))
+			assert.Nil(t, err)
+			it, err := reader.Messages(
+				readopts.UsingIndex(true),
+				readopts.After(4),
+				readopts.Before(8),
+			)
+			assert.Nil(t, err)
+			var logTimes []uint64
+			for {
+				_, _, message, err := it.Next(nil)
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				assert.Nil(t, err)
+				logTimes = append(logTimes, message.LogTime)
+			}
+			assert.ElementsMatch(t, []uint64{4, 5, 6, 7}, logTimes)
+		})
+	}
+}
+
+func TestDecompressionWorkers(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{
+		Chunked:           true,
+		Compression:       CompressionZSTD,
+		ChunkMessageLimit: 7,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.WriteSchema(&Schema{ID: 1}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/foo"}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 2, SchemaID: 1, Topic: "/bar"}))
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, writer.WriteMessage(&Message{
+			ChannelID: uint16(i%2 + 1),
+			Sequence:  uint32(i),
+			LogTime:   uint64((i * 37) % 100),
+			Data:      []byte{byte(i)},
+		}))
+	}
+	assert.Nil(t, writer.Close())
+
+	read := func(t *testing.T, opts ...readopts.ReadOpt) []uint32 {
+		reader, err := NewReader(bytes.NewReader(buf.Bytes()))
+		assert.Nil(t, err)
+		it, err := reader.Messages(opts...)
+		assert.Nil(t, err)
+		sequences := []uint32{}
+		for {
+			_, _, message, err := it.Next(nil)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, []byte{byte(message.Sequence)}, message.Data)
+			sequences = append(sequences, message.Sequence)
+		}
+		return sequences
+	}
+	for _, order := range []readopts.ReadOrder{
+		readopts.FileOrder,
+		readopts.LogTimeOrder,
+		readopts.ReverseLogTimeOrder,
+	} {
+		for _, workers := range []int{2, 4, 32} {
+			t.Run(fmt.Sprintf("order %d with %d workers", order, workers), func(t *testing.T) {
+				expected := read(t, readopts.InOrder(order), readopts.After(10), readopts.Before(90))
+				assert.Len(t, expected, 80)
+				actual := read(t,
+					readopts.InOrder(order),
+					readopts.After(10),
+					readopts.Before(90),
+					readopts.DecompressionWorkers(workers),
+				)
+				assert.Equal(t, expected, actual)
+			})
+		}
+	}
+}
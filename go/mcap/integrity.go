@@ -0,0 +1,208 @@
+package mcap
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// CRCStatus is the outcome of checking one CRC with VerifyIntegrity.
+type CRCStatus int
+
+const (
+	// CRCNotPresent indicates the CRC was zero, meaning the writer did not
+	// compute one, so the data it would cover could not be checked.
+	CRCNotPresent CRCStatus = iota
+	// CRCValid indicates the CRC matched the data it covers.
+	CRCValid
+	// CRCMismatch indicates the CRC did not match the data it covers.
+	CRCMismatch
+)
+
+// String converts a CRC status to its string representation.
+func (s CRCStatus) String() string {
+	switch s {
+	case CRCNotPresent:
+		return "not present"
+	case CRCValid:
+		return "valid"
+	case CRCMismatch:
+		return "mismatch"
+	default:
+		return "unknown"
+	}
+}
+
+// CRCCheck is the result of checking one CRC. Expected is the CRC recorded in
+// the file, and Actual the CRC computed from its data, which is zero if the
+// CRC was not present.
+type CRCCheck struct {
+	Status   CRCStatus
+	Expected uint32
+	Actual   uint32
+}
+
+// ChunkCRCCheck is the result of checking the CRC of a chunk at Offset.
+type ChunkCRCCheck struct {
+	Offset uint64
+	CRCCheck
+}
+
+// IntegrityReport describes the CRCs checked by VerifyIntegrity.
+type IntegrityReport struct {
+	// Chunks holds the result for each chunk in the data section, in file
+	// order.
+	Chunks []ChunkCRCCheck
+	// DataSection is the result for the data section CRC of the data end
+	// record.
+	DataSection CRCCheck
+	// Summary is the result for the summary CRC of the footer.
+	Summary CRCCheck
+}
+
+// OK reports whether no CRC in the report was mismatched. CRCs that were not
+// present do not count against the file.
+func (r *IntegrityReport) OK() bool {
+	for _, chunk := range r.Chunks {
+		if chunk.Status == CRCMismatch {
+			return false
+		}
+	}
+	return r.DataSection.Status != CRCMismatch && r.Summary.Status != CRCMismatch
+}
+
+// VerifyIntegrity checks every CRC of an MCAP file: the uncompressed CRC of
+// each chunk, the data section CRC of the data end record, and the summary
+// CRC of the footer. The footer is read first to locate the data end record.
+// The data section is then read in a single forward pass, decompressing each
+// chunk to check its CRC, and the summary CRC is checked with a read of the
+// end of the file.
+//
+// CRCs that do not match, and CRCs of zero, which writers use to indicate no
+// CRC was computed, are reported in the returned report rather than as
+// errors. An error is returned if the file cannot be read or is malformed.
+func VerifyIntegrity(rs io.ReadSeeker) (*IntegrityReport, error) {
+	footer, err := ReadFooter(rs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read footer: %w", err)
+	}
+	footerStart, err := rs.Seek(-int64(SizeRecordPrefix+SizeFooter+SizeMagic), io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to seek to footer: %w", err)
+	}
+	dataEndOffset := uint64(footerStart)
+	if footer.SummaryStart != 0 {
+		dataEndOffset = footer.SummaryStart
+	}
+	if dataEndOffset < SizeMagic+SizeRecordPrefix+SizeDataEnd {
+		return nil, fmt.Errorf("file too short for data end record")
+	}
+	dataEndOffset -= SizeRecordPrefix + SizeDataEnd
+
+	report := &IntegrityReport{}
+	if err := report.verifyDataSection(rs, dataEndOffset); err != nil {
+		return nil, err
+	}
+	if footer.SummaryCRC != 0 {
+		actual, err := computeSummaryCRC(rs, footer)
+		if err != nil {
+			return nil, err
+		}
+		report.Summary = newCRCCheck(footer.SummaryCRC, actual)
+	}
+	return report, nil
+}
+
+func newCRCCheck(expected, actual uint32) CRCCheck {
+	status := CRCValid
+	if actual != expected {
+		status = CRCMismatch
+	}
+	return CRCCheck{Status: status, Expected: expected, Actual: actual}
+}
+
+// verifyDataSection reads the data section from the start of the file,
+// checking the CRC of each chunk and computing the CRC of the bytes preceding
+// the data end record at dataEndOffset.
+func (r *IntegrityReport) verifyDataSection(rs io.ReadSeeker, dataEndOffset uint64) error {
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek to start of file: %w", err)
+	}
+	hashed := &crcPrefixReader{r: rs, remaining: dataEndOffset}
+	lexer, err := NewLexer(hashed, &LexerOptions{EmitChunks: true})
+	if err != nil {
+		return err
+	}
+	defer lexer.Close()
+	var decompressor chunkDecompressor
+	defer decompressor.Close()
+	var buf []byte
+	for {
+		tokenType, record, offset, err := lexer.NextWithOffset(buf)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("file has no data end record")
+			}
+			return fmt.Errorf("failed to read data section: %w", err)
+		}
+		if len(record) > len(buf) {
+			buf = record
+		}
+		switch tokenType {
+		case TokenChunk:
+			check, err := verifyChunkCRC(&decompressor, record)
+			if err != nil {
+				return fmt.Errorf("failed to check chunk at offset %d: %w", offset, err)
+			}
+			r.Chunks = append(r.Chunks, ChunkCRCCheck{Offset: offset, CRCCheck: check})
+		case TokenDataEnd:
+			if offset != dataEndOffset {
+				return fmt.Errorf("data end record at offset %d, expected %d", offset, dataEndOffset)
+			}
+			dataEnd, err := ParseDataEnd(record)
+			if err != nil {
+				return fmt.Errorf("failed to parse data end: %w", err)
+			}
+			if dataEnd.DataSectionCRC != 0 {
+				r.DataSection = newCRCCheck(dataEnd.DataSectionCRC, hashed.crc)
+			}
+			return nil
+		}
+	}
+}
+
+// verifyChunkCRC decompresses a chunk and checks its uncompressed CRC.
+func verifyChunkCRC(decompressor *chunkDecompressor, record []byte) (CRCCheck, error) {
+	chunk, err := ParseChunk(record)
+	if err != nil {
+		return CRCCheck{}, err
+	}
+	if chunk.UncompressedCRC == 0 {
+		return CRCCheck{}, nil
+	}
+	data, err := decompressor.decompress(chunk)
+	if err != nil {
+		return CRCCheck{}, err
+	}
+	return newCRCCheck(chunk.UncompressedCRC, crc32.ChecksumIEEE(data)), nil
+}
+
+// crcPrefixReader computes the CRC of the first bytes read through it, up to
+// remaining.
+type crcPrefixReader struct {
+	r         io.Reader
+	remaining uint64
+	crc       uint32
+}
+
+func (c *crcPrefixReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	hashed := uint64(n)
+	if hashed > c.remaining {
+		hashed = c.remaining
+	}
+	c.crc = crc32.Update(c.crc, crc32.IEEETable, p[:hashed])
+	c.remaining -= hashed
+	return n, err
+}
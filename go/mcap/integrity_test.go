@@ -0,0 +1,91 @@
+package mcap
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyIntegrity(t *testing.T) {
+	writeFile := func(includeCRC bool) ([]byte, *Writer) {
+		buf := &bytes.Buffer{}
+		writer, err := NewWriter(buf, &WriterOptions{
+			Chunked:     true,
+			ChunkSize:   256,
+			Compression: CompressionNone,
+			IncludeCRC:  includeCRC,
+		})
+		assert.Nil(t, err)
+		assert.Nil(t, writer.WriteHeader(&Header{}))
+		assert.Nil(t, writer.WriteSchema(&Schema{ID: 1, Name: "schema", Encoding: "jsonschema"}))
+		assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/topic"}))
+		for i := 0; i < 20; i++ {
+			assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, LogTime: uint64(i), Data: []byte("hello")}))
+		}
+		assert.Nil(t, writer.Close())
+		return buf.Bytes(), writer
+	}
+	statuses := func(report *IntegrityReport) []CRCStatus {
+		var result []CRCStatus
+		for _, chunk := range report.Chunks {
+			result = append(result, chunk.Status)
+		}
+		return result
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		data, writer := writeFile(true)
+		report, err := VerifyIntegrity(bytes.NewReader(data))
+		assert.Nil(t, err)
+		assert.True(t, report.OK())
+		assert.Len(t, report.Chunks, len(writer.ChunkIndexes))
+		for i, chunk := range report.Chunks {
+			assert.Equal(t, CRCValid, chunk.Status)
+			assert.Equal(t, writer.ChunkIndexes[i].ChunkStartOffset, chunk.Offset)
+		}
+		assert.Equal(t, CRCValid, report.DataSection.Status)
+		assert.Equal(t, CRCValid, report.Summary.Status)
+	})
+	t.Run("CRCs not present", func(t *testing.T) {
+		data, writer := writeFile(false)
+		report, err := VerifyIntegrity(bytes.NewReader(data))
+		assert.Nil(t, err)
+		assert.True(t, report.OK())
+		assert.Len(t, report.Chunks, len(writer.ChunkIndexes))
+		for _, chunk := range report.Chunks {
+			assert.Equal(t, CRCNotPresent, chunk.Status)
+		}
+		assert.Equal(t, CRCNotPresent, report.DataSection.Status)
+		assert.Equal(t, CRCNotPresent, report.Summary.Status)
+	})
+	t.Run("corrupted chunk", func(t *testing.T) {
+		data, writer := writeFile(true)
+		// the last byte of the second chunk is message data
+		second := writer.ChunkIndexes[1]
+		data[second.ChunkStartOffset+second.ChunkLength-1] ^= 0xff
+		report, err := VerifyIntegrity(bytes.NewReader(data))
+		assert.Nil(t, err)
+		assert.False(t, report.OK())
+		expected := make([]CRCStatus, len(writer.ChunkIndexes))
+		for i := range expected {
+			expected[i] = CRCValid
+		}
+		expected[1] = CRCMismatch
+		assert.Equal(t, expected, statuses(report))
+		assert.Equal(t, CRCMismatch, report.DataSection.Status)
+		assert.NotEqual(t, report.DataSection.Expected, report.DataSection.Actual)
+		assert.Equal(t, CRCValid, report.Summary.Status)
+	})
+	t.Run("corrupted summary", func(t *testing.T) {
+		data, _ := writeFile(true)
+		footer, err := ReadFooter(bytes.NewReader(data))
+		assert.Nil(t, err)
+		data[footer.SummaryStart+SizeRecordPrefix] ^= 0xff
+		report, err := VerifyIntegrity(bytes.NewReader(data))
+		assert.Nil(t, err)
+		assert.False(t, report.OK())
+		assert.Equal(t, CRCValid, report.DataSection.Status)
+		assert.Equal(t, CRCMismatch, report.Summary.Status)
+	})
+}
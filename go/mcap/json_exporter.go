@@ -0,0 +1,89 @@
+package mcap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MessageDecoder decodes message payloads into values that may be marshaled
+// as JSON.
+type MessageDecoder interface {
+	Decode(schema *Schema, data []byte) (any, error)
+}
+
+type jsonExportedMessage struct {
+	Topic       string `json:"topic"`
+	Sequence    uint32 `json:"sequence"`
+	LogTime     uint64 `json:"log_time"`
+	PublishTime uint64 `json:"publish_time"`
+	Data        any    `json:"data"`
+}
+
+// JSONExporter writes messages to an io.Writer as JSON lines, one object per
+// message with the fields "topic", "sequence", "log_time", "publish_time" and
+// "data".
+type JSONExporter struct {
+	encoder *json.Encoder
+	decoder MessageDecoder
+
+	// OnDecodeError, if set, is called when the decoder fails on a message's
+	// data, so that a few malformed messages need not abort a long export.
+	// If it returns true, the message is written with its data undecoded, as
+	// a base64-encoded string, and the export continues; otherwise the
+	// decode error is returned. Errors reading or writing messages are always
+	// returned.
+	OnDecodeError func(message *Message, err error) bool
+}
+
+// NewJSONExporter returns a JSONExporter writing to w. If decoder is nil,
+// message data is written as a base64-encoded string.
+func NewJSONExporter(w io.Writer, decoder MessageDecoder) *JSONExporter {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	return &JSONExporter{
+		encoder: encoder,
+		decoder: decoder,
+	}
+}
+
+// WriteMessage writes a single message as a line of JSON.
+func (e *JSONExporter) WriteMessage(schema *Schema, channel *Channel, message *Message) error {
+	var data any = message.Data
+	if e.decoder != nil {
+		decoded, err := e.decoder.Decode(schema, message.Data)
+		switch {
+		case err == nil:
+			data = decoded
+		case e.OnDecodeError != nil && e.OnDecodeError(message, err):
+			// write the message undecoded
+		default:
+			return fmt.Errorf("failed to decode message on %s: %w", channel.Topic, err)
+		}
+	}
+	return e.encoder.Encode(jsonExportedMessage{
+		Topic:       channel.Topic,
+		Sequence:    message.Sequence,
+		LogTime:     message.LogTime,
+		PublishTime: message.PublishTime,
+		Data:        data,
+	})
+}
+
+// Export writes every message from it as a line of JSON, returning nil once
+// the iterator is exhausted.
+func (e *JSONExporter) Export(it MessageIterator) error {
+	for {
+		schema, channel, message, err := it.Next(nil)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if err := e.WriteMessage(schema, channel, message); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package mcap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type jsonTestDecoder struct{}
+
+func (jsonTestDecoder) Decode(_ *Schema, data []byte) (any, error) {
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("invalid JSON")
+	}
+	return json.RawMessage(data), nil
+}
+
+func writeJSONExporterTestFile(t *testing.T, data ...[]byte) []byte {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{Chunked: true, ChunkSize: 1024})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.WriteSchema(&Schema{ID: 1}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/foo"}))
+	for i, d := range data {
+		assert.Nil(t, writer.WriteMessage(&Message{
+			ChannelID:   1,
+			Sequence:    uint32(i),
+			LogTime:     uint64(i + 10),
+			PublishTime: uint64(i + 20),
+			Data:        d,
+		}))
+	}
+	assert.Nil(t, writer.Close())
+	return buf.Bytes()
+}
+
+func TestJSONExporter(t *testing.T) {
+	cases := []struct {
+		assertion string
+		data      [][]byte
+		decoder   MessageDecoder
+		expected  string
+		err       string
+	}{
+		{
+			"raw data",
+			[][]byte{[]byte("hello")},
+			nil,
+			`{"topic":"/foo","sequence":0,"log_time":10,"publish_time":20,"data":"aGVsbG8="}` + "\n",
+			"",
+		},
+		{
+			"decoded data",
+			[][]byte{[]byte(`{"a":1}`), []byte(`[true]`)},
+			jsonTestDecoder{},
+			`{"topic":"/foo","sequence":0,"log_time":10,"publish_time":20,"data":{"a":1}}` + "\n" +
+				`{"topic":"/foo","sequence":1,"log_time":11,"publish_time":21,"data":[true]}` + "\n",
+			"",
+		},
+		{
+			"decode error",
+			[][]byte{[]byte("hello")},
+			jsonTestDecoder{},
+			"",
+			"failed to decode message on /foo: invalid JSON",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			reader, err := NewReader(bytes.NewReader(writeJSONExporterTestFile(t, c.data...)))
+			assert.Nil(t, err)
+			it, err := reader.Messages()
+			assert.Nil(t, err)
+			output := &bytes.Buffer{}
+			err = NewJSONExporter(output, c.decoder).Export(it)
+			if c.err != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), c.err)
+				}
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, c.expected, output.String())
+		})
+	}
+}
+
+func TestJSONExporterOnDecodeError(t *testing.T) {
+	data := writeJSONExporterTestFile(t, []byte(`{"a":1}`), []byte("hello"), []byte(`[true]`))
+	for _, resume := range []bool{true, false} {
+		t.Run(fmt.Sprintf("continue %v", resume), func(t *testing.T) {
+			reader, err := NewReader(bytes.NewReader(data))
+			assert.Nil(t, err)
+			it, err := reader.Messages()
+			assert.Nil(t, err)
+			output := &bytes.Buffer{}
+			exporter := NewJSONExporter(output, jsonTestDecoder{})
+			failed := []uint32{}
+			exporter.OnDecodeError = func(message *Message, err error) bool {
+				assert.EqualError(t, err, "invalid JSON")
+				failed = append(failed, message.Sequence)
+				return resume
+			}
+			err = exporter.Export(it)
+			assert.Equal(t, []uint32{1}, failed)
+			if !resume {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), "failed to decode message on /foo: invalid JSON")
+				}
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t,
+				`{"topic":"/foo","sequence":0,"log_time":10,"publish_time":20,"data":{"a":1}}`+"\n"+
+					`{"topic":"/foo","sequence":1,"log_time":11,"publish_time":21,"data":"aGVsbG8="}`+"\n"+
+					`{"topic":"/foo","sequence":2,"log_time":12,"publish_time":22,"data":[true]}`+"\n",
+				output.String(),
+			)
+		})
+	}
+}
@@ -1,12 +1,17 @@
 package mcap
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
+	"math"
+	"sync/atomic"
 
 	"github.com/klauspost/compress/zstd"
 	"github.com/pierrec/lz4/v4"
@@ -18,6 +23,31 @@ var ErrChunkTooLarge = errors.New("chunk exceeds configured maximum size")
 var ErrRecordTooLarge = errors.New("record exceeds configured maximum size")
 var ErrInvalidZeroOpcode = errors.New("invalid zero opcode")
 
+// ErrInvalidOpcode indicates ReadRecordAt found a record whose opcode has no
+// token type.
+var ErrInvalidOpcode = errors.New("invalid opcode for token")
+
+// ErrStopIteration may be returned by the function passed to ForEach to stop
+// iteration without error.
+var ErrStopIteration = errors.New("stop iteration")
+
+// ErrChunkCRCMissing indicates a chunk has no uncompressed CRC, when the
+// lexer requires one.
+var ErrChunkCRCMissing = errors.New("chunk CRC missing")
+
+// ErrRecordTooShort indicates a record is shorter than the fixed-size portion
+// of its opcode's layout.
+var ErrRecordTooShort = errors.New("record shorter than minimum length")
+
+// ErrTruncatedFile indicates the input ended part way through a record or
+// chunk, as when a recording is interrupted. It is returned by lexers with
+// TolerateTruncation set, after all records read before the truncation.
+var ErrTruncatedFile = errors.New("file is truncated")
+
+// ErrNotAtChunk indicates a lexer created with NewLexerAt was not positioned
+// at a chunk record.
+var ErrNotAtChunk = errors.New("expected reader to be positioned at a chunk record")
+
 type errInvalidChunkCrc struct {
 	expected uint32
 	actual   uint32
@@ -27,6 +57,26 @@ func (e *errInvalidChunkCrc) Error() string {
 	return fmt.Sprintf("invalid chunk CRC: %x != %x", e.actual, e.expected)
 }
 
+// ErrInvalidAttachmentCRC indicates an attachment record failed CRC validation.
+type ErrInvalidAttachmentCRC struct {
+	expected uint32
+	actual   uint32
+}
+
+func (e *ErrInvalidAttachmentCRC) Error() string {
+	return fmt.Sprintf("invalid attachment CRC: %x != %x", e.actual, e.expected)
+}
+
+// ErrInvalidSummaryCRC indicates the summary section failed CRC validation.
+type ErrInvalidSummaryCRC struct {
+	expected uint32
+	actual   uint32
+}
+
+func (e *ErrInvalidSummaryCRC) Error() string {
+	return fmt.Sprintf("invalid summary CRC: %x != %x", e.actual, e.expected)
+}
+
 type ErrTruncatedRecord struct {
 	opcode      OpCode
 	actualLen   int
@@ -55,6 +105,39 @@ func (e *ErrTruncatedRecord) Unwrap() error {
 	return io.ErrUnexpectedEOF
 }
 
+// LexError describes a failure to read or parse a record, with the context of
+// the record being read. Errors from Next other than io.EOF at the clean end
+// of input and context errors are returned as a *LexError.
+type LexError struct {
+	// Opcode is the opcode of the record being read. It is OpReserved if the
+	// failure occurred before an opcode was read.
+	Opcode OpCode
+	// RecordLength is the declared length of the record, or -1 if the
+	// failure occurred before the length was read.
+	RecordLength int64
+	// Offset is the file offset of the record. For records within a chunk, it
+	// is the offset of the enclosing chunk.
+	Offset uint64
+	Err    error
+}
+
+func (e *LexError) Error() string {
+	switch {
+	case e.Opcode == OpReserved:
+		return fmt.Sprintf("failed reading record at offset %d: %v", e.Offset, e.Err)
+	case e.RecordLength < 0:
+		return fmt.Sprintf("failed reading %s record at offset %d: %v", e.Opcode, e.Offset, e.Err)
+	default:
+		return fmt.Sprintf(
+			"failed reading %s record of %d bytes at offset %d: %v", e.Opcode, e.RecordLength, e.Offset, e.Err,
+		)
+	}
+}
+
+func (e *LexError) Unwrap() error {
+	return e.Err
+}
+
 // ErrBadMagic indicates the lexer has detected invalid magic bytes.
 type ErrBadMagic struct {
 	actual []byte
@@ -97,6 +180,9 @@ const (
 	TokenError
 	// TokenInvalidChunk represents a chunk token that failed CRC validation.
 	TokenInvalidChunk
+	// TokenUnknown represents a record with an opcode not defined by the
+	// specification, emitted under LexerOptions.EmitUnknownRecords.
+	TokenUnknown
 )
 
 // TokenType encodes a type of token from the lexer.
@@ -135,6 +221,8 @@ func (t TokenType) String() string {
 		return "error"
 	case TokenInvalidChunk:
 		return "invalid chunk"
+	case TokenUnknown:
+		return "unknown record"
 	default:
 		return "unknown"
 	}
@@ -153,27 +241,476 @@ type Lexer struct {
 	buf                      []byte
 	uncompressedChunk        []byte
 	validateChunkCRCs        bool
+	streamChunkCRCs          bool
 	computeAttachmentCRCs    bool
+	validateAttachmentCRCs   bool
 	emitInvalidChunks        bool
 	maxRecordSize            int
+	maxScratchSize           int
+	scratch                  []byte
 	maxDecompressedChunkSize int
 	attachmentCallback       func(*AttachmentReader) error
 	decompressors            map[CompressionFormat]ResettableReader
+	zstdDictionaries         [][]byte
+	zstdConcurrency          int
+	requireChunkCRC          bool
+	tolerateTruncation       bool
+	decoderPool              *DecoderPool
+	zstdMaxMemory            uint64
+	expectChunk              bool
+	validateRecordLengths    bool
+	checksum                 func([]byte) uint32
+	skipBadChunks            bool
+	badChunkCallback         func(uint64, error)
+	// buffered is the lexer's internal read buffer, if any, and seeker the
+	// underlying reader when it can be seeked.
+	buffered *bufio.Reader
+	seeker   io.ReadSeeker
+	// input counts the bytes read from the underlying reader, and
+	// decompressed the bytes produced by chunk decoders.
+	input        *countingReader
+	decompressed countingReader
+	// closeOuter releases the decompressor of a compressed input under
+	// AutoDecompressOuter.
+	closeOuter func()
+	// chunkReader reads the remaining bytes of the chunk record being lexed.
+	chunkReader    *chunkReader
+	ctx            atomic.Value
+	chunkStartTime uint64
+	chunkEndTime   uint64
+	// allowLegacyLZ4 permits chunks of raw LZ4 blocks, which are read whole
+	// into compressedChunk.
+	allowLegacyLZ4  bool
+	lz4Prefix       [4]byte
+	compressedChunk []byte
+	// tokenInChunk records whether the most recent record was read from a
+	// chunk, since inChunk is cleared at the end of the chunk.
+	tokenInChunk bool
+	// lastOpcode is the opcode of the most recent record.
+	lastOpcode         OpCode
+	emitUnknownRecords bool
+	// emitOpcodes, if not nil, holds the opcodes of the records to return.
+	emitOpcodes map[OpCode]bool
+
+	// chunkCRC computes the CRC of the records of the current chunk under
+	// StreamChunkCRCs, to be checked against chunkExpectedCRC at its end.
+	chunkCRC         *crcReader
+	chunkExpectedCRC uint32
+	checkChunkCRC    bool
+
+	// recovering is set under Recover, reporting discarded input to
+	// onRecover. inputSize is the size of the input if it is seekable, or zero
+	// if not. recoveredEOF is set once lexing has ended at a truncated record.
+	recovering   bool
+	onRecover    func(Recovery)
+	inputSize    uint64
+	recoveredEOF bool
+
+	// offset is the offset of the next top-level record, relative to the
+	// reader position when the lexer was created. chunkOffset is the offset of
+	// the next record within the current chunk's decompressed data.
+	offset      uint64
+	chunkOffset uint64
+	// recordOffset is the file offset of the most recently read record, or of
+	// its enclosing chunk, and recordChunkOffset its offset within the chunk.
+	// chunkRecordOffset is the file offset of the current chunk.
+	recordOffset      uint64
+	recordChunkOffset uint64
+	chunkRecordOffset uint64
+	chunkLength       uint64
+	// chunkUncompressedSize is the declared uncompressed size of the current
+	// chunk, and chunkProgress the decompressed bytes last reported to
+	// onChunkProgress.
+	onChunkProgress       func(bytesDecompressed, totalUncompressed uint64)
+	chunkUncompressedSize uint64
+	chunkProgress         uint64
+	// order checks record ordering under StrictOrdering.
+	order *orderChecker
+	// current describes the record being read, for errors returned by Next.
+	current      LexError
+	keepHistory  int
+	history      []RecordRef
+	historyStart int
+}
+
+// RecordRef identifies a record read by the lexer.
+type RecordRef struct {
+	OpCode OpCode
+	// Offset is the offset of the record's opcode byte, relative to the start
+	// of the lexer's input. For records inside a chunk, it is relative to the
+	// start of the chunk's decompressed data.
+	Offset uint64
+	// Length is the length of the record, excluding the opcode and length prefix.
+	Length  uint64
+	InChunk bool
+}
+
+// String formats the record reference for display.
+func (r RecordRef) String() string {
+	if r.InChunk {
+		return fmt.Sprintf("%s of length %d at chunk offset %d", r.OpCode, r.Length, r.Offset)
+	}
+	return fmt.Sprintf("%s of length %d at offset %d", r.OpCode, r.Length, r.Offset)
+}
+
+// History returns references to the most recent records read by the lexer,
+// oldest first, up to the limit set by LexerOptions.KeepHistory. Records are
+// included once their opcode and length have been read, so after an error
+// the final entry may be the record that could not be read. This includes
+// chunks and attachments, and records with unrecognized opcodes.
+func (l *Lexer) History() []RecordRef {
+	result := make([]RecordRef, 0, len(l.history))
+	result = append(result, l.history[l.historyStart:]...)
+	return append(result, l.history[:l.historyStart]...)
+}
+
+func (l *Lexer) pushHistory(ref RecordRef) {
+	if l.keepHistory <= 0 {
+		return
+	}
+	if len(l.history) < l.keepHistory {
+		l.history = append(l.history, ref)
+		return
+	}
+	l.history[l.historyStart] = ref
+	l.historyStart = (l.historyStart + 1) % l.keepHistory
+}
+
+// minRecordLengths lists the minimum record length for each opcode, being the
+// size of the record's fixed-width fields plus the length prefixes of its
+// variable-width fields.
+var minRecordLengths = map[OpCode]uint64{
+	OpHeader:          4 + 4,                             // profile, library
+	OpFooter:          8 + 8 + 4,                         // summary start, summary offset start, summary crc
+	OpSchema:          2 + 4 + 4 + 4,                     // id, name, encoding, data
+	OpChannel:         2 + 2 + 4 + 4 + 4,                 // id, schema id, topic, message encoding, metadata
+	OpMessage:         2 + 4 + 8 + 8,                     // channel id, sequence, log time, publish time
+	OpChunk:           8 + 8 + 8 + 4 + 4 + 8,             // start, end, size, crc, compression, records
+	OpMessageIndex:    2 + 4,                             // channel id, records
+	OpChunkIndex:      8 + 8 + 8 + 8 + 4 + 8 + 4 + 8 + 8, // times, offset, length, index offsets, index length, compression, sizes
+	OpAttachment:      8 + 8 + 4 + 4 + 8 + 4,             // log time, create time, name, media type, data, crc
+	OpAttachmentIndex: 8 + 8 + 8 + 8 + 8 + 4 + 4,         // offset, length, log time, create time, data size, name, media type
+	OpStatistics:      8 + 2 + 4 + 4 + 4 + 4 + 8 + 8 + 4, // counts, times, channel message counts
+	OpMetadata:        4 + 4,                             // name, metadata
+	OpMetadataIndex:   8 + 8 + 4,                         // offset, length, name
+	OpSummaryOffset:   1 + 8 + 8,                         // group opcode, group start, group length
+	OpDataEnd:         4,                                 // data section crc
+}
+
+// CurrentChunkTimeRange returns the message start and end times declared by
+// the chunk from which the lexer is currently reading records, as an aid to
+// progress reporting. ok is false if the lexer is not inside a chunk, as when
+// reading records outside of any chunk or when emitting chunks whole.
+func (l *Lexer) CurrentChunkTimeRange() (start, end uint64, ok bool) {
+	if !l.inChunk {
+		return 0, 0, false
+	}
+	return l.chunkStartTime, l.chunkEndTime, true
+}
+
+// LastOpcode returns the opcode of the record most recently returned by Next,
+// which identifies the type of a TokenUnknown record.
+func (l *Lexer) LastOpcode() OpCode {
+	return l.lastOpcode
+}
+
+// IOStats returns the number of bytes the lexer has read from its underlying
+// reader, and the number of bytes its chunk decoders have produced. Bytes read
+// include those buffered ahead of the current record, and exclude data skipped
+// by seeking, such as attachment data not consumed by a callback. Decompressed
+// bytes include the records of uncompressed chunks, but not of chunks emitted
+// whole under EmitChunks. Comparing the two measures the IO consumed against
+// the data produced.
+func (l *Lexer) IOStats() (bytesRead uint64, bytesDecompressed uint64) {
+	return l.input.Count(), l.decompressed.Count()
+}
+
+// InChunk reports whether the record most recently returned by Next was read
+// from inside a chunk, rather than from the top level of the file. Chunks
+// returned whole under EmitChunks are top-level records.
+func (l *Lexer) InChunk() bool {
+	return l.tokenInChunk
+}
+
+// NextContext is like Next, but returns the context's error once ctx is done.
+// The context is checked before each record, including records within chunks,
+// and between reads of compressed chunk data, so that a canceled context does
+// not wait on decompression of the remainder of a large chunk. After a
+// context error the lexer may be left part way through a record and should be
+// closed rather than reused.
+func (l *Lexer) NextContext(ctx context.Context, p []byte) (TokenType, []byte, error) {
+	l.ctx.Store(lexerContext{ctx: ctx})
+	defer l.ctx.Store(lexerContext{})
+	return l.Next(p)
+}
+
+// contextErr returns the error of the context passed to NextContext, if any.
+func (l *Lexer) contextErr() error {
+	if c, ok := l.ctx.Load().(lexerContext); ok && c.ctx != nil {
+		return c.ctx.Err()
+	}
+	return nil
+}
+
+// ForEach calls fn with each remaining token from the lexer, reusing a single
+// buffer for the records, until the input is exhausted or fn returns an error.
+// The record passed to fn is only valid until fn returns. If fn returns
+// ErrStopIteration, ForEach stops and returns nil; any other error from fn or
+// the lexer is returned.
+func (l *Lexer) ForEach(fn func(tokenType TokenType, record []byte) error) error {
+	var buf []byte
+	for {
+		tokenType, record, err := l.Next(buf)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if cap(record) > cap(buf) {
+			buf = record[:cap(record)]
+		}
+		if err := fn(tokenType, record); err != nil {
+			if errors.Is(err, ErrStopIteration) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// NextWithOffset is like Next, additionally returning the offset of the
+// record's opcode byte in the lexer's input. For records read from inside a
+// chunk, the offset of the enclosing chunk record is returned. Offsets include
+// the leading magic unless SkipMagic is set, in which case they are relative
+// to the reader's position when the lexer was created.
+func (l *Lexer) NextWithOffset(p []byte) (TokenType, []byte, uint64, error) {
+	tokenType, record, err := l.Next(p)
+	if err != nil {
+		return tokenType, record, 0, err
+	}
+	return tokenType, record, l.recordOffset, nil
+}
+
+// TokenPosition locates a record returned by the lexer.
+type TokenPosition struct {
+	// Offset is the offset of the record's opcode byte in the lexer's input,
+	// or for records inside a chunk, of the enclosing chunk record. As with
+	// NextWithOffset, offsets include the leading magic unless SkipMagic is
+	// set.
+	Offset uint64
+	// InChunk is set for records read from inside a chunk.
+	InChunk bool
+	// ChunkOffset is the offset of the record's opcode byte within the
+	// decompressed records of its chunk, as used by MessageIndex records. It
+	// is zero for records not inside a chunk.
+	ChunkOffset uint64
+}
+
+// NextWithPosition is like Next, additionally returning the position of the
+// record in the lexer's input and, for records inside a chunk, within the
+// chunk.
+func (l *Lexer) NextWithPosition(p []byte) (TokenType, []byte, TokenPosition, error) {
+	tokenType, record, err := l.Next(p)
+	if err != nil {
+		return tokenType, record, TokenPosition{}, err
+	}
+	position := TokenPosition{Offset: l.recordOffset, InChunk: l.tokenInChunk}
+	if position.InChunk {
+		position.ChunkOffset = l.recordChunkOffset
+	}
+	return tokenType, record, position, nil
 }
 
 // Next returns the next token from the lexer as a byte array. The result will
 // be sliced out of the provided buffer `p`, if p has adequate space. If p does
 // not have adequate space, a new buffer with sufficient size is allocated for
-// the result.
+// the result, or under LexerOptions.MaxScratchSize the result may be sliced
+// out of the lexer's scratch buffer, in which case it is invalidated by the
+// next call to Next.
 func (l *Lexer) Next(p []byte) (TokenType, []byte, error) {
 	for {
+		if l.recoveredEOF {
+			return TokenError, nil, io.EOF
+		}
+		tokenType, record, err := l.next(p)
+		if err != nil && tokenType == TokenError && l.skipBadChunk(err) {
+			continue
+		}
+		if err != nil && l.recovering && l.contextErr() == nil && l.truncated(err) {
+			l.recoverTruncation(err)
+			return TokenError, nil, io.EOF
+		}
+		if err != nil && err != io.EOF && l.contextErr() == nil {
+			if l.tolerateTruncation && l.truncated(err) {
+				err = fmt.Errorf("%w: %v", ErrTruncatedFile, err)
+			}
+			lexErr := l.current
+			lexErr.Err = err
+			return tokenType, record, &lexErr
+		}
+		return tokenType, record, err
+	}
+}
+
+// truncated reports whether err resulted from the input ending part way
+// through a record or chunk.
+func (l *Lexer) truncated(err error) bool {
+	return errors.Is(err, io.ErrUnexpectedEOF) || (l.chunkReader != nil && l.chunkReader.truncated)
+}
+
+// skipBadChunk discards the remainder of the chunk in which err occurred, so
+// that lexing can resume at the following top-level record, if SkipBadChunks
+// is set. It reports whether the chunk was skipped.
+func (l *Lexer) skipBadChunk(err error) bool {
+	if !l.skipBadChunks || l.chunkReader == nil || l.contextErr() != nil {
+		return false
+	}
+	// stop any decoding of the chunk in progress before reading past it
+	if l.decoders.zstd != nil && l.decompressed.r == l.decoders.zstd {
+		_ = l.decoders.zstd.Reset(bytes.NewReader(nil))
+	}
+	if l.chunkReader.truncated {
+		return false
+	}
+	if skipErr := l.skip(l.basereader, l.chunkReader.remaining); skipErr != nil {
+		return false
+	}
+	l.inChunk = false
+	l.reader = l.basereader
+	l.chunkReader = nil
+	l.checkChunkCRC = false
+	if l.badChunkCallback != nil {
+		l.badChunkCallback(l.chunkRecordOffset, err)
+	}
+	l.reportRecovery(Recovery{Offset: l.chunkRecordOffset, Length: 9 + l.chunkLength, Err: err})
+	return true
+}
+
+// validateAttachmentCRC reads any data of the attachment left unread by the
+// attachment callback, then checks the computed CRC against the stored one.
+func validateAttachmentCRC(ar *AttachmentReader) error {
+	if _, err := io.Copy(io.Discard, ar.data); err != nil {
+		return fmt.Errorf("failed to read attachment data: %w", err)
+	}
+	if ar.data.N > 0 {
+		return fmt.Errorf("failed to read attachment data: %w", io.ErrUnexpectedEOF)
+	}
+	expected, err := ar.ParsedCRC()
+	if err != nil {
+		return err
+	}
+	actual := ar.crcReader.Checksum()
+	if expected != 0 && expected != actual {
+		return &ErrInvalidAttachmentCRC{expected: expected, actual: actual}
+	}
+	return nil
+}
+
+// skip discards the next n bytes of r. When r is the lexer's internal read
+// buffer over a seekable reader, bytes beyond the buffer are seeked over.
+func (l *Lexer) skip(r io.Reader, n int64) error {
+	if l.seeker == nil || r != l.basereader {
+		return skipReader(r, n)
+	}
+	buffered := int64(l.buffered.Buffered())
+	if n <= buffered {
+		_, err := l.buffered.Discard(int(n))
+		return err
+	}
+	if _, err := l.buffered.Discard(int(buffered)); err != nil {
+		return err
+	}
+	if _, err := l.seeker.Seek(n-buffered, io.SeekCurrent); err != nil {
+		return err
+	}
+	l.buffered.Reset(l.input)
+	return nil
+}
+
+// recordBuffer returns a buffer of at least size bytes for a record too large
+// for the buffer passed to Next. Under MaxScratchSize the lexer's scratch
+// buffer is grown, to at least double its previous size but within the
+// limit, and returned.
+func (l *Lexer) recordBuffer(size uint64) ([]byte, error) {
+	if l.maxScratchSize <= 0 || size > uint64(l.maxScratchSize) {
+		return makeSafe(size)
+	}
+	if size > uint64(len(l.scratch)) {
+		grown := 2 * uint64(len(l.scratch))
+		if grown < size {
+			grown = size
+		}
+		if grown > uint64(l.maxScratchSize) {
+			grown = uint64(l.maxScratchSize)
+		}
+		scratch, err := makeSafe(grown)
+		if err != nil {
+			return nil, err
+		}
+		l.scratch = scratch
+	}
+	return l.scratch, nil
+}
+
+// chunkReader limits reads to the remaining bytes of a chunk record, noting
+// whether the underlying reader ended before the record did.
+type chunkReader struct {
+	r         io.Reader
+	remaining int64
+	truncated bool
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if c.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if errors.Is(err, io.EOF) && c.remaining > 0 {
+		c.truncated = true
+	}
+	return n, err
+}
+
+func (l *Lexer) next(p []byte) (TokenType, []byte, error) {
+	for {
+		if err := l.contextErr(); err != nil {
+			return TokenError, nil, err
+		}
+		if l.inChunk {
+			l.current = LexError{Opcode: OpChunk, RecordLength: int64(l.chunkLength), Offset: l.chunkRecordOffset}
+		} else {
+			if l.recovering {
+				if err := l.resync(); err != nil {
+					return TokenError, nil, err
+				}
+			}
+			l.current = LexError{Opcode: OpReserved, RecordLength: -1, Offset: l.offset}
+		}
 		readLength, err := io.ReadFull(l.reader, l.buf[:9])
 		if err != nil {
 			unexpectedEOF := errors.Is(err, io.ErrUnexpectedEOF)
 			eof := errors.Is(err, io.EOF)
 			if l.inChunk && (eof || unexpectedEOF) {
+				// the chunk's data running out is only its clean end if the
+				// chunk record itself was read in full.
+				if l.chunkReader != nil && l.chunkReader.truncated {
+					return TokenError, nil, &ErrTruncatedRecord{
+						opcode:      OpChunk,
+						actualLen:   int(int64(l.chunkLength) - l.chunkReader.remaining),
+						expectedLen: l.chunkLength,
+					}
+				}
+				if err := l.finishChunkCRC(); err != nil {
+					return TokenError, nil, err
+				}
 				l.inChunk = false
 				l.reader = l.basereader
+				l.chunkReader = nil
 				continue
 			}
 			if unexpectedEOF {
@@ -182,15 +719,69 @@ func (l *Lexer) Next(p []byte) (TokenType, []byte, error) {
 				}
 				// unexpectedEOF indicates at least one byte was read
 				opcode := OpCode(l.buf[0])
+				l.current.Opcode = opcode
 				return TokenError, nil, &ErrTruncatedRecord{opcode: opcode, actualLen: readLength}
 			}
 			return TokenError, nil, err
 		}
+		if l.order != nil && l.order.section == SectionEnd && bytes.Equal(l.buf[:len(Magic)], Magic) {
+			// another file follows the closing magic
+			return TokenError, nil, &ErrMultipleHeaders{Offset: l.offset + uint64(len(Magic))}
+		}
 		opcode := OpCode(l.buf[0])
 		recordLen := binary.LittleEndian.Uint64(l.buf[1:9])
+		ref := RecordRef{OpCode: opcode, Length: recordLen, InChunk: l.inChunk}
+		if l.inChunk {
+			ref.Offset = l.chunkOffset
+			l.chunkOffset += 9 + recordLen
+			l.recordOffset = l.chunkRecordOffset
+			l.recordChunkOffset = ref.Offset
+		} else {
+			ref.Offset = l.offset
+			l.offset += 9 + recordLen
+			l.recordOffset = ref.Offset
+			if opcode == OpChunk {
+				l.chunkRecordOffset = ref.Offset
+				l.chunkLength = recordLen
+			}
+		}
+		if !l.inChunk {
+			l.current = LexError{Opcode: opcode, RecordLength: int64(recordLen), Offset: ref.Offset}
+		}
+		l.pushHistory(ref)
+		l.tokenInChunk = l.inChunk
+		l.lastOpcode = opcode
+		if l.expectChunk {
+			if opcode != OpChunk {
+				return TokenError, nil, fmt.Errorf("%w: found %s", ErrNotAtChunk, opcode)
+			}
+			l.expectChunk = false
+		}
+		if l.order != nil && opcode != OpReserved {
+			if err := l.order.check(opcode, l.inChunk, ref.Offset); err != nil {
+				return TokenError, nil, err
+			}
+		}
 		if l.maxRecordSize > 0 && recordLen > uint64(l.maxRecordSize) {
 			return TokenError, nil, ErrRecordTooLarge
 		}
+		if recordLen > math.MaxInt64 {
+			return TokenError, nil, fmt.Errorf("%s record length %d: %w", opcode, recordLen, ErrLengthOutOfRange)
+		}
+		if l.validateRecordLengths {
+			if minLength, ok := minRecordLengths[opcode]; ok && recordLen < minLength {
+				return TokenError, nil, fmt.Errorf(
+					"%s record length %d is less than minimum %d: %w", opcode, recordLen, minLength, ErrRecordTooShort,
+				)
+			}
+		}
+		if l.emitOpcodes != nil && !l.emitOpcodes[opcode] && opcode != OpAttachment &&
+			(opcode != OpChunk || l.emitChunks) {
+			if err := l.skip(l.reader, int64(recordLen)); err != nil {
+				return TokenError, nil, fmt.Errorf("failed to skip %s record: %w", opcode, err)
+			}
+			continue
+		}
 
 		// Chunks and attachments require special handling to avoid
 		// materialization into RAM. If it's a chunk, open up a decompressor and
@@ -219,22 +810,29 @@ func (l *Lexer) Next(p []byte) (TokenType, []byte, error) {
 				N: int64(recordLen),
 			}
 
-			if l.attachmentCallback != nil {
+			if l.attachmentCallback != nil || l.validateAttachmentCRCs {
 				attachmentReader, err := parseAttachmentReader(
 					limitReader,
-					l.computeAttachmentCRCs,
+					l.computeAttachmentCRCs || l.validateAttachmentCRCs,
 				)
 				if err != nil {
 					return TokenError, nil, fmt.Errorf("failed to parse attachment: %w", err)
 				}
-				err = l.attachmentCallback(attachmentReader)
-				if err != nil {
-					return TokenError, nil, fmt.Errorf("failed to handle attachment: %w", err)
+				if l.attachmentCallback != nil {
+					err = l.attachmentCallback(attachmentReader)
+					if err != nil {
+						return TokenError, nil, fmt.Errorf("failed to handle attachment: %w", err)
+					}
+				}
+				if l.validateAttachmentCRCs {
+					if err := validateAttachmentCRC(attachmentReader); err != nil {
+						return TokenError, nil, err
+					}
 				}
 			}
 
 			// skip the base reader ahead to cover any unconsumed bytes of the attachment
-			err := skipReader(limitReader.R, limitReader.N)
+			err := l.skip(limitReader.R, limitReader.N)
 			if err != nil {
 				return TokenError, nil, fmt.Errorf("failed to consume unhandled attachment data: %w", err)
 			}
@@ -242,7 +840,7 @@ func (l *Lexer) Next(p []byte) (TokenType, []byte, error) {
 		}
 
 		if recordLen > uint64(len(p)) {
-			p, err = makeSafe(recordLen)
+			p, err = l.recordBuffer(recordLen)
 			if err != nil {
 				return TokenError, nil, fmt.Errorf("failed to allocate %d bytes for %s token: %w", recordLen, opcode, err)
 			}
@@ -260,66 +858,204 @@ func (l *Lexer) Next(p []byte) (TokenType, []byte, error) {
 		if err != nil {
 			return TokenError, nil, err
 		}
+		if l.tokenInChunk && l.onChunkProgress != nil && !l.bufferChunks() {
+			l.reportChunkProgress(l.chunkOffset)
+		}
 
-		switch opcode {
-		case OpMessage:
-			return TokenMessage, record, nil
-		case OpHeader:
-			return TokenHeader, record, nil
-		case OpSchema:
-			return TokenSchema, record, nil
-		case OpDataEnd:
-			return TokenDataEnd, record, nil
-		case OpChannel:
-			return TokenChannel, record, nil
-		case OpFooter:
-			return TokenFooter, record, nil
-		case OpAttachmentIndex:
-			return TokenAttachmentIndex, record, nil
-		case OpChunkIndex:
-			return TokenChunkIndex, record, nil
-		case OpStatistics:
-			return TokenStatistics, record, nil
-		case OpMessageIndex:
-			return TokenMessageIndex, record, nil
-		case OpChunk:
-			return TokenChunk, record, nil
-		case OpMetadata:
-			return TokenMetadata, record, nil
-		case OpMetadataIndex:
-			return TokenMetadataIndex, record, nil
-		case OpSummaryOffset:
-			return TokenSummaryOffset, record, nil
-		case OpReserved:
+		if opcode == OpReserved {
 			return TokenError, nil, ErrInvalidZeroOpcode
-		default:
+		}
+		tokenType, ok := opcodeTokenType(opcode)
+		if !ok {
+			if l.emitUnknownRecords {
+				return TokenUnknown, record, nil
+			}
 			continue // skip unrecognized opcodes
 		}
+		return tokenType, record, nil
 	}
 }
 
+// opcodeTokenType returns the token type emitted for records with the given
+// opcode. It returns false for opcodes that are never emitted as tokens:
+// attachments, the reserved zero opcode, and unrecognized opcodes.
+func opcodeTokenType(opcode OpCode) (TokenType, bool) {
+	switch opcode {
+	case OpMessage:
+		return TokenMessage, true
+	case OpHeader:
+		return TokenHeader, true
+	case OpSchema:
+		return TokenSchema, true
+	case OpDataEnd:
+		return TokenDataEnd, true
+	case OpChannel:
+		return TokenChannel, true
+	case OpFooter:
+		return TokenFooter, true
+	case OpAttachmentIndex:
+		return TokenAttachmentIndex, true
+	case OpChunkIndex:
+		return TokenChunkIndex, true
+	case OpStatistics:
+		return TokenStatistics, true
+	case OpMessageIndex:
+		return TokenMessageIndex, true
+	case OpChunk:
+		return TokenChunk, true
+	case OpMetadata:
+		return TokenMetadata, true
+	case OpMetadataIndex:
+		return TokenMetadataIndex, true
+	case OpSummaryOffset:
+		return TokenSummaryOffset, true
+	default:
+		return TokenError, false
+	}
+}
+
+// ReadRecordAt reads the record starting at offset in ra, and returns its
+// token type and body, as Next would. The body is read into buf if it is large
+// enough, and into a new buffer otherwise. Chunks are returned as TokenChunk
+// without decompression, since records within a chunk are not addressable by
+// offset in the file. This allows records located through the summary
+// section, such as chunks or metadata, to be read directly.
+//
+// An error wrapping ErrInvalidZeroOpcode is returned for a zero opcode, as from
+// an offset that does not point at a record, and one wrapping ErrInvalidOpcode
+// for any other opcode with no token type, including attachments, which are
+// read through an AttachmentReader.
+func ReadRecordAt(ra io.ReaderAt, offset int64, buf []byte) (TokenType, []byte, error) {
+	if offset < 0 {
+		return TokenError, nil, fmt.Errorf("negative record offset %d: %w", offset, ErrLengthOutOfRange)
+	}
+	var prefix [SizeRecordPrefix]byte
+	n, err := ra.ReadAt(prefix[:], offset)
+	if n < len(prefix) {
+		if n > 0 && (err == nil || errors.Is(err, io.EOF)) {
+			return TokenError, nil, &ErrTruncatedRecord{opcode: OpCode(prefix[0]), actualLen: n}
+		}
+		return TokenError, nil, fmt.Errorf("failed to read record at offset %d: %w", offset, err)
+	}
+	opcode := OpCode(prefix[0])
+	recordLen := binary.LittleEndian.Uint64(prefix[1:])
+	if opcode == OpReserved {
+		return TokenError, nil, fmt.Errorf("%w at offset %d", ErrInvalidZeroOpcode, offset)
+	}
+	tokenType, ok := opcodeTokenType(opcode)
+	if !ok {
+		return TokenError, nil, fmt.Errorf("%w at offset %d: %s", ErrInvalidOpcode, offset, opcode)
+	}
+	if recordLen > uint64(math.MaxInt64-offset-SizeRecordPrefix) {
+		return TokenError, nil, fmt.Errorf("%s record length %d: %w", opcode, recordLen, ErrLengthOutOfRange)
+	}
+	if recordLen > uint64(len(buf)) {
+		buf, err = makeSafe(recordLen)
+		if err != nil {
+			return TokenError, nil, fmt.Errorf("failed to allocate %d bytes for %s token: %w", recordLen, opcode, err)
+		}
+	}
+	record := buf[:recordLen]
+	n, err = ra.ReadAt(record, offset+SizeRecordPrefix)
+	if n < len(record) {
+		if err == nil || errors.Is(err, io.EOF) {
+			return TokenError, nil, &ErrTruncatedRecord{opcode: opcode, actualLen: n, expectedLen: recordLen}
+		}
+		return TokenError, nil, fmt.Errorf("failed to read %s record at offset %d: %w", opcode, offset, err)
+	}
+	return tokenType, record, nil
+}
+
 // Close the lexer.
 func (l *Lexer) Close() {
 	if l.decoders.zstd != nil {
-		l.decoders.zstd.Close()
+		if l.poolZSTD() {
+			l.decoderPool.putZSTD(l.decoders.zstd)
+		} else {
+			l.decoders.zstd.Close()
+		}
+		l.decoders.zstd = nil
+	}
+	if l.decoders.lz4 != nil && l.decoderPool != nil {
+		l.decoderPool.putLZ4(l.decoders.lz4)
+		l.decoders.lz4 = nil
+	}
+	for _, decoder := range l.decoders.registered {
+		closeDecoder(decoder)
 	}
+	l.decoders.registered = nil
 	for _, decompressor := range l.decompressors {
 		if closer, ok := decompressor.(io.Closer); ok {
 			closer.Close()
 		}
 	}
+	if l.closeOuter != nil {
+		l.closeOuter()
+		l.closeOuter = nil
+	}
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// decompressOuter detects input compressed as a whole with gzip or zstd and
+// returns a reader of the decompressed data, with a function to release the
+// decompressor. If the input is not compressed, the returned closer is nil.
+// The leading bytes are peeked rather than read, so r must be buffered if the
+// input may not be compressed.
+func decompressOuter(r io.Reader) (io.Reader, func(), error) {
+	p, ok := r.(peeker)
+	if !ok {
+		p = bufio.NewReader(r)
+		r = p.(io.Reader)
+	}
+	prefix, err := p.Peek(len(zstdMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, nil, err
+	}
+	switch {
+	case bytes.HasPrefix(prefix, gzipMagic):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read gzip header: %w", err)
+		}
+		return gz, func() { gz.Close() }, nil
+	case bytes.HasPrefix(prefix, zstdMagic):
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create zstd decoder: %w", err)
+		}
+		return zr, zr.Close, nil
+	default:
+		return r, nil, nil
+	}
 }
 
 type decoders struct {
 	zstd *zstd.Decoder
 	lz4  *lz4.Reader
 	none *bytes.Reader
+	// registered holds the decoders created by factories passed to
+	// RegisterDecoder.
+	registered map[CompressionFormat]ResettableReader
 }
 
+// validateMagic reads and checks the magic at the start of a file. If the
+// reader ends before the full magic is read, ErrBadMagic is returned only if
+// the bytes read do not match the magic; otherwise the read error is returned,
+// with a clean io.EOF promoted to io.ErrUnexpectedEOF.
 func validateMagic(r io.Reader) error {
 	magic := make([]byte, len(Magic))
 	if readLen, err := io.ReadFull(r, magic); err != nil {
-		return &ErrBadMagic{actual: magic[:readLen]}
+		if !bytes.HasPrefix(Magic, magic[:readLen]) {
+			return &ErrBadMagic{actual: magic[:readLen]}
+		}
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("failed to read magic: %w", err)
 	}
 	if !bytes.Equal(magic, Magic) {
 		return &ErrBadMagic{actual: magic}
@@ -336,9 +1072,35 @@ func (l *Lexer) setNoneDecoder(buf []byte) {
 	l.reader = l.decoders.none
 }
 
+// poolZSTD reports whether the lexer's zstd decoder is taken from and
+// returned to its decoder pool.
+func (l *Lexer) poolZSTD() bool {
+	return l.decoderPool != nil &&
+		(l.zstdConcurrency == 0 || l.zstdConcurrency == 1) &&
+		l.zstdMaxMemory == 0 &&
+		len(l.zstdDictionaries) == 0
+}
+
 func (l *Lexer) setZSTDDecoder(r io.Reader) error {
+	if l.decoders.zstd == nil && l.poolZSTD() {
+		l.decoders.zstd = l.decoderPool.getZSTD()
+	}
 	if l.decoders.zstd == nil {
-		decoder, err := zstd.NewReader(r)
+		concurrency := l.zstdConcurrency
+		switch {
+		case concurrency == 0:
+			concurrency = 1
+		case concurrency < 0:
+			concurrency = 0 // the decoder's own default of GOMAXPROCS
+		}
+		opts := []zstd.DOption{zstd.WithDecoderConcurrency(concurrency)}
+		if l.zstdMaxMemory > 0 {
+			opts = append(opts, zstd.WithDecoderMaxMemory(l.zstdMaxMemory))
+		}
+		if len(l.zstdDictionaries) > 0 {
+			opts = append(opts, zstd.WithDecoderDicts(l.zstdDictionaries...))
+		}
+		decoder, err := zstd.NewReader(r, opts...)
 		if err != nil {
 			return err
 		}
@@ -354,6 +1116,9 @@ func (l *Lexer) setZSTDDecoder(r io.Reader) error {
 }
 
 func (l *Lexer) setLZ4Decoder(r io.Reader) {
+	if l.decoders.lz4 == nil && l.decoderPool != nil {
+		l.decoders.lz4 = l.decoderPool.getLZ4()
+	}
 	if l.decoders.lz4 == nil {
 		l.decoders.lz4 = lz4.NewReader(r)
 	} else {
@@ -362,11 +1127,96 @@ func (l *Lexer) setLZ4Decoder(r io.Reader) {
 	l.reader = l.decoders.lz4
 }
 
+// lz4FrameMagic begins every LZ4 frame.
+var lz4FrameMagic = []byte{0x04, 0x22, 0x4d, 0x18}
+
+// setLegacyCompatibleLZ4Decoder uses the LZ4 frame decoder if the chunk data
+// begins with the frame magic. Otherwise the data is decompressed as a single
+// raw LZ4 block of the chunk's declared uncompressed size, as written by some
+// older recorders.
+func (l *Lexer) setLegacyCompatibleLZ4Decoder(r io.Reader, compressedSize, uncompressedSize uint64) error {
+	n, err := io.ReadFull(r, l.lz4Prefix[:])
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("failed to read chunk data: %w", err)
+	}
+	if n == 0 || bytes.Equal(l.lz4Prefix[:n], lz4FrameMagic) {
+		l.setLZ4Decoder(io.MultiReader(bytes.NewReader(l.lz4Prefix[:n]), r))
+		return nil
+	}
+	if l.maxDecompressedChunkSize > 0 && uncompressedSize > uint64(l.maxDecompressedChunkSize) {
+		return ErrChunkTooLarge
+	}
+	if uint64(len(l.compressedChunk)) < compressedSize {
+		l.compressedChunk, err = makeSafe(compressedSize)
+		if err != nil {
+			return fmt.Errorf("failed to allocate chunk buffer: %w", err)
+		}
+	}
+	copy(l.compressedChunk, l.lz4Prefix[:n])
+	_, err = io.ReadFull(r, l.compressedChunk[n:compressedSize])
+	if err != nil {
+		return fmt.Errorf("failed to read chunk data: %w", err)
+	}
+	if uint64(len(l.uncompressedChunk)) < uncompressedSize {
+		l.uncompressedChunk, err = makeSafe(uncompressedSize)
+		if err != nil {
+			return fmt.Errorf("failed to allocate chunk buffer: %w", err)
+		}
+	}
+	size, err := lz4.UncompressBlock(l.compressedChunk[:compressedSize], l.uncompressedChunk[:uncompressedSize])
+	if err != nil {
+		return fmt.Errorf("failed to decompress legacy lz4 block: %w", err)
+	}
+	if uint64(size) != uncompressedSize {
+		return fmt.Errorf("legacy lz4 block decompressed to %d bytes, expected %d", size, uncompressedSize)
+	}
+	l.setNoneDecoder(l.uncompressedChunk[:size])
+	return nil
+}
+
+// setRegisteredDecoder sets the lexer's reader to decode chunk records read
+// from r, using the decoder factory registered for compression.
+func (l *Lexer) setRegisteredDecoder(
+	compression CompressionFormat,
+	r io.Reader,
+	compressedSize, uncompressedSize uint64,
+) error {
+	factory, ok := lookupDecoderFactory(compression)
+	if !ok {
+		return fmt.Errorf("unsupported compression: %s", string(compression))
+	}
+	if factory.setLexerDecoder != nil {
+		return factory.setLexerDecoder(l, r, compressedSize, uncompressedSize)
+	}
+	decoder := l.decoders.registered[compression]
+	if decoder == nil {
+		var err error
+		decoder, err = factory.newDecoder()
+		if err != nil {
+			return fmt.Errorf("failed to instantiate %s decoder: %w", compression, err)
+		}
+		if l.decoders.registered == nil {
+			l.decoders.registered = make(map[CompressionFormat]ResettableReader)
+		}
+		l.decoders.registered[compression] = decoder
+	}
+	if err := decoder.Reset(r); err != nil {
+		return fmt.Errorf("failed to reset %s decoder: %w", compression, err)
+	}
+	l.reader = decoder
+	return nil
+}
+
 func loadChunk(l *Lexer, recordLen uint64) error {
 	if l.inChunk {
 		return ErrNestedChunk
 	}
-	readLength, err := io.ReadFull(l.reader, l.buf[:8+8+8+4+4])
+	l.chunkReader = &chunkReader{r: l.reader, remaining: int64(recordLen)}
+	const fixedFieldsLen = 8 + 8 + 8 + 4 + 4 + 8 // times, size, crc, compression length, records length
+	if recordLen < fixedFieldsLen {
+		return fmt.Errorf("chunk record length %d is too short: %w", recordLen, ErrRecordTooShort)
+	}
+	readLength, err := io.ReadFull(l.chunkReader, l.buf[:8+8+8+4+4])
 	if errors.Is(err, io.ErrUnexpectedEOF) {
 		return &ErrTruncatedRecord{
 			opcode:      OpChunk,
@@ -378,11 +1228,11 @@ func loadChunk(l *Lexer, recordLen uint64) error {
 		return err
 	}
 
-	_, offset, err := getUint64(l.buf, 0) // start
+	start, offset, err := getUint64(l.buf, 0)
 	if err != nil {
 		return fmt.Errorf("failed to read start: %w", err)
 	}
-	_, offset, err = getUint64(l.buf, offset) // end
+	end, offset, err := getUint64(l.buf, offset)
 	if err != nil {
 		return fmt.Errorf("failed to read end: %w", err)
 	}
@@ -398,9 +1248,29 @@ func loadChunk(l *Lexer, recordLen uint64) error {
 	if err != nil {
 		return fmt.Errorf("failed to read compression length: %w", err)
 	}
+	if l.requireChunkCRC && uncompressedCRC == 0 {
+		return ErrChunkCRCMissing
+	}
+
+	if uint64(compressionLen) > recordLen-fixedFieldsLen {
+		return fmt.Errorf("chunk compression length %d exceeds record length %d: %w",
+			compressionLen, recordLen, ErrLengthOutOfRange)
+	}
 
-	// read compression and records length into buffer
-	thisReadLength, err := io.ReadFull(l.reader, l.buf[:compressionLen+8])
+	// read compression and records length into buffer. A compression length
+	// too long for the buffer is most likely corrupt, so rather than
+	// allocating it up front, the buffer is grown only as the data arrives.
+	fieldsLen := int64(compressionLen) + 8
+	var thisReadLength int
+	if fieldsLen <= int64(len(l.buf)) {
+		thisReadLength, err = io.ReadFull(l.chunkReader, l.buf[:fieldsLen])
+	} else {
+		fields := bytes.NewBuffer(l.buf[:0])
+		var n int64
+		n, err = io.CopyN(fields, l.chunkReader, fieldsLen)
+		thisReadLength = int(n)
+		l.buf = fields.Bytes()
+	}
 	readLength += thisReadLength
 	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
 		return &ErrTruncatedRecord{
@@ -417,48 +1287,68 @@ func loadChunk(l *Lexer, recordLen uint64) error {
 	if err != nil {
 		return fmt.Errorf("failed to read records length: %w", err)
 	}
+	if recordsLength > recordLen-fixedFieldsLen-uint64(compressionLen) {
+		return fmt.Errorf("chunk records length %d exceeds record length %d: %w",
+			recordsLength, recordLen, ErrLengthOutOfRange)
+	}
 
-	// remaining bytes in the record are the chunk data
-	lr := io.LimitReader(l.reader, int64(recordsLength))
-	switch {
-	case l.decompressors[compression] != nil: // must be top
-		decoder := l.decompressors[compression]
+	// remaining bytes in the record are the chunk data. For an empty chunk the
+	// reader is immediately exhausted, and Next falls through to the following
+	// top-level record.
+	lr := io.LimitReader(&contextReader{l: l, r: l.chunkReader}, int64(recordsLength))
+	if decoder := l.decompressors[compression]; decoder != nil {
 		err = decoder.Reset(lr)
 		if err != nil {
 			return fmt.Errorf("failed to reset custom decompressor: %w", err)
 		}
 		l.reader = decoder
-	case compression == CompressionNone:
-		l.reader = lr
-	case compression == CompressionZSTD:
-		err = l.setZSTDDecoder(lr)
+	} else {
+		err = l.setRegisteredDecoder(compression, lr, recordsLength, uncompressedSize)
 		if err != nil {
 			return err
 		}
-	case compression == CompressionLZ4:
-		l.setLZ4Decoder(lr)
-	default:
-		return fmt.Errorf("unsupported compression: %s", string(compression))
 	}
+	// count the bytes produced by the chunk's decoder
+	l.decompressed.r = l.reader
+	l.reader = &l.decompressed
 	l.inChunk = true
+	l.chunkOffset = 0
+	l.chunkStartTime = start
+	l.chunkEndTime = end
+	l.chunkUncompressedSize = uncompressedSize
+	l.chunkProgress = 0
 
-	// if we are validating the CRC, we need to fully decompress the chunk right
-	// here, then rewrap the decompressed data in a compatible reader after
-	// validation. If we are not validating CRCs, we can use incremental
-	// decompression for the chunk's data, which may be beneficial to streaming
-	// readers.
-	if l.validateChunkCRCs {
+	// if we are validating the CRC ahead of reading the chunk, we need to
+	// fully decompress the chunk right here, then rewrap the decompressed data
+	// in a compatible reader after validation. Otherwise we can use
+	// incremental decompression for the chunk's data, which may be beneficial
+	// to streaming readers, computing the CRC as the records are read if
+	// validating it at the end of the chunk.
+	if l.validateChunkCRCs && l.streamChunkCRCs && uncompressedCRC > 0 {
+		if l.chunkCRC == nil {
+			l.chunkCRC = newCRCReader(nil, true)
+		}
+		l.chunkCRC.r = l.reader
+		l.chunkCRC.crc.Reset()
+		l.chunkExpectedCRC = uncompressedCRC
+		l.checkChunkCRC = true
+		l.reader = l.chunkCRC
+	}
+	if l.bufferChunks() {
 		if l.maxDecompressedChunkSize > 0 && uncompressedSize > uint64(l.maxDecompressedChunkSize) {
 			return ErrChunkTooLarge
 		}
 		if uint64(len(l.uncompressedChunk)) < uncompressedSize {
+			if uncompressedSize >= math.MaxInt32 {
+				return fmt.Errorf("failed to allocate chunk buffer: %w", ErrLengthOutOfRange)
+			}
 			l.uncompressedChunk, err = makeSafe(uncompressedSize * 2)
 			if err != nil {
 				return fmt.Errorf("failed to allocate chunk buffer: %w", err)
 			}
 		}
 
-		_, err := io.ReadFull(l.reader, l.uncompressedChunk[:uncompressedSize])
+		err := l.decompressChunk(l.uncompressedChunk[:uncompressedSize])
 		if err != nil {
 			return fmt.Errorf("failed to decompress chunk: %w", err)
 		}
@@ -477,7 +1367,7 @@ func loadChunk(l *Lexer, recordLen uint64) error {
 			}
 		}
 
-		crc := crc32.ChecksumIEEE(l.uncompressedChunk[:uncompressedSize])
+		crc := l.checksum(l.uncompressedChunk[:uncompressedSize])
 		if uncompressedCRC > 0 && crc != uncompressedCRC {
 			return &errInvalidChunkCrc{expected: uncompressedCRC, actual: crc}
 		}
@@ -486,17 +1376,89 @@ func loadChunk(l *Lexer, recordLen uint64) error {
 	return nil
 }
 
+// bufferChunks reports whether chunks are decompressed into memory in full
+// when they are reached, to validate their CRCs before their records are read.
+func (l *Lexer) bufferChunks() bool {
+	return l.validateChunkCRCs && !l.streamChunkCRCs
+}
+
+// finishChunkCRC checks the CRC of the chunk just read in full under
+// StreamChunkCRCs.
+func (l *Lexer) finishChunkCRC() error {
+	if !l.checkChunkCRC {
+		return nil
+	}
+	l.checkChunkCRC = false
+	if crc := l.chunkCRC.Checksum(); crc != l.chunkExpectedCRC {
+		return &errInvalidChunkCrc{expected: l.chunkExpectedCRC, actual: crc}
+	}
+	return nil
+}
+
+// chunkProgressInterval is the minimum number of decompressed bytes between
+// calls to LexerOptions.OnChunkProgress.
+const chunkProgressInterval = 1024 * 1024
+
+// decompressChunk reads the decompressed chunk data into buf, reporting
+// progress as it goes.
+func (l *Lexer) decompressChunk(buf []byte) error {
+	if l.onChunkProgress == nil {
+		_, err := io.ReadFull(l.reader, buf)
+		return err
+	}
+	for n := 0; n < len(buf); {
+		end := n + chunkProgressInterval
+		if end > len(buf) {
+			end = len(buf)
+		}
+		if _, err := io.ReadFull(l.reader, buf[n:end]); err != nil {
+			return err
+		}
+		n = end
+		l.reportChunkProgress(uint64(n))
+	}
+	return nil
+}
+
+// reportChunkProgress calls the progress callback with the number of bytes of
+// the current chunk decompressed, if enough have been since the last call or
+// the chunk is complete.
+func (l *Lexer) reportChunkProgress(done uint64) {
+	if done-l.chunkProgress < chunkProgressInterval && done < l.chunkUncompressedSize {
+		return
+	}
+	l.chunkProgress = done
+	l.onChunkProgress(done, l.chunkUncompressedSize)
+}
+
 // LexerOptions holds options for the lexer.
 type LexerOptions struct {
 	// SkipMagic instructs the lexer not to perform validation of the leading magic bytes.
 	SkipMagic bool
 	// ValidateChunkCRC instructs the lexer to validate CRC checksums for
-	// chunks.
+	// chunks. It does not affect attachments; see ValidateAttachmentCRCs.
 	ValidateChunkCRCs bool
+	// StreamChunkCRCs, with ValidateChunkCRCs, validates the CRC of each chunk
+	// as its records are read, rather than decompressing the whole chunk into
+	// memory ahead of validation, so that memory use does not grow with the
+	// size of chunks. A mismatch is then only detected at the end of the
+	// chunk, and is returned from the call to Next following the chunk's last
+	// record, after its records have been returned, with TokenError even
+	// under EmitInvalidChunks. It cannot be combined with CRC32, which cannot
+	// compute a CRC incrementally, and NewLexer returns an error if both are
+	// set.
+	StreamChunkCRCs bool
 	// ComputeAttachmentCRCs instructs the lexer to compute CRCs for any
 	// attachments parsed from the file. Consumers should only set this to true
 	// if they intend to validate those CRCs in their attachment callback.
 	ComputeAttachmentCRCs bool
+	// ValidateAttachmentCRCs instructs the lexer to validate the CRC of each
+	// attachment, returning an *ErrInvalidAttachmentCRC on mismatch. Attachments
+	// with a zero CRC are not validated. Validation requires reading every
+	// byte of attachment data rather than seeking over it, which is costly for
+	// large attachments, so it is separate from ValidateChunkCRCs. The stored
+	// CRC remains available from AttachmentReader.ParsedCRC without it.
+	ValidateAttachmentCRCs bool
 	// EmitChunks instructs the lexer to emit chunk records without de-chunking.
 	// It is incompatible with ValidateCRC.
 	EmitChunks bool
@@ -509,51 +1471,388 @@ type LexerOptions struct {
 	// MaxRecordSize defines the maximum size record the lexer will read.
 	// Records larger than this will result in an error.
 	MaxRecordSize int
-	// AttachmentCallback is a function to execute on attachments encountered in the file.
+	// MaxScratchSize, if positive, has the lexer retain an internal scratch
+	// buffer for records too large for the buffer passed to Next, grown as
+	// needed up to MaxScratchSize bytes. Records that fit are returned in the
+	// scratch buffer, so repeated large records reuse one allocation rather
+	// than each allocating its own; records larger still are allocated
+	// individually as without this option. A record returned in the scratch
+	// buffer is only valid until the next call to Next.
+	MaxScratchSize int
+	// KeepHistory sets the number of recently read records retained for
+	// retrieval with Lexer.History, as an aid to diagnosing malformed files.
+	// If zero, no history is kept.
+	KeepHistory int
+	// CRC32 is the function used to compute chunk CRCs for validation, which
+	// may be replaced with a faster implementation of the IEEE CRC-32. If nil,
+	// crc32.ChecksumIEEE is used. Attachment CRCs are computed incrementally
+	// as attachment data is read, and always use the standard library. It
+	// cannot be combined with StreamChunkCRCs.
+	CRC32 func([]byte) uint32
+	// SkipBadChunks instructs the lexer to recover from an error reading a
+	// chunk, such as a CRC mismatch or corrupt compressed data, by discarding
+	// the rest of the chunk and continuing with the next top-level record.
+	// Records already returned from the chunk are not retracted. Errors from
+	// the underlying reader, including truncation of the file, are still
+	// returned.
+	SkipBadChunks bool
+	// BadChunkCallback, if set, is called with the offset of each chunk
+	// skipped under SkipBadChunks and the error that caused it to be skipped.
+	BadChunkCallback func(offset uint64, err error)
+	// ReadBufferSize, if positive, sets the size of a buffer the lexer reads
+	// its input through, so that callers need not wrap unbuffered readers such
+	// as an *os.File in a bufio.Reader. Input that already provides a Peek
+	// method, such as a *bufio.Reader, is not wrapped. By default the input is
+	// not buffered.
+	//
+	// A buffered lexer reads ahead of the record it last returned, so it
+	// should not be enabled by callers that reposition or otherwise read from
+	// the underlying reader between calls to Next.
+	ReadBufferSize int
+	// RequireChunkCRC causes chunks without an uncompressed CRC, which the
+	// specification permits, to fail with ErrChunkCRCMissing. Otherwise a zero
+	// CRC is taken to mean the CRC was not computed, and the chunk is not
+	// validated even if ValidateChunkCRCs is set. It has no effect on chunks
+	// returned whole under EmitChunks.
+	RequireChunkCRC bool
+	// ZSTDConcurrency sets the number of goroutines the zstd decoder may use
+	// to decode a chunk. Zero selects a single goroutine, with which chunks
+	// are decoded synchronously, for predictable resource use when many
+	// lexers run at once. A negative value uses GOMAXPROCS goroutines.
+	ZSTDConcurrency int
+	// ZSTDMaxMemory, if nonzero, limits the memory the zstd decoder may
+	// allocate to decode a chunk. Chunks requiring more fail to decode.
+	ZSTDMaxMemory uint64
+	// AllowLegacyLZ4 enables reading lz4 chunks written by some older
+	// recorders as a single raw LZ4 block, rather than in the LZ4 frame
+	// format. Chunk data that does not begin with the LZ4 frame magic is
+	// decompressed as a block of the chunk's declared uncompressed size.
+	AllowLegacyLZ4 bool
+	// Tee, if set, receives a copy of every byte the lexer reads from its
+	// input, including the magic and compressed chunk data, so that lexing a
+	// file to io.EOF writes a byte-identical copy of it. Under
+	// AutoDecompressOuter the copy is of the input as read, before
+	// decompression. Errors writing to Tee are returned from Next. Attachments
+	// are read through rather than seeked over when Tee is set.
+	Tee io.Writer
+	// ValidateRecordLengths instructs the lexer to check each record's length
+	// against the minimum length for its opcode, returning ErrRecordTooShort
+	// for records too short to be parsed.
+	ValidateRecordLengths bool
+	// AttachmentCallback is a function to execute on attachments encountered in
+	// the file. Attachments are never emitted as tokens; instead the callback
+	// receives an AttachmentReader whose Data reader streams the attachment
+	// content directly from the underlying reader, without buffering it in
+	// memory. Any data left unread by the callback is skipped.
 	AttachmentCallback func(*AttachmentReader) error
 	// Decompressors are custom decompressors. Chunks matching the supplied
 	// compression format will be decompressed with the provided
-	// ResettableReader instead of the default implementation. If the
+	// ResettableReader instead of the decoder registered for the format with
+	// RegisterDecoder. If the
 	// ResettableReader also implements io.Closer, Close will be called on close
 	// of the reader.
 	Decompressors map[CompressionFormat]ResettableReader
+	// ZSTDDictionaries are zstd dictionaries made available to the zstd chunk
+	// decoder. Frames referencing a dictionary by ID will be decoded with the
+	// matching entry. Frames written without a dictionary are unaffected.
+	ZSTDDictionaries [][]byte
+	// DecoderPool, if set, supplies the lexer's lz4 and zstd chunk decoders,
+	// which are returned to it by Close. See DecoderPool.
+	DecoderPool *DecoderPool
+	// TolerateTruncation causes input that ends part way through a top-level
+	// record or chunk to be reported with an error wrapping ErrTruncatedFile,
+	// so that callers recovering an interrupted recording can keep the records
+	// read before it, distinguishing truncation from other read failures.
+	TolerateTruncation bool
+	// AutoDecompressOuter causes the lexer to detect input compressed as a
+	// whole with gzip or zstd, as for a file stored as file.mcap.gz, and
+	// decompress it transparently. The format is detected from the leading
+	// bytes of the input without consuming them, so uncompressed input is read
+	// as usual. Compressed input cannot be seeked, so attachments and skipped
+	// chunks are read through. Detection requires the input to be buffered,
+	// so it is buffered even if ReadBufferSize is not set. Tee receives the
+	// compressed input. It has no effect with SkipMagic.
+	AutoDecompressOuter bool
+	// OnChunkProgress, if set, is called as the lexer decompresses each chunk
+	// with the number of bytes decompressed so far and the chunk's declared
+	// uncompressed size, as an aid to reporting progress through large chunks.
+	// Under ValidateChunkCRCs, unless StreamChunkCRCs is also set, it is
+	// called as the chunk is decompressed ahead of validation; otherwise it is
+	// called as records are read from the chunk. Calls are made at most once
+	// per MiB, and once the chunk is read in full. It is not called for chunks
+	// returned whole under EmitChunks.
+	OnChunkProgress func(bytesDecompressed, totalUncompressed uint64)
+	// StrictOrdering causes the lexer to check that each record appears in a
+	// position the specification permits, returning an *ErrUnexpectedRecord
+	// for one that does not: the header must come first, data end must
+	// separate the data section from the summary section, message indexes must
+	// follow a chunk, chunks may contain only schemas, channels and messages,
+	// summary offsets must follow the summary section, and the footer must come
+	// last. Records with opcodes not defined by the specification are
+	// permitted anywhere after the header. A second header, or a second file
+	// following the closing magic, is reported as an *ErrMultipleHeaders. With
+	// SkipMagic, the input is taken to begin in the data section.
+	StrictOrdering bool
+	// EmitUnknownRecords instructs the lexer to emit records with opcodes not
+	// defined by the specification as TokenUnknown, rather than skipping them,
+	// so that transformations can preserve them with Writer.WriteRaw. The
+	// opcode of each is available from Lexer.LastOpcode.
+	EmitUnknownRecords bool
+	// Recover instructs the lexer to salvage what it can of a corrupt or
+	// truncated file, as left by a crashed recorder, rather than stopping at
+	// the first error. It implies SkipBadChunks. Between top-level records,
+	// bytes that do not begin a plausible record are skipped one at a time
+	// until one that does is found: one with an opcode defined by the
+	// specification, and a length no less than the minimum for the opcode and
+	// no more than MaxRecordSize, if set, or the int32 range for records other
+	// than chunks and attachments. Once bytes are being skipped, a record must
+	// also fit in the remaining input, if seekable. Input ending part way
+	// through a record ends lexing as though the input ended before it, after
+	// any records read from a truncated chunk. Discarded input is reported to
+	// OnRecover. The input is buffered even if ReadBufferSize is not set,
+	// since the lexer must look ahead for records.
+	Recover bool
+	// OnRecover, if set, is called under Recover for each chunk, run of bytes
+	// or truncated record discarded.
+	OnRecover func(Recovery)
+	// EmitOpcodes, if not empty, restricts the records returned by the lexer
+	// to those with the listed opcodes. Other records are skipped over without
+	// being returned, or read into memory where the input is seekable. Chunks
+	// are still expanded and their records filtered in the same way, unless
+	// EmitChunks is set, in which case chunks are themselves returned only if
+	// OpChunk is listed. Attachments are passed to AttachmentCallback
+	// regardless. For example, []OpCode{OpMessage} yields only messages.
+	EmitOpcodes []OpCode
+}
+
+const defaultReadBufferSize = 64 * 1024
+
+// peeker is implemented by buffered readers such as *bufio.Reader, which the
+// lexer reads from directly.
+type peeker interface {
+	Peek(n int) ([]byte, error)
 }
 
-// NewLexer returns a new lexer for the given reader.
+// NewLexer returns a new lexer for the given reader. Input is buffered
+// internally if LexerOptions.ReadBufferSize is set.
+//
+// The lexer reads its input in a single forward pass and never requires it to
+// be seekable, so pipes and network streams are supported with all options,
+// including EmitChunks and ValidateChunkCRCs. If the reader is an
+// io.ReadSeeker, unread attachment data and skipped chunks are seeked over
+// rather than read.
 func NewLexer(r io.Reader, opts ...*LexerOptions) (*Lexer, error) {
 	var maxRecordSize, maxDecompressedChunkSize int
 	var computeAttachmentCRCs, validateChunkCRCs, emitChunks, emitInvalidChunks, skipMagic bool
+	var streamChunkCRCs bool
+	var validateAttachmentCRCs bool
+	var validateRecordLengths bool
+	var keepHistory int
+	var maxScratchSize int
+	var skipBadChunks bool
+	var recovering bool
+	var onRecover func(Recovery)
+	var badChunkCallback func(uint64, error)
+	var readBufferSize int
+	var allowLegacyLZ4 bool
+	var zstdConcurrency int
+	var requireChunkCRC bool
+	var tolerateTruncation bool
+	var autoDecompressOuter bool
+	var onChunkProgress func(uint64, uint64)
+	var strictOrdering bool
+	var emitUnknownRecords bool
+	var emitOpcodes map[OpCode]bool
+	var decoderPool *DecoderPool
+	var zstdMaxMemory uint64
+	var tee io.Writer
+	checksum := crc32.ChecksumIEEE
 	var attachmentCallback func(*AttachmentReader) error
 	var decompressors map[CompressionFormat]ResettableReader
+	var zstdDictionaries [][]byte
 	if len(opts) > 0 {
 		validateChunkCRCs = opts[0].ValidateChunkCRCs
+		streamChunkCRCs = opts[0].StreamChunkCRCs
 		computeAttachmentCRCs = opts[0].ComputeAttachmentCRCs
+		validateAttachmentCRCs = opts[0].ValidateAttachmentCRCs
 		emitChunks = opts[0].EmitChunks
 		emitInvalidChunks = opts[0].EmitInvalidChunks
 		skipMagic = opts[0].SkipMagic
 		maxRecordSize = opts[0].MaxRecordSize
+		maxScratchSize = opts[0].MaxScratchSize
 		maxDecompressedChunkSize = opts[0].MaxDecompressedChunkSize
 		attachmentCallback = opts[0].AttachmentCallback
 		decompressors = opts[0].Decompressors
+		zstdDictionaries = opts[0].ZSTDDictionaries
+		validateRecordLengths = opts[0].ValidateRecordLengths
+		keepHistory = opts[0].KeepHistory
+		skipBadChunks = opts[0].SkipBadChunks
+		badChunkCallback = opts[0].BadChunkCallback
+		recovering = opts[0].Recover
+		onRecover = opts[0].OnRecover
+		skipBadChunks = skipBadChunks || recovering
+		if opts[0].CRC32 != nil {
+			if streamChunkCRCs {
+				return nil, fmt.Errorf("StreamChunkCRCs cannot be combined with CRC32")
+			}
+			checksum = opts[0].CRC32
+		}
+		readBufferSize = opts[0].ReadBufferSize
+		allowLegacyLZ4 = opts[0].AllowLegacyLZ4
+		zstdConcurrency = opts[0].ZSTDConcurrency
+		requireChunkCRC = opts[0].RequireChunkCRC
+		tolerateTruncation = opts[0].TolerateTruncation
+		autoDecompressOuter = opts[0].AutoDecompressOuter
+		onChunkProgress = opts[0].OnChunkProgress
+		strictOrdering = opts[0].StrictOrdering
+		emitUnknownRecords = opts[0].EmitUnknownRecords
+		if len(opts[0].EmitOpcodes) > 0 {
+			emitOpcodes = make(map[OpCode]bool, len(opts[0].EmitOpcodes))
+			for _, opcode := range opts[0].EmitOpcodes {
+				emitOpcodes[opcode] = true
+			}
+		}
+		decoderPool = opts[0].DecoderPool
+		zstdMaxMemory = opts[0].ZSTDMaxMemory
+		tee = opts[0].Tee
+	}
+	var buffered *bufio.Reader
+	var seeker io.ReadSeeker
+	original := r
+	input := newCountingReader(r)
+	if _, ok := r.(peeker); !ok && readBufferSize > 0 {
+		if rs, ok := r.(io.ReadSeeker); ok && tee == nil {
+			seeker = rs
+		}
+		buffered = bufio.NewReaderSize(input, readBufferSize)
+		r = buffered
+	} else {
+		r = input
+	}
+	var inputSize uint64
+	if rs, ok := original.(io.Seeker); ok && recovering {
+		size, err := remainingSize(rs)
+		if err != nil {
+			return nil, err
+		}
+		inputSize = size
+	}
+	var closeOuter func()
+	if autoDecompressOuter && !skipMagic {
+		// tee the input as read, before it is decompressed
+		if tee != nil {
+			r = io.TeeReader(r, tee)
+			tee = nil
+		}
+		decompressed, closer, err := decompressOuter(r)
+		if err != nil {
+			return nil, err
+		}
+		r = decompressed
+		if closer != nil {
+			if readBufferSize <= 0 {
+				readBufferSize = defaultReadBufferSize
+			}
+			buffered = bufio.NewReaderSize(decompressed, readBufferSize)
+			r = buffered
+			seeker = nil
+			closeOuter = closer
+		}
+	}
+	if tee != nil {
+		r = io.TeeReader(r, tee)
 	}
+	if recovering {
+		if closeOuter != nil {
+			inputSize = 0
+		}
+		if _, ok := r.(peekDiscarder); !ok {
+			// an unbuffered input is buffered here as it would have been
+			// under ReadBufferSize, and may still be seeked over
+			unwrapped := r == io.Reader(input)
+			buffered = bufio.NewReaderSize(r, defaultReadBufferSize)
+			r = buffered
+			seeker = nil
+			if rs, ok := original.(io.ReadSeeker); ok && unwrapped {
+				seeker = rs
+			}
+		}
+	}
+	var order *orderChecker
+	if strictOrdering {
+		order = &orderChecker{}
+		if skipMagic {
+			order.section = SectionData
+		}
+	}
+	var offset uint64
 	if !skipMagic {
 		err := validateMagic(r)
 		if err != nil {
 			return nil, err
 		}
+		offset = uint64(len(Magic))
 	}
 
 	return &Lexer{
 		basereader:               r,
+		input:                    input,
 		reader:                   r,
 		buf:                      make([]byte, 32),
 		validateChunkCRCs:        validateChunkCRCs,
+		streamChunkCRCs:          streamChunkCRCs,
 		computeAttachmentCRCs:    computeAttachmentCRCs,
+		validateAttachmentCRCs:   validateAttachmentCRCs,
 		emitChunks:               emitChunks,
 		emitInvalidChunks:        emitInvalidChunks,
 		maxRecordSize:            maxRecordSize,
+		maxScratchSize:           maxScratchSize,
 		maxDecompressedChunkSize: maxDecompressedChunkSize,
 		attachmentCallback:       attachmentCallback,
 		decompressors:            decompressors,
+		zstdDictionaries:         zstdDictionaries,
+		validateRecordLengths:    validateRecordLengths,
+		keepHistory:              keepHistory,
+		checksum:                 checksum,
+		buffered:                 buffered,
+		allowLegacyLZ4:           allowLegacyLZ4,
+		zstdConcurrency:          zstdConcurrency,
+		requireChunkCRC:          requireChunkCRC,
+		tolerateTruncation:       tolerateTruncation,
+		decoderPool:              decoderPool,
+		zstdMaxMemory:            zstdMaxMemory,
+		seeker:                   seeker,
+		closeOuter:               closeOuter,
+		onChunkProgress:          onChunkProgress,
+		order:                    order,
+		emitUnknownRecords:       emitUnknownRecords,
+		emitOpcodes:              emitOpcodes,
+		skipBadChunks:            skipBadChunks,
+		recovering:               recovering,
+		onRecover:                onRecover,
+		inputSize:                inputSize,
+		badChunkCallback:         badChunkCallback,
+		offset:                   offset,
 	}, nil
 }
+
+// NewLexerAt returns a new lexer for a reader positioned in the middle of an
+// MCAP file. The reader must be positioned at the opcode byte of a top-level
+// record, such as the ChunkStartOffset of a ChunkIndex record; the leading
+// magic is not expected and is not validated. Offsets inside a chunk, such as
+// those in MessageIndex records, refer to the decompressed chunk data and are
+// not valid starting points. If startInChunk is true, the first record read
+// must be a Chunk, otherwise Next returns ErrNotAtChunk.
+func NewLexerAt(r io.Reader, startInChunk bool, opts ...*LexerOptions) (*Lexer, error) {
+	options := LexerOptions{}
+	if len(opts) > 0 {
+		options = *opts[0]
+	}
+	options.SkipMagic = true
+	lexer, err := NewLexer(r, &options)
+	if err != nil {
+		return nil, err
+	}
+	lexer.expectChunk = startInChunk
+	return lexer, nil
+}
@@ -0,0 +1,130 @@
+package mcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// ErrUnrecognizedBytes indicates input skipped under LexerOptions.Recover
+// because it did not begin a plausible record.
+var ErrUnrecognizedBytes = errors.New("bytes do not begin a plausible record")
+
+// Recovery describes input discarded by a lexer under LexerOptions.Recover.
+type Recovery struct {
+	// Offset is the offset in the lexer's input of the discarded bytes, or of
+	// the chunk or record they belonged to.
+	Offset uint64
+	// Length is the number of bytes discarded. It is zero for a record cut
+	// short by the end of the input, where the number of bytes read before
+	// the input ended is not known.
+	Length uint64
+	// Err is the error that caused the input to be discarded: a chunk error
+	// as for SkipBadChunks, ErrUnrecognizedBytes, or an error wrapping
+	// io.ErrUnexpectedEOF for a truncated record.
+	Err error
+}
+
+// peekDiscarder is implemented by buffered readers such as *bufio.Reader,
+// which the lexer requires to look for records under Recover.
+type peekDiscarder interface {
+	peeker
+	Discard(n int) (int, error)
+}
+
+// resync skips input that does not begin a plausible top-level record, one
+// byte at a time, until one is found or fewer bytes than a record prefix
+// remain.
+func (l *Lexer) resync() error {
+	r := l.basereader.(peekDiscarder)
+	start := l.offset
+	var skipped uint64
+	for {
+		prefix, err := r.Peek(9)
+		if err != nil || l.plausibleRecord(prefix, skipped > 0) {
+			break
+		}
+		if _, err := r.Discard(1); err != nil {
+			return err
+		}
+		skipped++
+		l.offset++
+	}
+	if skipped > 0 {
+		l.reportRecovery(Recovery{Offset: start, Length: skipped, Err: ErrUnrecognizedBytes})
+	}
+	return nil
+}
+
+// plausibleRecord reports whether prefix, the opcode and length of a record,
+// could begin a top-level record: the opcode must be defined by the
+// specification, and the length at least the minimum for the opcode and no
+// more than MaxRecordSize where that is set, or than can be read into memory.
+// When scanning past skipped bytes, the length must also be within the
+// remaining input where that is known. This is not required of a record
+// directly following the last one read, which is more likely to have been cut
+// short by the end of the input than to be corrupt, so that it is read as far
+// as it goes.
+func (l *Lexer) plausibleRecord(prefix []byte, scanning bool) bool {
+	opcode := OpCode(prefix[0])
+	minLength, ok := minRecordLengths[opcode]
+	if !ok {
+		return false
+	}
+	recordLen := binary.LittleEndian.Uint64(prefix[1:9])
+	if recordLen < minLength {
+		return false
+	}
+	if scanning && l.inputSize > 0 && recordLen > l.inputSize-l.offset-9 {
+		return false
+	}
+	if l.maxRecordSize > 0 && recordLen > uint64(l.maxRecordSize) {
+		return false
+	}
+	// records other than chunks and attachments, which are streamed, are
+	// read into memory, which is limited to lengths in int32 range.
+	if opcode != OpChunk && opcode != OpAttachment && recordLen >= math.MaxInt32 {
+		return false
+	}
+	return true
+}
+
+// recoverTruncation ends lexing at a record cut short by the end of the
+// input, reporting the record as discarded.
+func (l *Lexer) recoverTruncation(err error) {
+	offset := l.current.Offset
+	if l.chunkReader != nil {
+		offset = l.chunkRecordOffset
+	}
+	l.inChunk = false
+	l.reader = l.basereader
+	l.chunkReader = nil
+	l.checkChunkCRC = false
+	l.recoveredEOF = true
+	l.reportRecovery(Recovery{Offset: offset, Err: err})
+}
+
+func (l *Lexer) reportRecovery(recovery Recovery) {
+	if l.onRecover != nil {
+		l.onRecover(recovery)
+	}
+}
+
+// remainingSize returns the number of bytes from the current position of rs
+// to its end, leaving its position unchanged.
+func remainingSize(rs io.Seeker) (uint64, error) {
+	current, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find position in input: %w", err)
+	}
+	end, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find size of input: %w", err)
+	}
+	if _, err := rs.Seek(current, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to restore position in input: %w", err)
+	}
+	return uint64(end - current), nil
+}
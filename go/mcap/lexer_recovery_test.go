@@ -0,0 +1,137 @@
+package mcap
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// unseekableReader hides the Seek method of a reader.
+type unseekableReader struct {
+	r io.Reader
+}
+
+func (r unseekableReader) Read(p []byte) (int, error) {
+	return r.r.Read(p)
+}
+
+// minimalRecord returns a record with a body of zeros of the minimum length
+// for its opcode, which unlike the zero-length records of the other test
+// helpers are plausible to a recovering lexer.
+func minimalRecord(op OpCode) []byte {
+	return flatten([]byte{byte(op)}, encodedUint64(minRecordLengths[op]), make([]byte, minRecordLengths[op]))
+}
+
+func TestRecover(t *testing.T) {
+	header := minimalRecord(OpHeader)
+	channelInfo := minimalRecord(OpChannel)
+	message := minimalRecord(OpMessage)
+	footer := minimalRecord(OpFooter)
+	garbage := bytes.Repeat([]byte{0xff}, 13)
+	badChunk := chunk(t, CompressionZSTD, true, channelInfo, message)
+	badChunk[1+8+8+8+8] ^= 0xff // first byte of the uncompressed CRC
+	lastChunk := chunk(t, CompressionZSTD, true, channelInfo, message, message)
+	headerEnd := uint64(len(Magic) + len(header))
+	longChunkPrefix := flatten([]byte{byte(OpChunk)}, encodedUint64(1<<40))
+	cases := []struct {
+		assertion  string
+		input      []byte
+		expected   []TokenType
+		recoveries []Recovery
+		// seekableOnly marks cases relying on the size of a seekable input
+		seekableOnly bool
+	}{
+		{
+			"unrecognized bytes between records",
+			file(header, garbage, channelInfo, message, footer),
+			[]TokenType{TokenHeader, TokenChannel, TokenMessage, TokenFooter},
+			[]Recovery{{Offset: headerEnd, Length: uint64(len(garbage)), Err: ErrUnrecognizedBytes}},
+			false,
+		},
+		{
+			"implausible record length",
+			file(header, []byte{byte(OpMessage), 1, 0, 0, 0, 0, 0, 0, 0}, message, footer),
+			[]TokenType{TokenHeader, TokenMessage, TokenFooter},
+			[]Recovery{{Offset: headerEnd, Length: 9, Err: ErrUnrecognizedBytes}},
+			false,
+		},
+		{
+			"record longer than the remaining input",
+			file(header, []byte{0xff}, longChunkPrefix, channelInfo, message, footer),
+			[]TokenType{TokenHeader, TokenChannel, TokenMessage, TokenFooter},
+			[]Recovery{{Offset: headerEnd, Length: 1 + uint64(len(longChunkPrefix)), Err: ErrUnrecognizedBytes}},
+			true,
+		},
+		{
+			"bad chunk",
+			file(header, badChunk, message, footer),
+			[]TokenType{TokenHeader, TokenMessage, TokenFooter},
+			[]Recovery{{Offset: headerEnd, Length: uint64(len(badChunk))}},
+			false,
+		},
+		{
+			"truncated top-level record",
+			flatten(Magic, header, channelInfo, message[:5]),
+			[]TokenType{TokenHeader, TokenChannel},
+			[]Recovery{{Offset: headerEnd + uint64(len(channelInfo))}},
+			false,
+		},
+		{
+			"truncated chunk",
+			flatten(Magic, header, lastChunk[:len(lastChunk)-10]),
+			[]TokenType{TokenHeader},
+			[]Recovery{{Offset: headerEnd}},
+			false,
+		},
+	}
+	for _, c := range cases {
+		for _, seekable := range []bool{true, false} {
+			if c.seekableOnly && !seekable {
+				continue
+			}
+			name := c.assertion
+			if !seekable {
+				name += " unseekable"
+			}
+			t.Run(name, func(t *testing.T) {
+				var r io.Reader = bytes.NewReader(c.input)
+				if !seekable {
+					r = unseekableReader{r}
+				}
+				var recoveries []Recovery
+				lexer, err := NewLexer(r, &LexerOptions{
+					ValidateChunkCRCs: true,
+					Recover:           true,
+					OnRecover: func(recovery Recovery) {
+						recoveries = append(recoveries, recovery)
+					},
+				})
+				assert.Nil(t, err)
+				tokens := []TokenType{}
+				for {
+					tokenType, _, err := lexer.Next(nil)
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					assert.Nil(t, err)
+					tokens = append(tokens, tokenType)
+				}
+				assert.Equal(t, c.expected, tokens)
+				assert.Equal(t, len(c.recoveries), len(recoveries))
+				for i, recovery := range recoveries {
+					assert.Equal(t, c.recoveries[i].Offset, recovery.Offset)
+					assert.Equal(t, c.recoveries[i].Length, recovery.Length)
+					assert.NotNil(t, recovery.Err)
+					if c.recoveries[i].Err != nil {
+						assert.ErrorIs(t, recovery.Err, c.recoveries[i].Err)
+					}
+				}
+				_, _, err = lexer.Next(nil)
+				assert.ErrorIs(t, err, io.EOF)
+			})
+		}
+	}
+}
@@ -2,15 +2,24 @@ package mcap
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"math"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
 	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/pierrec/lz4/v4"
 	"github.com/stretchr/testify/assert"
 )
@@ -53,7 +62,7 @@ func TestRejectsUnsupportedCompression(t *testing.T) {
 	lexer, err := NewLexer(bytes.NewReader(file))
 	assert.Nil(t, err)
 	_, _, err = lexer.Next(nil)
-	assert.Equal(t, "unsupported compression: unknown", err.Error())
+	assert.Equal(t, "failed reading chunk record of 124 bytes at offset 8: unsupported compression: unknown", err.Error())
 }
 
 func TestRejectsTooLargeRecords(t *testing.T) {
@@ -68,6 +77,54 @@ func TestRejectsTooLargeRecords(t *testing.T) {
 	assert.ErrorIs(t, err, ErrRecordTooLarge)
 }
 
+func TestValidateRecordLengths(t *testing.T) {
+	validHeader := flatten([]byte{byte(OpHeader)}, encodedUint64(8), prefixedString(""), prefixedString(""))
+	t.Run("zero-length records are lexed by default", func(t *testing.T) {
+		lexer, err := NewLexer(bytes.NewReader(file(validHeader, message(), footer())))
+		assert.Nil(t, err)
+		for _, expected := range []TokenType{TokenHeader, TokenMessage, TokenFooter} {
+			tokenType, _, err := lexer.Next(nil)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, tokenType)
+		}
+	})
+	cases := []struct {
+		assertion string
+		input     []byte
+		message   string
+	}{
+		{
+			"zero-length message",
+			file(validHeader, message(), footer()),
+			"message record length 0 is less than minimum 22",
+		},
+		{
+			"short channel",
+			file(validHeader, flatten([]byte{byte(OpChannel)}, encodedUint64(4), make([]byte, 4)), footer()),
+			"channel record length 4 is less than minimum 16",
+		},
+		{
+			"zero-length message in chunk",
+			file(validHeader, chunk(t, CompressionZSTD, true, message()), footer()),
+			"message record length 0 is less than minimum 22",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			lexer, err := NewLexer(bytes.NewReader(c.input), &LexerOptions{ValidateRecordLengths: true})
+			assert.Nil(t, err)
+			tokenType, _, err := lexer.Next(nil)
+			assert.Nil(t, err)
+			assert.Equal(t, TokenHeader, tokenType)
+			_, _, err = lexer.Next(nil)
+			assert.ErrorIs(t, err, ErrRecordTooShort)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), c.message)
+			}
+		})
+	}
+}
+
 func TestRejectsTooLargeChunks(t *testing.T) {
 	bigChunk := chunk(t, CompressionZSTD, true, channelInfo(), message(), message())
 	binary.LittleEndian.PutUint64(bigChunk[1+8+8+8:], 1000)
@@ -135,6 +192,38 @@ func TestBadMagic(t *testing.T) {
 	}
 }
 
+func TestTruncatedMagic(t *testing.T) {
+	cases := []struct {
+		assertion string
+		input     io.Reader
+		err       error
+	}{
+		{
+			"empty file",
+			bytes.NewReader(nil),
+			io.ErrUnexpectedEOF,
+		},
+		{
+			"partial magic",
+			bytes.NewReader(Magic[:4]),
+			io.ErrUnexpectedEOF,
+		},
+		{
+			"read error",
+			iotest.ErrReader(io.ErrClosedPipe),
+			io.ErrClosedPipe,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			_, err := NewLexer(c.input)
+			assert.ErrorIs(t, err, c.err)
+			var badMagic *ErrBadMagic
+			assert.False(t, errors.As(err, &badMagic))
+		})
+	}
+}
+
 type lzreader struct {
 	*lz4.Reader
 }
@@ -286,6 +375,25 @@ func TestChunkCRCValidation(t *testing.T) {
 			assert.Equal(t, expectedTokenType, tokenType, fmt.Sprintf("mismatch element %d", i))
 		}
 	})
+	t.Run("rejects zero'd CRCs when required", func(t *testing.T) {
+		file := file(
+			header(),
+			chunk(t, CompressionZSTD, true, channelInfo(), message()),
+			chunk(t, CompressionZSTD, false, channelInfo(), message()),
+			footer(),
+		)
+		lexer, err := NewLexer(bytes.NewReader(file), &LexerOptions{
+			RequireChunkCRC: true,
+		})
+		assert.Nil(t, err)
+		for _, expectedTokenType := range []TokenType{TokenHeader, TokenChannel, TokenMessage} {
+			tokenType, _, err := lexer.Next(nil)
+			assert.Nil(t, err)
+			assert.Equal(t, expectedTokenType, tokenType)
+		}
+		_, _, err = lexer.Next(nil)
+		assert.ErrorIs(t, err, ErrChunkCRCMissing)
+	})
 	t.Run("validates file with zero'd CRCs", func(t *testing.T) {
 		file := file(
 			header(),
@@ -517,3 +625,1760 @@ func BenchmarkLexer(b *testing.B) {
 		}
 	}
 }
+
+func TestZSTDDictionaryChunks(t *testing.T) {
+	// a small dictionary trained on JSON-encoded IMU samples.
+	dict := []byte{
+		0x37, 0xa4, 0x30, 0xec, 0xd2, 0x04, 0x00, 0x00, 0x08, 0xc0, 0x77, 0x44, 0x8a, 0x24, 0x0f, 0x80,
+		0x71, 0x12, 0x10, 0x00, 0x08, 0xf0, 0x07, 0x12, 0x00, 0x00, 0x08, 0x10, 0x0a, 0x3e, 0x50, 0x70,
+		0x60, 0x38, 0x10, 0xf8, 0x01, 0x12, 0x1a, 0x9e, 0x3f, 0x13, 0x00, 0x00, 0x00, 0x0a, 0x00, 0x00,
+		0x00, 0x08, 0x00, 0x00, 0x00,
+	}
+	dict = append(dict, []byte(`{"sensor":"imu","seq":,"accel":{"x":0.,"y":0.,"z":9.81},"status":"nominal"}`)...)
+
+	msg := make([]byte, 9)
+	msg[0] = byte(OpMessage)
+	payload := []byte(`{"sensor":"imu","seq":1,"accel":{"x":0.007,"y":0.013,"z":9.81},"status":"nominal"}`)
+	putUint64(msg[1:], uint64(len(payload)))
+	msg = append(msg, payload...)
+	data := flatten(channelInfo(), msg)
+
+	buf := &bytes.Buffer{}
+	w, err := zstd.NewWriter(buf, zstd.WithEncoderDict(dict))
+	assert.Nil(t, err)
+	_, err = w.Write(data)
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+
+	for _, validateCRC := range []bool{true, false} {
+		t.Run(fmt.Sprintf("crc validation %v", validateCRC), func(t *testing.T) {
+			file := file(
+				header(),
+				chunkRecord(t, CompressionZSTD, true, data, buf.Bytes()),
+				chunk(t, CompressionZSTD, true, channelInfo(), message()),
+				footer(),
+			)
+			lexer, err := NewLexer(bytes.NewReader(file), &LexerOptions{
+				ValidateChunkCRCs: validateCRC,
+				ZSTDDictionaries:  [][]byte{dict},
+			})
+			assert.Nil(t, err)
+			expected := []TokenType{
+				TokenHeader,
+				TokenChannel,
+				TokenMessage,
+				TokenChannel,
+				TokenMessage,
+				TokenFooter,
+			}
+			for i, expectedTokenType := range expected {
+				tokenType, record, err := lexer.Next(nil)
+				assert.Nil(t, err)
+				assert.Equal(t, expectedTokenType, tokenType, fmt.Sprintf("mismatch element %d", i))
+				if i == 2 {
+					assert.Equal(t, payload, record)
+				}
+			}
+		})
+	}
+}
+
+func TestLexerAtChunkOffset(t *testing.T) {
+	firstChunk := chunk(t, CompressionZSTD, true, channelInfo(), message())
+	secondChunk := chunk(t, CompressionLZ4, true, channelInfo(), message(), message())
+	input := file(header(), firstChunk, secondChunk, record(OpDataEnd), footer())
+	secondChunkOffset := len(Magic) + len(header()) + len(firstChunk)
+
+	t.Run("reads from a chunk offset", func(t *testing.T) {
+		lexer, err := NewLexerAt(bytes.NewReader(input[secondChunkOffset:]), true)
+		assert.Nil(t, err)
+		expected := []TokenType{
+			TokenChannel,
+			TokenMessage,
+			TokenMessage,
+			TokenDataEnd,
+			TokenFooter,
+		}
+		for i, expectedTokenType := range expected {
+			tokenType, _, err := lexer.Next(nil)
+			assert.Nil(t, err)
+			assert.Equal(t, expectedTokenType, tokenType, fmt.Sprintf("mismatch element %d", i))
+		}
+	})
+	t.Run("emits the chunk with EmitChunks", func(t *testing.T) {
+		lexer, err := NewLexerAt(bytes.NewReader(input[secondChunkOffset:]), true, &LexerOptions{
+			EmitChunks: true,
+		})
+		assert.Nil(t, err)
+		tokenType, _, err := lexer.Next(nil)
+		assert.Nil(t, err)
+		assert.Equal(t, TokenChunk, tokenType)
+	})
+	t.Run("rejects non-chunk offset", func(t *testing.T) {
+		lexer, err := NewLexerAt(bytes.NewReader(input[len(Magic):]), true)
+		assert.Nil(t, err)
+		_, _, err = lexer.Next(nil)
+		assert.ErrorIs(t, err, ErrNotAtChunk)
+	})
+	t.Run("accepts any record when not starting in a chunk", func(t *testing.T) {
+		lexer, err := NewLexerAt(bytes.NewReader(input[len(Magic):]), false)
+		assert.Nil(t, err)
+		tokenType, _, err := lexer.Next(nil)
+		assert.Nil(t, err)
+		assert.Equal(t, TokenHeader, tokenType)
+	})
+}
+
+func TestAttachmentCallbackPartialRead(t *testing.T) {
+	attachmentData := bytes.Repeat([]byte{0x01, 0x02, 0x03, 0x04}, 1024)
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.WriteAttachment(&Attachment{
+		Name:      "video",
+		MediaType: "video/mp4",
+		DataSize:  uint64(len(attachmentData)),
+		Data:      bytes.NewReader(attachmentData),
+	}))
+	assert.Nil(t, writer.Close())
+
+	var names []string
+	lexer, err := NewLexer(buf, &LexerOptions{
+		AttachmentCallback: func(ar *AttachmentReader) error {
+			names = append(names, ar.Name)
+			// read only the first few bytes of the attachment
+			head := make([]byte, 8)
+			_, err := io.ReadFull(ar.Data(), head)
+			assert.Nil(t, err)
+			assert.Equal(t, attachmentData[:8], head)
+			return nil
+		},
+	})
+	assert.Nil(t, err)
+	expected := []TokenType{
+		TokenHeader,
+		TokenDataEnd,
+		TokenStatistics,
+		TokenAttachmentIndex,
+		TokenSummaryOffset,
+		TokenSummaryOffset,
+		TokenFooter,
+	}
+	for i, expectedTokenType := range expected {
+		tokenType, _, err := lexer.Next(nil)
+		assert.Nil(t, err)
+		assert.Equal(t, expectedTokenType, tokenType, fmt.Sprintf("mismatch element %d", i))
+	}
+	_, _, err = lexer.Next(nil)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, []string{"video"}, names)
+}
+
+// cancelingReader cancels a context once more than limit bytes have been read.
+type cancelingReader struct {
+	r      io.Reader
+	read   int
+	limit  int
+	cancel context.CancelFunc
+}
+
+func (c *cancelingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.read += n
+	if c.read > c.limit {
+		c.cancel()
+	}
+	return n, err
+}
+
+func TestNextContext(t *testing.T) {
+	t.Run("checks context between records", func(t *testing.T) {
+		lexer, err := NewLexer(bytes.NewReader(file(header(), channelInfo(), footer())))
+		assert.Nil(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		tokenType, _, err := lexer.NextContext(ctx, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, TokenHeader, tokenType)
+		cancel()
+		_, _, err = lexer.NextContext(ctx, nil)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+	t.Run("context does not outlive the call", func(t *testing.T) {
+		lexer, err := NewLexer(bytes.NewReader(file(header(), footer())))
+		assert.Nil(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		_, _, err = lexer.NextContext(ctx, nil)
+		assert.Nil(t, err)
+		cancel()
+		tokenType, _, err := lexer.Next(nil)
+		assert.Nil(t, err)
+		assert.Equal(t, TokenFooter, tokenType)
+	})
+	t.Run("interrupts reading chunk data", func(t *testing.T) {
+		records := [][]byte{}
+		for i := 0; i < 1000; i++ {
+			records = append(records, channelInfo())
+		}
+		data := file(header(), chunk(t, CompressionNone, true, records...), footer())
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		r := &cancelingReader{r: bytes.NewReader(data), limit: 100, cancel: cancel}
+		lexer, err := NewLexer(iotest.OneByteReader(r), &LexerOptions{ValidateChunkCRCs: true})
+		assert.Nil(t, err)
+		tokenType, _, err := lexer.NextContext(ctx, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, TokenHeader, tokenType)
+		_, _, err = lexer.NextContext(ctx, nil)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Less(t, r.read, 200)
+	})
+}
+
+func TestCurrentChunkTimeRange(t *testing.T) {
+	lexer, err := NewLexer(bytes.NewReader(file(
+		header(),
+		channelInfo(),
+		chunk(t, CompressionLZ4, true, channelInfo(), message()),
+		footer(),
+	)))
+	assert.Nil(t, err)
+	expected := []struct {
+		tokenType TokenType
+		ok        bool
+	}{
+		{TokenHeader, false},
+		{TokenChannel, false},
+		{TokenChannel, true},
+		{TokenMessage, true},
+		{TokenFooter, false},
+	}
+	for i, e := range expected {
+		tokenType, _, err := lexer.Next(nil)
+		assert.Nil(t, err)
+		assert.Equal(t, e.tokenType, tokenType, fmt.Sprintf("mismatch element %d", i))
+		start, end, ok := lexer.CurrentChunkTimeRange()
+		assert.Equal(t, e.ok, ok, fmt.Sprintf("mismatch element %d", i))
+		if ok {
+			assert.Equal(t, uint64(0), start)
+			assert.Equal(t, uint64(1e9), end)
+		}
+	}
+}
+
+func TestLexerHistory(t *testing.T) {
+	chunkRecord := chunk(t, CompressionZSTD, true, channelInfo(), message())
+	input := file(header(), channelInfo(), chunkRecord, attachment(), flatten(message()[:1], encodedUint64(100)))
+	t.Run("disabled by default", func(t *testing.T) {
+		lexer, err := NewLexer(bytes.NewReader(input))
+		assert.Nil(t, err)
+		_, _, err = lexer.Next(nil)
+		assert.Nil(t, err)
+		assert.Empty(t, lexer.History())
+	})
+	t.Run("retains most recent records", func(t *testing.T) {
+		lexer, err := NewLexer(bytes.NewReader(input), &LexerOptions{KeepHistory: 4})
+		assert.Nil(t, err)
+		for i := 0; i < 4; i++ {
+			_, _, err = lexer.Next(nil)
+			assert.Nil(t, err)
+		}
+		_, _, err = lexer.Next(nil)
+		var truncated *ErrTruncatedRecord
+		assert.ErrorAs(t, err, &truncated)
+		attachmentOffset := uint64(len(Magic) + 2*9 + len(chunkRecord))
+		assert.Equal(t, []RecordRef{
+			{OpCode: OpChannel, Offset: 0, Length: 0, InChunk: true},
+			{OpCode: OpMessage, Offset: 9, Length: 0, InChunk: true},
+			{OpCode: OpAttachment, Offset: attachmentOffset, Length: uint64(len(attachment()) - 9)},
+			{OpCode: OpMessage, Offset: attachmentOffset + uint64(len(attachment())), Length: 100},
+		}, lexer.History())
+	})
+}
+
+func TestNextWithOffset(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{
+		Chunked:     true,
+		ChunkSize:   10,
+		Compression: CompressionLZ4,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.WriteSchema(&Schema{ID: 1}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/foo"}))
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, LogTime: uint64(i), Data: []byte("hello")}))
+	}
+	assert.Nil(t, writer.Close())
+
+	reader, err := NewReader(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	info, err := reader.Info()
+	assert.Nil(t, err)
+	chunkOffsets := map[uint64]bool{}
+	for _, idx := range info.ChunkIndexes {
+		chunkOffsets[idx.ChunkStartOffset] = true
+	}
+	assert.Equal(t, 3, len(chunkOffsets))
+
+	lexer, err := NewLexer(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	tokenType, _, offset, err := lexer.NextWithOffset(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, TokenHeader, tokenType)
+	assert.Equal(t, uint64(len(Magic)), offset)
+	messageOffsets := map[uint64]bool{}
+	for {
+		tokenType, record, offset, err := lexer.NextWithOffset(nil)
+		assert.Nil(t, err)
+		if tokenType == TokenFooter {
+			assert.Equal(t, uint64(buf.Len()-len(Magic)-9-len(record)), offset)
+			break
+		}
+		if tokenType == TokenMessage {
+			assert.True(t, chunkOffsets[offset], "message offset %d is not a chunk offset", offset)
+			messageOffsets[offset] = true
+		}
+	}
+	assert.Equal(t, chunkOffsets, messageOffsets)
+}
+
+func TestNextWithPosition(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{
+		Chunked:     true,
+		ChunkSize:   100,
+		Compression: CompressionZSTD,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.WriteSchema(&Schema{ID: 1}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/foo"}))
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, LogTime: uint64(i), Data: []byte("hello")}))
+	}
+	assert.Nil(t, writer.Close())
+
+	lexer, err := NewLexer(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	tokenType, _, position, err := lexer.NextWithPosition(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, TokenHeader, tokenType)
+	assert.Equal(t, TokenPosition{Offset: uint64(len(Magic))}, position)
+	// the chunk offsets of the messages of each chunk, keyed by chunk offset,
+	// should match those recorded in the chunk's message index.
+	messageOffsets := map[uint64][]uint64{}
+	indexedOffsets := map[uint64][]uint64{}
+	var lastChunk uint64
+	for tokenType != TokenFooter {
+		var record []byte
+		tokenType, record, position, err = lexer.NextWithPosition(nil)
+		assert.Nil(t, err)
+		switch tokenType {
+		case TokenMessage:
+			assert.True(t, position.InChunk)
+			messageOffsets[position.Offset] = append(messageOffsets[position.Offset], position.ChunkOffset)
+			lastChunk = position.Offset
+		case TokenMessageIndex:
+			assert.False(t, position.InChunk)
+			assert.Zero(t, position.ChunkOffset)
+			assert.Greater(t, position.Offset, lastChunk)
+			idx, err := ParseMessageIndex(record)
+			assert.Nil(t, err)
+			for _, entry := range idx.Records {
+				indexedOffsets[lastChunk] = append(indexedOffsets[lastChunk], entry.Offset)
+			}
+		}
+	}
+	assert.Greater(t, len(messageOffsets), 1)
+	assert.Equal(t, indexedOffsets, messageOffsets)
+}
+
+func TestLexerTee(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{
+		Chunked:     true,
+		ChunkSize:   100,
+		Compression: CompressionLZ4,
+		IncludeCRC:  true,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.WriteSchema(&Schema{ID: 1}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/foo"}))
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, LogTime: uint64(i), Data: []byte("hello")}))
+	}
+	assert.Nil(t, writer.WriteAttachment(&Attachment{Name: "file", DataSize: 4, Data: bytes.NewReader([]byte("data"))}))
+	assert.Nil(t, writer.Close())
+
+	t.Run("copies input", func(t *testing.T) {
+		for _, validateCRCs := range []bool{true, false} {
+			tee := &bytes.Buffer{}
+			lexer, err := NewLexer(bytes.NewReader(buf.Bytes()), &LexerOptions{
+				Tee:               tee,
+				ValidateChunkCRCs: validateCRCs,
+			})
+			assert.Nil(t, err)
+			for err == nil {
+				_, _, err = lexer.Next(nil)
+			}
+			assert.ErrorIs(t, err, io.EOF)
+			assert.Equal(t, buf.Bytes(), tee.Bytes())
+		}
+	})
+	t.Run("returns tee errors", func(t *testing.T) {
+		lexer, err := NewLexer(bytes.NewReader(buf.Bytes()), &LexerOptions{
+			Tee:       errWriter{io.ErrClosedPipe},
+			SkipMagic: true,
+		})
+		assert.Nil(t, err)
+		_, _, err = lexer.Next(nil)
+		assert.ErrorIs(t, err, io.ErrClosedPipe)
+	})
+}
+
+type errWriter struct {
+	err error
+}
+
+func (w errWriter) Write([]byte) (int, error) {
+	return 0, w.err
+}
+
+func TestCustomCRC32(t *testing.T) {
+	input := file(header(), chunk(t, CompressionZSTD, true, channelInfo(), message()), footer())
+	t.Run("custom function is used", func(t *testing.T) {
+		calls := 0
+		lexer, err := NewLexer(bytes.NewReader(input), &LexerOptions{
+			ValidateChunkCRCs: true,
+			CRC32: func(data []byte) uint32 {
+				calls++
+				return crc32.ChecksumIEEE(data)
+			},
+		})
+		assert.Nil(t, err)
+		for err == nil {
+			_, _, err = lexer.Next(nil)
+		}
+		assert.ErrorIs(t, err, io.EOF)
+		assert.Equal(t, 1, calls)
+	})
+	t.Run("mismatched function fails validation", func(t *testing.T) {
+		castagnoli := crc32.MakeTable(crc32.Castagnoli)
+		lexer, err := NewLexer(bytes.NewReader(input), &LexerOptions{
+			ValidateChunkCRCs: true,
+			CRC32: func(data []byte) uint32 {
+				return crc32.Checksum(data, castagnoli)
+			},
+		})
+		assert.Nil(t, err)
+		_, _, err = lexer.Next(nil)
+		assert.Nil(t, err)
+		_, _, err = lexer.Next(nil)
+		var invalidCrc *errInvalidChunkCrc
+		assert.ErrorAs(t, err, &invalidCrc)
+	})
+}
+
+func TestEmptyChunks(t *testing.T) {
+	for _, compression := range []CompressionFormat{CompressionNone, CompressionLZ4, CompressionZSTD} {
+		for _, validateCRCs := range []bool{true, false} {
+			fixtures := map[string][]byte{
+				"zero-length records":    chunkRecord(t, compression, true, nil, nil),
+				"compressed empty input": chunk(t, compression, true),
+			}
+			for name, emptyChunk := range fixtures {
+				t.Run(fmt.Sprintf("%s %s crc validation %v", compression, name, validateCRCs), func(t *testing.T) {
+					input := file(
+						header(),
+						emptyChunk,
+						chunk(t, compression, true, channelInfo(), message()),
+						emptyChunk,
+						message(),
+						footer(),
+					)
+					lexer, err := NewLexer(bytes.NewReader(input), &LexerOptions{
+						ValidateChunkCRCs: validateCRCs,
+					})
+					assert.Nil(t, err)
+					expected := []TokenType{
+						TokenHeader,
+						TokenChannel,
+						TokenMessage,
+						TokenMessage,
+						TokenFooter,
+					}
+					for i, expectedTokenType := range expected {
+						tokenType, _, err := lexer.Next(nil)
+						assert.Nil(t, err)
+						assert.Equal(t, expectedTokenType, tokenType, fmt.Sprintf("mismatch element %d", i))
+					}
+					_, _, err = lexer.Next(nil)
+					assert.ErrorIs(t, err, io.EOF)
+				})
+			}
+		}
+	}
+}
+
+func TestCorruptChunkCompressionLength(t *testing.T) {
+	record := chunk(t, CompressionNone, true, channelInfo(), message())
+	// claim a near 4GiB compression format and record length for a chunk in a
+	// small file
+	binary.LittleEndian.PutUint64(record[1:], math.MaxUint32+8+8+8+4+4+8)
+	binary.LittleEndian.PutUint32(record[1+8+8+8+8+4:], math.MaxUint32)
+	lexer, err := NewLexer(bytes.NewReader(file(header(), record, footer())))
+	assert.Nil(t, err)
+	_, _, err = lexer.Next(nil)
+	assert.Nil(t, err)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, _, err = lexer.Next(nil)
+	runtime.ReadMemStats(&after)
+	var truncated *ErrTruncatedRecord
+	assert.ErrorAs(t, err, &truncated)
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<20))
+}
+
+func FuzzLexer(f *testing.F) {
+	f.Add(file(header(), footer()))
+	f.Add(file(header(), channelInfo(), message(), attachment(), footer()))
+	f.Add(file(header(), chunk(f, CompressionNone, true, channelInfo(), message()), footer()))
+	f.Add(file(header(), chunk(f, CompressionLZ4, true, channelInfo(), message()), footer()))
+	f.Add(file(header(), chunk(f, CompressionZSTD, true, channelInfo(), message()), footer()))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, validateCRCs := range []bool{true, false} {
+			lexer, err := NewLexer(bytes.NewReader(data), &LexerOptions{
+				ValidateChunkCRCs:        validateCRCs,
+				MaxRecordSize:            1 << 20,
+				MaxDecompressedChunkSize: 1 << 20,
+			})
+			if err != nil {
+				return
+			}
+			for i := 0; i < 1000; i++ {
+				_, _, err := lexer.Next(nil)
+				if err != nil {
+					break
+				}
+			}
+			lexer.Close()
+		}
+	})
+}
+
+func TestSkipBadChunks(t *testing.T) {
+	goodChunk := chunk(t, CompressionZSTD, true, channelInfo(), message())
+	badCRCChunk := chunk(t, CompressionNone, true, channelInfo(), message())
+	badCRCChunk[len(badCRCChunk)-1] = 0xff
+	badDataChunk := chunk(t, CompressionZSTD, true, channelInfo(), message())
+	badDataChunk[1+8+8+8+8+4+4+4+8] = 0xff // first byte of the zstd frame magic
+	input := file(header(), goodChunk, badCRCChunk, badDataChunk, message(), footer())
+
+	t.Run("bad chunks are an error by default", func(t *testing.T) {
+		lexer, err := NewLexer(bytes.NewReader(input), &LexerOptions{ValidateChunkCRCs: true})
+		assert.Nil(t, err)
+		for err == nil {
+			_, _, err = lexer.Next(nil)
+		}
+		var invalidCrc *errInvalidChunkCrc
+		assert.ErrorAs(t, err, &invalidCrc)
+	})
+	t.Run("bad chunks are skipped", func(t *testing.T) {
+		var offsets []uint64
+		var errs []error
+		lexer, err := NewLexer(bytes.NewReader(input), &LexerOptions{
+			ValidateChunkCRCs: true,
+			SkipBadChunks:     true,
+			BadChunkCallback: func(offset uint64, err error) {
+				offsets = append(offsets, offset)
+				errs = append(errs, err)
+			},
+		})
+		assert.Nil(t, err)
+		expected := []TokenType{
+			TokenHeader,
+			TokenChannel,
+			TokenMessage,
+			TokenMessage,
+			TokenFooter,
+		}
+		for i, expectedTokenType := range expected {
+			tokenType, _, err := lexer.Next(nil)
+			assert.Nil(t, err)
+			assert.Equal(t, expectedTokenType, tokenType, "mismatch on token %d", i)
+		}
+		_, _, err = lexer.Next(nil)
+		assert.ErrorIs(t, err, io.EOF)
+		badCRCOffset := uint64(len(Magic) + len(header()) + len(goodChunk))
+		assert.Equal(t, []uint64{badCRCOffset, badCRCOffset + uint64(len(badCRCChunk))}, offsets)
+		assert.Equal(t, 2, len(errs))
+		assert.IsType(t, &errInvalidChunkCrc{}, errs[0])
+	})
+	t.Run("truncated chunk is not skipped", func(t *testing.T) {
+		truncated := flatten(Magic, header(), goodChunk[:len(goodChunk)-4])
+		lexer, err := NewLexer(bytes.NewReader(truncated), &LexerOptions{
+			ValidateChunkCRCs: true,
+			SkipBadChunks:     true,
+		})
+		assert.Nil(t, err)
+		for err == nil {
+			_, _, err = lexer.Next(nil)
+		}
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+}
+
+func TestReadBufferSize(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, Topic: "/foo"}))
+	for _, size := range []int{10, 100, 1000} {
+		assert.Nil(t, writer.WriteAttachment(&Attachment{
+			Name:     "attachment",
+			DataSize: uint64(size),
+			Data:     bytes.NewReader(make([]byte, size)),
+		}))
+		assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, LogTime: uint64(size)}))
+	}
+	assert.Nil(t, writer.Close())
+	input := buf.Bytes()
+
+	readers := map[string]func() io.Reader{
+		"seekable": func() io.Reader { return bytes.NewReader(input) },
+		"unseekable": func() io.Reader {
+			return iotest.HalfReader(bytes.NewReader(input))
+		},
+	}
+	for name, newReader := range readers {
+		for _, readBufferSize := range []int{0, 16, 1024} {
+			t.Run(fmt.Sprintf("%s reader with buffer size %d", name, readBufferSize), func(t *testing.T) {
+				lexer, err := NewLexer(newReader(), &LexerOptions{ReadBufferSize: readBufferSize})
+				assert.Nil(t, err)
+				var logTimes []uint64
+				for {
+					tokenType, record, err := lexer.Next(nil)
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					assert.Nil(t, err)
+					if tokenType == TokenMessage {
+						message, err := ParseMessage(record)
+						assert.Nil(t, err)
+						logTimes = append(logTimes, message.LogTime)
+					}
+					if tokenType == TokenFooter {
+						break
+					}
+				}
+				assert.Equal(t, []uint64{10, 100, 1000}, logTimes)
+			})
+		}
+	}
+}
+
+// readCountingReader counts calls to Read on an unbuffered reader.
+type readCountingReader struct {
+	io.ReadSeeker
+	reads int
+}
+
+func (r *readCountingReader) Read(p []byte) (int, error) {
+	r.reads++
+	return r.ReadSeeker.Read(p)
+}
+
+func BenchmarkLexerReadBufferSize(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "unchunked.mcap")
+	f, err := os.Create(path)
+	assert.Nil(b, err)
+	writer, err := NewWriter(f, &WriterOptions{})
+	assert.Nil(b, err)
+	assert.Nil(b, writer.WriteHeader(&Header{}))
+	assert.Nil(b, writer.WriteChannel(&Channel{ID: 1, Topic: "/foo"}))
+	for i := 0; i < 10000; i++ {
+		assert.Nil(b, writer.WriteMessage(&Message{ChannelID: 1, LogTime: uint64(i), Data: make([]byte, 64)}))
+	}
+	assert.Nil(b, writer.Close())
+	assert.Nil(b, f.Close())
+	msg := make([]byte, 1024)
+	for _, readBufferSize := range []int{0, 4096, 64 * 1024} {
+		b.Run(fmt.Sprintf("buffer size %d", readBufferSize), func(b *testing.B) {
+			f, err := os.Open(path)
+			assert.Nil(b, err)
+			defer f.Close()
+			var reads int
+			for n := 0; n < b.N; n++ {
+				_, err := f.Seek(0, io.SeekStart)
+				assert.Nil(b, err)
+				reader := &readCountingReader{ReadSeeker: f}
+				lexer, err := NewLexer(reader, &LexerOptions{ReadBufferSize: readBufferSize})
+				assert.Nil(b, err)
+				for {
+					_, _, err := lexer.Next(msg)
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					assert.Nil(b, err)
+				}
+				lexer.Close()
+				reads += reader.reads
+			}
+			b.ReportMetric(float64(reads)/float64(b.N), "reads/op")
+		})
+	}
+}
+
+func TestInChunk(t *testing.T) {
+	input := file(
+		header(),
+		channelInfo(),
+		chunk(t, CompressionZSTD, true, channelInfo(), message()),
+		message(),
+		footer(),
+	)
+	t.Run("records read from chunks", func(t *testing.T) {
+		lexer, err := NewLexer(bytes.NewReader(input))
+		assert.Nil(t, err)
+		expected := []struct {
+			tokenType TokenType
+			inChunk   bool
+		}{
+			{TokenHeader, false},
+			{TokenChannel, false},
+			{TokenChannel, true},
+			{TokenMessage, true},
+			{TokenMessage, false},
+			{TokenFooter, false},
+		}
+		for i, e := range expected {
+			tokenType, _, err := lexer.Next(nil)
+			assert.Nil(t, err)
+			assert.Equal(t, e.tokenType, tokenType, "mismatch element %d", i)
+			assert.Equal(t, e.inChunk, lexer.InChunk(), "mismatch element %d", i)
+		}
+	})
+	t.Run("emitted chunks are top-level", func(t *testing.T) {
+		lexer, err := NewLexer(bytes.NewReader(input), &LexerOptions{EmitChunks: true})
+		assert.Nil(t, err)
+		for {
+			tokenType, _, err := lexer.Next(nil)
+			assert.Nil(t, err)
+			assert.False(t, lexer.InChunk(), "token %s", tokenType)
+			if tokenType == TokenFooter {
+				break
+			}
+		}
+	})
+}
+
+func TestLexError(t *testing.T) {
+	truncatedMessage := messageRecord(1, 0)[:20]
+	recordOffset := uint64(len(Magic) + len(header()))
+	badChunk := chunk(t, CompressionNone, true, channelInfo())
+	badChunk[len(badChunk)-1] = 0xff
+	cases := []struct {
+		assertion string
+		input     []byte
+		expected  LexError
+		target    error
+	}{
+		{
+			"truncated record",
+			flatten(Magic, header(), truncatedMessage),
+			LexError{Opcode: OpMessage, RecordLength: 2 + 4 + 8 + 8, Offset: recordOffset},
+			io.ErrUnexpectedEOF,
+		},
+		{
+			"truncated record length",
+			flatten(Magic, header(), truncatedMessage[:3]),
+			LexError{Opcode: OpMessage, RecordLength: -1, Offset: recordOffset},
+			io.ErrUnexpectedEOF,
+		},
+		{
+			"error within chunk",
+			file(header(), badChunk),
+			LexError{Opcode: OpChunk, RecordLength: int64(len(badChunk) - 9), Offset: recordOffset},
+			&errInvalidChunkCrc{},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			lexer, err := NewLexer(bytes.NewReader(c.input), &LexerOptions{ValidateChunkCRCs: true})
+			assert.Nil(t, err)
+			for err == nil {
+				_, _, err = lexer.Next(nil)
+			}
+			var lexErr *LexError
+			assert.ErrorAs(t, err, &lexErr)
+			assert.Equal(t, c.expected.Opcode, lexErr.Opcode)
+			assert.Equal(t, c.expected.RecordLength, lexErr.RecordLength)
+			assert.Equal(t, c.expected.Offset, lexErr.Offset)
+			if target, ok := c.target.(*errInvalidChunkCrc); ok {
+				assert.ErrorAs(t, err, &target)
+			} else {
+				assert.ErrorIs(t, err, c.target)
+			}
+		})
+	}
+	t.Run("clean end of input is io.EOF", func(t *testing.T) {
+		lexer, err := NewLexer(bytes.NewReader(flatten(Magic, header(), message())))
+		assert.Nil(t, err)
+		for err == nil {
+			_, _, err = lexer.Next(nil)
+		}
+		assert.Equal(t, io.EOF, err)
+	})
+	t.Run("error message", func(t *testing.T) {
+		err := &LexError{Opcode: OpMessage, RecordLength: 1048576, Offset: 52344, Err: io.ErrUnexpectedEOF}
+		assert.Equal(t, "failed reading message record of 1048576 bytes at offset 52344: unexpected EOF", err.Error())
+	})
+}
+
+func TestAllowLegacyLZ4(t *testing.T) {
+	records := [][]byte{channelInfo()}
+	for i := 0; i < 10; i++ {
+		records = append(records, messageRecord(1, uint64(i)))
+	}
+	data := flatten(records...)
+	block := make([]byte, lz4.CompressBlockBound(len(data)))
+	n, err := lz4.CompressBlock(data, block, nil)
+	assert.Nil(t, err)
+	assert.Greater(t, n, 0)
+	legacyChunk := chunkRecord(t, CompressionLZ4, true, data, block[:n])
+	frameChunk := chunk(t, CompressionLZ4, true, records...)
+
+	for _, validateCRC := range []bool{true, false} {
+		t.Run(fmt.Sprintf("crc validation %v", validateCRC), func(t *testing.T) {
+			lexer, err := NewLexer(bytes.NewReader(file(header(), legacyChunk, frameChunk, footer())), &LexerOptions{
+				ValidateChunkCRCs: validateCRC,
+				AllowLegacyLZ4:    true,
+			})
+			assert.Nil(t, err)
+			messages := 0
+			for {
+				tokenType, _, err := lexer.Next(nil)
+				assert.Nil(t, err)
+				if tokenType == TokenMessage {
+					messages++
+				}
+				if tokenType == TokenFooter {
+					break
+				}
+			}
+			assert.Equal(t, 20, messages)
+		})
+	}
+	t.Run("legacy chunks are rejected by default", func(t *testing.T) {
+		lexer, err := NewLexer(bytes.NewReader(file(header(), legacyChunk, footer())))
+		assert.Nil(t, err)
+		for err == nil {
+			_, _, err = lexer.Next(nil)
+		}
+		assert.NotErrorIs(t, err, io.EOF)
+	})
+}
+
+func TestForEach(t *testing.T) {
+	input := file(header(), channelInfo(), chunk(t, CompressionZSTD, true, channelInfo(), message(), message()), footer())
+	t.Run("visits every token", func(t *testing.T) {
+		lexer, err := NewLexer(bytes.NewReader(input))
+		assert.Nil(t, err)
+		var tokens []TokenType
+		assert.Nil(t, lexer.ForEach(func(tokenType TokenType, _ []byte) error {
+			tokens = append(tokens, tokenType)
+			return nil
+		}))
+		assert.Equal(t, []TokenType{
+			TokenHeader, TokenChannel, TokenChannel, TokenMessage, TokenMessage, TokenFooter,
+		}, tokens)
+	})
+	t.Run("stops cleanly on ErrStopIteration", func(t *testing.T) {
+		lexer, err := NewLexer(bytes.NewReader(input))
+		assert.Nil(t, err)
+		count := 0
+		assert.Nil(t, lexer.ForEach(func(tokenType TokenType, _ []byte) error {
+			count++
+			if tokenType == TokenMessage {
+				return ErrStopIteration
+			}
+			return nil
+		}))
+		assert.Equal(t, 4, count)
+		tokenType, _, err := lexer.Next(nil)
+		assert.Nil(t, err)
+		assert.Equal(t, TokenMessage, tokenType)
+	})
+	t.Run("returns callback errors", func(t *testing.T) {
+		lexer, err := NewLexer(bytes.NewReader(input))
+		assert.Nil(t, err)
+		errCallback := errors.New("callback failed")
+		err = lexer.ForEach(func(TokenType, []byte) error {
+			return errCallback
+		})
+		assert.ErrorIs(t, err, errCallback)
+	})
+	t.Run("returns lexer errors", func(t *testing.T) {
+		lexer, err := NewLexer(bytes.NewReader(flatten(Magic, header(), messageRecord(1, 0)[:20])))
+		assert.Nil(t, err)
+		err = lexer.ForEach(func(TokenType, []byte) error { return nil })
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+}
+
+func TestZSTDDecoderOptions(t *testing.T) {
+	records := [][]byte{channelInfo()}
+	for i := 0; i < 100; i++ {
+		records = append(records, messageRecord(1, uint64(i)))
+	}
+	input := file(header(), chunk(t, CompressionZSTD, true, records...), footer())
+	cases := []struct {
+		assertion   string
+		concurrency int
+		maxMemory   uint64
+		fails       bool
+	}{
+		{"default", 0, 0, false},
+		{"concurrent", 4, 0, false},
+		{"gomaxprocs", -1, 0, false},
+		{"sufficient memory limit", 0, 1 << 20, false},
+		{"insufficient memory limit", 0, 64, true},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			lexer, err := NewLexer(bytes.NewReader(input), &LexerOptions{
+				ZSTDConcurrency: c.concurrency,
+				ZSTDMaxMemory:   c.maxMemory,
+			})
+			assert.Nil(t, err)
+			defer lexer.Close()
+			messages := 0
+			err = lexer.ForEach(func(tokenType TokenType, _ []byte) error {
+				if tokenType == TokenMessage {
+					messages++
+				}
+				return nil
+			})
+			if c.fails {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, 100, messages)
+		})
+	}
+}
+
+func TestTolerateTruncation(t *testing.T) {
+	lastChunk := func(compression CompressionFormat) []byte {
+		return chunk(t, compression, true, channelInfo(), message(), message())
+	}
+	cases := []struct {
+		assertion         string
+		input             []byte
+		validateChunkCRCs bool
+		minTokens         int
+	}{
+		{
+			"truncated top-level record",
+			flatten(Magic, header(), channelInfo(), message(), message()[:5]),
+			false,
+			3,
+		},
+		{
+			"truncated record length",
+			flatten(Magic, header(), channelInfo(), message(), []byte{byte(OpMessage), 1, 2}),
+			false,
+			3,
+		},
+		{
+			"truncated uncompressed chunk",
+			flatten(Magic, header(), lastChunk(CompressionNone)[:60]),
+			false,
+			1,
+		},
+		{
+			"truncated zstd chunk",
+			flatten(Magic, header(), lastChunk(CompressionZSTD)[:60]),
+			false,
+			1,
+		},
+		{
+			"truncated chunk with CRC validation",
+			flatten(Magic, header(), lastChunk(CompressionZSTD)[:60]),
+			true,
+			1,
+		},
+		{
+			"truncated chunk header",
+			flatten(Magic, header(), lastChunk(CompressionNone)[:20]),
+			false,
+			1,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			lexer, err := NewLexer(bytes.NewReader(c.input), &LexerOptions{
+				ValidateChunkCRCs:  c.validateChunkCRCs,
+				TolerateTruncation: true,
+			})
+			assert.Nil(t, err)
+			tokens := 0
+			for {
+				_, _, err = lexer.Next(nil)
+				if err != nil {
+					break
+				}
+				tokens++
+			}
+			assert.ErrorIs(t, err, ErrTruncatedFile)
+			assert.GreaterOrEqual(t, tokens, c.minTokens)
+		})
+	}
+	t.Run("complete chunks are read before truncation", func(t *testing.T) {
+		input := flatten(
+			Magic,
+			header(),
+			chunk(t, CompressionZSTD, true, channelInfo(), message()),
+			chunk(t, CompressionZSTD, true, message(), message()),
+			lastChunk(CompressionZSTD)[:50],
+		)
+		lexer, err := NewLexer(bytes.NewReader(input), &LexerOptions{TolerateTruncation: true})
+		assert.Nil(t, err)
+		messages := 0
+		for {
+			tokenType, _, err := lexer.Next(nil)
+			if err != nil {
+				assert.ErrorIs(t, err, ErrTruncatedFile)
+				break
+			}
+			if tokenType == TokenMessage {
+				messages++
+			}
+		}
+		assert.Equal(t, 3, messages)
+	})
+	t.Run("truncation is a read error by default", func(t *testing.T) {
+		input := flatten(Magic, header(), lastChunk(CompressionNone)[:60])
+		lexer, err := NewLexer(bytes.NewReader(input))
+		assert.Nil(t, err)
+		for err == nil {
+			_, _, err = lexer.Next(nil)
+		}
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		assert.False(t, errors.Is(err, ErrTruncatedFile))
+	})
+	t.Run("complete files end cleanly", func(t *testing.T) {
+		input := file(header(), lastChunk(CompressionZSTD), footer())
+		lexer, err := NewLexer(bytes.NewReader(input), &LexerOptions{TolerateTruncation: true})
+		assert.Nil(t, err)
+		for err == nil {
+			_, _, err = lexer.Next(nil)
+		}
+		assert.ErrorIs(t, err, io.EOF)
+	})
+}
+
+// pipe returns a reader that streams data through an io.Pipe, which cannot
+// seek.
+func pipe(t *testing.T, data []byte) io.Reader {
+	r, w := io.Pipe()
+	go func() {
+		_, err := w.Write(data)
+		w.CloseWithError(err)
+	}()
+	_, seekable := io.Reader(r).(io.Seeker)
+	assert.False(t, seekable)
+	return r
+}
+
+func TestLexerOnPipe(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{
+		Chunked:     true,
+		ChunkSize:   64,
+		Compression: CompressionZSTD,
+		IncludeCRC:  true,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.WriteSchema(&Schema{ID: 1}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/foo"}))
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, LogTime: uint64(i), Data: []byte("hello")}))
+	}
+	assert.Nil(t, writer.WriteAttachment(&Attachment{
+		Name:     "file",
+		DataSize: 4,
+		Data:     bytes.NewReader([]byte("data")),
+	}))
+	assert.Nil(t, writer.Close())
+	data := buf.Bytes()
+
+	tokenTypes := func(t *testing.T, r io.Reader, opts *LexerOptions) []TokenType {
+		lexer, err := NewLexer(r, opts)
+		assert.Nil(t, err)
+		defer lexer.Close()
+		var tokenTypes []TokenType
+		for {
+			tokenType, _, err := lexer.Next(nil)
+			if err != nil {
+				assert.ErrorIs(t, err, io.EOF)
+				return tokenTypes
+			}
+			tokenTypes = append(tokenTypes, tokenType)
+		}
+	}
+	cases := []struct {
+		assertion string
+		opts      func() *LexerOptions
+	}{
+		{"default options", func() *LexerOptions { return &LexerOptions{} }},
+		{"emit chunks", func() *LexerOptions { return &LexerOptions{EmitChunks: true} }},
+		{"validate chunk CRCs", func() *LexerOptions { return &LexerOptions{ValidateChunkCRCs: true} }},
+		{"buffered", func() *LexerOptions { return &LexerOptions{ReadBufferSize: 64 * 1024} }},
+		{"attachment callback", func() *LexerOptions {
+			return &LexerOptions{
+				ComputeAttachmentCRCs: true,
+				AttachmentCallback: func(ar *AttachmentReader) error {
+					content, err := io.ReadAll(ar.Data())
+					assert.Nil(t, err)
+					assert.Equal(t, []byte("data"), content)
+					_, err = ar.ParsedCRC()
+					return err
+				},
+			}
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			expected := tokenTypes(t, bytes.NewReader(data), c.opts())
+			assert.NotEmpty(t, expected)
+			assert.Equal(t, expected, tokenTypes(t, pipe(t, data), c.opts()))
+		})
+	}
+}
+
+func TestValidateAttachmentCRCs(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{Chunked: true, IncludeCRC: true})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.WriteAttachment(&Attachment{
+		Name:     "file",
+		DataSize: 4,
+		Data:     bytes.NewReader([]byte("data")),
+	}))
+	assert.Nil(t, writer.Close())
+	valid := buf.Bytes()
+	idx := writer.AttachmentIndexes[0]
+	crcOffset := idx.Offset + idx.Length - 4
+	corrupt := append([]byte{}, valid...)
+	corrupt[crcOffset-1] ^= 0xff // last byte of the data
+	zeroCRC := append([]byte{}, corrupt...)
+	copy(zeroCRC[crcOffset:], []byte{0, 0, 0, 0})
+
+	lex := func(input []byte, opts *LexerOptions) error {
+		lexer, err := NewLexer(bytes.NewReader(input), opts)
+		assert.Nil(t, err)
+		defer lexer.Close()
+		for {
+			_, _, err := lexer.Next(nil)
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	readPartially := func(ar *AttachmentReader) error {
+		_, err := ar.Data().Read(make([]byte, 1))
+		return err
+	}
+	cases := []struct {
+		assertion string
+		input     []byte
+		opts      *LexerOptions
+		valid     bool
+	}{
+		{"valid attachment", valid, &LexerOptions{ValidateAttachmentCRCs: true}, true},
+		{
+			"chunk CRC validation ignores attachments",
+			corrupt,
+			&LexerOptions{ValidateChunkCRCs: true},
+			true,
+		},
+		{"corrupt attachment", corrupt, &LexerOptions{ValidateAttachmentCRCs: true}, false},
+		{
+			"corrupt attachment partially read by callback",
+			corrupt,
+			&LexerOptions{ValidateAttachmentCRCs: true, AttachmentCallback: readPartially},
+			false,
+		},
+		{"zero CRC is not validated", zeroCRC, &LexerOptions{ValidateAttachmentCRCs: true}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			err := lex(c.input, c.opts)
+			if c.valid {
+				assert.Nil(t, err)
+				return
+			}
+			var invalidCRC *ErrInvalidAttachmentCRC
+			assert.ErrorAs(t, err, &invalidCRC)
+		})
+	}
+}
+
+func writeDecoderPoolTestFile(t testing.TB, compression CompressionFormat) []byte {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{Chunked: true, ChunkSize: 1024, Compression: compression})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, Topic: "/foo"}))
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, LogTime: uint64(i), Data: make([]byte, 64)}))
+	}
+	assert.Nil(t, writer.Close())
+	return buf.Bytes()
+}
+
+func countMessages(t testing.TB, input []byte, opts *LexerOptions) int {
+	lexer, err := NewLexer(bytes.NewReader(input), opts)
+	assert.Nil(t, err)
+	defer lexer.Close()
+	count := 0
+	for {
+		tokenType, _, err := lexer.Next(nil)
+		if errors.Is(err, io.EOF) {
+			return count
+		}
+		assert.Nil(t, err)
+		if tokenType == TokenMessage {
+			count++
+		}
+	}
+}
+
+func TestDecoderPool(t *testing.T) {
+	inputs := [][]byte{
+		writeDecoderPoolTestFile(t, CompressionLZ4),
+		writeDecoderPoolTestFile(t, CompressionZSTD),
+	}
+	pool := &DecoderPool{}
+	t.Run("concurrent lexers share the pool", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func(input []byte) {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					assert.Equal(t, 100, countMessages(t, input, &LexerOptions{DecoderPool: pool}))
+				}
+			}(inputs[i%len(inputs)])
+		}
+		wg.Wait()
+	})
+	t.Run("decoders with options are not pooled", func(t *testing.T) {
+		opts := &LexerOptions{DecoderPool: pool, ZSTDConcurrency: 2}
+		assert.Equal(t, 100, countMessages(t, inputs[1], opts))
+		lexer, err := NewLexer(bytes.NewReader(inputs[1]), opts)
+		assert.Nil(t, err)
+		assert.False(t, lexer.poolZSTD())
+		lexer.Close()
+	})
+	t.Run("closing twice does not return decoders twice", func(t *testing.T) {
+		lexer, err := NewLexer(bytes.NewReader(inputs[0]), &LexerOptions{DecoderPool: pool})
+		assert.Nil(t, err)
+		for {
+			tokenType, _, err := lexer.Next(nil)
+			assert.Nil(t, err)
+			if tokenType == TokenMessage {
+				break
+			}
+		}
+		lexer.Close()
+		lexer.Close()
+		assert.Nil(t, lexer.decoders.lz4)
+	})
+}
+
+func BenchmarkDecoderPool(b *testing.B) {
+	for _, compression := range []CompressionFormat{CompressionLZ4, CompressionZSTD} {
+		input := writeDecoderPoolTestFile(b, compression)
+		for _, pool := range []*DecoderPool{nil, {}} {
+			b.Run(fmt.Sprintf("%s pooled %v", compression, pool != nil), func(b *testing.B) {
+				b.ReportAllocs()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						countMessages(b, input, &LexerOptions{DecoderPool: pool})
+					}
+				})
+			})
+		}
+	}
+}
+
+func TestReadRecordAt(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(buf, &WriterOptions{
+		Chunked:     true,
+		ChunkSize:   1024,
+		Compression: CompressionLZ4,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, w.WriteHeader(&Header{Profile: "test"}))
+	assert.Nil(t, w.WriteSchema(&Schema{ID: 1, Name: "a", Encoding: "ros1msg"}))
+	assert.Nil(t, w.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/a", MessageEncoding: "ros1"}))
+	assert.Nil(t, w.WriteMessage(&Message{ChannelID: 1, LogTime: 1, Data: []byte("hello")}))
+	assert.Nil(t, w.WriteAttachment(&Attachment{Name: "a.txt", DataSize: 4, Data: bytes.NewReader([]byte("data"))}))
+	assert.Nil(t, w.WriteMetadata(&Metadata{Name: "m", Metadata: map[string]string{"k": "v"}}))
+	assert.Nil(t, w.Close())
+	data := bytes.NewReader(buf.Bytes())
+
+	t.Run("header", func(t *testing.T) {
+		tokenType, record, err := ReadRecordAt(data, int64(len(Magic)), nil)
+		assert.Nil(t, err)
+		assert.Equal(t, TokenHeader, tokenType)
+		header, err := ParseHeader(record)
+		assert.Nil(t, err)
+		assert.Equal(t, "test", header.Profile)
+	})
+	t.Run("chunk", func(t *testing.T) {
+		assert.Equal(t, 1, len(w.ChunkIndexes))
+		idx := w.ChunkIndexes[0]
+		scratch := make([]byte, 4096)
+		tokenType, record, err := ReadRecordAt(data, int64(idx.ChunkStartOffset), scratch)
+		assert.Nil(t, err)
+		assert.Equal(t, TokenChunk, tokenType)
+		assert.Equal(t, int(idx.ChunkLength)-9, len(record))
+		assert.Equal(t, &scratch[0], &record[0])
+		chunk, err := ParseChunk(record)
+		assert.Nil(t, err)
+		assert.Equal(t, "lz4", chunk.Compression)
+	})
+	t.Run("metadata", func(t *testing.T) {
+		assert.Equal(t, 1, len(w.MetadataIndexes))
+		tokenType, record, err := ReadRecordAt(data, int64(w.MetadataIndexes[0].Offset), nil)
+		assert.Nil(t, err)
+		assert.Equal(t, TokenMetadata, tokenType)
+		metadata, err := ParseMetadata(record)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{"k": "v"}, metadata.Metadata)
+	})
+	t.Run("attachment", func(t *testing.T) {
+		assert.Equal(t, 1, len(w.AttachmentIndexes))
+		_, _, err := ReadRecordAt(data, int64(w.AttachmentIndexes[0].Offset), nil)
+		assert.ErrorIs(t, err, ErrInvalidOpcode)
+	})
+	t.Run("zero opcode", func(t *testing.T) {
+		_, _, err := ReadRecordAt(bytes.NewReader(make([]byte, 16)), 0, nil)
+		assert.ErrorIs(t, err, ErrInvalidZeroOpcode)
+	})
+	t.Run("unknown opcode", func(t *testing.T) {
+		record := append([]byte{0x80}, encodedUint64(0)...)
+		_, _, err := ReadRecordAt(bytes.NewReader(record), 0, nil)
+		assert.ErrorIs(t, err, ErrInvalidOpcode)
+	})
+	t.Run("truncated length", func(t *testing.T) {
+		_, _, err := ReadRecordAt(data, int64(data.Len()-4), nil)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+	t.Run("truncated body", func(t *testing.T) {
+		truncated := bytes.NewReader(buf.Bytes()[:len(Magic)+12])
+		_, _, err := ReadRecordAt(truncated, int64(len(Magic)), nil)
+		var truncatedErr *ErrTruncatedRecord
+		assert.ErrorAs(t, err, &truncatedErr)
+	})
+	t.Run("past end", func(t *testing.T) {
+		_, _, err := ReadRecordAt(data, int64(data.Len()), nil)
+		assert.ErrorIs(t, err, io.EOF)
+	})
+}
+
+func TestAutoDecompressOuter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{Chunked: true, ChunkSize: 1024, Compression: CompressionLZ4})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, Topic: "/foo"}))
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, LogTime: uint64(i), Data: make([]byte, 64)}))
+	}
+	assert.Nil(t, writer.WriteAttachment(&Attachment{Name: "a", DataSize: 3, Data: bytes.NewReader([]byte("abc"))}))
+	assert.Nil(t, writer.Close())
+	file := buf.Bytes()
+
+	gzipped := &bytes.Buffer{}
+	gz := gzip.NewWriter(gzipped)
+	_, err = gz.Write(file)
+	assert.Nil(t, err)
+	assert.Nil(t, gz.Close())
+
+	zstdEncoder, err := zstd.NewWriter(nil)
+	assert.Nil(t, err)
+	zstdCompressed := zstdEncoder.EncodeAll(file, nil)
+	assert.Nil(t, zstdEncoder.Close())
+
+	cases := []struct {
+		assertion string
+		input     []byte
+		opts      *LexerOptions
+	}{
+		{"gzip", gzipped.Bytes(), &LexerOptions{AutoDecompressOuter: true}},
+		{"zstd", zstdCompressed, &LexerOptions{AutoDecompressOuter: true}},
+		{"uncompressed", file, &LexerOptions{AutoDecompressOuter: true}},
+		{"uncompressed with buffering", file, &LexerOptions{AutoDecompressOuter: true, ReadBufferSize: 4096}},
+		{"gzip with buffering", gzipped.Bytes(), &LexerOptions{AutoDecompressOuter: true, ReadBufferSize: 4096}},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			attachments := 0
+			c.opts.AttachmentCallback = func(ar *AttachmentReader) error {
+				attachments++
+				data, err := io.ReadAll(ar.Data())
+				assert.Nil(t, err)
+				assert.Equal(t, "abc", string(data))
+				return nil
+			}
+			assert.Equal(t, 100, countMessages(t, c.input, c.opts))
+			assert.Equal(t, 1, attachments)
+		})
+	}
+	t.Run("tee receives compressed input", func(t *testing.T) {
+		tee := &bytes.Buffer{}
+		assert.Equal(t, 100, countMessages(t, gzipped.Bytes(), &LexerOptions{AutoDecompressOuter: true, Tee: tee}))
+		assert.Equal(t, gzipped.Bytes(), tee.Bytes())
+
+		tee.Reset()
+		assert.Equal(t, 100, countMessages(t, file, &LexerOptions{AutoDecompressOuter: true, Tee: tee}))
+		assert.Equal(t, file, tee.Bytes())
+	})
+	t.Run("not detected without option", func(t *testing.T) {
+		_, err := NewLexer(bytes.NewReader(gzipped.Bytes()))
+		var badMagic *ErrBadMagic
+		assert.ErrorAs(t, err, &badMagic)
+	})
+	t.Run("corrupt gzip", func(t *testing.T) {
+		corrupt := append([]byte{}, gzipped.Bytes()[:20]...)
+		lexer, err := NewLexer(bytes.NewReader(corrupt), &LexerOptions{AutoDecompressOuter: true})
+		if err == nil {
+			defer lexer.Close()
+			for err == nil {
+				_, _, err = lexer.Next(nil)
+			}
+		}
+		assert.NotErrorIs(t, err, io.EOF)
+	})
+}
+
+func TestOnChunkProgress(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{
+		Chunked:     true,
+		ChunkSize:   8 * 1024 * 1024,
+		Compression: CompressionZSTD,
+		IncludeCRC:  true,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, Topic: "/foo"}))
+	for i := 0; i < 300; i++ {
+		assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, LogTime: uint64(i), Data: make([]byte, 10*1024)}))
+	}
+	assert.Nil(t, writer.Close())
+	assert.Equal(t, 1, len(writer.ChunkIndexes))
+	uncompressedSize := writer.ChunkIndexes[0].UncompressedSize
+
+	for _, validateCRCs := range []bool{false, true} {
+		t.Run(fmt.Sprintf("validate CRCs %v", validateCRCs), func(t *testing.T) {
+			var progress [][2]uint64
+			count := countMessages(t, buf.Bytes(), &LexerOptions{
+				ValidateChunkCRCs: validateCRCs,
+				OnChunkProgress: func(bytesDecompressed, totalUncompressed uint64) {
+					progress = append(progress, [2]uint64{bytesDecompressed, totalUncompressed})
+				},
+			})
+			assert.Equal(t, 300, count)
+			assert.GreaterOrEqual(t, len(progress), 3)
+			assert.LessOrEqual(t, len(progress), 4)
+			var last uint64
+			for _, p := range progress {
+				assert.Greater(t, p[0], last)
+				assert.Equal(t, uncompressedSize, p[1])
+				last = p[0]
+			}
+			assert.Equal(t, uncompressedSize, last)
+		})
+	}
+}
+
+func TestLexerIOStats(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{
+		Chunked:     true,
+		ChunkSize:   1024,
+		Compression: CompressionZSTD,
+		IncludeCRC:  true,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.WriteSchema(&Schema{ID: 1, Name: "schema", Encoding: "jsonschema"}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/topic"}))
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, LogTime: uint64(i), Data: make([]byte, 64)}))
+	}
+	assert.Nil(t, writer.Close())
+	var uncompressed uint64
+	for _, idx := range writer.ChunkIndexes {
+		uncompressed += idx.UncompressedSize
+	}
+
+	cases := []struct {
+		assertion    string
+		opts         *LexerOptions
+		decompressed uint64
+	}{
+		{"streaming", &LexerOptions{}, uncompressed},
+		{"validating chunk CRCs", &LexerOptions{ValidateChunkCRCs: true}, uncompressed},
+		{"emitting chunks", &LexerOptions{EmitChunks: true}, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			lexer, err := NewLexer(bytes.NewReader(buf.Bytes()), c.opts)
+			assert.Nil(t, err)
+			defer lexer.Close()
+			for {
+				_, _, err := lexer.Next(nil)
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				assert.Nil(t, err)
+			}
+			bytesRead, bytesDecompressed := lexer.IOStats()
+			assert.Equal(t, uint64(buf.Len()), bytesRead)
+			assert.Equal(t, c.decompressed, bytesDecompressed)
+		})
+	}
+}
+
+func writeMixedSizeTestFile(t testing.TB) []byte {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, Topic: "/foo"}))
+	sizes := []int{16, 4096, 256, 65536, 1024, 32768}
+	for i := 0; i < 600; i++ {
+		data := bytes.Repeat([]byte{byte(i)}, sizes[i%len(sizes)])
+		assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, LogTime: uint64(i), Data: data}))
+	}
+	assert.Nil(t, writer.Close())
+	return buf.Bytes()
+}
+
+func TestMaxScratchSize(t *testing.T) {
+	input := writeMixedSizeTestFile(t)
+	cases := []struct {
+		assertion      string
+		maxScratchSize int
+	}{
+		{"no scratch buffer", 0},
+		{"scratch buffer for all records", 1 << 20},
+		{"scratch buffer for some records", 8192},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			lexer, err := NewLexer(bytes.NewReader(input), &LexerOptions{MaxScratchSize: c.maxScratchSize})
+			assert.Nil(t, err)
+			defer lexer.Close()
+			count := 0
+			for {
+				tokenType, record, err := lexer.Next(nil)
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				assert.Nil(t, err)
+				if tokenType != TokenMessage {
+					continue
+				}
+				message, err := ParseMessage(record)
+				assert.Nil(t, err)
+				assert.Equal(t, uint64(count), message.LogTime)
+				assert.Equal(t, bytes.Repeat([]byte{byte(count)}, len(message.Data)), message.Data)
+				count++
+			}
+			assert.Equal(t, 600, count)
+			assert.LessOrEqual(t, len(lexer.scratch), c.maxScratchSize)
+		})
+	}
+}
+
+func BenchmarkLexerMaxScratchSize(b *testing.B) {
+	input := writeMixedSizeTestFile(b)
+	msg := make([]byte, 1024)
+	for _, maxScratchSize := range []int{0, 1 << 20} {
+		b.Run(fmt.Sprintf("max scratch size %d", maxScratchSize), func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				lexer, err := NewLexer(bytes.NewReader(input), &LexerOptions{MaxScratchSize: maxScratchSize})
+				assert.Nil(b, err)
+				for {
+					_, _, err := lexer.Next(msg)
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					assert.Nil(b, err)
+				}
+				lexer.Close()
+			}
+		})
+	}
+}
+
+func TestEmitOpcodes(t *testing.T) {
+	writeFile := func(chunked bool) []byte {
+		buf := &bytes.Buffer{}
+		writer, err := NewWriter(buf, &WriterOptions{Chunked: chunked, ChunkSize: 256, Compression: CompressionZSTD})
+		assert.Nil(t, err)
+		assert.Nil(t, writer.WriteHeader(&Header{}))
+		assert.Nil(t, writer.WriteSchema(&Schema{ID: 1, Name: "schema", Encoding: "jsonschema"}))
+		assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/topic"}))
+		for i := 0; i < 30; i++ {
+			assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, LogTime: uint64(i), Data: []byte("hello")}))
+			if i%10 == 0 {
+				data := bytes.Repeat([]byte{1}, 4096)
+				assert.Nil(t, writer.WriteAttachment(&Attachment{
+					Name:     "attachment",
+					DataSize: uint64(len(data)),
+					Data:     bytes.NewReader(data),
+				}))
+				assert.Nil(t, writer.WriteMetadata(&Metadata{Name: "metadata"}))
+			}
+		}
+		assert.Nil(t, writer.Close())
+		return buf.Bytes()
+	}
+	lexTokens := func(input []byte, opts *LexerOptions) map[TokenType]int {
+		lexer, err := NewLexer(bytes.NewReader(input), opts)
+		assert.Nil(t, err)
+		defer lexer.Close()
+		counts := make(map[TokenType]int)
+		for {
+			tokenType, _, err := lexer.Next(nil)
+			if errors.Is(err, io.EOF) {
+				return counts
+			}
+			assert.Nil(t, err)
+			counts[tokenType]++
+		}
+	}
+	for _, chunked := range []bool{false, true} {
+		input := writeFile(chunked)
+		t.Run(fmt.Sprintf("chunked %v", chunked), func(t *testing.T) {
+			t.Run("messages only", func(t *testing.T) {
+				counts := lexTokens(input, &LexerOptions{EmitOpcodes: []OpCode{OpMessage}})
+				assert.Equal(t, map[TokenType]int{TokenMessage: 30}, counts)
+			})
+			t.Run("messages and metadata", func(t *testing.T) {
+				counts := lexTokens(input, &LexerOptions{EmitOpcodes: []OpCode{OpMessage, OpMetadata}})
+				assert.Equal(t, map[TokenType]int{TokenMessage: 30, TokenMetadata: 3}, counts)
+			})
+			t.Run("attachment callback", func(t *testing.T) {
+				attachments := 0
+				counts := lexTokens(input, &LexerOptions{
+					EmitOpcodes: []OpCode{OpFooter},
+					AttachmentCallback: func(*AttachmentReader) error {
+						attachments++
+						return nil
+					},
+				})
+				assert.Equal(t, map[TokenType]int{TokenFooter: 1}, counts)
+				assert.Equal(t, 3, attachments)
+			})
+			t.Run("emitted chunks are filtered", func(t *testing.T) {
+				counts := lexTokens(input, &LexerOptions{EmitChunks: true, EmitOpcodes: []OpCode{OpChunk, OpMessage}})
+				if chunked {
+					assert.Equal(t, 0, counts[TokenMessage])
+					assert.Greater(t, counts[TokenChunk], 1)
+				} else {
+					assert.Equal(t, map[TokenType]int{TokenMessage: 30}, counts)
+				}
+			})
+		})
+	}
+}
+
+func TestStreamChunkCRCs(t *testing.T) {
+	for _, compression := range []CompressionFormat{CompressionNone, CompressionLZ4, CompressionZSTD} {
+		goodChunk := chunk(t, compression, true, channelInfo(), message())
+		badChunk := chunk(t, compression, true, channelInfo(), message(), message())
+		badChunk[1+8+8+8+8] ^= 0xff // first byte of the uncompressed CRC
+		noCRCChunk := chunk(t, compression, false, message())
+		input := file(header(), goodChunk, noCRCChunk, badChunk, message(), footer())
+
+		t.Run(fmt.Sprintf("%s mismatch follows the chunk's records", compression), func(t *testing.T) {
+			lexer, err := NewLexer(bytes.NewReader(input), &LexerOptions{
+				ValidateChunkCRCs: true,
+				StreamChunkCRCs:   true,
+			})
+			assert.Nil(t, err)
+			expected := []TokenType{
+				TokenHeader,
+				TokenChannel,
+				TokenMessage,
+				TokenMessage,
+				TokenChannel,
+				TokenMessage,
+				TokenMessage,
+			}
+			for i, expectedTokenType := range expected {
+				tokenType, _, err := lexer.Next(nil)
+				assert.Nil(t, err)
+				assert.Equal(t, expectedTokenType, tokenType, "mismatch on token %d", i)
+			}
+			_, _, err = lexer.Next(nil)
+			var invalidCrc *errInvalidChunkCrc
+			assert.ErrorAs(t, err, &invalidCrc)
+		})
+		t.Run(fmt.Sprintf("%s mismatched chunk is skipped", compression), func(t *testing.T) {
+			var offsets []uint64
+			lexer, err := NewLexer(bytes.NewReader(input), &LexerOptions{
+				ValidateChunkCRCs: true,
+				StreamChunkCRCs:   true,
+				SkipBadChunks:     true,
+				BadChunkCallback: func(offset uint64, err error) {
+					offsets = append(offsets, offset)
+				},
+			})
+			assert.Nil(t, err)
+			tokens := 0
+			for {
+				_, _, err := lexer.Next(nil)
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				assert.Nil(t, err)
+				tokens++
+			}
+			// the records of the mismatched chunk are returned before it is
+			// found to be bad
+			assert.Equal(t, 9, tokens)
+			badOffset := uint64(len(Magic) + len(header()) + len(goodChunk) + len(noCRCChunk))
+			assert.Equal(t, []uint64{badOffset}, offsets)
+		})
+	}
+	t.Run("custom CRC32 is rejected", func(t *testing.T) {
+		_, err := NewLexer(bytes.NewReader(file(header(), footer())), &LexerOptions{
+			ValidateChunkCRCs: true,
+			StreamChunkCRCs:   true,
+			CRC32:             crc32.ChecksumIEEE,
+		})
+		assert.NotNil(t, err)
+	})
+}
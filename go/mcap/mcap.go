@@ -8,11 +8,34 @@ import (
 	"math"
 )
 
-// Magic is the magic number for an MCAP file.
+// Magic is the magic number that begins and ends an MCAP file. It must not be
+// modified.
 var Magic = []byte{0x89, 'M', 'C', 'A', 'P', 0x30, '\r', '\n'}
 
+// Sizes in bytes of the fixed-width parts of the MCAP format, for encoders and
+// decoders working with the byte layout directly.
+const (
+	// SizeMagic is the size of the magic at the start and end of a file.
+	SizeMagic = 8
+	// SizeOpcode is the size of the opcode that begins each record.
+	SizeOpcode = 1
+	// SizeRecordLength is the size of the record length following the opcode.
+	SizeRecordLength = 8
+	// SizeRecordPrefix is the size of the opcode and record length together,
+	// which precede the content of each record.
+	SizeRecordPrefix = SizeOpcode + SizeRecordLength
+	// SizeFooter is the length of the content of a footer record: the summary
+	// start, summary offset start, and summary CRC.
+	SizeFooter = 8 + 8 + 4
+	// SizeDataEnd is the length of the content of a data end record: the data
+	// section CRC.
+	SizeDataEnd = 4
+)
+
 var ErrLengthOutOfRange = errors.New("length out of int32 range")
 
+// The canonical chunk compression formats supported by the library. These
+// are the formats for which CompressionFormat.Valid reports true.
 const (
 	// CompressionZSTD represents zstd compression.
 	CompressionZSTD CompressionFormat = "zstd"
@@ -20,6 +43,10 @@ const (
 	CompressionLZ4 CompressionFormat = "lz4"
 	// CompressionNone represents no compression.
 	CompressionNone CompressionFormat = ""
+	// CompressionAuto is not a chunk compression format, but instructs the
+	// Writer to choose between zstd and lz4 by compressing the first chunk
+	// with each. See WriterOptions.Compression.
+	CompressionAuto CompressionFormat = "auto"
 )
 
 // CompressionFormat represents a supported chunk compression format.
@@ -30,6 +57,34 @@ func (c CompressionFormat) String() string {
 	return string(c)
 }
 
+// Valid reports whether c is one of the chunk compression formats supported
+// by the library: CompressionZSTD, CompressionLZ4 or CompressionNone. Formats
+// handled by custom compressors or decompressors are not included.
+func (c CompressionFormat) Valid() bool {
+	switch c {
+	case CompressionZSTD, CompressionLZ4, CompressionNone:
+		return true
+	default:
+		return false
+	}
+}
+
+// ParseCompressionFormat parses the name of a supported chunk compression
+// format, as given in a command-line flag. "none" and the empty string both
+// select CompressionNone.
+func ParseCompressionFormat(s string) (CompressionFormat, error) {
+	if s == "none" {
+		return CompressionNone, nil
+	}
+	if c := CompressionFormat(s); c.Valid() {
+		return c, nil
+	}
+	return "", fmt.Errorf("unrecognized compression format %q: valid options are %q, %q, or \"none\"",
+		s, CompressionLZ4, CompressionZSTD)
+}
+
+// The record opcodes defined by the specification. Opcodes 0x80 and above are
+// reserved for user-defined records, which readers skip.
 const (
 	OpReserved        OpCode = 0x00
 	OpHeader          OpCode = 0x01
@@ -49,8 +104,10 @@ const (
 	OpDataEnd         OpCode = 0x0F
 )
 
+// OpCode is the first byte of each record, identifying its type.
 type OpCode byte
 
+// String returns the name of the record type for display.
 func (c OpCode) String() string {
 	switch c {
 	case OpReserved:
@@ -211,6 +268,9 @@ type Attachment struct {
 	MediaType  string
 	DataSize   uint64
 	Data       io.Reader
+	// CRC is the checksum stored in a parsed attachment record. It is ignored
+	// by the writer, which computes the CRC from the written data.
+	CRC uint32
 }
 
 // AttachmentReader represents an attachment for handling in a streaming manner.
@@ -295,6 +355,11 @@ type Statistics struct {
 type Metadata struct {
 	Name     string
 	Metadata map[string]string
+	// Keys lists the keys of Metadata in file order. It is populated by
+	// ParseMetadata. When writing, if Keys contains exactly the keys of
+	// Metadata, entries are written in this order; otherwise they are written
+	// in sorted key order.
+	Keys []string
 }
 
 // MetadataIndex records each contain the location of a metadata record within the file.
@@ -341,6 +406,45 @@ func (i *Info) ChannelCounts() map[string]uint64 {
 	return counts
 }
 
+// ChunkOffsetForTime returns the file offset of the chunk containing messages
+// at log time t, for use in seeking a reader to a position in the file. If no
+// chunk contains t, the offset of the nearest chunk starting before t is
+// returned, or the earliest chunk if t precedes all chunks. If t is later than
+// the end of every chunk, found is false.
+//
+// Where chunks overlap, the chunk with the lowest offset among those
+// containing t is chosen, so that lexing from the offset will not skip any
+// message at t.
+func (i *Info) ChunkOffsetForTime(t uint64) (offset int64, found bool) {
+	var containing, preceding, first *ChunkIndex
+	for _, idx := range i.ChunkIndexes {
+		if t > idx.MessageEndTime {
+			if idx.MessageStartTime <= t &&
+				(preceding == nil || idx.MessageStartTime > preceding.MessageStartTime) {
+				preceding = idx
+			}
+			continue
+		}
+		if idx.MessageStartTime <= t {
+			if containing == nil || idx.ChunkStartOffset < containing.ChunkStartOffset {
+				containing = idx
+			}
+		} else if first == nil || idx.MessageStartTime < first.MessageStartTime {
+			first = idx
+		}
+	}
+	switch {
+	case containing != nil:
+		return int64(containing.ChunkStartOffset), true
+	case preceding != nil && first != nil:
+		return int64(preceding.ChunkStartOffset), true
+	case first != nil:
+		return int64(first.ChunkStartOffset), true
+	default:
+		return 0, false
+	}
+}
+
 type MessageIndexEntry struct {
 	Timestamp uint64
 	Offset    uint64
@@ -1,6 +1,7 @@
 package mcap
 
 import (
+	"bytes"
 	"encoding/binary"
 	"io"
 	"testing"
@@ -84,3 +85,134 @@ func TestPutByte(t *testing.T) {
 	assert.ErrorIs(t, err, io.ErrShortBuffer)
 	assert.Equal(t, 0, offset)
 }
+
+func TestChunkOffsetForTime(t *testing.T) {
+	info := &Info{
+		ChunkIndexes: []*ChunkIndex{
+			{MessageStartTime: 100, MessageEndTime: 200, ChunkStartOffset: 10},
+			{MessageStartTime: 300, MessageEndTime: 400, ChunkStartOffset: 20},
+			{MessageStartTime: 350, MessageEndTime: 500, ChunkStartOffset: 30},
+		},
+	}
+	cases := []struct {
+		assertion string
+		t         uint64
+		offset    int64
+		found     bool
+	}{
+		{"before first chunk", 50, 10, true},
+		{"start of first chunk", 100, 10, true},
+		{"within first chunk", 150, 10, true},
+		{"between chunks", 250, 10, true},
+		{"overlapping chunks", 375, 20, true},
+		{"end of last chunk", 500, 30, true},
+		{"after last chunk", 501, 0, false},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			offset, found := info.ChunkOffsetForTime(c.t)
+			assert.Equal(t, c.offset, offset)
+			assert.Equal(t, c.found, found)
+		})
+	}
+	t.Run("no chunks", func(t *testing.T) {
+		_, found := (&Info{}).ChunkOffsetForTime(0)
+		assert.False(t, found)
+	})
+}
+
+func TestCompressionFormatValid(t *testing.T) {
+	for _, c := range []CompressionFormat{CompressionZSTD, CompressionLZ4, CompressionNone} {
+		assert.True(t, c.Valid(), "%q should be valid", c)
+	}
+	for _, c := range []CompressionFormat{CompressionAuto, "none", "gzip", "ZSTD"} {
+		assert.False(t, c.Valid(), "%q should not be valid", c)
+	}
+}
+
+func TestParseCompressionFormat(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected CompressionFormat
+		err      bool
+	}{
+		{"zstd", CompressionZSTD, false},
+		{"lz4", CompressionLZ4, false},
+		{"none", CompressionNone, false},
+		{"", CompressionNone, false},
+		{"gzip", "", true},
+		{"auto", "", true},
+	}
+	for _, c := range cases {
+		t.Run(c.input, func(t *testing.T) {
+			compression, err := ParseCompressionFormat(c.input)
+			if c.err {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), "unrecognized compression format")
+				}
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, c.expected, compression)
+		})
+	}
+}
+
+func TestSpecConstants(t *testing.T) {
+	t.Run("magic", func(t *testing.T) {
+		assert.Equal(t, []byte{0x89, 0x4d, 0x43, 0x41, 0x50, 0x30, 0x0d, 0x0a}, Magic)
+		assert.Equal(t, SizeMagic, len(Magic))
+	})
+	t.Run("opcodes", func(t *testing.T) {
+		cases := []struct {
+			opcode   OpCode
+			expected byte
+		}{
+			{OpReserved, 0x00},
+			{OpHeader, 0x01},
+			{OpFooter, 0x02},
+			{OpSchema, 0x03},
+			{OpChannel, 0x04},
+			{OpMessage, 0x05},
+			{OpChunk, 0x06},
+			{OpMessageIndex, 0x07},
+			{OpChunkIndex, 0x08},
+			{OpAttachment, 0x09},
+			{OpAttachmentIndex, 0x0a},
+			{OpStatistics, 0x0b},
+			{OpMetadata, 0x0c},
+			{OpMetadataIndex, 0x0d},
+			{OpSummaryOffset, 0x0e},
+			{OpDataEnd, 0x0f},
+		}
+		for _, c := range cases {
+			assert.Equal(t, c.expected, byte(c.opcode), c.opcode.String())
+		}
+	})
+	t.Run("compression formats", func(t *testing.T) {
+		assert.Equal(t, "zstd", string(CompressionZSTD))
+		assert.Equal(t, "lz4", string(CompressionLZ4))
+		assert.Equal(t, "", string(CompressionNone))
+	})
+	t.Run("sizes", func(t *testing.T) {
+		assert.Equal(t, 1, SizeOpcode)
+		assert.Equal(t, 8, SizeRecordLength)
+		assert.Equal(t, 9, SizeRecordPrefix)
+		assert.Equal(t, 20, SizeFooter)
+		assert.Equal(t, 4, SizeDataEnd)
+	})
+	t.Run("sizes match written file", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		w, err := NewWriter(buf, &WriterOptions{SkipStatistics: true, SkipSummaryOffsets: true})
+		assert.Nil(t, err)
+		assert.Nil(t, w.WriteHeader(&Header{}))
+		assert.Nil(t, w.Close())
+		data := buf.Bytes()
+		footerStart := len(data) - SizeMagic - SizeRecordPrefix - SizeFooter
+		assert.Equal(t, byte(OpFooter), data[footerStart])
+		assert.Equal(t, uint64(SizeFooter), binary.LittleEndian.Uint64(data[footerStart+SizeOpcode:]))
+		dataEndStart := footerStart - SizeRecordPrefix - SizeDataEnd
+		assert.Equal(t, byte(OpDataEnd), data[dataEndStart])
+		assert.Equal(t, uint64(SizeDataEnd), binary.LittleEndian.Uint64(data[dataEndStart+SizeOpcode:]))
+	})
+}
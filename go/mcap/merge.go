@@ -0,0 +1,303 @@
+package mcap
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/foxglove/mcap/go/mcap/readopts"
+)
+
+// MergeOptions holds options for Merge.
+type MergeOptions struct {
+	// WriterOptions configures the output. If nil, the output is chunked and
+	// compressed with zstd.
+	WriterOptions *WriterOptions
+	// DedupSchemas writes schemas with identical name, encoding, and data from
+	// different inputs to the output once, shared by the channels of all
+	// inputs that use them.
+	DedupSchemas bool
+}
+
+// ErrInputOutOfOrder indicates an input to Merge or MultiFileReader has a
+// message logged before the message preceding it.
+var ErrInputOutOfOrder = errors.New("input messages out of log time order")
+
+// mergeInputID identifies a schema or channel ID of a particular input.
+type mergeInputID struct {
+	input int
+	id    uint16
+}
+
+// schemaContent identifies a schema by its content, for deduplication.
+type schemaContent struct {
+	name     string
+	encoding string
+	data     string
+}
+
+// channelContent identifies a channel by its content, for deduplication. The
+// schema ID is the renumbered ID.
+type channelContent struct {
+	schemaID        uint16
+	topic           string
+	messageEncoding string
+	metadata        string
+}
+
+// messageMerger merges the messages of several inputs into a single stream in
+// log time order, with messages of equal log times in the order of their
+// inputs. Since each input numbers its schemas and channels independently,
+// they are renumbered in the order they are first referenced, optionally
+// sharing one ID among those with identical content. Each input must return
+// its messages in log time order; ErrInputOutOfOrder is returned at the first
+// message of an input logged before the one preceding it.
+type messageMerger struct {
+	iterators     []MessageIterator
+	heap          mergeHeap
+	logTimes      []uint64
+	dedupSchemas  bool
+	dedupChannels bool
+	started       bool
+
+	schemaIDs       map[mergeInputID]uint16
+	channelIDs      map[mergeInputID]uint16
+	schemas         map[uint16]*Schema
+	channels        map[uint16]*Channel
+	schemasByValue  map[schemaContent]uint16
+	channelsByValue map[channelContent]uint16
+	nextSchemaID    uint16
+	nextChannelID   uint16
+}
+
+func newMessageMerger(iterators []MessageIterator, dedupSchemas, dedupChannels bool) *messageMerger {
+	return &messageMerger{
+		iterators:       iterators,
+		logTimes:        make([]uint64, len(iterators)),
+		dedupSchemas:    dedupSchemas,
+		dedupChannels:   dedupChannels,
+		schemaIDs:       make(map[mergeInputID]uint16),
+		channelIDs:      make(map[mergeInputID]uint16),
+		schemas:         make(map[uint16]*Schema),
+		channels:        make(map[uint16]*Channel),
+		schemasByValue:  make(map[schemaContent]uint16),
+		channelsByValue: make(map[channelContent]uint16),
+		nextSchemaID:    1,
+		nextChannelID:   1,
+	}
+}
+
+// Merge reads the messages of each MCAP file in srcs and writes them to dst
+// as a single file, in log time order. Messages with equal log times are
+// written in the order of their inputs. Inputs are read forward in the order
+// their messages were written, which must be log time order, as a recorder
+// writes them; Merge returns ErrInputOutOfOrder at the first message of an
+// input logged before the one preceding it. Since each input numbers its
+// schemas and channels independently, schemas and channels are renumbered in
+// the output, and are written when first referenced by a message. The output
+// header carries the inputs' profile if they all agree, and no profile
+// otherwise. Attachments and metadata are not copied.
+func Merge(dst io.Writer, srcs []io.Reader, opts *MergeOptions) error {
+	if opts == nil {
+		opts = &MergeOptions{}
+	}
+	writerOpts := opts.WriterOptions
+	if writerOpts == nil {
+		writerOpts = &WriterOptions{Chunked: true, Compression: CompressionZSTD}
+	}
+	iterators := make([]MessageIterator, len(srcs))
+	var profile string
+	for i, src := range srcs {
+		reader, err := NewReader(src)
+		if err != nil {
+			return fmt.Errorf("failed to open input %d: %w", i, err)
+		}
+		defer reader.Close()
+		if i == 0 {
+			profile = reader.Header().Profile
+		} else if reader.Header().Profile != profile {
+			profile = ""
+		}
+		iterators[i], err = reader.Messages(readopts.UsingIndex(false))
+		if err != nil {
+			return fmt.Errorf("failed to read messages from input %d: %w", i, err)
+		}
+	}
+	writer, err := NewWriter(dst, writerOpts)
+	if err != nil {
+		return fmt.Errorf("failed to create writer: %w", err)
+	}
+	if err := writer.WriteHeader(&Header{Profile: profile}); err != nil {
+		return err
+	}
+	m := newMessageMerger(iterators, opts.DedupSchemas, false)
+	writtenSchemas := make(map[uint16]bool)
+	writtenChannels := make(map[uint16]bool)
+	for {
+		schema, channel, message, err := m.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if !writtenChannels[channel.ID] {
+			if schema != nil && !writtenSchemas[schema.ID] {
+				if err := writer.WriteSchema(schema); err != nil {
+					return fmt.Errorf("failed to write schema: %w", err)
+				}
+				writtenSchemas[schema.ID] = true
+			}
+			if err := writer.WriteChannel(channel); err != nil {
+				return fmt.Errorf("failed to write channel: %w", err)
+			}
+			writtenChannels[channel.ID] = true
+		}
+		if err := writer.WriteMessage(message); err != nil {
+			return fmt.Errorf("failed to write message: %w", err)
+		}
+	}
+	return writer.Close()
+}
+
+// Next returns the next message in log time order across all inputs, with its
+// renumbered schema and channel. The schema is nil for schemaless channels.
+// It returns io.EOF once every input is exhausted.
+func (m *messageMerger) Next() (*Schema, *Channel, *Message, error) {
+	if !m.started {
+		m.started = true
+		for i := range m.iterators {
+			if err := m.pull(i); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+	}
+	if m.heap.Len() == 0 {
+		return nil, nil, nil, io.EOF
+	}
+	next := heap.Pop(&m.heap).(mergeHeapEntry)
+	if err := m.pull(next.input); err != nil {
+		return nil, nil, nil, err
+	}
+	channel := m.channels[next.message.ChannelID]
+	return m.schemas[channel.SchemaID], channel, next.message, nil
+}
+
+// pull reads the next message from an input and pushes it onto the heap with
+// its channel ID renumbered.
+func (m *messageMerger) pull(input int) error {
+	schema, channel, message, err := m.iterators[input].Next(nil)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return fmt.Errorf("failed to read message from input %d: %w", input, err)
+	}
+	if message.LogTime < m.logTimes[input] {
+		return fmt.Errorf(
+			"input %d: message at log time %d follows one at %d: %w",
+			input, message.LogTime, m.logTimes[input], ErrInputOutOfOrder,
+		)
+	}
+	m.logTimes[input] = message.LogTime
+	channelID, ok := m.channelIDs[mergeInputID{input, channel.ID}]
+	if !ok {
+		channelID, err = m.addChannel(input, schema, channel)
+		if err != nil {
+			return err
+		}
+	}
+	message.ChannelID = channelID
+	heap.Push(&m.heap, mergeHeapEntry{input: input, message: message})
+	return nil
+}
+
+func (m *messageMerger) addSchema(input int, schema *Schema) (uint16, error) {
+	key := schemaContent{schema.Name, schema.Encoding, string(schema.Data)}
+	id, ok := m.schemasByValue[key]
+	if !ok || !m.dedupSchemas {
+		if m.nextSchemaID == 0 {
+			return 0, fmt.Errorf("too many schemas in inputs")
+		}
+		id = m.nextSchemaID
+		m.nextSchemaID++
+		m.schemas[id] = &Schema{
+			ID:       id,
+			Name:     schema.Name,
+			Encoding: schema.Encoding,
+			Data:     schema.Data,
+		}
+		m.schemasByValue[key] = id
+	}
+	m.schemaIDs[mergeInputID{input, schema.ID}] = id
+	return id, nil
+}
+
+func (m *messageMerger) addChannel(input int, schema *Schema, channel *Channel) (uint16, error) {
+	var schemaID uint16
+	if schema != nil {
+		var ok bool
+		schemaID, ok = m.schemaIDs[mergeInputID{input, schema.ID}]
+		if !ok {
+			var err error
+			schemaID, err = m.addSchema(input, schema)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	key := channelContent{
+		schemaID:        schemaID,
+		topic:           channel.Topic,
+		messageEncoding: channel.MessageEncoding,
+		metadata:        string(makePrefixedMap(channel.Metadata)),
+	}
+	id, ok := m.channelsByValue[key]
+	if !ok || !m.dedupChannels {
+		if m.nextChannelID == 0 {
+			return 0, fmt.Errorf("too many channels in inputs")
+		}
+		id = m.nextChannelID
+		m.nextChannelID++
+		m.channels[id] = &Channel{
+			ID:              id,
+			SchemaID:        schemaID,
+			Topic:           channel.Topic,
+			MessageEncoding: channel.MessageEncoding,
+			Metadata:        channel.Metadata,
+		}
+		m.channelsByValue[key] = id
+	}
+	m.channelIDs[mergeInputID{input, channel.ID}] = id
+	return id, nil
+}
+
+type mergeHeapEntry struct {
+	input   int
+	message *Message
+}
+
+// mergeHeap orders the next message of each input by log time, then by input.
+type mergeHeap []mergeHeapEntry
+
+func (h mergeHeap) Len() int      { return len(h) }
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h mergeHeap) Less(i, j int) bool {
+	if h[i].message.LogTime != h[j].message.LogTime {
+		return h[i].message.LogTime < h[j].message.LogTime
+	}
+	return h[i].input < h[j].input
+}
+
+func (h *mergeHeap) Push(x any) {
+	*h = append(*h, x.(mergeHeapEntry))
+}
+
+func (h *mergeHeap) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
@@ -0,0 +1,96 @@
+package mcap
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeMergeInput(t *testing.T, profile string, schemaData string, topic string, logTimes ...uint64) io.Reader {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{Chunked: true, Compression: CompressionLZ4})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{Profile: profile}))
+	assert.Nil(t, writer.WriteSchema(&Schema{ID: 1, Name: "schema", Encoding: "jsonschema", Data: []byte(schemaData)}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: topic, MessageEncoding: "json"}))
+	for _, logTime := range logTimes {
+		assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, LogTime: logTime, Data: []byte(topic)}))
+	}
+	assert.Nil(t, writer.Close())
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestMerge(t *testing.T) {
+	cases := []struct {
+		assertion       string
+		opts            *MergeOptions
+		schemaData      []string
+		profiles        []string
+		expectedSchemas int
+		expectedProfile string
+	}{
+		{
+			"distinct schemas",
+			nil,
+			[]string{"{}", "{}", "{\"type\": \"object\"}"},
+			[]string{"ros1", "ros1", "ros1"},
+			3,
+			"ros1",
+		},
+		{
+			"identical schemas deduplicated",
+			&MergeOptions{DedupSchemas: true},
+			[]string{"{}", "{}", "{\"type\": \"object\"}"},
+			[]string{"ros1", "ros2", "ros1"},
+			2,
+			"",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			srcs := []io.Reader{
+				writeMergeInput(t, c.profiles[0], c.schemaData[0], "/a", 1, 4, 7),
+				writeMergeInput(t, c.profiles[1], c.schemaData[1], "/b", 2, 5, 8),
+				writeMergeInput(t, c.profiles[2], c.schemaData[2], "/c", 3, 4, 9),
+			}
+			output := &bytes.Buffer{}
+			assert.Nil(t, Merge(output, srcs, c.opts))
+
+			reader, err := NewReader(bytes.NewReader(output.Bytes()))
+			assert.Nil(t, err)
+			info, err := reader.Info()
+			assert.Nil(t, err)
+			assert.Equal(t, c.expectedProfile, info.Header.Profile)
+			assert.Equal(t, c.expectedSchemas, len(info.Schemas))
+			assert.Equal(t, 3, len(info.Channels))
+			it, err := reader.Messages()
+			assert.Nil(t, err)
+			var logTimes []uint64
+			var topics []string
+			for {
+				_, channel, message, err := it.Next(nil)
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				assert.Nil(t, err)
+				assert.Equal(t, channel.Topic, string(message.Data))
+				logTimes = append(logTimes, message.LogTime)
+				topics = append(topics, channel.Topic)
+			}
+			assert.Equal(t, []uint64{1, 2, 3, 4, 4, 5, 7, 8, 9}, logTimes)
+			assert.Equal(t, []string{"/a", "/b", "/c", "/a", "/c", "/b", "/a", "/b", "/c"}, topics)
+		})
+	}
+}
+
+func TestMergeInputOutOfOrder(t *testing.T) {
+	srcs := []io.Reader{
+		writeMergeInput(t, "", "{}", "/a", 1, 4, 7),
+		writeMergeInput(t, "", "{}", "/b", 2, 8, 5),
+	}
+	err := Merge(&bytes.Buffer{}, srcs, nil)
+	assert.ErrorIs(t, err, ErrInputOutOfOrder)
+}
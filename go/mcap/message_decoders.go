@@ -0,0 +1,90 @@
+package mcap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrUnknownMessageEncoding is returned when no message decoder is registered
+// for a channel's message encoding.
+var ErrUnknownMessageEncoding = errors.New("no decoder registered for message encoding")
+
+// MessageDecoderFactory constructs a MessageDecoder for messages described by
+// a schema. The schema is nil for schemaless channels.
+type MessageDecoderFactory func(schema *Schema) (MessageDecoder, error)
+
+var (
+	messageDecodersMtx sync.RWMutex
+	messageDecoders    = map[string]MessageDecoderFactory{
+		"json": func(*Schema) (MessageDecoder, error) { return jsonMessageDecoder{}, nil },
+		"raw":  func(*Schema) (MessageDecoder, error) { return rawMessageDecoder{}, nil },
+	}
+)
+
+// RegisterMessageDecoder registers a factory for decoders of the given
+// message encoding, such as "protobuf" or "ros1", replacing any factory
+// previously registered for it. Decoders for "json" and "raw" are built in.
+func RegisterMessageDecoder(encoding string, factory MessageDecoderFactory) {
+	messageDecodersMtx.Lock()
+	defer messageDecodersMtx.Unlock()
+	messageDecoders[encoding] = factory
+}
+
+// NewMessageDecoder returns a decoder for messages with the given encoding
+// and schema, using the factory registered for the encoding.
+func NewMessageDecoder(encoding string, schema *Schema) (MessageDecoder, error) {
+	messageDecodersMtx.RLock()
+	factory, ok := messageDecoders[encoding]
+	messageDecodersMtx.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownMessageEncoding, encoding)
+	}
+	return factory(schema)
+}
+
+// MessageDecoderResolver decodes messages using the registered message
+// decoders, constructing one decoder per channel on first use.
+type MessageDecoderResolver struct {
+	decoders map[uint16]MessageDecoder
+}
+
+// NewMessageDecoderResolver returns a new MessageDecoderResolver.
+func NewMessageDecoderResolver() *MessageDecoderResolver {
+	return &MessageDecoderResolver{
+		decoders: make(map[uint16]MessageDecoder),
+	}
+}
+
+// Decode decodes a message on the given channel. The schema is nil for
+// schemaless channels.
+func (r *MessageDecoderResolver) Decode(schema *Schema, channel *Channel, message *Message) (any, error) {
+	decoder, ok := r.decoders[channel.ID]
+	if !ok {
+		var err error
+		decoder, err = NewMessageDecoder(channel.MessageEncoding, schema)
+		if err != nil {
+			return nil, err
+		}
+		r.decoders[channel.ID] = decoder
+	}
+	return decoder.Decode(schema, message.Data)
+}
+
+// jsonMessageDecoder validates JSON message data, leaving it encoded.
+type jsonMessageDecoder struct{}
+
+func (jsonMessageDecoder) Decode(_ *Schema, data []byte) (any, error) {
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("invalid JSON message data")
+	}
+	return json.RawMessage(data), nil
+}
+
+// rawMessageDecoder returns message data as-is.
+type rawMessageDecoder struct{}
+
+func (rawMessageDecoder) Decode(_ *Schema, data []byte) (any, error) {
+	return data, nil
+}
@@ -0,0 +1,70 @@
+package mcap
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type upperCaseDecoder struct{}
+
+func (upperCaseDecoder) Decode(_ *Schema, data []byte) (any, error) {
+	return strings.ToUpper(string(data)), nil
+}
+
+func TestMessageDecoderRegistry(t *testing.T) {
+	factoryCalls := 0
+	RegisterMessageDecoder("test-upper", func(schema *Schema) (MessageDecoder, error) {
+		factoryCalls++
+		assert.Equal(t, "schema", schema.Name)
+		return upperCaseDecoder{}, nil
+	})
+
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{Chunked: true, ChunkSize: 1024})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.WriteSchema(&Schema{ID: 1, Name: "schema"}))
+	channels := []*Channel{
+		{ID: 1, SchemaID: 1, Topic: "/upper", MessageEncoding: "test-upper"},
+		{ID: 2, Topic: "/json", MessageEncoding: "json"},
+		{ID: 3, Topic: "/raw", MessageEncoding: "raw"},
+		{ID: 4, Topic: "/unknown", MessageEncoding: "unknown"},
+	}
+	for _, channel := range channels {
+		assert.Nil(t, writer.WriteChannel(channel))
+	}
+	for _, data := range []string{"hello", "world"} {
+		assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, Data: []byte(data)}))
+	}
+	assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 2, Data: []byte(`{"a":1}`)}))
+	assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 3, Data: []byte{1, 2}}))
+	assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 4, Data: []byte{1, 2}}))
+	assert.Nil(t, writer.Close())
+
+	reader, err := NewReader(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	it, err := reader.Messages()
+	assert.Nil(t, err)
+	decoded := map[string][]any{}
+	err = Range(it, func(schema *Schema, channel *Channel, message *Message) error {
+		value, err := reader.DecodeMessage(schema, channel, message)
+		if channel.MessageEncoding == "unknown" {
+			assert.ErrorIs(t, err, ErrUnknownMessageEncoding)
+			return nil
+		}
+		assert.Nil(t, err)
+		decoded[channel.Topic] = append(decoded[channel.Topic], value)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]any{
+		"/upper": {"HELLO", "WORLD"},
+		"/json":  {json.RawMessage(`{"a":1}`)},
+		"/raw":   {[]byte{1, 2}},
+	}, decoded)
+	assert.Equal(t, 1, factoryCalls)
+}
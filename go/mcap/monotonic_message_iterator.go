@@ -0,0 +1,73 @@
+package mcap
+
+import (
+	"fmt"
+
+	"github.com/foxglove/mcap/go/mcap/readopts"
+)
+
+// ErrNonMonotonicTime is returned by a message iterator created with
+// readopts.EnforceMonotonicTime when a message's log time goes backward
+// relative to the previous message checked.
+type ErrNonMonotonicTime struct {
+	ChannelID       uint16
+	PreviousLogTime uint64
+	LogTime         uint64
+}
+
+func (e *ErrNonMonotonicTime) Error() string {
+	return fmt.Sprintf(
+		"non-monotonic log time on channel %d: %d follows %d",
+		e.ChannelID,
+		e.LogTime,
+		e.PreviousLogTime,
+	)
+}
+
+// monotonicMessageIterator wraps a message iterator, checking that the log
+// times of the messages it returns do not go backward.
+type monotonicMessageIterator struct {
+	it       MessageIterator
+	mode     readopts.MonotonicTimeMode
+	reverse  bool
+	hasLast  bool
+	last     uint64
+	channels map[uint16]uint64
+}
+
+func newMonotonicMessageIterator(
+	it MessageIterator,
+	mode readopts.MonotonicTimeMode,
+	order readopts.ReadOrder,
+) *monotonicMessageIterator {
+	return &monotonicMessageIterator{
+		it:       it,
+		mode:     mode,
+		reverse:  order == readopts.ReverseLogTimeOrder,
+		channels: make(map[uint16]uint64),
+	}
+}
+
+func (it *monotonicMessageIterator) Next(p []byte) (*Schema, *Channel, *Message, error) {
+	schema, channel, message, err := it.it.Next(p)
+	if err != nil {
+		return schema, channel, message, err
+	}
+	var last uint64
+	var ok bool
+	if it.mode == readopts.MonotonicTimePerChannel {
+		last, ok = it.channels[message.ChannelID]
+		it.channels[message.ChannelID] = message.LogTime
+	} else {
+		last, ok = it.last, it.hasLast
+		it.last, it.hasLast = message.LogTime, true
+	}
+	if ok && (!it.reverse && message.LogTime < last || it.reverse && message.LogTime > last) {
+		return nil, nil, nil, &ErrNonMonotonicTime{
+			ChannelID:       message.ChannelID,
+			PreviousLogTime: last,
+			LogTime:         message.LogTime,
+		}
+	}
+	return schema, channel, message, nil
+}
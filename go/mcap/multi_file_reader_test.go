@@ -0,0 +1,120 @@
+package mcap
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type readMessage struct {
+	topic     string
+	channelID uint16
+	schemaID  uint16
+	logTime   uint64
+}
+
+func readMultiFile(t *testing.T, r *MultiFileReader) []readMessage {
+	var messages []readMessage
+	for {
+		schema, channel, message, err := r.Next(nil)
+		if errors.Is(err, io.EOF) {
+			return messages
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, channel.ID, message.ChannelID)
+		assert.Equal(t, channel.SchemaID, schema.ID)
+		messages = append(messages, readMessage{channel.Topic, channel.ID, schema.ID, message.LogTime})
+	}
+}
+
+func TestMultiFileReader(t *testing.T) {
+	t.Run("consecutive files share identical definitions", func(t *testing.T) {
+		r, err := NewMultiFileReader([]io.Reader{
+			writeMergeInput(t, "", "{}", "/foo", 1, 2),
+			writeMergeInput(t, "", "{}", "/foo", 3, 4),
+		})
+		assert.Nil(t, err)
+		defer r.Close()
+		assert.Equal(t, []readMessage{
+			{"/foo", 1, 1, 1},
+			{"/foo", 1, 1, 2},
+			{"/foo", 1, 1, 3},
+			{"/foo", 1, 1, 4},
+		}, readMultiFile(t, r))
+	})
+	t.Run("overlapping files are interleaved", func(t *testing.T) {
+		r, err := NewMultiFileReader([]io.Reader{
+			writeMergeInput(t, "", "{}", "/foo", 1, 3, 5),
+			writeMergeInput(t, "", `{"type": "object"}`, "/bar", 2, 3, 4),
+		})
+		assert.Nil(t, err)
+		defer r.Close()
+		assert.Equal(t, []readMessage{
+			{"/foo", 1, 1, 1},
+			{"/bar", 2, 2, 2},
+			{"/foo", 1, 1, 3},
+			{"/bar", 2, 2, 3},
+			{"/bar", 2, 2, 4},
+			{"/foo", 1, 1, 5},
+		}, readMultiFile(t, r))
+	})
+	t.Run("same ID with different content is renumbered", func(t *testing.T) {
+		r, err := NewMultiFileReader([]io.Reader{
+			writeMergeInput(t, "", "{}", "/foo", 1),
+			writeMergeInput(t, "", "{}", "/bar", 2),
+		})
+		assert.Nil(t, err)
+		defer r.Close()
+		assert.Equal(t, []readMessage{
+			{"/foo", 1, 1, 1},
+			{"/bar", 2, 1, 2},
+		}, readMultiFile(t, r))
+	})
+	t.Run("file out of log time order", func(t *testing.T) {
+		r, err := NewMultiFileReader([]io.Reader{
+			writeMergeInput(t, "", "{}", "/foo", 1, 3),
+			writeMergeInput(t, "", "{}", "/bar", 4, 2),
+		})
+		assert.Nil(t, err)
+		defer r.Close()
+		var readErr error
+		for readErr == nil {
+			_, _, _, readErr = r.Next(nil)
+		}
+		assert.ErrorIs(t, readErr, ErrInputOutOfOrder)
+	})
+	t.Run("invalid magic", func(t *testing.T) {
+		_, err := NewMultiFileReader([]io.Reader{
+			writeMergeInput(t, "", "{}", "/foo", 1),
+			bytes.NewReader([]byte("not an mcap file")),
+		})
+		var badMagic *ErrBadMagic
+		assert.ErrorAs(t, err, &badMagic)
+	})
+}
+
+func TestOpenMultiFileReader(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for i, logTimes := range [][]uint64{{1, 2}, {3}} {
+		data, err := io.ReadAll(writeMergeInput(t, "ros1", "{}", "/foo", logTimes...))
+		assert.Nil(t, err)
+		path := filepath.Join(dir, []string{"rec_0001.mcap", "rec_0002.mcap"}[i])
+		assert.Nil(t, os.WriteFile(path, data, 0o600))
+		paths = append(paths, path)
+	}
+	r, err := OpenMultiFileReader(paths)
+	assert.Nil(t, err)
+	defer r.Close()
+	assert.Equal(t, 2, len(r.Headers()))
+	assert.Equal(t, "ros1", r.Headers()[1].Profile)
+	assert.Equal(t, 3, len(readMultiFile(t, r)))
+
+	_, err = OpenMultiFileReader([]string{filepath.Join(dir, "missing.mcap")})
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -1,11 +1,16 @@
 package mcap
 
 import (
+	"bytes"
 	"fmt"
+	"hash/crc32"
 	"io"
 )
 
-// ParseHeader parses a header record.
+// ParseHeader parses the content of a header record, as returned by the lexer
+// for TokenHeader. Each string's length prefix is checked against the record,
+// so a truncated or corrupt header returns an error wrapping
+// io.ErrShortBuffer. Bytes following the library field are ignored.
 func ParseHeader(buf []byte) (*Header, error) {
 	profile, offset, err := getPrefixedString(buf, 0)
 	if err != nil {
@@ -44,6 +49,12 @@ func ParseFooter(buf []byte) (*Footer, error) {
 
 // ParseSchema parses a schema record.
 func ParseSchema(buf []byte) (*Schema, error) {
+	return parseSchema(buf, false)
+}
+
+// parseSchema parses a schema record, leaving the schema's Data nil if
+// skipData is set. The data field is still checked to be within the record.
+func parseSchema(buf []byte, skipData bool) (*Schema, error) {
 	schemaID, offset, err := getUint16(buf, 0)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read schema ID: %w", err)
@@ -60,12 +71,15 @@ func ParseSchema(buf []byte) (*Schema, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to read schema data: %w", err)
 	}
-	return &Schema{
+	schema := &Schema{
 		ID:       schemaID,
 		Name:     name,
 		Encoding: encoding,
-		Data:     append([]byte{}, data...),
-	}, nil
+	}
+	if !skipData {
+		schema.Data = append([]byte{}, data...)
+	}
+	return schema, nil
 }
 
 // ParseChannel parses a channel record.
@@ -153,6 +167,9 @@ func ParseChunk(buf []byte) (*Chunk, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to read compression: %w", err)
 	}
+	if recordsLength > uint64(len(buf)-offset) {
+		return nil, fmt.Errorf("chunk records length %d exceeds data: %w", recordsLength, io.ErrShortBuffer)
+	}
 	records := buf[offset : offset+int(recordsLength)]
 	return &Chunk{
 		MessageStartTime: messageStartTime,
@@ -174,10 +191,18 @@ func ParseMessageIndex(buf []byte) (*MessageIndex, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to read message index entries byte length: %w", err)
 	}
+	if uint64(entriesByteLength) > uint64(len(buf)-offset) {
+		return nil, fmt.Errorf(
+			"message index entries length %d exceeds record: %w", entriesByteLength, io.ErrShortBuffer,
+		)
+	}
+	if entriesByteLength%(8+8) != 0 {
+		return nil, fmt.Errorf("message index entries length %d is not a multiple of 16", entriesByteLength)
+	}
 	var value, stamp uint64
-	var start = offset
-	records := make([]MessageIndexEntry, 0, (len(buf)-2)/(8+8))
-	for uint32(offset) < uint32(start)+entriesByteLength {
+	end := offset + int(entriesByteLength)
+	records := make([]MessageIndexEntry, 0, entriesByteLength/(8+8))
+	for offset < end {
 		stamp, offset, err = getUint64(buf, offset)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read message index entry stamp: %w", err)
@@ -307,6 +332,70 @@ func parseAttachmentReader(
 	}, nil
 }
 
+// ParseAttachment parses an attachment record, such as one located through an
+// AttachmentIndex. The returned attachment's Data reads from buf. The CRC is
+// returned as stored and not validated, so that attachments with corrupt CRCs
+// can still be read; use VerifyAttachmentCRC to validate it.
+func ParseAttachment(buf []byte) (*Attachment, error) {
+	logTime, offset, err := getUint64(buf, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read record time: %w", err)
+	}
+	createTime, offset, err := getUint64(buf, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read create time: %w", err)
+	}
+	name, offset, err := getPrefixedString(buf, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attachment name: %w", err)
+	}
+	mediaType, offset, err := getPrefixedString(buf, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read media type: %w", err)
+	}
+	dataSize, offset, err := getUint64(buf, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attachment data size: %w", err)
+	}
+	if dataSize > uint64(len(buf)-offset) {
+		return nil, fmt.Errorf("failed to read attachment data: %w", io.ErrShortBuffer)
+	}
+	data := buf[offset : offset+int(dataSize)]
+	offset += int(dataSize)
+	crc, _, err := getUint32(buf, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attachment crc: %w", err)
+	}
+	return &Attachment{
+		LogTime:    logTime,
+		CreateTime: createTime,
+		Name:       name,
+		MediaType:  mediaType,
+		DataSize:   dataSize,
+		Data:       bytes.NewReader(data),
+		CRC:        crc,
+	}, nil
+}
+
+// VerifyAttachmentCRC checks the CRC of an attachment record, as passed to
+// ParseAttachment, against the record's contents. It returns an
+// *ErrInvalidAttachmentCRC on mismatch. A zero CRC means none was computed
+// when the record was written, and is not checked.
+func VerifyAttachmentCRC(buf []byte) error {
+	attachment, err := ParseAttachment(buf)
+	if err != nil {
+		return err
+	}
+	if attachment.CRC == 0 {
+		return nil
+	}
+	crcOffset := 8 + 8 + 4 + len(attachment.Name) + 4 + len(attachment.MediaType) + 8 + int(attachment.DataSize)
+	if computed := crc32.ChecksumIEEE(buf[:crcOffset]); computed != attachment.CRC {
+		return &ErrInvalidAttachmentCRC{expected: attachment.CRC, actual: computed}
+	}
+	return nil
+}
+
 // ParseAttachmentIndex parses an attachment index record.
 func ParseAttachmentIndex(buf []byte) (*AttachmentIndex, error) {
 	attachmentOffset, offset, err := getUint64(buf, 0)
@@ -396,6 +485,9 @@ func ParseStatistics(buf []byte) (*Statistics, error) {
 	if len(buf) < start+int(channelMessageCountLength) {
 		return nil, fmt.Errorf("short channel message count lengths: %w", io.ErrShortBuffer)
 	}
+	if channelMessageCountLength%(2+8) != 0 {
+		return nil, fmt.Errorf("channel message counts length %d is not a multiple of 10", channelMessageCountLength)
+	}
 	for offset < start+int(channelMessageCountLength) {
 		chanID, offset, err = getUint16(buf, offset)
 		if err != nil {
@@ -426,13 +518,14 @@ func ParseMetadata(buf []byte) (*Metadata, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to read metadata name: %w", err)
 	}
-	metadata, _, err := getPrefixedMap(buf, offset)
+	metadata, keys, _, err := getPrefixedMapWithKeys(buf, offset)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read metadata: %w", err)
 	}
 	return &Metadata{
 		Name:     name,
 		Metadata: metadata,
+		Keys:     keys,
 	}, nil
 }
 
@@ -1,6 +1,8 @@
 package mcap
 
 import (
+	"bytes"
+	"hash/crc32"
 	"io"
 	"testing"
 
@@ -26,6 +28,39 @@ func TestParseHeader(t *testing.T) {
 			nil,
 			io.ErrShortBuffer,
 		},
+		{
+			"profile length exceeds record",
+			flatten(encodedUint32(100), []byte("ros1")),
+			nil,
+			io.ErrShortBuffer,
+		},
+		{
+			"library length exceeds record",
+			flatten(prefixedString("ros1"), encodedUint32(8), []byte("library")),
+			nil,
+			io.ErrShortBuffer,
+		},
+		{
+			"maximum length prefix",
+			flatten(encodedUint32(0xffffffff), []byte("ros1")),
+			nil,
+			io.ErrShortBuffer,
+		},
+		{
+			"truncated length prefix",
+			flatten(prefixedString("ros1"), []byte{7, 0}),
+			nil,
+			io.ErrShortBuffer,
+		},
+		{
+			"trailing bytes",
+			flatten(prefixedString("ros1"), prefixedString("library"), []byte("extra")),
+			&Header{
+				Profile: "ros1",
+				Library: "library",
+			},
+			nil,
+		},
 		{
 			"valid header",
 			flatten(prefixedString("ros1"), prefixedString("library")),
@@ -70,6 +105,7 @@ func TestParseMetadata(t *testing.T) {
 			&Metadata{
 				Name:     "metadata",
 				Metadata: make(map[string]string),
+				Keys:     []string{},
 			},
 			nil,
 		},
@@ -83,6 +119,7 @@ func TestParseMetadata(t *testing.T) {
 				Metadata: map[string]string{
 					"foo": "bar",
 				},
+				Keys: []string{"foo"},
 			},
 			nil,
 		},
@@ -98,9 +135,48 @@ func TestParseMetadata(t *testing.T) {
 					"foo":  "bar",
 					"spam": "eggs",
 				},
+				Keys: []string{"foo", "spam"},
 			},
 			nil,
 		},
+		{
+			"keys in file order",
+			flatten(
+				prefixedString("metadata"),
+				encodedUint32(8+8+7+8),
+				prefixedString("spam"), prefixedString("eggs"),
+				prefixedString("foo"), prefixedString("barr"),
+			),
+			&Metadata{
+				Name: "metadata",
+				Metadata: map[string]string{
+					"foo":  "barr",
+					"spam": "eggs",
+				},
+				Keys: []string{"spam", "foo"},
+			},
+			nil,
+		},
+		{
+			"map length exceeds record",
+			flatten(
+				prefixedString("metadata"),
+				encodedUint32(100),
+				prefixedString("spam"), prefixedString("eggs"),
+			),
+			nil,
+			io.ErrShortBuffer,
+		},
+		{
+			"truncated map entry",
+			flatten(
+				prefixedString("metadata"),
+				encodedUint32(4+4+4),
+				prefixedString("spam"), prefixedString("eggs"),
+			),
+			nil,
+			io.ErrShortBuffer,
+		},
 	}
 	for _, c := range cases {
 		t.Run(c.assertion, func(t *testing.T) {
@@ -257,3 +333,373 @@ func TestParseSchema(t *testing.T) {
 		})
 	}
 }
+
+func TestParseMessageIndex(t *testing.T) {
+	cases := []struct {
+		assertion string
+		input     []byte
+		output    *MessageIndex
+		err       error
+	}{
+		{
+			"empty input",
+			[]byte{},
+			nil,
+			io.ErrShortBuffer,
+		},
+		{
+			"missing entries length",
+			encodedUint16(1),
+			nil,
+			io.ErrShortBuffer,
+		},
+		{
+			"no entries",
+			flatten(encodedUint16(1), encodedUint32(0)),
+			&MessageIndex{
+				ChannelID: 1,
+				Records:   []MessageIndexEntry{},
+			},
+			nil,
+		},
+		{
+			"two entries",
+			flatten(
+				encodedUint16(1),
+				encodedUint32(32),
+				encodedUint64(10), encodedUint64(0),
+				encodedUint64(20), encodedUint64(100),
+			),
+			&MessageIndex{
+				ChannelID: 1,
+				Records: []MessageIndexEntry{
+					{Timestamp: 10, Offset: 0},
+					{Timestamp: 20, Offset: 100},
+				},
+			},
+			nil,
+		},
+		{
+			"declared entries length exceeds record",
+			flatten(
+				encodedUint16(1),
+				encodedUint32(48),
+				encodedUint64(10), encodedUint64(0),
+				encodedUint64(20), encodedUint64(100),
+			),
+			nil,
+			io.ErrShortBuffer,
+		},
+		{
+			"maximum declared entries length",
+			flatten(
+				encodedUint16(1),
+				encodedUint32(0xffffffff),
+				encodedUint64(10), encodedUint64(0),
+			),
+			nil,
+			io.ErrShortBuffer,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			output, err := ParseMessageIndex(c.input)
+			assert.ErrorIs(t, err, c.err)
+			assert.Equal(t, c.output, output)
+		})
+	}
+	t.Run("partial entry", func(t *testing.T) {
+		_, err := ParseMessageIndex(flatten(
+			encodedUint16(1),
+			encodedUint32(8),
+			encodedUint64(10),
+		))
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "not a multiple of 16")
+		}
+	})
+}
+
+func TestParseAttachment(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.WriteAttachment(&Attachment{
+		LogTime:    1,
+		CreateTime: 2,
+		Name:       "calibration",
+		MediaType:  "application/json",
+		DataSize:   2,
+		Data:       bytes.NewReader([]byte("{}")),
+	}))
+	assert.Nil(t, writer.Close())
+	idx := writer.AttachmentIndexes[0]
+	record := buf.Bytes()[idx.Offset+9 : idx.Offset+idx.Length]
+
+	t.Run("valid attachment", func(t *testing.T) {
+		attachment, err := ParseAttachment(record)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(1), attachment.LogTime)
+		assert.Equal(t, uint64(2), attachment.CreateTime)
+		assert.Equal(t, "calibration", attachment.Name)
+		assert.Equal(t, "application/json", attachment.MediaType)
+		assert.Equal(t, uint64(2), attachment.DataSize)
+		assert.NotZero(t, attachment.CRC)
+		data, err := io.ReadAll(attachment.Data)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("{}"), data)
+	})
+	t.Run("corrupted attachment is parsed without validation", func(t *testing.T) {
+		corrupted := append([]byte{}, record...)
+		corrupted[len(corrupted)-5] = 'x'
+		attachment, err := ParseAttachment(corrupted)
+		assert.Nil(t, err)
+		assert.NotEqual(t, crc32.ChecksumIEEE(corrupted[:len(corrupted)-4]), attachment.CRC)
+		data, err := io.ReadAll(attachment.Data)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("{x"), data)
+	})
+	t.Run("zero CRC", func(t *testing.T) {
+		corrupted := append([]byte{}, record...)
+		putUint32(corrupted[len(corrupted)-4:], 0)
+		attachment, err := ParseAttachment(corrupted)
+		assert.Nil(t, err)
+		assert.Zero(t, attachment.CRC)
+	})
+	t.Run("data size exceeds record", func(t *testing.T) {
+		_, err := ParseAttachment(record[:len(record)-6])
+		assert.ErrorIs(t, err, io.ErrShortBuffer)
+	})
+	t.Run("missing crc", func(t *testing.T) {
+		_, err := ParseAttachment(record[:len(record)-4])
+		assert.ErrorIs(t, err, io.ErrShortBuffer)
+	})
+}
+
+func TestVerifyAttachmentCRC(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.WriteAttachment(&Attachment{
+		Name:      "calibration",
+		MediaType: "application/json",
+		DataSize:  2,
+		Data:      bytes.NewReader([]byte("{}")),
+	}))
+	assert.Nil(t, writer.Close())
+	idx := writer.AttachmentIndexes[0]
+	record := buf.Bytes()[idx.Offset+9 : idx.Offset+idx.Length]
+
+	t.Run("valid attachment", func(t *testing.T) {
+		assert.Nil(t, VerifyAttachmentCRC(record))
+	})
+	t.Run("corrupted attachment", func(t *testing.T) {
+		corrupted := append([]byte{}, record...)
+		corrupted[len(corrupted)-5] = 'x'
+		err := VerifyAttachmentCRC(corrupted)
+		var invalidCRC *ErrInvalidAttachmentCRC
+		assert.ErrorAs(t, err, &invalidCRC)
+	})
+	t.Run("zero CRC is not checked", func(t *testing.T) {
+		corrupted := append([]byte{}, record...)
+		corrupted[len(corrupted)-5] = 'x'
+		putUint32(corrupted[len(corrupted)-4:], 0)
+		assert.Nil(t, VerifyAttachmentCRC(corrupted))
+	})
+	t.Run("truncated record", func(t *testing.T) {
+		err := VerifyAttachmentCRC(record[:len(record)-4])
+		assert.ErrorIs(t, err, io.ErrShortBuffer)
+	})
+}
+
+func TestParseAttachmentIndex(t *testing.T) {
+	valid := flatten(
+		encodedUint64(100),
+		encodedUint64(50),
+		encodedUint64(1),
+		encodedUint64(2),
+		encodedUint64(3),
+		prefixedString("name"),
+		prefixedString("mediaType"),
+	)
+	cases := []struct {
+		assertion string
+		input     []byte
+		output    *AttachmentIndex
+		err       error
+	}{
+		{
+			"empty input",
+			[]byte{},
+			nil,
+			io.ErrShortBuffer,
+		},
+		{
+			"missing media type",
+			valid[:len(valid)-len(prefixedString("mediaType"))],
+			nil,
+			io.ErrShortBuffer,
+		},
+		{
+			"valid attachment index",
+			valid,
+			&AttachmentIndex{
+				Offset:     100,
+				Length:     50,
+				LogTime:    1,
+				CreateTime: 2,
+				DataSize:   3,
+				Name:       "name",
+				MediaType:  "mediaType",
+			},
+			nil,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			output, err := ParseAttachmentIndex(c.input)
+			assert.ErrorIs(t, err, c.err)
+			assert.Equal(t, c.output, output)
+		})
+	}
+}
+
+func TestParseStatistics(t *testing.T) {
+	fields := flatten(
+		encodedUint64(30),
+		encodedUint16(1),
+		encodedUint32(2),
+		encodedUint32(3),
+		encodedUint32(4),
+		encodedUint32(5),
+		encodedUint64(100),
+		encodedUint64(200),
+	)
+	counts := flatten(encodedUint16(1), encodedUint64(10), encodedUint16(2), encodedUint64(20))
+	cases := []struct {
+		assertion string
+		input     []byte
+		output    *Statistics
+		err       string
+	}{
+		{
+			"empty input",
+			[]byte{},
+			nil,
+			"short statistics record",
+		},
+		{
+			"truncated channel message counts",
+			flatten(fields, encodedUint32(uint32(len(counts))), counts[:15]),
+			nil,
+			"short channel message count lengths",
+		},
+		{
+			"misaligned channel message counts",
+			flatten(fields, encodedUint32(15), counts[:15]),
+			nil,
+			"not a multiple of 10",
+		},
+		{
+			"valid statistics",
+			flatten(fields, encodedUint32(uint32(len(counts))), counts),
+			&Statistics{
+				MessageCount:         30,
+				SchemaCount:          1,
+				ChannelCount:         2,
+				AttachmentCount:      3,
+				MetadataCount:        4,
+				ChunkCount:           5,
+				MessageStartTime:     100,
+				MessageEndTime:       200,
+				ChannelMessageCounts: map[uint16]uint64{1: 10, 2: 20},
+			},
+			"",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			output, err := ParseStatistics(c.input)
+			if c.err != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), c.err)
+				}
+			} else {
+				assert.Nil(t, err)
+			}
+			assert.Equal(t, c.output, output)
+		})
+	}
+}
+
+func TestParseSummaryOffset(t *testing.T) {
+	valid := flatten([]byte{byte(OpChannel)}, encodedUint64(100), encodedUint64(50))
+	cases := []struct {
+		assertion string
+		input     []byte
+		output    *SummaryOffset
+		err       error
+	}{
+		{
+			"short input",
+			valid[:16],
+			nil,
+			io.ErrShortBuffer,
+		},
+		{
+			"valid summary offset",
+			valid,
+			&SummaryOffset{GroupOpcode: OpChannel, GroupStart: 100, GroupLength: 50},
+			nil,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			output, err := ParseSummaryOffset(c.input)
+			assert.ErrorIs(t, err, c.err)
+			assert.Equal(t, c.output, output)
+		})
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add(byte(OpChunk), chunk(f, CompressionNone, true, channelInfo())[9:])
+	f.Add(byte(OpStatistics), make([]byte, 46))
+	f.Add(byte(OpChunkIndex), make([]byte, 64))
+	f.Fuzz(func(t *testing.T, opcode byte, data []byte) {
+		switch OpCode(opcode) {
+		case OpHeader:
+			_, _ = ParseHeader(data)
+		case OpFooter:
+			_, _ = ParseFooter(data)
+		case OpSchema:
+			_, _ = ParseSchema(data)
+		case OpChannel:
+			_, _ = ParseChannel(data)
+		case OpMessage:
+			_, _ = ParseMessage(data)
+		case OpChunk:
+			_, _ = ParseChunk(data)
+		case OpMessageIndex:
+			_, _ = ParseMessageIndex(data)
+		case OpChunkIndex:
+			_, _ = ParseChunkIndex(data)
+		case OpAttachment:
+			_, _ = ParseAttachment(data)
+		case OpAttachmentIndex:
+			_, _ = ParseAttachmentIndex(data)
+		case OpStatistics:
+			_, _ = ParseStatistics(data)
+		case OpMetadata:
+			_, _ = ParseMetadata(data)
+		case OpMetadataIndex:
+			_, _ = ParseMetadataIndex(data)
+		case OpSummaryOffset:
+			_, _ = ParseSummaryOffset(data)
+		case OpDataEnd:
+			_, _ = ParseDataEnd(data)
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package mcap
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ProfileValidator checks the schemas and channels of a file against the
+// conventions implied by the profile in its header. Validate calls it once
+// for each schema and channel ID, and reports any error returned as an issue.
+type ProfileValidator interface {
+	// ValidateSchema checks a schema.
+	ValidateSchema(schema *Schema) error
+	// ValidateChannel checks a channel. The schema is nil for schemaless
+	// channels, and for channels referencing an undeclared schema.
+	ValidateChannel(channel *Channel, schema *Schema) error
+}
+
+var (
+	profileValidatorsMtx sync.RWMutex
+	profileValidators    = map[string]ProfileValidator{
+		"ros1": encodingProfileValidator{
+			schemaEncodings: []string{"ros1msg"},
+			messageEncoding: "ros1",
+		},
+		"ros2": encodingProfileValidator{
+			schemaEncodings: []string{"ros2msg", "ros2idl"},
+			messageEncoding: "cdr",
+		},
+	}
+)
+
+// RegisterProfileValidator registers a validator for files with the given
+// header profile, replacing any validator previously registered for it.
+// Validators for the "ros1" and "ros2" profiles are built in.
+func RegisterProfileValidator(profile string, v ProfileValidator) {
+	profileValidatorsMtx.Lock()
+	defer profileValidatorsMtx.Unlock()
+	profileValidators[profile] = v
+}
+
+// lookupProfileValidator returns the validator registered for profile, or nil
+// if there is none.
+func lookupProfileValidator(profile string) ProfileValidator {
+	profileValidatorsMtx.RLock()
+	defer profileValidatorsMtx.RUnlock()
+	return profileValidators[profile]
+}
+
+// encodingProfileValidator checks that schemas and channels use the encodings
+// a profile requires, and that channels have a schema.
+type encodingProfileValidator struct {
+	schemaEncodings []string
+	messageEncoding string
+}
+
+func (p encodingProfileValidator) ValidateSchema(schema *Schema) error {
+	for _, encoding := range p.schemaEncodings {
+		if schema.Encoding == encoding {
+			return nil
+		}
+	}
+	return fmt.Errorf("schema encoding %q is not one of %q", schema.Encoding, p.schemaEncodings)
+}
+
+func (p encodingProfileValidator) ValidateChannel(channel *Channel, _ *Schema) error {
+	if channel.MessageEncoding != p.messageEncoding {
+		return fmt.Errorf("message encoding %q is not %q", channel.MessageEncoding, p.messageEncoding)
+	}
+	if channel.SchemaID == 0 {
+		return fmt.Errorf("channel has no schema")
+	}
+	return nil
+}
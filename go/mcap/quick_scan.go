@@ -0,0 +1,68 @@
+package mcap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ScanResult holds the figures estimated by QuickScan.
+type ScanResult struct {
+	// EstimatedMessages is the number of message index entries found, plus the
+	// number of messages outside of chunks. Messages in chunks without message
+	// indexes are not counted.
+	EstimatedMessages uint64
+	// UncompressedBytes is the sum of the uncompressed sizes of all chunks,
+	// plus the lengths of message records outside of chunks.
+	UncompressedBytes uint64
+	// ChunkCount is the number of chunks in the data section.
+	ChunkCount uint64
+}
+
+// QuickScan reads the data section of an MCAP file and estimates the number
+// of messages and bytes it holds, without decompressing any chunks. It is
+// intended as a cheap preflight for unindexed files; for indexed files the
+// Statistics record, available from Reader.Statistics, is exact.
+func QuickScan(r io.Reader) (ScanResult, error) {
+	var result ScanResult
+	lexer, err := NewLexer(r, &LexerOptions{
+		EmitChunks: true,
+	})
+	if err != nil {
+		return result, err
+	}
+	defer lexer.Close()
+	var buf []byte
+	for {
+		tokenType, record, err := lexer.Next(buf)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return result, nil
+			}
+			return result, err
+		}
+		if len(record) > len(buf) {
+			buf = record
+		}
+		switch tokenType {
+		case TokenChunk:
+			chunk, err := ParseChunk(record)
+			if err != nil {
+				return result, fmt.Errorf("failed to parse chunk: %w", err)
+			}
+			result.ChunkCount++
+			result.UncompressedBytes += chunk.UncompressedSize
+		case TokenMessageIndex:
+			messageIndex, err := ParseMessageIndex(record)
+			if err != nil {
+				return result, fmt.Errorf("failed to parse message index: %w", err)
+			}
+			result.EstimatedMessages += uint64(len(messageIndex.Records))
+		case TokenMessage:
+			result.EstimatedMessages++
+			result.UncompressedBytes += uint64(len(record))
+		case TokenDataEnd, TokenFooter:
+			return result, nil
+		}
+	}
+}
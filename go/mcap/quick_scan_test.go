@@ -0,0 +1,59 @@
+package mcap
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuickScan(t *testing.T) {
+	cases := []struct {
+		assertion string
+		opts      *WriterOptions
+		expected  ScanResult
+	}{
+		{
+			"chunked with message indexes",
+			&WriterOptions{Chunked: true, ChunkSize: 1024, Compression: CompressionZSTD},
+			ScanResult{EstimatedMessages: 100, UncompressedBytes: 100 * (9 + 22 + 100), ChunkCount: 13},
+		},
+		{
+			"chunked without message indexes",
+			&WriterOptions{Chunked: true, ChunkSize: 1024, Compression: CompressionLZ4, SkipMessageIndexing: true},
+			ScanResult{EstimatedMessages: 0, UncompressedBytes: 100 * (9 + 22 + 100), ChunkCount: 13},
+		},
+		{
+			"unchunked",
+			&WriterOptions{},
+			ScanResult{EstimatedMessages: 100, UncompressedBytes: 100 * (22 + 100), ChunkCount: 0},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			writer, err := NewWriter(buf, c.opts)
+			assert.Nil(t, err)
+			assert.Nil(t, writer.WriteHeader(&Header{}))
+			assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, Topic: "/foo"}))
+			for i := 0; i < 100; i++ {
+				assert.Nil(t, writer.WriteMessage(&Message{
+					ChannelID: 1,
+					LogTime:   uint64(i),
+					Data:      make([]byte, 100),
+				}))
+			}
+			assert.Nil(t, writer.Close())
+			result, err := QuickScan(bytes.NewReader(buf.Bytes()))
+			assert.Nil(t, err)
+			assert.Equal(t, c.expected.EstimatedMessages, result.EstimatedMessages)
+			assert.Equal(t, c.expected.ChunkCount, result.ChunkCount)
+			if c.expected.ChunkCount > 0 {
+				// chunks also contain the channel record
+				assert.Greater(t, result.UncompressedBytes, c.expected.UncompressedBytes)
+			} else {
+				assert.Equal(t, c.expected.UncompressedBytes, result.UncompressedBytes)
+			}
+		})
+	}
+}
@@ -1,65 +1,100 @@
 package mcap
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"math"
+	"sort"
 
 	"github.com/foxglove/mcap/go/mcap/readopts"
 )
 
 func getPrefixedString(data []byte, offset int) (s string, newoffset int, err error) {
-	if len(data[offset:]) < 4 {
+	if offset < 0 || offset > len(data) || len(data)-offset < 4 {
 		return "", 0, io.ErrShortBuffer
 	}
-	length := int(binary.LittleEndian.Uint32(data[offset : offset+4]))
-	if len(data[offset+4:]) < length {
+	// compare as uint64 so that lengths beyond the range of int cannot wrap.
+	length := uint64(binary.LittleEndian.Uint32(data[offset : offset+4]))
+	if uint64(len(data)-offset-4) < length {
 		return "", 0, io.ErrShortBuffer
 	}
-	return string(data[offset+4 : offset+length+4]), offset + 4 + length, nil
+	return string(data[offset+4 : offset+4+int(length)]), offset + 4 + int(length), nil
 }
 
 func getPrefixedBytes(data []byte, offset int) (s []byte, newoffset int, err error) {
-	if len(data[offset:]) < 4 {
+	if offset < 0 || offset > len(data) || len(data)-offset < 4 {
 		return nil, 0, io.ErrShortBuffer
 	}
-	length := int(binary.LittleEndian.Uint32(data[offset : offset+4]))
-	if len(data[offset+4:]) < length {
+	length := uint64(binary.LittleEndian.Uint32(data[offset : offset+4]))
+	if uint64(len(data)-offset-4) < length {
 		return nil, 0, io.ErrShortBuffer
 	}
-	return data[offset+4 : offset+length+4], offset + 4 + length, nil
+	return data[offset+4 : offset+4+int(length)], offset + 4 + int(length), nil
 }
 
 func getPrefixedMap(data []byte, offset int) (result map[string]string, newoffset int, err error) {
+	m, _, newoffset, err := getPrefixedMapWithKeys(data, offset)
+	return m, newoffset, err
+}
+
+// getPrefixedMapWithKeys reads a length-prefixed map, additionally returning
+// the keys in the order they appear in the data.
+func getPrefixedMapWithKeys(
+	data []byte,
+	offset int,
+) (result map[string]string, keys []string, newoffset int, err error) {
 	var key, value string
 	var inset int
 	m := make(map[string]string)
+	keys = []string{}
 	maplen, offset, err := getUint32(data, offset)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to read map length: %w", err)
+		return nil, nil, 0, fmt.Errorf("failed to read map length: %w", err)
 	}
-	for uint32(offset+inset) < uint32(offset)+maplen {
-		key, inset, err = getPrefixedString(data[offset:], inset)
+	if uint64(maplen) > uint64(len(data)-offset) {
+		return nil, nil, 0, fmt.Errorf("map length %d exceeds data: %w", maplen, io.ErrShortBuffer)
+	}
+	mapdata := data[offset : offset+int(maplen)]
+	for inset < len(mapdata) {
+		key, inset, err = getPrefixedString(mapdata, inset)
 		if err != nil {
-			return nil, 0, fmt.Errorf("failed to read map key: %w", err)
+			return nil, nil, 0, fmt.Errorf("failed to read map key: %w", err)
 		}
-		value, inset, err = getPrefixedString(data[offset:], inset)
+		value, inset, err = getPrefixedString(mapdata, inset)
 		if err != nil {
-			return nil, 0, fmt.Errorf("failed to read map value: %w", err)
+			return nil, nil, 0, fmt.Errorf("failed to read map value: %w", err)
+		}
+		if _, ok := m[key]; !ok {
+			keys = append(keys, key)
 		}
 		m[key] = value
 	}
-	return m, offset + inset, nil
+	return m, keys, offset + inset, nil
 }
 
+// Reader reads an MCAP file. Files are read in one of two ways: forward, in a
+// single pass over the data section, or through the index in the summary
+// section, which requires seeking. The forward path never seeks, so a Reader
+// over a pipe or network stream can read every message with
+// readopts.UsingIndex(false). Methods that consult the summary section, such
+// as Info, Statistics and indexed message iteration, return an error if the
+// underlying reader is not an io.ReadSeeker.
 type Reader struct {
 	l        *Lexer
 	r        io.Reader
 	rs       io.ReadSeeker
 	header   *Header
 	channels map[uint16]*Channel
+	decoders *MessageDecoderResolver
+	warnings []Warning
+	// openIterators holds the indexed message iterators returned by the
+	// reader that have not yet released their decompressors.
+	openIterators map[*indexedMessageIterator]struct{}
 }
 
 type MessageIterator interface {
@@ -121,6 +156,20 @@ func (r *Reader) indexedMessageIterator(
 	}
 }
 
+// trackIterator records an indexed message iterator returned to the caller,
+// so that Close can release its decompressors if it is left unfinished.
+func (r *Reader) trackIterator(it *indexedMessageIterator) {
+	if r.openIterators == nil {
+		r.openIterators = make(map[*indexedMessageIterator]struct{})
+	}
+	r.openIterators[it] = struct{}{}
+	it.onClose = func() { delete(r.openIterators, it) }
+}
+
+// Messages returns an iterator over the messages of the file. By default the
+// file's index is used, which requires a seekable reader; with
+// readopts.UsingIndex(false) messages are instead read forward in a single
+// pass, in file order, which works on any io.Reader.
 func (r *Reader) Messages(
 	opts ...readopts.ReadOpt,
 ) (MessageIterator, error) {
@@ -131,15 +180,64 @@ func (r *Reader) Messages(
 			return nil, err
 		}
 	}
+	var it MessageIterator
 	if ro.UseIndex {
 		if rs, ok := r.r.(io.ReadSeeker); ok {
 			r.rs = rs
 		} else {
 			return nil, fmt.Errorf("indexed reader requires a seekable reader")
 		}
-		return r.indexedMessageIterator(ro.Topics, uint64(ro.Start), uint64(ro.End), ro.Order), nil
+		indexed := r.indexedMessageIterator(ro.Topics, uint64(ro.Start), uint64(ro.End), ro.Order)
+		indexed.skipSchemaData = ro.SkipSchemaData
+		indexed.decompressionWorkers = ro.DecompressionWorkers
+		r.trackIterator(indexed)
+		it = indexed
+	} else {
+		unindexed := r.unindexedIterator(ro.Topics, uint64(ro.Start), uint64(ro.End))
+		unindexed.skipSchemaData = ro.SkipSchemaData
+		it = unindexed
+	}
+	if ro.EnforceMonotonicTime != readopts.MonotonicTimeOff {
+		it = newMonotonicMessageIterator(it, ro.EnforceMonotonicTime, ro.Order)
+	}
+	if ro.CheckProfile {
+		it = r.newProfileCheckingIterator(it)
+	}
+	return it, nil
+}
+
+// ChannelMessages returns an iterator over the messages of a single channel,
+// using the file's index. Only chunks whose chunk index records a message
+// index for the channel are read, and within each chunk only the channel's
+// message index is consulted. Chunks are the unit of compression, so a chunk
+// containing any message on the channel is still decompressed in full. Time
+// range and order options apply as for Messages; the index is always used.
+func (r *Reader) ChannelMessages(channelID uint16, opts ...readopts.ReadOpt) (MessageIterator, error) {
+	ro := readopts.Default()
+	for _, opt := range opts {
+		err := opt(&ro)
+		if err != nil {
+			return nil, err
+		}
+	}
+	rs, ok := r.r.(io.ReadSeeker)
+	if !ok {
+		return nil, fmt.Errorf("indexed reader requires a seekable reader")
+	}
+	r.rs = rs
+	indexed := r.indexedMessageIterator(ro.Topics, uint64(ro.Start), uint64(ro.End), ro.Order)
+	indexed.channelIDs = map[uint16]bool{channelID: true}
+	indexed.skipSchemaData = ro.SkipSchemaData
+	indexed.decompressionWorkers = ro.DecompressionWorkers
+	r.trackIterator(indexed)
+	var it MessageIterator = indexed
+	if ro.EnforceMonotonicTime != readopts.MonotonicTimeOff {
+		it = newMonotonicMessageIterator(it, ro.EnforceMonotonicTime, ro.Order)
+	}
+	if ro.CheckProfile {
+		it = r.newProfileCheckingIterator(it)
 	}
-	return r.unindexedIterator(ro.Topics, uint64(ro.Start), uint64(ro.End)), nil
+	return it, nil
 }
 
 // Get the Header record from this MCAP.
@@ -150,6 +248,9 @@ func (r *Reader) Header() *Header {
 // Info scans the summary section to form a structure describing characteristics
 // of the underlying mcap file.
 func (r *Reader) Info() (*Info, error) {
+	if r.rs == nil {
+		return nil, fmt.Errorf("reading the summary section requires a seekable reader")
+	}
 	it := r.indexedMessageIterator(nil, 0, math.MaxUint64, readopts.FileOrder)
 	err := it.parseSummarySection()
 	if err != nil {
@@ -167,31 +268,568 @@ func (r *Reader) Info() (*Info, error) {
 	}, nil
 }
 
-// Close the reader.
+// ChunkOffsetForTime reads the summary section and returns the offset of the
+// chunk containing log time t, as described by Info.ChunkOffsetForTime. Callers
+// looking up many times should call Info once and use its method directly.
+func (r *Reader) ChunkOffsetForTime(t uint64) (offset int64, found bool, err error) {
+	info, err := r.Info()
+	if err != nil {
+		return 0, false, err
+	}
+	offset, found = info.ChunkOffsetForTime(t)
+	return offset, found, nil
+}
+
+// Statistics returns the Statistics record from the summary section, without
+// scanning the data section. If the file has summary offsets, only the
+// statistics group of the summary is read. If the file has no summary or the
+// summary does not include statistics, found is false.
+func (r *Reader) Statistics() (stats *Statistics, found bool, err error) {
+	offsets, err := r.SummaryOffsets()
+	if err != nil {
+		return nil, false, err
+	}
+	if len(offsets) == 0 {
+		info, err := r.Info()
+		if err != nil {
+			return nil, false, err
+		}
+		return info.Statistics, info.Statistics != nil, nil
+	}
+	for _, offset := range offsets {
+		if offset.GroupOpcode != OpStatistics {
+			continue
+		}
+		err = r.ReadSummaryGroup(offset, func(tokenType TokenType, record []byte) error {
+			if tokenType != TokenStatistics {
+				return nil
+			}
+			stats, err = ParseStatistics(record)
+			if err != nil {
+				return fmt.Errorf("failed to parse statistics: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, false, err
+		}
+		return stats, stats != nil, nil
+	}
+	return nil, false, nil
+}
+
+// Attachments returns the attachment indexes from the summary section, which
+// describe the name, media type, size and location of each attachment without
+// reading its data. If the file has summary offsets, only the attachment index
+// group of the summary is read. The result is empty if the file has no
+// attachment indexes.
+func (r *Reader) Attachments() ([]*AttachmentIndex, error) {
+	var indexes []*AttachmentIndex
+	found, err := r.readIndexGroup(OpAttachmentIndex, TokenAttachmentIndex, func(record []byte) error {
+		idx, err := ParseAttachmentIndex(record)
+		if err != nil {
+			return fmt.Errorf("failed to parse attachment index: %w", err)
+		}
+		indexes = append(indexes, idx)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		info, err := r.Info()
+		if err != nil {
+			return nil, err
+		}
+		return info.AttachmentIndexes, nil
+	}
+	return indexes, nil
+}
+
+// MetadataEntries returns the metadata indexes from the summary section, which
+// describe the name and location of each metadata record without reading it.
+// They are located in the same manner as by Attachments.
+func (r *Reader) MetadataEntries() ([]*MetadataIndex, error) {
+	var indexes []*MetadataIndex
+	found, err := r.readIndexGroup(OpMetadataIndex, TokenMetadataIndex, func(record []byte) error {
+		idx, err := ParseMetadataIndex(record)
+		if err != nil {
+			return fmt.Errorf("failed to parse metadata index: %w", err)
+		}
+		indexes = append(indexes, idx)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		info, err := r.Info()
+		if err != nil {
+			return nil, err
+		}
+		return info.MetadataIndexes, nil
+	}
+	return indexes, nil
+}
+
+// GetAttachmentReader returns an AttachmentReader over the attachment record at
+// offset, such as the Offset of an AttachmentIndex returned by Attachments, so
+// that its data can be streamed without being read into memory. The
+// AttachmentReader reads from the underlying file, so its data must be
+// consumed before the Reader is used again. Its CRC is computed as the data is
+// read, for comparison with ParsedCRC.
+func (r *Reader) GetAttachmentReader(offset uint64) (*AttachmentReader, error) {
+	if r.rs == nil {
+		return nil, fmt.Errorf("reading attachments by offset requires a seekable reader")
+	}
+	if _, err := r.rs.Seek(int64(offset), io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek to attachment: %w", err)
+	}
+	prefix := make([]byte, 9)
+	if _, err := io.ReadFull(r.rs, prefix); err != nil {
+		return nil, fmt.Errorf("failed to read attachment record prefix: %w", err)
+	}
+	if op := OpCode(prefix[0]); op != OpAttachment {
+		return nil, fmt.Errorf("unexpected %s record at attachment offset %d", op, offset)
+	}
+	recordLen := binary.LittleEndian.Uint64(prefix[1:])
+	ar, err := parseAttachmentReader(io.LimitReader(r.rs, int64(recordLen)), true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse attachment: %w", err)
+	}
+	return ar, nil
+}
+
+// readIndexGroup calls f with each record of type tokenType in the summary
+// group for opcode, located through the summary offset section. If the file has no
+// summary offsets, found is false and the caller must read the whole summary.
+func (r *Reader) readIndexGroup(
+	opcode OpCode,
+	tokenType TokenType,
+	f func([]byte) error,
+) (found bool, err error) {
+	offsets, err := r.SummaryOffsets()
+	if err != nil {
+		return false, err
+	}
+	if len(offsets) == 0 {
+		return false, nil
+	}
+	for _, offset := range offsets {
+		if offset.GroupOpcode != opcode {
+			continue
+		}
+		err := r.ReadSummaryGroup(offset, func(recordType TokenType, record []byte) error {
+			if recordType != tokenType {
+				return nil
+			}
+			return f(record)
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// SummaryOffsets returns the SummaryOffset records from the summary offset
+// section of the file, which locate each group of records in the summary
+// section by opcode. If the file has no summary offset section, the result is
+// empty. The reader must be seekable.
+func (r *Reader) SummaryOffsets() ([]*SummaryOffset, error) {
+	if r.rs == nil {
+		return nil, fmt.Errorf("reading summary offsets requires a seekable reader")
+	}
+	footer, err := ReadFooter(r.rs)
+	if err != nil {
+		return nil, err
+	}
+	if footer.SummaryOffsetStart == 0 {
+		return nil, nil
+	}
+	if _, err := r.rs.Seek(int64(footer.SummaryOffsetStart), io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek to summary offset section: %w", err)
+	}
+	lexer, err := NewLexer(bufio.NewReader(r.rs), &LexerOptions{SkipMagic: true})
+	if err != nil {
+		return nil, err
+	}
+	defer lexer.Close()
+	offsets := []*SummaryOffset{}
+	for {
+		tokenType, record, err := lexer.Next(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read summary offset section: %w", err)
+		}
+		switch tokenType {
+		case TokenSummaryOffset:
+			offset, err := ParseSummaryOffset(record)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse summary offset: %w", err)
+			}
+			offsets = append(offsets, offset)
+		case TokenFooter:
+			return offsets, nil
+		default:
+			return nil, fmt.Errorf("unexpected %s record in summary offset section", tokenType)
+		}
+	}
+}
+
+// ReadSummaryGroup reads the group of summary records located by offset,
+// calling f with each record. The records of a group share an opcode, so a
+// single group can be read without reading the rest of the summary section.
+// The record passed to f is only valid until f returns.
+func (r *Reader) ReadSummaryGroup(offset *SummaryOffset, f func(TokenType, []byte) error) error {
+	if r.rs == nil {
+		return fmt.Errorf("reading summary groups requires a seekable reader")
+	}
+	if _, err := r.rs.Seek(int64(offset.GroupStart), io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek to %s group: %w", offset.GroupOpcode, err)
+	}
+	lexer, err := NewLexer(
+		bufio.NewReader(io.LimitReader(r.rs, int64(offset.GroupLength))),
+		&LexerOptions{SkipMagic: true},
+	)
+	if err != nil {
+		return err
+	}
+	defer lexer.Close()
+	var buf []byte
+	for {
+		tokenType, record, err := lexer.Next(buf)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to read %s group: %w", offset.GroupOpcode, err)
+		}
+		if len(record) > len(buf) {
+			buf = record
+		}
+		if err := f(tokenType, record); err != nil {
+			return err
+		}
+	}
+}
+
+// DefinitionsOptions holds options for Reader.Channels and Reader.Schemas.
+type DefinitionsOptions struct {
+	// ChannelsOnly stops the data section scan used for files without a
+	// summary at the first message, rather than reading to the end of the file.
+	// Well-formed files declare schemas and channels before their messages, so
+	// this only misses definitions that are written part way through a file.
+	ChannelsOnly bool
+}
+
+// Channels returns the channels declared in the file, ordered by ID. For
+// indexed files they are read from the summary section without scanning any
+// data. If the summary contains no channels, the data section is scanned
+// instead; see DefinitionsOptions for limiting the scan. The reader must be
+// seekable, and its position is restored afterward.
+func (r *Reader) Channels(opts ...*DefinitionsOptions) ([]*Channel, error) {
+	_, channels, err := r.definitions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return sortedChannels(channels), nil
+}
+
+// Schemas returns the schemas declared in the file, ordered by ID. They are
+// located in the same manner as by Channels.
+func (r *Reader) Schemas(opts ...*DefinitionsOptions) ([]*Schema, error) {
+	schemas, _, err := r.definitions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return sortedSchemas(schemas), nil
+}
+
+func sortedSchemas(schemas map[uint16]*Schema) []*Schema {
+	ids := make([]uint16, 0, len(schemas))
+	for id := range schemas {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	result := make([]*Schema, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, schemas[id])
+	}
+	return result
+}
+
+func sortedChannels(channels map[uint16]*Channel) []*Channel {
+	ids := make([]uint16, 0, len(channels))
+	for id := range channels {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	result := make([]*Channel, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, channels[id])
+	}
+	return result
+}
+
+func (r *Reader) definitions(
+	opts ...*DefinitionsOptions,
+) (schemas map[uint16]*Schema, channels map[uint16]*Channel, err error) {
+	if r.rs == nil {
+		return nil, nil, fmt.Errorf("reading definitions requires a seekable reader")
+	}
+	pos, err := r.rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get reader position: %w", err)
+	}
+	defer func() {
+		if _, seekErr := r.rs.Seek(pos, io.SeekStart); seekErr != nil && err == nil {
+			err = fmt.Errorf("failed to restore reader position: %w", seekErr)
+		}
+	}()
+	it := r.indexedMessageIterator(nil, 0, math.MaxUint64, readopts.FileOrder)
+	if err := it.parseSummarySection(); err != nil {
+		return nil, nil, err
+	}
+	if len(it.channels) > 0 {
+		return it.schemas, it.channels, nil
+	}
+	channelsOnly := len(opts) > 0 && opts[0].ChannelsOnly
+	return scanDefinitions(r.rs, channelsOnly)
+}
+
+// scanDefinitions reads the data section of the file from the start,
+// collecting schema and channel records.
+func scanDefinitions(
+	rs io.ReadSeeker,
+	stopAtMessage bool,
+) (schemas map[uint16]*Schema, channels map[uint16]*Channel, err error) {
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, fmt.Errorf("failed to seek to start of file: %w", err)
+	}
+	return readDefinitions(bufio.NewReader(rs), stopAtMessage)
+}
+
+// ReadDefinitions reads the schemas and channels declared in the data section
+// of an MCAP file, ordered by ID, without requiring r to be seekable. Reading
+// stops at the first message, including a message inside a chunk, of which
+// only as much is decompressed as precedes that message. Well-formed files
+// declare schemas and channels before their messages, so this is much cheaper
+// than reading the whole file; definitions written after the first message
+// are not returned.
+func ReadDefinitions(r io.Reader) ([]*Schema, []*Channel, error) {
+	schemas, channels, err := readDefinitions(r, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sortedSchemas(schemas), sortedChannels(channels), nil
+}
+
+// readDefinitions reads an MCAP file from its magic, collecting schema and
+// channel records until the end of the data section, or the first message if
+// stopAtMessage is set.
+func readDefinitions(
+	r io.Reader,
+	stopAtMessage bool,
+) (schemas map[uint16]*Schema, channels map[uint16]*Channel, err error) {
+	lexer, err := NewLexer(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer lexer.Close()
+	schemas = make(map[uint16]*Schema)
+	channels = make(map[uint16]*Channel)
+	for {
+		tokenType, record, err := lexer.Next(nil)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return schemas, channels, nil
+			}
+			return nil, nil, fmt.Errorf("failed to read record: %w", err)
+		}
+		switch tokenType {
+		case TokenSchema:
+			schema, err := ParseSchema(record)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to parse schema: %w", err)
+			}
+			schemas[schema.ID] = schema
+		case TokenChannel:
+			channel, err := ParseChannel(record)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to parse channel: %w", err)
+			}
+			channels[channel.ID] = channel
+		case TokenMessage:
+			if stopAtMessage {
+				return schemas, channels, nil
+			}
+		case TokenDataEnd, TokenFooter:
+			return schemas, channels, nil
+		}
+	}
+}
+
+// DecodeMessage decodes a message read from this reader using the decoder
+// registered for its channel's message encoding. Decoders are constructed
+// once per channel and reused for later messages. Decoding is separate from
+// iteration, so a message that fails to decode does not stop the iterator it
+// was read from.
+func (r *Reader) DecodeMessage(schema *Schema, channel *Channel, message *Message) (any, error) {
+	if r.decoders == nil {
+		r.decoders = NewMessageDecoderResolver()
+	}
+	return r.decoders.Decode(schema, channel, message)
+}
+
+// Close the reader, releasing the decoders of any indexed message iterators
+// it created that have not reached the end of their messages.
 func (r *Reader) Close() {
+	for it := range r.openIterators {
+		it.close()
+	}
 	r.l.Close()
 }
 
+// ReadHeader validates the leading magic and reads the Header record from the
+// start of an MCAP file. It consumes only the magic and the header record,
+// leaving r positioned at the start of the following record.
+func ReadHeader(r io.Reader) (*Header, error) {
+	err := validateMagic(r)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 9)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header opcode and length: %w", err)
+	}
+	opcode := OpCode(buf[0])
+	if opcode != OpHeader {
+		return nil, fmt.Errorf("expected first record in MCAP to be a Header, found %s", opcode)
+	}
+	recordLen := binary.LittleEndian.Uint64(buf[1:])
+	record, err := makeSafe(recordLen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate %d bytes for header: %w", recordLen, err)
+	}
+	_, err = io.ReadFull(r, record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	return ParseHeader(record)
+}
+
+// ReadFooter reads the Footer record from the end of an MCAP file. The closing
+// magic is validated and the reader is left positioned at the end of the file.
+// Files without a summary section report zero for SummaryStart and
+// SummaryOffsetStart.
+func ReadFooter(rs io.ReadSeeker) (*Footer, error) {
+	footerRecordLen := SizeRecordPrefix + SizeFooter
+	_, err := rs.Seek(-int64(footerRecordLen+SizeMagic), io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to seek to footer: %w", err)
+	}
+	buf := make([]byte, footerRecordLen+SizeMagic)
+	_, err = io.ReadFull(rs, buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read footer: %w", err)
+	}
+	if magic := buf[footerRecordLen:]; !bytes.Equal(magic, Magic) {
+		return nil, &ErrBadMagic{actual: magic}
+	}
+	if opcode := OpCode(buf[0]); opcode != OpFooter {
+		return nil, fmt.Errorf("expected Footer before closing magic, found %s", opcode)
+	}
+	if recordLen := binary.LittleEndian.Uint64(buf[SizeOpcode:SizeRecordPrefix]); recordLen != SizeFooter {
+		return nil, fmt.Errorf("invalid footer record length %d", recordLen)
+	}
+	return ParseFooter(buf[SizeRecordPrefix:footerRecordLen])
+}
+
+// ErrSummaryCRCNotPresent is returned by ValidateSummaryCRC when the footer's
+// summary CRC is zero, indicating that the writer did not compute one.
+var ErrSummaryCRCNotPresent = errors.New("summary CRC not present")
+
+// ValidateSummaryCRC checks the SummaryCRC field of the footer against the
+// file contents. Per the specification, the CRC covers every byte from the
+// start of the summary section up to and including the SummaryOffsetStart
+// field of the footer: the summary section, the summary offset section, and
+// the footer's opcode, length, SummaryStart, and SummaryOffsetStart fields.
+// For files without a summary section, only the footer fields are covered.
+//
+// It returns ErrSummaryCRCNotPresent if the CRC is zero and
+// ErrInvalidSummaryCRC if it does not match. The reader must be seekable.
+func (r *Reader) ValidateSummaryCRC() error {
+	if r.rs == nil {
+		return fmt.Errorf("validating the summary CRC requires a seekable reader")
+	}
+	footer, err := ReadFooter(r.rs)
+	if err != nil {
+		return err
+	}
+	if footer.SummaryCRC == 0 {
+		return ErrSummaryCRCNotPresent
+	}
+	actual, err := computeSummaryCRC(r.rs, footer)
+	if err != nil {
+		return err
+	}
+	if actual != footer.SummaryCRC {
+		return &ErrInvalidSummaryCRC{expected: footer.SummaryCRC, actual: actual}
+	}
+	return nil
+}
+
+// computeSummaryCRC computes the CRC of the bytes covered by the footer's
+// summary CRC, as described by ValidateSummaryCRC.
+func computeSummaryCRC(rs io.ReadSeeker, footer *Footer) (uint32, error) {
+	footerStart, err := rs.Seek(-int64(SizeRecordPrefix+SizeFooter+SizeMagic), io.SeekEnd)
+	if err != nil {
+		return 0, fmt.Errorf("failed to seek to footer: %w", err)
+	}
+	start := footerStart
+	if footer.SummaryStart != 0 {
+		if footer.SummaryStart > uint64(footerStart) {
+			return 0, fmt.Errorf("summary start %d is beyond footer at %d", footer.SummaryStart, footerStart)
+		}
+		start = int64(footer.SummaryStart)
+	}
+	if _, err := rs.Seek(start, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to seek to summary start: %w", err)
+	}
+	crc := crc32.NewIEEE()
+	if _, err := io.CopyN(crc, rs, footerStart-start+1+8+8+8); err != nil {
+		return 0, fmt.Errorf("failed to read summary: %w", err)
+	}
+	return crc.Sum32(), nil
+}
+
+// NewReaderAt returns a Reader for an MCAP file of the given size that is
+// accessed through an io.ReaderAt, such as a client for an object store
+// supporting ranged requests. Every read of the file is issued as a ReadAt
+// call at the reader's current offset, so reading the footer and reading each
+// chunk through the index each map to a single ranged read, and chunks not
+// needed for a query are never fetched.
+func NewReaderAt(r io.ReaderAt, size int64) (*Reader, error) {
+	return NewReader(io.NewSectionReader(r, 0, size))
+}
+
+// NewReader returns a Reader for the MCAP file read from r, after reading and
+// validating its header. If r is not an io.ReadSeeker, only forward reading
+// is available; see Reader.
 func NewReader(r io.Reader) (*Reader, error) {
 	var rs io.ReadSeeker
 	if readseeker, ok := r.(io.ReadSeeker); ok {
 		rs = readseeker
 	}
-	lexer, err := NewLexer(r, &LexerOptions{
-		EmitChunks: true,
-	})
-	if err != nil {
-		return nil, err
-	}
-	defer lexer.Close()
-	token, headerData, err := lexer.Next(nil)
+	header, err := ReadHeader(r)
 	if err != nil {
 		return nil, fmt.Errorf("could not read MCAP header when opening reader: %w", err)
 	}
-	if token != TokenHeader {
-		return nil, fmt.Errorf("expected first record in MCAP to be a Header, found %v", headerData)
-	}
-	header, err := ParseHeader(headerData)
+	lexer, err := NewLexer(r, &LexerOptions{
+		SkipMagic:  true,
+		EmitChunks: true,
+	})
 	if err != nil {
 		return nil, err
 	}
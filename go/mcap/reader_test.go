@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"testing"
@@ -673,3 +674,878 @@ func TestReadingMessageOrderWithOverlappingChunks(t *testing.T) {
 	assert.Nil(t, msg)
 	assert.Error(t, io.EOF, err)
 }
+
+func TestSeekToChunkForTime(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{
+		Chunked:     true,
+		ChunkSize:   10,
+		Compression: CompressionZSTD,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.WriteSchema(&Schema{ID: 1}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/foo"}))
+	for i := uint64(1); i <= 5; i++ {
+		assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, LogTime: i * 100, Data: []byte("hello")}))
+	}
+	assert.Nil(t, writer.Close())
+
+	rs := bytes.NewReader(buf.Bytes())
+	reader, err := NewReader(rs)
+	assert.Nil(t, err)
+	offset, found, err := reader.ChunkOffsetForTime(300)
+	assert.Nil(t, err)
+	assert.True(t, found)
+
+	_, err = rs.Seek(offset, io.SeekStart)
+	assert.Nil(t, err)
+	lexer, err := NewLexerAt(rs, true)
+	assert.Nil(t, err)
+	for {
+		tokenType, record, err := lexer.Next(nil)
+		assert.Nil(t, err)
+		if tokenType == TokenMessage {
+			msg, err := ParseMessage(record)
+			assert.Nil(t, err)
+			assert.Equal(t, uint64(300), msg.LogTime)
+			break
+		}
+	}
+
+	_, found, err = reader.ChunkOffsetForTime(600)
+	assert.Nil(t, err)
+	assert.False(t, found)
+}
+
+func TestReaderDefinitions(t *testing.T) {
+	writeFile := func(t *testing.T, opts *WriterOptions) []byte {
+		buf := &bytes.Buffer{}
+		writer, err := NewWriter(buf, opts)
+		assert.Nil(t, err)
+		assert.Nil(t, writer.WriteHeader(&Header{}))
+		assert.Nil(t, writer.WriteSchema(&Schema{ID: 2, Name: "b"}))
+		assert.Nil(t, writer.WriteSchema(&Schema{ID: 1, Name: "a"}))
+		assert.Nil(t, writer.WriteChannel(&Channel{ID: 2, SchemaID: 2, Topic: "/bar"}))
+		assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 2, Data: []byte("hello")}))
+		assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/foo"}))
+		assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, Data: []byte("hello")}))
+		assert.Nil(t, writer.Close())
+		return buf.Bytes()
+	}
+	topics := func(channels []*Channel) []string {
+		result := []string{}
+		for _, channel := range channels {
+			result = append(result, channel.Topic)
+		}
+		return result
+	}
+	cases := []struct {
+		assertion    string
+		writerOpts   *WriterOptions
+		channelsOnly bool
+		topics       []string
+	}{
+		{
+			"from summary",
+			&WriterOptions{Chunked: true, ChunkSize: 1024},
+			true,
+			[]string{"/foo", "/bar"},
+		},
+		{
+			"scanning data section",
+			&WriterOptions{SkipRepeatedSchemas: true, SkipRepeatedChannelInfos: true},
+			false,
+			[]string{"/foo", "/bar"},
+		},
+		{
+			"scanning data section until first message",
+			&WriterOptions{SkipRepeatedSchemas: true, SkipRepeatedChannelInfos: true},
+			true,
+			[]string{"/bar"},
+		},
+		{
+			"scanning chunks until first message",
+			&WriterOptions{Chunked: true, ChunkSize: 1024, SkipRepeatedSchemas: true, SkipRepeatedChannelInfos: true},
+			true,
+			[]string{"/bar"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			reader, err := NewReader(bytes.NewReader(writeFile(t, c.writerOpts)))
+			assert.Nil(t, err)
+			defer reader.Close()
+			opts := &DefinitionsOptions{ChannelsOnly: c.channelsOnly}
+			channels, err := reader.Channels(opts)
+			assert.Nil(t, err)
+			assert.Equal(t, c.topics, topics(channels))
+			schemas, err := reader.Schemas(opts)
+			assert.Nil(t, err)
+			assert.Equal(t, 2, len(schemas))
+			assert.Equal(t, "a", schemas[0].Name)
+
+			// the reader position is unaffected
+			it, err := reader.Messages(readopts.UsingIndex(false))
+			assert.Nil(t, err)
+			count := 0
+			assert.Nil(t, Range(it, func(*Schema, *Channel, *Message) error {
+				count++
+				return nil
+			}))
+			assert.Equal(t, 2, count)
+		})
+	}
+}
+
+func TestReaderStatistics(t *testing.T) {
+	for _, c := range []struct {
+		skipStatistics     bool
+		skipSummaryOffsets bool
+	}{
+		{false, false},
+		{false, true},
+		{true, false},
+		{true, true},
+	} {
+		skipStatistics := c.skipStatistics
+		t.Run(fmt.Sprintf("skip statistics %v, skip summary offsets %v", skipStatistics, c.skipSummaryOffsets), func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			writer, err := NewWriter(buf, &WriterOptions{
+				Chunked:            true,
+				ChunkSize:          1024,
+				SkipStatistics:     skipStatistics,
+				SkipSummaryOffsets: c.skipSummaryOffsets,
+			})
+			assert.Nil(t, err)
+			assert.Nil(t, writer.WriteHeader(&Header{}))
+			assert.Nil(t, writer.WriteSchema(&Schema{ID: 1}))
+			assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/foo"}))
+			assert.Nil(t, writer.WriteChannel(&Channel{ID: 2, SchemaID: 1, Topic: "/bar"}))
+			for i := 0; i < 3; i++ {
+				assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, Data: []byte("hello")}))
+			}
+			assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 2, Data: []byte("hello")}))
+			assert.Nil(t, writer.Close())
+
+			reader, err := NewReader(bytes.NewReader(buf.Bytes()))
+			assert.Nil(t, err)
+			stats, found, err := reader.Statistics()
+			assert.Nil(t, err)
+			assert.Equal(t, !skipStatistics, found)
+			if found {
+				assert.Equal(t, uint64(4), stats.MessageCount)
+				assert.Equal(t, map[uint16]uint64{1: 3, 2: 1}, stats.ChannelMessageCounts)
+			}
+		})
+	}
+}
+
+func TestReaderAttachmentsAndMetadataEntries(t *testing.T) {
+	for _, skipSummaryOffsets := range []bool{false, true} {
+		t.Run(fmt.Sprintf("skip summary offsets %v", skipSummaryOffsets), func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			writer, err := NewWriter(buf, &WriterOptions{
+				Chunked:            true,
+				ChunkSize:          1024,
+				SkipSummaryOffsets: skipSummaryOffsets,
+			})
+			assert.Nil(t, err)
+			assert.Nil(t, writer.WriteHeader(&Header{}))
+			assert.Nil(t, writer.WriteAttachment(&Attachment{
+				Name:      "calibration.yaml",
+				MediaType: "application/yaml",
+				DataSize:  5,
+				Data:      bytes.NewReader([]byte("hello")),
+			}))
+			assert.Nil(t, writer.WriteMetadata(&Metadata{
+				Name:     "run",
+				Metadata: map[string]string{"robot": "a"},
+			}))
+			assert.Nil(t, writer.Close())
+
+			reader, err := NewReader(bytes.NewReader(buf.Bytes()))
+			assert.Nil(t, err)
+			attachments, err := reader.Attachments()
+			assert.Nil(t, err)
+			assert.Equal(t, writer.AttachmentIndexes, attachments)
+			metadata, err := reader.MetadataEntries()
+			assert.Nil(t, err)
+			assert.Equal(t, writer.MetadataIndexes, metadata)
+		})
+	}
+}
+
+func TestGetAttachmentReader(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{Chunked: true})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.WriteAttachment(&Attachment{
+		LogTime:   10,
+		Name:      "video.mp4",
+		MediaType: "video/mp4",
+		DataSize:  5,
+		Data:      bytes.NewReader([]byte("hello")),
+	}))
+	assert.Nil(t, writer.WriteMetadata(&Metadata{Name: "run"}))
+	assert.Nil(t, writer.Close())
+
+	reader, err := NewReader(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	attachments, err := reader.Attachments()
+	assert.Nil(t, err)
+	assert.Len(t, attachments, 1)
+	ar, err := reader.GetAttachmentReader(attachments[0].Offset)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(10), ar.LogTime)
+	assert.Equal(t, "video.mp4", ar.Name)
+	assert.Equal(t, "video/mp4", ar.MediaType)
+	assert.Equal(t, uint64(5), ar.DataSize)
+	data, err := io.ReadAll(ar.Data())
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hello"), data)
+	computed, err := ar.ComputedCRC()
+	assert.Nil(t, err)
+	parsed, err := ar.ParsedCRC()
+	assert.Nil(t, err)
+	assert.Equal(t, parsed, computed)
+
+	metadata, err := reader.MetadataEntries()
+	assert.Nil(t, err)
+	_, err = reader.GetAttachmentReader(metadata[0].Offset)
+	assert.NotNil(t, err)
+
+	unseekable, err := NewReader(bytes.NewBuffer(buf.Bytes()))
+	assert.Nil(t, err)
+	_, err = unseekable.GetAttachmentReader(attachments[0].Offset)
+	assert.NotNil(t, err)
+}
+
+func TestEnforceMonotonicTime(t *testing.T) {
+	type msg struct {
+		channelID uint16
+		logTime   uint64
+	}
+	writeFile := func(t *testing.T, messages []msg) []byte {
+		buf := &bytes.Buffer{}
+		writer, err := NewWriter(buf, &WriterOptions{Chunked: true, ChunkSize: 1024})
+		assert.Nil(t, err)
+		assert.Nil(t, writer.WriteHeader(&Header{}))
+		assert.Nil(t, writer.WriteSchema(&Schema{ID: 1}))
+		assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/foo"}))
+		assert.Nil(t, writer.WriteChannel(&Channel{ID: 2, SchemaID: 1, Topic: "/bar"}))
+		for _, m := range messages {
+			assert.Nil(t, writer.WriteMessage(&Message{ChannelID: m.channelID, LogTime: m.logTime}))
+		}
+		assert.Nil(t, writer.Close())
+		return buf.Bytes()
+	}
+	interleaved := []msg{{1, 10}, {2, 5}, {1, 20}, {2, 6}}
+	cases := []struct {
+		assertion string
+		messages  []msg
+		opts      []readopts.ReadOpt
+		count     int
+		err       *ErrNonMonotonicTime
+	}{
+		{
+			"off",
+			interleaved,
+			[]readopts.ReadOpt{readopts.UsingIndex(false)},
+			4,
+			nil,
+		},
+		{
+			"global",
+			interleaved,
+			[]readopts.ReadOpt{
+				readopts.UsingIndex(false),
+				readopts.EnforceMonotonicTime(readopts.MonotonicTimeGlobal),
+			},
+			1,
+			&ErrNonMonotonicTime{ChannelID: 2, PreviousLogTime: 10, LogTime: 5},
+		},
+		{
+			"per channel",
+			interleaved,
+			[]readopts.ReadOpt{
+				readopts.UsingIndex(false),
+				readopts.EnforceMonotonicTime(readopts.MonotonicTimePerChannel),
+			},
+			4,
+			nil,
+		},
+		{
+			"per channel violation",
+			append(interleaved, msg{1, 15}),
+			[]readopts.ReadOpt{
+				readopts.UsingIndex(false),
+				readopts.EnforceMonotonicTime(readopts.MonotonicTimePerChannel),
+			},
+			4,
+			&ErrNonMonotonicTime{ChannelID: 1, PreviousLogTime: 20, LogTime: 15},
+		},
+		{
+			"reverse log time order",
+			interleaved,
+			[]readopts.ReadOpt{
+				readopts.InOrder(readopts.ReverseLogTimeOrder),
+				readopts.EnforceMonotonicTime(readopts.MonotonicTimeGlobal),
+			},
+			4,
+			nil,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			reader, err := NewReader(bytes.NewReader(writeFile(t, c.messages)))
+			assert.Nil(t, err)
+			it, err := reader.Messages(c.opts...)
+			assert.Nil(t, err)
+			count := 0
+			err = Range(it, func(*Schema, *Channel, *Message) error {
+				count++
+				return nil
+			})
+			assert.Equal(t, c.count, count)
+			if c.err == nil {
+				assert.Nil(t, err)
+				return
+			}
+			var nonMonotonic *ErrNonMonotonicTime
+			assert.ErrorAs(t, err, &nonMonotonic)
+			assert.Equal(t, c.err, nonMonotonic)
+		})
+	}
+	t.Run("unknown mode", func(t *testing.T) {
+		reader, err := NewReader(bytes.NewReader(writeFile(t, interleaved)))
+		assert.Nil(t, err)
+		_, err = reader.Messages(readopts.EnforceMonotonicTime(readopts.MonotonicTimeMode(3)))
+		assert.NotNil(t, err)
+	})
+}
+
+func TestSummaryOffsets(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{Chunked: true, ChunkSize: 1024})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.WriteSchema(&Schema{ID: 1}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/foo"}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 2, SchemaID: 1, Topic: "/bar"}))
+	assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, Data: []byte("hello")}))
+	assert.Nil(t, writer.Close())
+
+	reader, err := NewReader(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	offsets, err := reader.SummaryOffsets()
+	assert.Nil(t, err)
+	groups := map[OpCode]*SummaryOffset{}
+	for _, offset := range offsets {
+		groups[offset.GroupOpcode] = offset
+	}
+	for _, opcode := range []OpCode{OpSchema, OpChannel, OpChunkIndex, OpStatistics} {
+		assert.Contains(t, groups, opcode)
+	}
+	topics := []string{}
+	err = reader.ReadSummaryGroup(groups[OpChannel], func(tokenType TokenType, record []byte) error {
+		assert.Equal(t, TokenChannel, tokenType)
+		channel, err := ParseChannel(record)
+		assert.Nil(t, err)
+		topics = append(topics, channel.Topic)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/foo", "/bar"}, topics)
+}
+
+func TestSummarySectionNavigation(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{Chunked: true, ChunkSize: 1024, IncludeCRC: true})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.WriteSchema(&Schema{ID: 1}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/foo"}))
+	assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, Data: []byte("hello")}))
+	assert.Nil(t, writer.WriteAttachment(&Attachment{Name: "a", DataSize: 1, Data: bytes.NewReader([]byte("a"))}))
+	assert.Nil(t, writer.WriteAttachment(&Attachment{Name: "b", DataSize: 1, Data: bytes.NewReader([]byte("b"))}))
+	assert.Nil(t, writer.WriteMetadata(&Metadata{Name: "m"}))
+	assert.Nil(t, writer.Close())
+
+	footer, err := ReadFooter(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	reader, err := NewReader(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	assert.Nil(t, reader.ValidateSummaryCRC())
+	offsets, err := reader.SummaryOffsets()
+	assert.Nil(t, err)
+
+	// groups are written back to back from the start of the summary section
+	// up to the summary offset section.
+	opcodes := []OpCode{}
+	next := footer.SummaryStart
+	for _, offset := range offsets {
+		opcodes = append(opcodes, offset.GroupOpcode)
+		assert.Equal(t, next, offset.GroupStart)
+		next = offset.GroupStart + offset.GroupLength
+	}
+	assert.Equal(t, footer.SummaryOffsetStart, next)
+	assert.Equal(t, []OpCode{
+		OpSchema, OpChannel, OpStatistics, OpChunkIndex, OpAttachmentIndex, OpMetadataIndex,
+	}, opcodes)
+
+	counts := map[TokenType]int{}
+	for _, offset := range offsets {
+		err := reader.ReadSummaryGroup(offset, func(tokenType TokenType, record []byte) error {
+			counts[tokenType]++
+			return nil
+		})
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, map[TokenType]int{
+		TokenSchema:          1,
+		TokenChannel:         1,
+		TokenStatistics:      1,
+		TokenChunkIndex:      1,
+		TokenAttachmentIndex: 2,
+		TokenMetadataIndex:   1,
+	}, counts)
+}
+
+func TestValidateSummaryCRC(t *testing.T) {
+	writeFile := func(t *testing.T, includeCRC bool) []byte {
+		buf := &bytes.Buffer{}
+		writer, err := NewWriter(buf, &WriterOptions{
+			Chunked:    true,
+			ChunkSize:  1024,
+			IncludeCRC: includeCRC,
+		})
+		assert.Nil(t, err)
+		assert.Nil(t, writer.WriteHeader(&Header{}))
+		assert.Nil(t, writer.WriteSchema(&Schema{ID: 1}))
+		assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/foo"}))
+		assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, Data: []byte("hello")}))
+		assert.Nil(t, writer.Close())
+		return buf.Bytes()
+	}
+	t.Run("valid CRC", func(t *testing.T) {
+		reader, err := NewReader(bytes.NewReader(writeFile(t, true)))
+		assert.Nil(t, err)
+		assert.Nil(t, reader.ValidateSummaryCRC())
+	})
+	t.Run("no CRC", func(t *testing.T) {
+		reader, err := NewReader(bytes.NewReader(writeFile(t, false)))
+		assert.Nil(t, err)
+		assert.ErrorIs(t, reader.ValidateSummaryCRC(), ErrSummaryCRCNotPresent)
+	})
+	t.Run("corrupted summary", func(t *testing.T) {
+		data := writeFile(t, true)
+		footer, err := ReadFooter(bytes.NewReader(data))
+		assert.Nil(t, err)
+		data[footer.SummaryStart+10]++
+		reader, err := NewReader(bytes.NewReader(data))
+		assert.Nil(t, err)
+		assert.IsType(t, &ErrInvalidSummaryCRC{}, reader.ValidateSummaryCRC())
+	})
+	t.Run("corrupted footer", func(t *testing.T) {
+		data := writeFile(t, true)
+		data[len(data)-len(Magic)-4-1]++
+		reader, err := NewReader(bytes.NewReader(data))
+		assert.Nil(t, err)
+		assert.Error(t, reader.ValidateSummaryCRC())
+	})
+	t.Run("no summary section", func(t *testing.T) {
+		footerFields := flatten([]byte{byte(OpFooter)}, encodedUint64(8+8+4), encodedUint64(0), encodedUint64(0))
+		data := file(
+			flatten([]byte{byte(OpHeader)}, encodedUint64(8), encodedUint32(0), encodedUint32(0)),
+			footerFields,
+			encodedUint32(crc32.ChecksumIEEE(footerFields)),
+		)
+		reader, err := NewReader(bytes.NewReader(data))
+		assert.Nil(t, err)
+		assert.Nil(t, reader.ValidateSummaryCRC())
+	})
+}
+
+func TestReadHeader(t *testing.T) {
+	headerRecord := flatten(
+		[]byte{byte(OpHeader)},
+		encodedUint64(4+4+4+7),
+		prefixedString("ros1"),
+		prefixedString("library"),
+	)
+	cases := []struct {
+		assertion string
+		input     []byte
+		output    *Header
+		err       string
+	}{
+		{
+			"valid header",
+			file(headerRecord, message()),
+			&Header{Profile: "ros1", Library: "library"},
+			"",
+		},
+		{
+			"first record is not a header",
+			file(chunk(t, CompressionNone, true, message()), headerRecord),
+			nil,
+			"expected first record in MCAP to be a Header, found chunk",
+		},
+		{
+			"truncated header",
+			flatten(Magic, headerRecord[:12]),
+			nil,
+			"failed to read header: unexpected EOF",
+		},
+		{
+			"bad magic",
+			flatten([]byte("notmagic"), headerRecord),
+			nil,
+			"Invalid magic at start of file",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			r := bytes.NewReader(c.input)
+			header, err := ReadHeader(r)
+			if c.err != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), c.err)
+				}
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, c.output, header)
+			// the reader is left at the start of the next record
+			opcode, err := r.ReadByte()
+			assert.Nil(t, err)
+			assert.Equal(t, byte(OpMessage), opcode)
+		})
+	}
+}
+
+func TestReadFooter(t *testing.T) {
+	t.Run("file with summary", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		writer, err := NewWriter(buf, &WriterOptions{
+			Chunked:    true,
+			IncludeCRC: true,
+		})
+		assert.Nil(t, err)
+		assert.Nil(t, writer.WriteHeader(&Header{}))
+		assert.Nil(t, writer.WriteSchema(&Schema{ID: 1}))
+		assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/foo"}))
+		assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1}))
+		assert.Nil(t, writer.Close())
+
+		footer, err := ReadFooter(bytes.NewReader(buf.Bytes()))
+		assert.Nil(t, err)
+		assert.Positive(t, footer.SummaryStart)
+		assert.Greater(t, footer.SummaryOffsetStart, footer.SummaryStart)
+		assert.NotZero(t, footer.SummaryCRC)
+	})
+	t.Run("file without summary", func(t *testing.T) {
+		footer, err := ReadFooter(bytes.NewReader(file(header(), message(), footerRecord(0, 0, 0))))
+		assert.Nil(t, err)
+		assert.Equal(t, &Footer{}, footer)
+	})
+	t.Run("bad closing magic", func(t *testing.T) {
+		input := file(header(), footerRecord(0, 0, 0))
+		input[len(input)-1] = 0x00
+		_, err := ReadFooter(bytes.NewReader(input))
+		assert.IsType(t, &ErrBadMagic{}, err)
+	})
+	t.Run("last record is not a footer", func(t *testing.T) {
+		_, err := ReadFooter(bytes.NewReader(file(header(), footerRecord(0, 0, 0), message())))
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "expected Footer before closing magic")
+		}
+	})
+	t.Run("file too short", func(t *testing.T) {
+		_, err := ReadFooter(bytes.NewReader(Magic))
+		assert.NotNil(t, err)
+	})
+}
+
+func footerRecord(summaryStart, summaryOffsetStart uint64, summaryCRC uint32) []byte {
+	return flatten(
+		[]byte{byte(OpFooter)},
+		encodedUint64(8+8+4),
+		encodedUint64(summaryStart),
+		encodedUint64(summaryOffsetStart),
+		encodedUint32(summaryCRC),
+	)
+}
+
+func TestChannelMessages(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{
+		Chunked:     true,
+		ChunkSize:   512,
+		Compression: CompressionZSTD,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.WriteSchema(&Schema{ID: 1, Name: "schema", Encoding: "jsonschema"}))
+	for id := uint16(1); id <= 20; id++ {
+		assert.Nil(t, writer.WriteChannel(&Channel{ID: id, SchemaID: 1, Topic: fmt.Sprintf("/topic%d", id)}))
+	}
+	for i := 0; i < 400; i++ {
+		channelID := uint16(1 + i%20)
+		// channel 20 appears only in the first half of the file
+		if channelID == 20 && i >= 200 {
+			continue
+		}
+		assert.Nil(t, writer.WriteMessage(&Message{
+			ChannelID: channelID,
+			LogTime:   uint64(i),
+			Data:      []byte("data"),
+		}))
+	}
+	assert.Nil(t, writer.Close())
+
+	cases := []struct {
+		assertion string
+		channelID uint16
+		opts      []readopts.ReadOpt
+		expected  []uint64
+	}{
+		{
+			"all messages on a channel",
+			20,
+			nil,
+			[]uint64{19, 39, 59, 79, 99, 119, 139, 159, 179, 199},
+		},
+		{
+			"time range",
+			3,
+			[]readopts.ReadOpt{readopts.After(100), readopts.Before(200)},
+			[]uint64{102, 122, 142, 162, 182},
+		},
+		{
+			"reverse order",
+			20,
+			[]readopts.ReadOpt{readopts.After(150), readopts.InOrder(readopts.ReverseLogTimeOrder)},
+			[]uint64{199, 179, 159},
+		},
+		{
+			"unknown channel",
+			21,
+			nil,
+			nil,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			reader, err := NewReader(bytes.NewReader(buf.Bytes()))
+			assert.Nil(t, err)
+			it, err := reader.ChannelMessages(c.channelID, c.opts...)
+			assert.Nil(t, err)
+			var logTimes []uint64
+			for {
+				schema, channel, message, err := it.Next(nil)
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				assert.Nil(t, err)
+				assert.Equal(t, c.channelID, channel.ID)
+				assert.Equal(t, uint16(1), schema.ID)
+				assert.Equal(t, c.channelID, message.ChannelID)
+				logTimes = append(logTimes, message.LogTime)
+			}
+			assert.Equal(t, c.expected, logTimes)
+		})
+	}
+}
+
+// rangeRecordingReaderAt records the ranges read from an io.ReaderAt.
+type rangeRecordingReaderAt struct {
+	r      io.ReaderAt
+	ranges [][2]int64
+}
+
+func (r *rangeRecordingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.r.ReadAt(p, off)
+	r.ranges = append(r.ranges, [2]int64{off, off + int64(n)})
+	return n, err
+}
+
+func TestNewReaderAt(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{
+		Chunked:     true,
+		ChunkSize:   256,
+		Compression: CompressionZSTD,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, Topic: "/foo"}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 2, Topic: "/bar"}))
+	// channel 2 appears only in the first chunk
+	assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 2, LogTime: 0, Data: make([]byte, 20)}))
+	for i := 1; i < 50; i++ {
+		assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, LogTime: uint64(i), Data: make([]byte, 20)}))
+	}
+	assert.Nil(t, writer.Close())
+	data := buf.Bytes()
+
+	reader, err := NewReader(bytes.NewReader(data))
+	assert.Nil(t, err)
+	info, err := reader.Info()
+	assert.Nil(t, err)
+	assert.Greater(t, len(info.ChunkIndexes), 2)
+
+	readerAt := &rangeRecordingReaderAt{r: bytes.NewReader(data)}
+	reader, err = NewReaderAt(readerAt, int64(len(data)))
+	assert.Nil(t, err)
+	it, err := reader.ChannelMessages(2)
+	assert.Nil(t, err)
+	_, _, message, err := it.Next(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), message.LogTime)
+	_, _, _, err = it.Next(nil)
+	assert.ErrorIs(t, err, io.EOF)
+
+	// no chunk but the first was fetched
+	for _, chunkIndex := range info.ChunkIndexes[1:] {
+		start := int64(chunkIndex.ChunkStartOffset)
+		end := start + int64(chunkIndex.ChunkLength)
+		for _, r := range readerAt.ranges {
+			assert.False(t, r[0] < end && r[1] > start, "read %v overlaps chunk at %d", r, start)
+		}
+	}
+}
+
+func TestReaderOnPipe(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{Chunked: true, ChunkSize: 64, IncludeCRC: true})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{Profile: "test"}))
+	assert.Nil(t, writer.WriteSchema(&Schema{ID: 1}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/foo"}))
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, LogTime: uint64(i), Data: []byte("hello")}))
+	}
+	assert.Nil(t, writer.Close())
+
+	t.Run("messages are read forward", func(t *testing.T) {
+		reader, err := NewReader(pipe(t, buf.Bytes()))
+		assert.Nil(t, err)
+		assert.Equal(t, "test", reader.Header().Profile)
+		it, err := reader.Messages(readopts.UsingIndex(false))
+		assert.Nil(t, err)
+		var logTimes []uint64
+		err = Range(it, func(_ *Schema, channel *Channel, message *Message) error {
+			assert.Equal(t, "/foo", channel.Topic)
+			logTimes = append(logTimes, message.LogTime)
+			return nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, []uint64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, logTimes)
+	})
+	t.Run("summary access is an error", func(t *testing.T) {
+		reader, err := NewReader(pipe(t, buf.Bytes()))
+		assert.Nil(t, err)
+		_, err = reader.Messages()
+		assert.Error(t, err)
+		_, err = reader.Info()
+		assert.Error(t, err)
+		_, _, err = reader.Statistics()
+		assert.Error(t, err)
+		_, err = reader.Attachments()
+		assert.Error(t, err)
+	})
+}
+
+func TestSkipSchemaData(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{Chunked: true, ChunkSize: 1024})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.WriteSchema(&Schema{ID: 1, Name: "schema", Encoding: "protobuf", Data: []byte("descriptor")}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/foo"}))
+	assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, LogTime: 1}))
+	assert.Nil(t, writer.Close())
+
+	for _, useIndex := range []bool{true, false} {
+		for _, skip := range []bool{true, false} {
+			t.Run(fmt.Sprintf("use index %v, skip %v", useIndex, skip), func(t *testing.T) {
+				reader, err := NewReader(bytes.NewReader(buf.Bytes()))
+				assert.Nil(t, err)
+				it, err := reader.Messages(readopts.UsingIndex(useIndex), readopts.SkipSchemaData(skip))
+				assert.Nil(t, err)
+				schema, _, message, err := it.Next(nil)
+				assert.Nil(t, err)
+				assert.Equal(t, uint64(1), message.LogTime)
+				assert.Equal(t, "schema", schema.Name)
+				assert.Equal(t, "protobuf", schema.Encoding)
+				if skip {
+					assert.Nil(t, schema.Data)
+				} else {
+					assert.Equal(t, []byte("descriptor"), schema.Data)
+				}
+			})
+		}
+	}
+}
+
+func TestReadDefinitions(t *testing.T) {
+	corruptChunk := chunk(t, CompressionZSTD, true, channelRecord(3, 0), messageRecord(3, 0))
+	corruptChunk[1+8+8+8+8+4+4+4+8] = 0xff // first byte of the zstd frame magic
+	cases := []struct {
+		assertion  string
+		input      []byte
+		schemaIDs  []uint16
+		channelIDs []uint16
+	}{
+		{
+			"definitions in the data section",
+			file(header(), schemaRecord(2, "{}"), schemaRecord(1, "{}"), channelRecord(1, 1), messageRecord(1, 0)),
+			[]uint16{1, 2},
+			[]uint16{1},
+		},
+		{
+			"definitions inside the first chunk",
+			file(
+				header(),
+				chunk(t, CompressionZSTD, true, schemaRecord(1, "{}"), channelRecord(1, 1), channelRecord(2, 1), messageRecord(1, 0)),
+				corruptChunk,
+			),
+			[]uint16{1},
+			[]uint16{1, 2},
+		},
+		{
+			"definitions after the first message are not read",
+			file(
+				header(),
+				chunk(t, CompressionNone, true, channelRecord(1, 0), messageRecord(1, 0), channelRecord(2, 0)),
+				corruptChunk,
+			),
+			nil,
+			[]uint16{1},
+		},
+		{
+			"file without messages",
+			file(header(), channelRecord(1, 0), dataEnd(), footer()),
+			nil,
+			[]uint16{1},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			schemas, channels, err := ReadDefinitions(bytes.NewReader(c.input))
+			assert.Nil(t, err)
+			var schemaIDs, channelIDs []uint16
+			for _, schema := range schemas {
+				schemaIDs = append(schemaIDs, schema.ID)
+			}
+			for _, channel := range channels {
+				channelIDs = append(channelIDs, channel.ID)
+			}
+			assert.Equal(t, c.schemaIDs, schemaIDs)
+			assert.Equal(t, c.channelIDs, channelIDs)
+		})
+	}
+}
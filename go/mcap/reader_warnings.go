@@ -0,0 +1,104 @@
+package mcap
+
+import "fmt"
+
+// WarningCode identifies the kind of a Warning.
+type WarningCode int
+
+const (
+	// WarningSchemaProfileMismatch indicates a schema does not conform to the
+	// profile named in the file's header.
+	WarningSchemaProfileMismatch WarningCode = iota
+	// WarningChannelProfileMismatch indicates a channel does not conform to
+	// the profile named in the file's header.
+	WarningChannelProfileMismatch
+)
+
+// String converts a warning code to its string representation.
+func (c WarningCode) String() string {
+	switch c {
+	case WarningSchemaProfileMismatch:
+		return "schema profile mismatch"
+	case WarningChannelProfileMismatch:
+		return "channel profile mismatch"
+	default:
+		return "unknown"
+	}
+}
+
+// Warning is an advisory problem noticed by a Reader while reading messages,
+// which did not prevent reading.
+type Warning struct {
+	Code    WarningCode
+	Message string
+}
+
+// String formats the warning for display.
+func (w Warning) String() string {
+	return fmt.Sprintf("%s: %s", w.Code, w.Message)
+}
+
+// Warnings returns the warnings collected by the reader's message iterators,
+// in the order they were found. Warnings are only collected for checks
+// requested in the read options, such as readopts.CheckProfile.
+func (r *Reader) Warnings() []Warning {
+	return r.warnings
+}
+
+func (r *Reader) warnf(code WarningCode, format string, args ...any) {
+	r.warnings = append(r.warnings, Warning{Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+// profileCheckingIterator wraps a message iterator, checking the schema and
+// channel of each message returned against the profile of the file, once per
+// schema and channel ID.
+type profileCheckingIterator struct {
+	it        MessageIterator
+	reader    *Reader
+	profile   string
+	validator ProfileValidator
+	schemas   map[uint16]bool
+	channels  map[uint16]bool
+}
+
+func (r *Reader) newProfileCheckingIterator(it MessageIterator) MessageIterator {
+	profile := r.header.Profile
+	validator := lookupProfileValidator(profile)
+	if validator == nil {
+		return it
+	}
+	return &profileCheckingIterator{
+		it:        it,
+		reader:    r,
+		profile:   profile,
+		validator: validator,
+		schemas:   make(map[uint16]bool),
+		channels:  make(map[uint16]bool),
+	}
+}
+
+func (it *profileCheckingIterator) Next(p []byte) (*Schema, *Channel, *Message, error) {
+	schema, channel, message, err := it.it.Next(p)
+	if err != nil {
+		return schema, channel, message, err
+	}
+	if schema != nil && !it.schemas[schema.ID] {
+		it.schemas[schema.ID] = true
+		if err := it.validator.ValidateSchema(schema); err != nil {
+			it.reader.warnf(
+				WarningSchemaProfileMismatch,
+				"schema %d does not conform to %s profile: %s", schema.ID, it.profile, err,
+			)
+		}
+	}
+	if !it.channels[channel.ID] {
+		it.channels[channel.ID] = true
+		if err := it.validator.ValidateChannel(channel, schema); err != nil {
+			it.reader.warnf(
+				WarningChannelProfileMismatch,
+				"channel %d (%s) does not conform to %s profile: %s", channel.ID, channel.Topic, it.profile, err,
+			)
+		}
+	}
+	return schema, channel, message, nil
+}
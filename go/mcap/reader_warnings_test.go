@@ -0,0 +1,81 @@
+package mcap
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/foxglove/mcap/go/mcap/readopts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReaderProfileWarnings(t *testing.T) {
+	writeFile := func(profile string) []byte {
+		buf := &bytes.Buffer{}
+		writer, err := NewWriter(buf, &WriterOptions{Chunked: true, ChunkSize: 128})
+		assert.Nil(t, err)
+		assert.Nil(t, writer.WriteHeader(&Header{Profile: profile}))
+		assert.Nil(t, writer.WriteSchema(&Schema{ID: 1, Name: "ros", Encoding: "ros2msg"}))
+		assert.Nil(t, writer.WriteSchema(&Schema{ID: 2, Name: "json", Encoding: "jsonschema"}))
+		assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/ros", MessageEncoding: "cdr"}))
+		assert.Nil(t, writer.WriteChannel(&Channel{ID: 2, SchemaID: 2, Topic: "/json", MessageEncoding: "json"}))
+		for i := 0; i < 20; i++ {
+			assert.Nil(t, writer.WriteMessage(&Message{ChannelID: uint16(1 + i%2), LogTime: uint64(i)}))
+		}
+		assert.Nil(t, writer.Close())
+		return buf.Bytes()
+	}
+	cases := []struct {
+		assertion string
+		profile   string
+		opts      []readopts.ReadOpt
+		expected  []WarningCode
+	}{
+		{
+			"mismatched encodings",
+			"ros2",
+			[]readopts.ReadOpt{readopts.CheckProfile(true)},
+			[]WarningCode{WarningSchemaProfileMismatch, WarningChannelProfileMismatch},
+		},
+		{
+			"check not requested",
+			"ros2",
+			nil,
+			nil,
+		},
+		{
+			"profile without validator",
+			"custom",
+			[]readopts.ReadOpt{readopts.CheckProfile(true)},
+			nil,
+		},
+		{
+			"topic filter excludes mismatched channel",
+			"ros2",
+			[]readopts.ReadOpt{readopts.CheckProfile(true), readopts.WithTopics([]string{"/ros"})},
+			nil,
+		},
+	}
+	for _, c := range cases {
+		input := writeFile(c.profile)
+		for _, indexed := range []bool{true, false} {
+			t.Run(fmt.Sprintf("%s indexed %v", c.assertion, indexed), func(t *testing.T) {
+				reader, err := NewReader(bytes.NewReader(input))
+				assert.Nil(t, err)
+				it, err := reader.Messages(append([]readopts.ReadOpt{readopts.UsingIndex(indexed)}, c.opts...)...)
+				assert.Nil(t, err)
+				count := 0
+				assert.Nil(t, Range(it, func(*Schema, *Channel, *Message) error {
+					count++
+					return nil
+				}))
+				assert.Greater(t, count, 0)
+				var codes []WarningCode
+				for _, warning := range reader.Warnings() {
+					codes = append(codes, warning.Code)
+				}
+				assert.Equal(t, c.expected, codes)
+			})
+		}
+	}
+}
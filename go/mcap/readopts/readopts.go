@@ -13,12 +13,31 @@ const (
 	ReverseLogTimeOrder ReadOrder = 2
 )
 
+// MonotonicTimeMode selects how message log times are checked for
+// monotonicity while reading.
+type MonotonicTimeMode int
+
+const (
+	// MonotonicTimeOff disables checking of message log times.
+	MonotonicTimeOff MonotonicTimeMode = 0
+	// MonotonicTimeGlobal requires log times to be non-decreasing across all
+	// messages read.
+	MonotonicTimeGlobal MonotonicTimeMode = 1
+	// MonotonicTimePerChannel requires log times to be non-decreasing within
+	// each channel.
+	MonotonicTimePerChannel MonotonicTimeMode = 2
+)
+
 type ReadOptions struct {
-	Start    int64
-	End      int64
-	Topics   []string
-	UseIndex bool
-	Order    ReadOrder
+	Start                int64
+	End                  int64
+	Topics               []string
+	UseIndex             bool
+	Order                ReadOrder
+	EnforceMonotonicTime MonotonicTimeMode
+	SkipSchemaData       bool
+	CheckProfile         bool
+	DecompressionWorkers int
 }
 
 func Default() ReadOptions {
@@ -79,3 +98,57 @@ func UsingIndex(useIndex bool) ReadOpt {
 		return nil
 	}
 }
+
+// EnforceMonotonicTime causes the message iterator to return an error on
+// encountering a message whose log time is earlier than that of the previous
+// message, either across all messages or within its channel depending on the
+// mode. When reading in reverse log time order, log times are instead required
+// to be non-increasing.
+func EnforceMonotonicTime(mode MonotonicTimeMode) ReadOpt {
+	return func(ro *ReadOptions) error {
+		if mode < MonotonicTimeOff || mode > MonotonicTimePerChannel {
+			return fmt.Errorf("unknown monotonic time mode %d", mode)
+		}
+		ro.EnforceMonotonicTime = mode
+		return nil
+	}
+}
+
+// SkipSchemaData causes schemas returned by the message iterator to be read
+// without their Data, which is left nil. The schema ID, name and encoding are
+// still available. For files with large schemas, such as protobuf
+// FileDescriptorSets, this avoids copying and retaining the schema data when
+// only message metadata such as timing is needed.
+func SkipSchemaData(skip bool) ReadOpt {
+	return func(ro *ReadOptions) error {
+		ro.SkipSchemaData = skip
+		return nil
+	}
+}
+
+// CheckProfile causes the message iterator to check the schemas and channels
+// of the messages it returns against the profile named in the file's header,
+// using the profile validator registered for it. Nonconforming schemas and
+// channels do not stop iteration; they are reported as warnings by the
+// reader's Warnings method.
+func CheckProfile(check bool) ReadOpt {
+	return func(ro *ReadOptions) error {
+		ro.CheckProfile = check
+		return nil
+	}
+}
+
+// DecompressionWorkers causes indexed reads to read up to n chunks ahead and
+// decompress them concurrently, for files where decompression rather than
+// reading is the bottleneck. Messages are returned in the same order as
+// without it. Values of zero or one decompress each chunk as it is reached,
+// which is the default. Non-indexed reads ignore it.
+func DecompressionWorkers(n int) ReadOpt {
+	return func(ro *ReadOptions) error {
+		if n < 0 {
+			return fmt.Errorf("decompression workers cannot be negative")
+		}
+		ro.DecompressionWorkers = n
+		return nil
+	}
+}
@@ -0,0 +1,198 @@
+package mcap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ErrNoChunkIndexes indicates a file has no chunk index records in its summary
+// section, as required by Split.
+var ErrNoChunkIndexes = errors.New("file has no chunk indexes")
+
+// Split divides a chunked, indexed MCAP file into len(outputs) standalone
+// files. Chunks are ordered by start time and divided into consecutive runs
+// with roughly equal numbers of messages, so that each output covers a
+// contiguous time range. Messages are assigned to outputs a whole chunk at a
+// time, so the ranges of adjacent outputs overlap where the input's chunks do.
+//
+// Every output receives the input's header and all of its schemas and
+// channels, followed by the messages of its chunks, and is closed with a
+// summary section according to opts. If opts is nil, outputs are chunked and
+// compressed with zstd. An output is still written if it is assigned no
+// chunks. Attachments and metadata are not copied. Message counts are taken
+// from the message indexes; if any chunk lacks message indexes, chunks are
+// balanced by uncompressed size instead.
+func Split(rs io.ReadSeeker, outputs []io.Writer, opts *WriterOptions) error {
+	if len(outputs) == 0 {
+		return fmt.Errorf("split requires at least one output")
+	}
+	if opts == nil {
+		opts = &WriterOptions{Chunked: true, Compression: CompressionZSTD}
+	}
+	reader, err := NewReader(rs)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	info, err := reader.Info()
+	if err != nil {
+		return fmt.Errorf("failed to read summary: %w", err)
+	}
+	if len(info.ChunkIndexes) == 0 {
+		return ErrNoChunkIndexes
+	}
+	chunkIndexes := make([]*ChunkIndex, len(info.ChunkIndexes))
+	copy(chunkIndexes, info.ChunkIndexes)
+	sort.SliceStable(chunkIndexes, func(i, j int) bool {
+		return chunkIndexes[i].MessageStartTime < chunkIndexes[j].MessageStartTime
+	})
+	weights, err := chunkWeights(rs, chunkIndexes)
+	if err != nil {
+		return err
+	}
+	var total uint64
+	for _, weight := range weights {
+		total += weight
+	}
+	groups := make([][]*ChunkIndex, len(outputs))
+	var cumulative uint64
+	for i, chunkIndex := range chunkIndexes {
+		var group int
+		if total > 0 {
+			group = int(cumulative * uint64(len(outputs)) / total)
+		} else {
+			group = i * len(outputs) / len(chunkIndexes)
+		}
+		// chunks without messages following the last with any have the full
+		// cumulative weight, and go in the last output
+		if group >= len(outputs) {
+			group = len(outputs) - 1
+		}
+		groups[group] = append(groups[group], chunkIndex)
+		cumulative += weights[i]
+	}
+
+	schemas := make([]*Schema, 0, len(info.Schemas))
+	for _, schema := range info.Schemas {
+		schemas = append(schemas, schema)
+	}
+	sort.Slice(schemas, func(i, j int) bool { return schemas[i].ID < schemas[j].ID })
+	channels := make([]*Channel, 0, len(info.Channels))
+	for _, channel := range info.Channels {
+		channels = append(channels, channel)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].ID < channels[j].ID })
+
+	for i, output := range outputs {
+		writerOpts := *opts
+		writer, err := NewWriter(output, &writerOpts)
+		if err != nil {
+			return err
+		}
+		if err := writer.WriteHeader(info.Header); err != nil {
+			return err
+		}
+		for _, schema := range schemas {
+			if err := writer.WriteSchema(schema); err != nil {
+				return err
+			}
+		}
+		for _, channel := range channels {
+			if err := writer.WriteChannel(channel); err != nil {
+				return err
+			}
+		}
+		for _, chunkIndex := range groups[i] {
+			if err := copyChunkMessages(rs, chunkIndex, writer); err != nil {
+				return err
+			}
+		}
+		if err := writer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunkWeights returns the number of messages in each chunk according to its
+// message indexes, or the chunks' uncompressed sizes if any chunk has no
+// message indexes.
+func chunkWeights(rs io.ReadSeeker, chunkIndexes []*ChunkIndex) ([]uint64, error) {
+	weights := make([]uint64, len(chunkIndexes))
+	for i, chunkIndex := range chunkIndexes {
+		if chunkIndex.MessageIndexLength == 0 {
+			for j, chunkIndex := range chunkIndexes {
+				weights[j] = chunkIndex.UncompressedSize
+			}
+			return weights, nil
+		}
+		offset := int64(chunkIndex.ChunkStartOffset + chunkIndex.ChunkLength)
+		if _, err := rs.Seek(offset, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to seek to message indexes: %w", err)
+		}
+		lexer, err := NewLexer(io.LimitReader(rs, int64(chunkIndex.MessageIndexLength)), &LexerOptions{
+			SkipMagic: true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for {
+			tokenType, record, err := lexer.Next(nil)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				lexer.Close()
+				return nil, fmt.Errorf("failed to read message indexes: %w", err)
+			}
+			if tokenType != TokenMessageIndex {
+				continue
+			}
+			messageIndex, err := ParseMessageIndex(record)
+			if err != nil {
+				lexer.Close()
+				return nil, fmt.Errorf("failed to parse message index: %w", err)
+			}
+			weights[i] += uint64(len(messageIndex.Records))
+		}
+		lexer.Close()
+	}
+	return weights, nil
+}
+
+// copyChunkMessages writes the messages of the chunk at chunkIndex to writer.
+func copyChunkMessages(rs io.ReadSeeker, chunkIndex *ChunkIndex, writer *Writer) error {
+	if _, err := rs.Seek(int64(chunkIndex.ChunkStartOffset), io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek to chunk: %w", err)
+	}
+	lexer, err := NewLexerAt(io.LimitReader(rs, int64(chunkIndex.ChunkLength)), true)
+	if err != nil {
+		return err
+	}
+	defer lexer.Close()
+	var buf []byte
+	for {
+		tokenType, record, err := lexer.Next(buf)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read chunk at offset %d: %w", chunkIndex.ChunkStartOffset, err)
+		}
+		if len(record) > len(buf) {
+			buf = record
+		}
+		if tokenType != TokenMessage {
+			continue
+		}
+		message, err := ParseMessage(record)
+		if err != nil {
+			return fmt.Errorf("failed to parse message: %w", err)
+		}
+		if err := writer.WriteMessage(message); err != nil {
+			return err
+		}
+	}
+}
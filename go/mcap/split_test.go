@@ -0,0 +1,156 @@
+package mcap
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplit(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{
+		Chunked:     true,
+		ChunkSize:   1024,
+		Compression: CompressionZSTD,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{Profile: "ros1"}))
+	assert.Nil(t, writer.WriteSchema(&Schema{ID: 1, Name: "schema", Encoding: "ros1msg"}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/foo", MessageEncoding: "ros1"}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 2, SchemaID: 1, Topic: "/bar", MessageEncoding: "ros1"}))
+	for i := 0; i < 300; i++ {
+		assert.Nil(t, writer.WriteMessage(&Message{
+			ChannelID: uint16(1 + i%2),
+			LogTime:   uint64(i),
+			Data:      make([]byte, 50),
+		}))
+	}
+	assert.Nil(t, writer.Close())
+
+	t.Run("outputs are balanced and consecutive", func(t *testing.T) {
+		outputs := []*bytes.Buffer{{}, {}, {}}
+		assert.Nil(t, Split(bytes.NewReader(buf.Bytes()), []io.Writer{outputs[0], outputs[1], outputs[2]}, &WriterOptions{
+			Chunked:     true,
+			Compression: CompressionLZ4,
+		}))
+		var total int
+		var lastLogTime uint64
+		for i, output := range outputs {
+			reader, err := NewReader(bytes.NewReader(output.Bytes()))
+			assert.Nil(t, err)
+			info, err := reader.Info()
+			assert.Nil(t, err)
+			assert.Equal(t, "ros1", info.Header.Profile)
+			assert.Equal(t, 1, len(info.Schemas))
+			assert.Equal(t, 2, len(info.Channels))
+			count := int(info.Statistics.MessageCount)
+			assert.InDelta(t, 100, count, 30, "output %d", i)
+			if i > 0 {
+				assert.Greater(t, info.Statistics.MessageStartTime, lastLogTime)
+			}
+			lastLogTime = info.Statistics.MessageEndTime
+			total += count
+		}
+		assert.Equal(t, 300, total)
+	})
+	t.Run("more outputs than chunks", func(t *testing.T) {
+		outputs := make([]io.Writer, 100)
+		buffers := make([]*bytes.Buffer, 100)
+		for i := range outputs {
+			buffers[i] = &bytes.Buffer{}
+			outputs[i] = buffers[i]
+		}
+		assert.Nil(t, Split(bytes.NewReader(buf.Bytes()), outputs, &WriterOptions{Chunked: true}))
+		var total uint64
+		for _, output := range buffers {
+			reader, err := NewReader(bytes.NewReader(output.Bytes()))
+			assert.Nil(t, err)
+			info, err := reader.Info()
+			assert.Nil(t, err)
+			assert.Equal(t, 2, len(info.Channels))
+			total += info.Statistics.MessageCount
+		}
+		assert.Equal(t, uint64(300), total)
+	})
+	t.Run("nil options", func(t *testing.T) {
+		output := &bytes.Buffer{}
+		assert.Nil(t, Split(bytes.NewReader(buf.Bytes()), []io.Writer{output}, nil))
+		reader, err := NewReader(bytes.NewReader(output.Bytes()))
+		assert.Nil(t, err)
+		info, err := reader.Info()
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(300), info.Statistics.MessageCount)
+		assert.NotEmpty(t, info.ChunkIndexes)
+		assert.Equal(t, CompressionZSTD, info.ChunkIndexes[0].Compression)
+	})
+	t.Run("trailing chunk without messages", func(t *testing.T) {
+		input := &bytes.Buffer{}
+		writer, err := NewWriter(input, &WriterOptions{})
+		assert.Nil(t, err)
+		assert.Nil(t, writer.WriteHeader(&Header{}))
+		assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, Topic: "/foo"}))
+		// the last chunk in start time order has an empty message index, and
+		// so no weight
+		writeIndexedChunk(t, writer, 1, 10)
+		writeIndexedChunk(t, writer, 1, 0)
+		assert.Nil(t, writer.Close())
+		outputs := []*bytes.Buffer{{}, {}}
+		assert.Nil(t, Split(bytes.NewReader(input.Bytes()), []io.Writer{outputs[0], outputs[1]}, nil))
+		var total uint64
+		for _, output := range outputs {
+			reader, err := NewReader(bytes.NewReader(output.Bytes()))
+			assert.Nil(t, err)
+			info, err := reader.Info()
+			assert.Nil(t, err)
+			total += info.Statistics.MessageCount
+		}
+		assert.Equal(t, uint64(10), total)
+	})
+	t.Run("unindexed input", func(t *testing.T) {
+		unchunked := &bytes.Buffer{}
+		writer, err := NewWriter(unchunked, &WriterOptions{})
+		assert.Nil(t, err)
+		assert.Nil(t, writer.WriteHeader(&Header{}))
+		assert.Nil(t, writer.Close())
+		err = Split(bytes.NewReader(unchunked.Bytes()), []io.Writer{&bytes.Buffer{}}, &WriterOptions{})
+		assert.True(t, errors.Is(err, ErrNoChunkIndexes), err)
+	})
+}
+
+// writeIndexedChunk writes an uncompressed chunk of count messages on a
+// channel, all logged at time zero, followed by the channel's message index,
+// which is written even if empty.
+func writeIndexedChunk(t *testing.T, writer *Writer, channelID uint16, count int) {
+	var records [][]byte
+	idx := &MessageIndex{ChannelID: channelID}
+	var offset uint64
+	for i := 0; i < count; i++ {
+		content := flatten(
+			encodedUint16(channelID),
+			encodedUint32(uint32(i)),
+			encodedUint64(0),
+			encodedUint64(0),
+		)
+		record := flatten([]byte{byte(OpMessage)}, encodedUint64(uint64(len(content))), content)
+		idx.Add(0, offset)
+		offset += uint64(len(record))
+		records = append(records, record)
+	}
+	chunkRecord := chunk(t, CompressionNone, true, records...)
+	chunkStart := writer.Offset()
+	assert.Nil(t, writer.WriteRaw(OpChunk, chunkRecord[9:]))
+	chunkEnd := writer.Offset()
+	assert.Nil(t, writer.WriteMessageIndex(idx))
+	writer.ChunkIndexes = append(writer.ChunkIndexes, &ChunkIndex{
+		ChunkStartOffset:    chunkStart,
+		ChunkLength:         chunkEnd - chunkStart,
+		MessageIndexOffsets: map[uint16]uint64{channelID: chunkEnd},
+		MessageIndexLength:  writer.Offset() - chunkEnd,
+		Compression:         CompressionNone,
+		CompressedSize:      offset,
+		UncompressedSize:    offset,
+	})
+}
@@ -0,0 +1,109 @@
+package mcap
+
+// StatisticsBuilder accumulates the counts and message time range of a
+// Statistics record from the records of a file as they are observed. Schemas
+// and channels are counted once per ID, so a builder may observe the same
+// definition several times, as happens when definitions are repeated across
+// chunks.
+type StatisticsBuilder struct {
+	stats    *Statistics
+	schemas  map[uint16]bool
+	channels map[uint16]bool
+	timesSet bool
+}
+
+// NewStatisticsBuilder returns a StatisticsBuilder with no observations.
+func NewStatisticsBuilder() *StatisticsBuilder {
+	return &StatisticsBuilder{
+		stats: &Statistics{
+			ChannelMessageCounts: make(map[uint16]uint64),
+		},
+		schemas:  make(map[uint16]bool),
+		channels: make(map[uint16]bool),
+	}
+}
+
+// ObserveMessage records a message on channelID logged at logTime.
+func (b *StatisticsBuilder) ObserveMessage(channelID uint16, logTime uint64) {
+	b.stats.MessageCount++
+	b.stats.ChannelMessageCounts[channelID]++
+	b.observeTime(logTime, logTime)
+}
+
+// ObserveSchema records a schema with the given ID.
+func (b *StatisticsBuilder) ObserveSchema(id uint16) {
+	if b.schemas[id] {
+		return
+	}
+	b.schemas[id] = true
+	b.stats.SchemaCount++
+}
+
+// ObserveChannel records a channel with the given ID.
+func (b *StatisticsBuilder) ObserveChannel(id uint16) {
+	if b.channels[id] {
+		return
+	}
+	b.channels[id] = true
+	b.stats.ChannelCount++
+}
+
+// ObserveAttachment records an attachment.
+func (b *StatisticsBuilder) ObserveAttachment() {
+	b.stats.AttachmentCount++
+}
+
+// ObserveMetadata records a metadata record.
+func (b *StatisticsBuilder) ObserveMetadata() {
+	b.stats.MetadataCount++
+}
+
+// ObserveChunk records a chunk whose messages span start to end, which
+// extends the message time range. This allows statistics to be built from
+// chunk indexes without observing individual messages. A chunk with a start
+// and end of zero is taken to contain no messages and leaves the time range
+// unchanged.
+func (b *StatisticsBuilder) ObserveChunk(start, end uint64) {
+	b.stats.ChunkCount++
+	if start == 0 && end == 0 {
+		return
+	}
+	b.observeTime(start, end)
+}
+
+func (b *StatisticsBuilder) observeTime(start, end uint64) {
+	if !b.timesSet || start < b.stats.MessageStartTime {
+		b.stats.MessageStartTime = start
+	}
+	if !b.timesSet || end > b.stats.MessageEndTime {
+		b.stats.MessageEndTime = end
+	}
+	b.timesSet = true
+}
+
+// Build returns the statistics accumulated so far. The result is a copy and
+// is not affected by later observations.
+func (b *StatisticsBuilder) Build() *Statistics {
+	stats := *b.stats
+	stats.ChannelMessageCounts = make(map[uint16]uint64, len(b.stats.ChannelMessageCounts))
+	for id, count := range b.stats.ChannelMessageCounts {
+		stats.ChannelMessageCounts[id] = count
+	}
+	return &stats
+}
+
+// resume replaces the accumulated statistics with those of stats, keeping the
+// schema and channel IDs already observed, so that observation can continue
+// where an earlier writer left off.
+func (b *StatisticsBuilder) resume(stats *Statistics) {
+	counts := b.stats.ChannelMessageCounts
+	for id := range counts {
+		delete(counts, id)
+	}
+	for id, count := range stats.ChannelMessageCounts {
+		counts[id] = count
+	}
+	*b.stats = *stats
+	b.stats.ChannelMessageCounts = counts
+	b.timesSet = stats.MessageCount > 0
+}
@@ -0,0 +1,134 @@
+package mcap
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatisticsBuilder(t *testing.T) {
+	cases := []struct {
+		assertion string
+		observe   func(b *StatisticsBuilder)
+		expected  *Statistics
+	}{
+		{
+			"empty",
+			func(b *StatisticsBuilder) {},
+			&Statistics{ChannelMessageCounts: map[uint16]uint64{}},
+		},
+		{
+			"messages",
+			func(b *StatisticsBuilder) {
+				b.ObserveMessage(1, 20)
+				b.ObserveMessage(2, 5)
+				b.ObserveMessage(1, 10)
+			},
+			&Statistics{
+				MessageCount:         3,
+				MessageStartTime:     5,
+				MessageEndTime:       20,
+				ChannelMessageCounts: map[uint16]uint64{1: 2, 2: 1},
+			},
+		},
+		{
+			"message at time zero",
+			func(b *StatisticsBuilder) {
+				b.ObserveMessage(1, 10)
+				b.ObserveMessage(1, 0)
+			},
+			&Statistics{
+				MessageCount:         2,
+				MessageStartTime:     0,
+				MessageEndTime:       10,
+				ChannelMessageCounts: map[uint16]uint64{1: 2},
+			},
+		},
+		{
+			"repeated definitions",
+			func(b *StatisticsBuilder) {
+				b.ObserveSchema(1)
+				b.ObserveSchema(1)
+				b.ObserveSchema(2)
+				b.ObserveChannel(1)
+				b.ObserveChannel(1)
+			},
+			&Statistics{
+				SchemaCount:          2,
+				ChannelCount:         1,
+				ChannelMessageCounts: map[uint16]uint64{},
+			},
+		},
+		{
+			"chunks, attachments and metadata",
+			func(b *StatisticsBuilder) {
+				b.ObserveChunk(100, 200)
+				b.ObserveChunk(0, 0)
+				b.ObserveChunk(50, 150)
+				b.ObserveAttachment()
+				b.ObserveMetadata()
+				b.ObserveMetadata()
+			},
+			&Statistics{
+				ChunkCount:           3,
+				AttachmentCount:      1,
+				MetadataCount:        2,
+				MessageStartTime:     50,
+				MessageEndTime:       200,
+				ChannelMessageCounts: map[uint16]uint64{},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			b := NewStatisticsBuilder()
+			c.observe(b)
+			assert.Equal(t, c.expected, b.Build())
+		})
+	}
+}
+
+func TestStatisticsBuilderBuildCopies(t *testing.T) {
+	b := NewStatisticsBuilder()
+	b.ObserveMessage(1, 10)
+	stats := b.Build()
+	b.ObserveMessage(1, 20)
+	assert.Equal(t, uint64(1), stats.MessageCount)
+	assert.Equal(t, uint64(1), stats.ChannelMessageCounts[1])
+	assert.Equal(t, uint64(10), stats.MessageEndTime)
+}
+
+func TestWriterStatisticsMatchBuilder(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(buf, &WriterOptions{
+		Chunked:   true,
+		ChunkSize: 1,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, w.WriteHeader(&Header{}))
+	assert.Nil(t, w.WriteSchema(&Schema{ID: 1, Name: "a", Encoding: "ros1msg"}))
+	assert.Nil(t, w.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/a", MessageEncoding: "ros1"}))
+	for _, logTime := range []uint64{30, 0, 20} {
+		assert.Nil(t, w.WriteMessage(&Message{ChannelID: 1, LogTime: logTime}))
+	}
+	assert.Nil(t, w.WriteMetadata(&Metadata{Name: "m"}))
+	assert.Nil(t, w.Close())
+
+	expected := NewStatisticsBuilder()
+	expected.ObserveSchema(1)
+	expected.ObserveChannel(1)
+	for _, logTime := range []uint64{30, 0, 20} {
+		expected.ObserveMessage(1, logTime)
+	}
+	expected.ObserveMetadata()
+	stats := expected.Build()
+	stats.ChunkCount = w.Statistics.ChunkCount
+	assert.Equal(t, stats, w.Statistics)
+
+	reader, err := NewReader(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	info, err := reader.Info()
+	assert.Nil(t, err)
+	assert.Equal(t, stats, info.Statistics)
+}
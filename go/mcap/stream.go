@@ -0,0 +1,68 @@
+package mcap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/foxglove/mcap/go/mcap/readopts"
+)
+
+// MessageResult is a message delivered by Reader.Stream, or the error that
+// ended the stream.
+type MessageResult struct {
+	Schema  *Schema
+	Channel *Channel
+	Message *Message
+	Err     error
+}
+
+// Stream reads the messages of the file on a new goroutine and delivers them
+// over the returned channel, which buffers up to bufferSize messages ahead of
+// the receiver. Read options apply as for Messages.
+//
+// The channel is closed once the messages are exhausted. If reading fails,
+// the error is delivered as the final result before the channel is closed.
+// If ctx is canceled, reading stops and the channel is closed without further
+// results; receivers should check ctx to distinguish cancellation from the
+// end of the file. Each message and its data are copied before delivery, so
+// results may be retained and shared between goroutines. The reader must not
+// be used for anything else until the channel is closed.
+func (r *Reader) Stream(ctx context.Context, bufferSize int, opts ...readopts.ReadOpt) (<-chan MessageResult, error) {
+	if bufferSize < 0 {
+		return nil, fmt.Errorf("invalid stream buffer size %d", bufferSize)
+	}
+	it, err := r.Messages(opts...)
+	if err != nil {
+		return nil, err
+	}
+	results := make(chan MessageResult, bufferSize)
+	go func() {
+		defer close(results)
+		for {
+			if ctx.Err() != nil {
+				return
+			}
+			schema, channel, message, err := it.Next(nil)
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return
+				}
+				select {
+				case results <- MessageResult{Err: err}:
+				case <-ctx.Done():
+				}
+				return
+			}
+			copied := *message
+			copied.Data = append([]byte(nil), message.Data...)
+			select {
+			case results <- MessageResult{Schema: schema, Channel: channel, Message: &copied}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return results, nil
+}
@@ -0,0 +1,85 @@
+package mcap
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeStreamTestFile(t *testing.T, opts *WriterOptions, count int) []byte {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, opts)
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.WriteSchema(&Schema{ID: 1, Name: "schema", Encoding: "jsonschema"}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/topic"}))
+	for i := 0; i < count; i++ {
+		assert.Nil(t, writer.WriteMessage(&Message{
+			ChannelID: 1,
+			LogTime:   uint64(i),
+			Data:      []byte(fmt.Sprintf("message %d", i)),
+		}))
+	}
+	assert.Nil(t, writer.Close())
+	return buf.Bytes()
+}
+
+func TestStream(t *testing.T) {
+	t.Run("delivers all messages", func(t *testing.T) {
+		data := writeStreamTestFile(t, &WriterOptions{Chunked: true, ChunkSize: 256}, 100)
+		reader, err := NewReader(bytes.NewReader(data))
+		assert.Nil(t, err)
+		results, err := reader.Stream(context.Background(), 8)
+		assert.Nil(t, err)
+		var received []MessageResult
+		for result := range results {
+			assert.Nil(t, result.Err)
+			received = append(received, result)
+		}
+		assert.Len(t, received, 100)
+		for i, result := range received {
+			assert.Equal(t, uint16(1), result.Schema.ID)
+			assert.Equal(t, "/topic", result.Channel.Topic)
+			assert.Equal(t, uint64(i), result.Message.LogTime)
+			assert.Equal(t, fmt.Sprintf("message %d", i), string(result.Message.Data))
+		}
+	})
+	t.Run("cancellation closes the channel", func(t *testing.T) {
+		data := writeStreamTestFile(t, &WriterOptions{Chunked: true, ChunkSize: 256}, 100)
+		reader, err := NewReader(bytes.NewReader(data))
+		assert.Nil(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		results, err := reader.Stream(ctx, 0)
+		assert.Nil(t, err)
+		first := <-results
+		assert.Nil(t, first.Err)
+		cancel()
+		count := 0
+		for range results {
+			count++
+		}
+		assert.Less(t, count, 99)
+	})
+	t.Run("read error is delivered", func(t *testing.T) {
+		data := writeStreamTestFile(t, &WriterOptions{SkipMessageIndexing: true, SkipSummaryOffsets: true}, 10)
+		reader, err := NewReader(bytes.NewReader(data[:len(data)/2]))
+		assert.Nil(t, err)
+		results, err := reader.Stream(context.Background(), 1)
+		assert.Nil(t, err)
+		var last MessageResult
+		for result := range results {
+			last = result
+		}
+		assert.Error(t, last.Err)
+	})
+	t.Run("negative buffer size", func(t *testing.T) {
+		data := writeStreamTestFile(t, &WriterOptions{}, 1)
+		reader, err := NewReader(bytes.NewReader(data))
+		assert.Nil(t, err)
+		_, err = reader.Stream(context.Background(), -1)
+		assert.Error(t, err)
+	})
+}
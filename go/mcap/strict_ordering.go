@@ -0,0 +1,148 @@
+package mcap
+
+import "fmt"
+
+// FileSection identifies a part of an MCAP file, for reporting records found
+// out of place under LexerOptions.StrictOrdering.
+type FileSection int
+
+const (
+	// SectionStart is the start of the file, before the header.
+	SectionStart FileSection = iota
+	// SectionData is the data section, between the header and the data end.
+	SectionData
+	// SectionChunk is the records within a chunk.
+	SectionChunk
+	// SectionSummary is the summary section, following the data end.
+	SectionSummary
+	// SectionSummaryOffset is the summary offset section, following the
+	// summary section.
+	SectionSummaryOffset
+	// SectionEnd is the end of the file, following the footer.
+	SectionEnd
+)
+
+// String converts a file section to its string representation.
+func (s FileSection) String() string {
+	switch s {
+	case SectionStart:
+		return "start of file"
+	case SectionData:
+		return "data section"
+	case SectionChunk:
+		return "chunk"
+	case SectionSummary:
+		return "summary section"
+	case SectionSummaryOffset:
+		return "summary offset section"
+	case SectionEnd:
+		return "end of file"
+	default:
+		return "unknown section"
+	}
+}
+
+// ErrUnexpectedRecord indicates a record appeared where the specification
+// does not permit it, as detected under LexerOptions.StrictOrdering.
+type ErrUnexpectedRecord struct {
+	Opcode  OpCode
+	Section FileSection
+}
+
+func (e *ErrUnexpectedRecord) Error() string {
+	return fmt.Sprintf("unexpected %s record in %s", e.Opcode, e.Section)
+}
+
+// ErrMultipleHeaders indicates a second header was found under
+// LexerOptions.StrictOrdering, most often because two MCAP files were
+// concatenated.
+type ErrMultipleHeaders struct {
+	// Offset is where the second file appears to begin: the offset of the
+	// second header, or, if it follows the closing magic of the first file,
+	// the offset of the magic leading it.
+	Offset uint64
+}
+
+func (e *ErrMultipleHeaders) Error() string {
+	return fmt.Sprintf("second header found at offset %d", e.Offset)
+}
+
+// orderChecker tracks the section of the file the lexer is in, and checks
+// that each record is permitted there.
+type orderChecker struct {
+	section FileSection
+	// previous is the opcode of the previous top-level record.
+	previous OpCode
+}
+
+// check returns an *ErrUnexpectedRecord if a record with the given opcode may
+// not appear next, or an *ErrMultipleHeaders for a second header at the top
+// level, and otherwise advances the section. Opcodes not defined by the
+// specification, which readers skip, are permitted anywhere after the header.
+func (c *orderChecker) check(opcode OpCode, inChunk bool, offset uint64) error {
+	if inChunk {
+		switch opcode {
+		case OpSchema, OpChannel, OpMessage:
+			return nil
+		case OpChunk:
+			// reported as ErrNestedChunk
+			return nil
+		}
+		if opcode > OpDataEnd {
+			return nil
+		}
+		return &ErrUnexpectedRecord{Opcode: opcode, Section: SectionChunk}
+	}
+	if opcode == OpHeader && c.section != SectionStart {
+		return &ErrMultipleHeaders{Offset: offset}
+	}
+	ok := c.advance(opcode)
+	c.previous = opcode
+	if !ok {
+		return &ErrUnexpectedRecord{Opcode: opcode, Section: c.section}
+	}
+	return nil
+}
+
+func (c *orderChecker) advance(opcode OpCode) bool {
+	switch c.section {
+	case SectionStart:
+		if opcode == OpHeader {
+			c.section = SectionData
+			return true
+		}
+		return false
+	case SectionData:
+		switch opcode {
+		case OpSchema, OpChannel, OpMessage, OpChunk, OpAttachment, OpMetadata:
+			return true
+		case OpMessageIndex:
+			return c.previous == OpChunk || c.previous == OpMessageIndex
+		case OpDataEnd:
+			c.section = SectionSummary
+			return true
+		}
+	case SectionSummary:
+		switch opcode {
+		case OpSchema, OpChannel, OpChunkIndex, OpAttachmentIndex, OpMetadataIndex, OpStatistics:
+			return true
+		case OpSummaryOffset:
+			c.section = SectionSummaryOffset
+			return true
+		case OpFooter:
+			c.section = SectionEnd
+			return true
+		}
+	case SectionSummaryOffset:
+		switch opcode {
+		case OpSummaryOffset:
+			return true
+		case OpFooter:
+			c.section = SectionEnd
+			return true
+		}
+	case SectionEnd:
+		return false
+	}
+	return opcode > OpDataEnd
+}
@@ -0,0 +1,204 @@
+package mcap
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStrictOrdering(t *testing.T) {
+	cases := []struct {
+		assertion string
+		input     []byte
+		expected  *ErrUnexpectedRecord
+	}{
+		{
+			"minimal file",
+			file(header(), record(OpDataEnd), footer()),
+			nil,
+		},
+		{
+			"full file",
+			file(
+				header(),
+				record(OpSchema),
+				channelInfo(),
+				message(),
+				chunk(t, CompressionNone, true, record(OpSchema), channelInfo(), message()),
+				record(OpMessageIndex),
+				record(OpMessageIndex),
+				attachment(),
+				record(OpMetadata),
+				record(0x80),
+				record(OpDataEnd),
+				record(OpSchema),
+				channelInfo(),
+				record(OpStatistics),
+				record(OpChunkIndex),
+				record(OpAttachmentIndex),
+				record(OpMetadataIndex),
+				record(OpSummaryOffset),
+				record(OpSummaryOffset),
+				footer(),
+			),
+			nil,
+		},
+		{
+			"header not first",
+			file(record(OpSchema), header(), record(OpDataEnd), footer()),
+			&ErrUnexpectedRecord{OpSchema, SectionStart},
+		},
+		{
+			"message after data end",
+			file(header(), record(OpDataEnd), message(), footer()),
+			&ErrUnexpectedRecord{OpMessage, SectionSummary},
+		},
+		{
+			"chunk index in data section",
+			file(header(), record(OpChunkIndex), record(OpDataEnd), footer()),
+			&ErrUnexpectedRecord{OpChunkIndex, SectionData},
+		},
+		{
+			"message index without chunk",
+			file(header(), message(), record(OpMessageIndex), record(OpDataEnd), footer()),
+			&ErrUnexpectedRecord{OpMessageIndex, SectionData},
+		},
+		{
+			"metadata in chunk",
+			file(header(), chunk(t, CompressionNone, true, record(OpMetadata)), record(OpDataEnd), footer()),
+			&ErrUnexpectedRecord{OpMetadata, SectionChunk},
+		},
+		{
+			"schema after summary offsets",
+			file(header(), record(OpDataEnd), record(OpSummaryOffset), record(OpSchema), footer()),
+			&ErrUnexpectedRecord{OpSchema, SectionSummaryOffset},
+		},
+		{
+			"footer without data end",
+			file(header(), message(), footer()),
+			&ErrUnexpectedRecord{OpFooter, SectionData},
+		},
+		{
+			"record after footer",
+			file(header(), record(OpDataEnd), footer(), record(0x80)),
+			&ErrUnexpectedRecord{0x80, SectionEnd},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			lexer, err := NewLexer(bytes.NewReader(c.input), &LexerOptions{StrictOrdering: true})
+			assert.Nil(t, err)
+			defer lexer.Close()
+			for err == nil {
+				_, _, err = lexer.Next(nil)
+			}
+			if c.expected == nil {
+				assert.ErrorIs(t, err, io.EOF)
+				return
+			}
+			var unexpected *ErrUnexpectedRecord
+			assert.True(t, errors.As(err, &unexpected), err)
+			assert.Equal(t, c.expected, unexpected)
+		})
+	}
+}
+
+func TestStrictOrderingAcceptsWriterOutput(t *testing.T) {
+	for _, chunked := range []bool{false, true} {
+		buf := &bytes.Buffer{}
+		writer, err := NewWriter(buf, &WriterOptions{Chunked: chunked, ChunkSize: 1024, Compression: CompressionLZ4})
+		assert.Nil(t, err)
+		assert.Nil(t, writer.WriteHeader(&Header{}))
+		assert.Nil(t, writer.WriteSchema(&Schema{ID: 1}))
+		assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/foo"}))
+		for i := 0; i < 100; i++ {
+			assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, LogTime: uint64(i), Data: make([]byte, 64)}))
+		}
+		assert.Nil(t, writer.WriteAttachment(&Attachment{Name: "a", DataSize: 1, Data: bytes.NewReader([]byte("a"))}))
+		assert.Nil(t, writer.WriteMetadata(&Metadata{Name: "m"}))
+		assert.Nil(t, writer.Close())
+		for _, emitChunks := range []bool{false, true} {
+			lexer, err := NewLexer(bytes.NewReader(buf.Bytes()), &LexerOptions{
+				StrictOrdering: true,
+				EmitChunks:     emitChunks,
+			})
+			assert.Nil(t, err)
+			for err == nil {
+				_, _, err = lexer.Next(nil)
+			}
+			assert.ErrorIs(t, err, io.EOF)
+			lexer.Close()
+		}
+	}
+}
+
+func TestStrictOrderingMultipleHeaders(t *testing.T) {
+	writeFile := func(t *testing.T) []byte {
+		buf := &bytes.Buffer{}
+		writer, err := NewWriter(buf, &WriterOptions{Chunked: true, Compression: CompressionZSTD})
+		assert.Nil(t, err)
+		assert.Nil(t, writer.WriteHeader(&Header{}))
+		assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, Topic: "/foo"}))
+		assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, Data: []byte("hello")}))
+		assert.Nil(t, writer.Close())
+		return buf.Bytes()
+	}
+	first := writeFile(t)
+	cases := []struct {
+		assertion string
+		input     []byte
+		offset    uint64
+		headers   int
+	}{
+		{
+			"repeated header",
+			file(header(), message(), header(), record(OpDataEnd), footer()),
+			uint64(len(Magic) + 2*9),
+			2,
+		},
+		{
+			"header in summary section",
+			file(header(), record(OpDataEnd), header(), footer()),
+			uint64(len(Magic) + 2*9),
+			2,
+		},
+		{
+			"concatenated files",
+			flatten(first, writeFile(t)),
+			uint64(len(first)),
+			1,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			lexer, err := NewLexer(bytes.NewReader(c.input), &LexerOptions{StrictOrdering: true})
+			assert.Nil(t, err)
+			defer lexer.Close()
+			for err == nil {
+				_, _, err = lexer.Next(nil)
+			}
+			var multipleHeaders *ErrMultipleHeaders
+			assert.ErrorAs(t, err, &multipleHeaders)
+			assert.Equal(t, &ErrMultipleHeaders{Offset: c.offset}, multipleHeaders)
+		})
+		t.Run(c.assertion+" without strict ordering", func(t *testing.T) {
+			lexer, err := NewLexer(bytes.NewReader(c.input))
+			assert.Nil(t, err)
+			defer lexer.Close()
+			headers := 0
+			for {
+				tokenType, _, err := lexer.Next(nil)
+				if err != nil {
+					break
+				}
+				if tokenType == TokenHeader {
+					headers++
+				}
+			}
+			assert.Equal(t, c.headers, headers)
+		})
+	}
+}
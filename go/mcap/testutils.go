@@ -84,7 +84,7 @@ func message() []byte {
 	return buf
 }
 
-func chunk(t *testing.T, compression CompressionFormat, includeCRC bool, records ...[]byte) []byte {
+func chunk(t testing.TB, compression CompressionFormat, includeCRC bool, records ...[]byte) []byte {
 	data := flatten(records...)
 	buf := &bytes.Buffer{}
 	switch compression {
@@ -97,8 +97,10 @@ func chunk(t *testing.T, compression CompressionFormat, includeCRC bool, records
 		assert.Nil(t, err)
 		w.Close()
 	case CompressionLZ4:
+		// write directly rather than copying, since the lz4 writer only emits a
+		// frame header on the first call to Write.
 		w := lz4.NewWriter(buf)
-		_, err := io.Copy(w, bytes.NewReader(data))
+		_, err := w.Write(data)
 		assert.Nil(t, err)
 		w.Close()
 	case CompressionNone:
@@ -108,8 +110,13 @@ func chunk(t *testing.T, compression CompressionFormat, includeCRC bool, records
 		_, err := buf.Write(data) // unrecognized compression
 		assert.Nil(t, err)
 	}
+	return chunkRecord(t, compression, includeCRC, data, buf.Bytes())
+}
+
+// chunkRecord assembles a chunk record from already-compressed chunk data.
+func chunkRecord(t testing.TB, compression CompressionFormat, includeCRC bool, data []byte, compressed []byte) []byte {
 	compressionLen := len(compression)
-	compressedLen := buf.Len()
+	compressedLen := len(compressed)
 	uncompressedLen := len(data)
 	msglen := uint64(8 + 8 + 8 + 4 + 4 + compressionLen + 8 + compressedLen)
 	record := make([]byte, msglen+9)
@@ -130,8 +137,8 @@ func chunk(t *testing.T, compression CompressionFormat, includeCRC bool, records
 	}
 	offset += putUint32(record[offset:], crc)
 	offset += putPrefixedString(record[offset:], string(compression))
-	offset += putUint64(record[offset:], uint64(buf.Len()))
-	_ = copy(record[offset:], buf.Bytes())
+	offset += putUint64(record[offset:], uint64(compressedLen))
+	_ = copy(record[offset:], compressed)
 	return record
 }
 
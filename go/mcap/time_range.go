@@ -0,0 +1,134 @@
+package mcap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNoMessages indicates a file has no messages, as reported by TimeRange.
+var ErrNoMessages = errors.New("file has no messages")
+
+// TimeRange returns the earliest and latest message log times in an MCAP
+// file, or ErrNoMessages if it has none.
+//
+// If r is an io.ReadSeeker, the range is taken from the summary section where
+// possible: from the Statistics record, or failing that from the time bounds
+// of the chunk indexes. Otherwise, and for files without a usable summary,
+// the data section is scanned from the current position of r, which must be
+// the start of the file. Chunks are not decompressed; their time bounds are
+// used instead of those of their messages. As elsewhere, a chunk with start
+// and end times of zero is taken to contain no messages.
+func TimeRange(r io.Reader) (start, end uint64, err error) {
+	if rs, ok := r.(io.ReadSeeker); ok {
+		position, err := rs.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to find position in file: %w", err)
+		}
+		var found bool
+		start, end, found, err = summaryTimeRange(rs)
+		if found || err != nil {
+			return start, end, err
+		}
+		if _, err := rs.Seek(position, io.SeekStart); err != nil {
+			return 0, 0, fmt.Errorf("failed to seek to start of file: %w", err)
+		}
+	}
+	return scanTimeRange(r)
+}
+
+// summaryTimeRange reads the time range of a file from its summary section.
+// If the file has no summary section recording the time range, or it cannot
+// be read, found is false and the file should be scanned instead.
+func summaryTimeRange(rs io.ReadSeeker) (start, end uint64, found bool, err error) {
+	reader, err := NewReader(rs)
+	if err != nil {
+		return 0, 0, false, nil
+	}
+	defer reader.Close()
+	stats, ok, err := reader.Statistics()
+	if err != nil {
+		return 0, 0, false, nil
+	}
+	if ok {
+		if stats.MessageCount == 0 {
+			return 0, 0, true, ErrNoMessages
+		}
+		return stats.MessageStartTime, stats.MessageEndTime, true, nil
+	}
+	info, err := reader.Info()
+	if err != nil {
+		return 0, 0, false, nil
+	}
+	for _, idx := range info.ChunkIndexes {
+		if idx.MessageStartTime == 0 && idx.MessageEndTime == 0 {
+			continue
+		}
+		if !found || idx.MessageStartTime < start {
+			start = idx.MessageStartTime
+		}
+		if !found || idx.MessageEndTime > end {
+			end = idx.MessageEndTime
+		}
+		found = true
+	}
+	return start, end, found, nil
+}
+
+// scanTimeRange reads the time range of a file by scanning its data section
+// for messages and chunks.
+func scanTimeRange(r io.Reader) (start, end uint64, err error) {
+	lexer, err := NewLexer(r, &LexerOptions{EmitChunks: true})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer lexer.Close()
+	var found bool
+	observe := func(first, last uint64) {
+		if !found || first < start {
+			start = first
+		}
+		if !found || last > end {
+			end = last
+		}
+		found = true
+	}
+	var buf []byte
+	for {
+		tokenType, record, err := lexer.Next(buf)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return 0, 0, fmt.Errorf("failed to read record: %w", err)
+		}
+		if len(record) > len(buf) {
+			buf = record
+		}
+		switch tokenType {
+		case TokenMessage:
+			message, err := ParseMessage(record)
+			if err != nil {
+				return 0, 0, fmt.Errorf("failed to parse message: %w", err)
+			}
+			observe(message.LogTime, message.LogTime)
+		case TokenChunk:
+			chunk, err := ParseChunk(record)
+			if err != nil {
+				return 0, 0, fmt.Errorf("failed to parse chunk: %w", err)
+			}
+			if chunk.MessageStartTime != 0 || chunk.MessageEndTime != 0 {
+				observe(chunk.MessageStartTime, chunk.MessageEndTime)
+			}
+		case TokenDataEnd:
+			if !found {
+				return 0, 0, ErrNoMessages
+			}
+			return start, end, nil
+		}
+	}
+	if !found {
+		return 0, 0, ErrNoMessages
+	}
+	return start, end, nil
+}
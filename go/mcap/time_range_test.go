@@ -0,0 +1,65 @@
+package mcap
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeRange(t *testing.T) {
+	writeFile := func(opts *WriterOptions, logTimes ...uint64) []byte {
+		buf := &bytes.Buffer{}
+		writer, err := NewWriter(buf, opts)
+		assert.Nil(t, err)
+		assert.Nil(t, writer.WriteHeader(&Header{}))
+		assert.Nil(t, writer.WriteSchema(&Schema{ID: 1, Name: "schema", Encoding: "jsonschema"}))
+		assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/topic"}))
+		for _, logTime := range logTimes {
+			assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, LogTime: logTime, Data: make([]byte, 32)}))
+		}
+		assert.Nil(t, writer.Close())
+		return buf.Bytes()
+	}
+	logTimes := []uint64{50, 10, 90, 30, 70, 20}
+	chunked := &WriterOptions{Chunked: true, ChunkSize: 64}
+	cases := []struct {
+		assertion string
+		input     []byte
+	}{
+		{"statistics", writeFile(chunked, logTimes...)},
+		{"chunk indexes", writeFile(&WriterOptions{Chunked: true, ChunkSize: 64, SkipStatistics: true}, logTimes...)},
+		{"unindexed chunks", writeFile(&WriterOptions{
+			Chunked:             true,
+			ChunkSize:           64,
+			SkipStatistics:      true,
+			SkipChunkIndex:      true,
+			SkipMessageIndexing: true,
+		}, logTimes...)},
+		{"unchunked", writeFile(&WriterOptions{SkipStatistics: true}, logTimes...)},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			start, end, err := TimeRange(bytes.NewReader(c.input))
+			assert.Nil(t, err)
+			assert.Equal(t, uint64(10), start)
+			assert.Equal(t, uint64(90), end)
+		})
+		t.Run(c.assertion+" without seeking", func(t *testing.T) {
+			start, end, err := TimeRange(io.MultiReader(bytes.NewReader(c.input)))
+			assert.Nil(t, err)
+			assert.Equal(t, uint64(10), start)
+			assert.Equal(t, uint64(90), end)
+		})
+	}
+	t.Run("no messages", func(t *testing.T) {
+		for _, opts := range []*WriterOptions{chunked, {Chunked: true, SkipStatistics: true}, {SkipStatistics: true}} {
+			input := writeFile(opts)
+			_, _, err := TimeRange(bytes.NewReader(input))
+			assert.ErrorIs(t, err, ErrNoMessages)
+			_, _, err = TimeRange(io.MultiReader(bytes.NewReader(input)))
+			assert.ErrorIs(t, err, ErrNoMessages)
+		}
+	})
+}
@@ -11,6 +11,8 @@ type unindexedMessageIterator struct {
 	topics   map[string]bool
 	start    uint64
 	end      uint64
+
+	skipSchemaData bool
 }
 
 func (it *unindexedMessageIterator) Next(p []byte) (*Schema, *Channel, *Message, error) {
@@ -21,7 +23,7 @@ func (it *unindexedMessageIterator) Next(p []byte) (*Schema, *Channel, *Message,
 		}
 		switch tokenType {
 		case TokenSchema:
-			schema, err := ParseSchema(record)
+			schema, err := parseSchema(record, it.skipSchemaData)
 			if err != nil {
 				return nil, nil, nil, fmt.Errorf("failed to parse schema: %w", err)
 			}
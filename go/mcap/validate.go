@@ -0,0 +1,539 @@
+package mcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+// ValidationSeverity indicates the seriousness of a ValidationIssue.
+type ValidationSeverity int
+
+const (
+	// ValidationSeverityWarning indicates a deviation from recommended practice
+	// that does not make the file invalid.
+	ValidationSeverityWarning ValidationSeverity = iota
+	// ValidationSeverityError indicates a violation of the MCAP specification.
+	ValidationSeverityError
+)
+
+// String converts a validation severity to its string representation.
+func (s ValidationSeverity) String() string {
+	switch s {
+	case ValidationSeverityWarning:
+		return "warning"
+	case ValidationSeverityError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// ValidationIssue describes a structural problem found by Validate.
+type ValidationIssue struct {
+	Severity ValidationSeverity
+	// Offset is the file offset of the record where the issue was found. For
+	// records inside a chunk, it is the offset of the enclosing chunk.
+	Offset  uint64
+	Message string
+	// Err, if set, is a structured error describing the issue, such as an
+	// *ErrDuplicateID.
+	Err error
+}
+
+// ErrDuplicateID indicates a schema or channel ID was redefined with
+// different content. Opcode is OpSchema or OpChannel, and Offset is the
+// offset of the redefinition, or of its enclosing chunk.
+type ErrDuplicateID struct {
+	Opcode OpCode
+	ID     uint16
+	Offset uint64
+}
+
+func (e *ErrDuplicateID) Error() string {
+	return fmt.Sprintf("%s %d redefined with different content at offset %d", e.Opcode, e.ID, e.Offset)
+}
+
+// ErrMisplacedMessageIndex indicates that a chunk index refers to a message
+// index that is not among the message index records following the chunk, for
+// instance because the writer emitted it before the chunk. ChunkOffset is the
+// start of the chunk, and Offset is the message index offset recorded for
+// ChannelID in the chunk index.
+type ErrMisplacedMessageIndex struct {
+	ChunkOffset uint64
+	ChannelID   uint16
+	Offset      uint64
+}
+
+func (e *ErrMisplacedMessageIndex) Error() string {
+	return fmt.Sprintf(
+		"chunk at offset %d indexes channel %d at offset %d, which is not a message index following the chunk",
+		e.ChunkOffset,
+		e.ChannelID,
+		e.Offset,
+	)
+}
+
+// String formats the issue for display.
+func (i ValidationIssue) String() string {
+	return fmt.Sprintf("%s at offset %d: %s", i.Severity, i.Offset, i.Message)
+}
+
+// ValidateOptions holds options for Validate.
+type ValidateOptions struct {
+	// CheckMonotonicLogTimes reports a warning for any message whose log time
+	// is earlier than that of the preceding message on the same channel.
+	CheckMonotonicLogTimes bool
+	// CrossCheckStatistics counts the schemas, channels, messages, chunks,
+	// attachments and metadata records in the file, and reports an error for
+	// each count or message time in the Statistics record that does not match.
+	// Files without a Statistics record are not checked.
+	CrossCheckStatistics bool
+}
+
+type validator struct {
+	opts         ValidateOptions
+	issues       []ValidationIssue
+	schemas      map[uint16]*Schema
+	channels     map[uint16]*Channel
+	lastLogTimes map[uint16]uint64
+	decompressor chunkDecompressor
+	seenDataEnd  bool
+	profile      string
+	profileCheck ProfileValidator
+	observed     *StatisticsBuilder
+	statistics   *Statistics
+	statsOffset  uint64
+	// messageIndexes maps the offset of each message index record in the
+	// data section to its channel ID.
+	messageIndexes map[uint64]uint16
+}
+
+func (v *validator) errorf(offset uint64, format string, args ...any) {
+	v.issues = append(v.issues, ValidationIssue{
+		Severity: ValidationSeverityError,
+		Offset:   offset,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) warnf(offset uint64, format string, args ...any) {
+	v.issues = append(v.issues, ValidationIssue{
+		Severity: ValidationSeverityWarning,
+		Offset:   offset,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// duplicate reports the redefinition of a schema or channel ID.
+func (v *validator) duplicate(opcode OpCode, id uint16, offset uint64) {
+	err := &ErrDuplicateID{Opcode: opcode, ID: id, Offset: offset}
+	v.issues = append(v.issues, ValidationIssue{
+		Severity: ValidationSeverityError,
+		Offset:   offset,
+		Message:  err.Error(),
+		Err:      err,
+	})
+}
+
+// profileIssue reports a schema or channel that does not conform to the
+// file's profile.
+func (v *validator) profileIssue(opcode OpCode, id uint16, offset uint64, err error) {
+	v.issues = append(v.issues, ValidationIssue{
+		Severity: ValidationSeverityError,
+		Offset:   offset,
+		Message:  fmt.Sprintf("%s %d does not conform to %s profile: %s", opcode, id, v.profile, err),
+		Err:      err,
+	})
+}
+
+func schemasEqual(a, b *Schema) bool {
+	return a.Name == b.Name && a.Encoding == b.Encoding && bytes.Equal(a.Data, b.Data)
+}
+
+func channelsEqual(a, b *Channel) bool {
+	if a.SchemaID != b.SchemaID || a.Topic != b.Topic || a.MessageEncoding != b.MessageEncoding ||
+		len(a.Metadata) != len(b.Metadata) {
+		return false
+	}
+	for k, v := range a.Metadata {
+		if value, ok := b.Metadata[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate reads an MCAP file from start to end and reports every structural
+// violation of the specification it finds. It checks that:
+//
+//   - the header comes first and the footer last, followed by the closing
+//     magic;
+//   - chunk CRCs and uncompressed sizes match their contents, and chunks are
+//     not nested;
+//   - schemas and channels are declared before they are referenced;
+//   - schema and channel IDs are not redefined with different content,
+//     though repeating an identical definition, as some writers do in each
+//     chunk, is permitted;
+//   - a single data end record separates the data section from the summary
+//     section;
+//   - each message index offset in a chunk index refers to a message index
+//     record following the chunk.
+//
+// If a ProfileValidator is registered for the profile named in the header,
+// each schema and channel is also checked against it.
+//
+// Problems with the file are reported as issues; reading continues past them
+// where possible. An error is returned only if the underlying reader fails.
+func Validate(r io.Reader, opts ValidateOptions) ([]ValidationIssue, error) {
+	v := &validator{
+		opts:           opts,
+		schemas:        make(map[uint16]*Schema),
+		channels:       make(map[uint16]*Channel),
+		lastLogTimes:   make(map[uint16]uint64),
+		messageIndexes: make(map[uint64]uint16),
+	}
+	defer v.decompressor.Close()
+	lexerOpts := &LexerOptions{
+		EmitChunks: true,
+	}
+	if opts.CrossCheckStatistics {
+		v.observed = NewStatisticsBuilder()
+		lexerOpts.AttachmentCallback = func(*AttachmentReader) error {
+			v.observed.ObserveAttachment()
+			return nil
+		}
+	}
+	cr := newCountingReader(r)
+	lexer, err := NewLexer(cr, lexerOpts)
+	if err != nil {
+		if cr.err != nil {
+			return nil, cr.err
+		}
+		v.errorf(0, "%s", err)
+		return v.issues, nil
+	}
+	defer lexer.Close()
+	var buf []byte
+	first := true
+	for {
+		tokenType, record, err := lexer.Next(buf)
+		if err != nil {
+			if cr.err != nil {
+				return v.issues, cr.err
+			}
+			var lexErr *LexError
+			if errors.As(err, &lexErr) {
+				err = lexErr.Err
+			}
+			if errors.Is(err, io.EOF) {
+				v.errorf(cr.Count(), "file does not end with a footer")
+			} else {
+				v.errorf(cr.Count(), "failed to read record: %s", err)
+			}
+			return v.issues, nil
+		}
+		if len(record) > len(buf) {
+			buf = record
+		}
+		offset := cr.Count() - 9 - uint64(len(record))
+		if first && tokenType != TokenHeader {
+			v.errorf(offset, "first record is %s, expected header", tokenType)
+		}
+		first = false
+		switch tokenType {
+		case TokenHeader:
+			// the profile is only used to select a profile validator
+			if header, err := ParseHeader(record); err == nil {
+				v.profile = header.Profile
+				v.profileCheck = lookupProfileValidator(header.Profile)
+			}
+		case TokenSchema, TokenChannel, TokenMessage:
+			v.checkRecord(tokenType, record, offset, nil)
+		case TokenChunk:
+			if v.observed != nil {
+				v.observed.ObserveChunk(0, 0)
+			}
+			v.checkChunk(record, offset)
+		case TokenMessageIndex:
+			if len(record) >= 2 {
+				v.messageIndexes[offset] = binary.LittleEndian.Uint16(record)
+			}
+		case TokenMetadata:
+			if v.observed != nil {
+				v.observed.ObserveMetadata()
+			}
+		case TokenDataEnd:
+			if v.seenDataEnd {
+				v.errorf(offset, "duplicate data end record")
+			}
+			v.seenDataEnd = true
+			if _, err := ParseDataEnd(record); err != nil {
+				v.errorf(offset, "failed to parse data end: %s", err)
+			}
+		case TokenChunkIndex, TokenAttachmentIndex, TokenMetadataIndex, TokenStatistics, TokenSummaryOffset:
+			if !v.seenDataEnd {
+				v.errorf(offset, "%s record precedes data end", tokenType)
+			}
+			if tokenType == TokenStatistics && v.observed != nil {
+				statistics, err := ParseStatistics(record)
+				if err != nil {
+					v.errorf(offset, "failed to parse statistics: %s", err)
+					break
+				}
+				v.statistics = statistics
+				v.statsOffset = offset
+			}
+			if tokenType == TokenChunkIndex {
+				v.checkChunkIndex(record, offset)
+			}
+		case TokenFooter:
+			if !v.seenDataEnd {
+				v.errorf(offset, "file has no data end record")
+			}
+			trailer, err := io.ReadAll(cr)
+			if err != nil {
+				return v.issues, err
+			}
+			if !bytes.Equal(trailer, Magic) {
+				v.errorf(offset, "footer is not followed by closing magic")
+			}
+			v.crossCheckStatistics()
+			return v.issues, nil
+		}
+	}
+}
+
+// checkRecord validates a schema, channel, or message record. If the record
+// was read from a chunk, chunk is the enclosing chunk.
+func (v *validator) checkRecord(tokenType TokenType, record []byte, offset uint64, chunk *Chunk) {
+	switch tokenType {
+	case TokenSchema:
+		schema, err := ParseSchema(record)
+		if err != nil {
+			v.errorf(offset, "failed to parse schema: %s", err)
+			return
+		}
+		existing, ok := v.schemas[schema.ID]
+		if ok && !schemasEqual(existing, schema) {
+			v.duplicate(OpSchema, schema.ID, offset)
+			return
+		}
+		if !ok && v.profileCheck != nil {
+			if err := v.profileCheck.ValidateSchema(schema); err != nil {
+				v.profileIssue(OpSchema, schema.ID, offset, err)
+			}
+		}
+		v.schemas[schema.ID] = schema
+		if v.observed != nil {
+			v.observed.ObserveSchema(schema.ID)
+		}
+	case TokenChannel:
+		channel, err := ParseChannel(record)
+		if err != nil {
+			v.errorf(offset, "failed to parse channel: %s", err)
+			return
+		}
+		if _, ok := v.schemas[channel.SchemaID]; channel.SchemaID != 0 && !ok {
+			v.errorf(offset, "channel %d references undeclared schema %d", channel.ID, channel.SchemaID)
+		}
+		existing, ok := v.channels[channel.ID]
+		if ok && !channelsEqual(existing, channel) {
+			v.duplicate(OpChannel, channel.ID, offset)
+			return
+		}
+		if !ok && v.profileCheck != nil {
+			if err := v.profileCheck.ValidateChannel(channel, v.schemas[channel.SchemaID]); err != nil {
+				v.profileIssue(OpChannel, channel.ID, offset, err)
+			}
+		}
+		v.channels[channel.ID] = channel
+		if v.observed != nil {
+			v.observed.ObserveChannel(channel.ID)
+		}
+	case TokenMessage:
+		message, err := ParseMessage(record)
+		if err != nil {
+			v.errorf(offset, "failed to parse message: %s", err)
+			return
+		}
+		if v.observed != nil {
+			v.observed.ObserveMessage(message.ChannelID, message.LogTime)
+		}
+		if _, ok := v.channels[message.ChannelID]; !ok {
+			v.errorf(offset, "message references undeclared channel %d", message.ChannelID)
+		}
+		if chunk != nil && (message.LogTime < chunk.MessageStartTime || message.LogTime > chunk.MessageEndTime) {
+			v.errorf(
+				offset,
+				"message log time %d is outside of chunk time range [%d, %d]",
+				message.LogTime,
+				chunk.MessageStartTime,
+				chunk.MessageEndTime,
+			)
+		}
+		if v.opts.CheckMonotonicLogTimes {
+			if last, ok := v.lastLogTimes[message.ChannelID]; ok && message.LogTime < last {
+				v.warnf(
+					offset,
+					"message log time %d on channel %d is earlier than preceding log time %d",
+					message.LogTime,
+					message.ChannelID,
+					last,
+				)
+			}
+			v.lastLogTimes[message.ChannelID] = message.LogTime
+		}
+	}
+}
+
+// crossCheckStatistics compares the counts and message times declared by the
+// Statistics record with those observed in the file.
+func (v *validator) crossCheckStatistics() {
+	if v.observed == nil || v.statistics == nil {
+		return
+	}
+	declared := v.statistics
+	found := v.observed.Build()
+	counts := []struct {
+		name     string
+		declared uint64
+		found    uint64
+	}{
+		{"messages", declared.MessageCount, found.MessageCount},
+		{"schemas", uint64(declared.SchemaCount), uint64(found.SchemaCount)},
+		{"channels", uint64(declared.ChannelCount), uint64(found.ChannelCount)},
+		{"attachments", uint64(declared.AttachmentCount), uint64(found.AttachmentCount)},
+		{"metadata records", uint64(declared.MetadataCount), uint64(found.MetadataCount)},
+		{"chunks", uint64(declared.ChunkCount), uint64(found.ChunkCount)},
+	}
+	for _, c := range counts {
+		if c.declared != c.found {
+			v.errorf(v.statsOffset, "statistics declares %d %s, found %d", c.declared, c.name, c.found)
+		}
+	}
+	channelIDs := make([]uint16, 0, len(found.ChannelMessageCounts))
+	for id := range found.ChannelMessageCounts {
+		channelIDs = append(channelIDs, id)
+	}
+	for id := range declared.ChannelMessageCounts {
+		if _, ok := found.ChannelMessageCounts[id]; !ok {
+			channelIDs = append(channelIDs, id)
+		}
+	}
+	sort.Slice(channelIDs, func(i, j int) bool { return channelIDs[i] < channelIDs[j] })
+	for _, id := range channelIDs {
+		if d, f := declared.ChannelMessageCounts[id], found.ChannelMessageCounts[id]; d != f {
+			v.errorf(v.statsOffset, "statistics declares %d messages on channel %d, found %d", d, id, f)
+		}
+	}
+	if found.MessageCount > 0 {
+		if declared.MessageStartTime != found.MessageStartTime {
+			v.errorf(v.statsOffset, "statistics declares message start time %d, found %d",
+				declared.MessageStartTime, found.MessageStartTime)
+		}
+		if declared.MessageEndTime != found.MessageEndTime {
+			v.errorf(v.statsOffset, "statistics declares message end time %d, found %d",
+				declared.MessageEndTime, found.MessageEndTime)
+		}
+	}
+}
+
+// checkChunkIndex checks that each message index offset in a chunk index
+// refers to a message index record for the same channel, within the range of
+// message indexes following the chunk.
+func (v *validator) checkChunkIndex(record []byte, offset uint64) {
+	idx, err := ParseChunkIndex(record)
+	if err != nil {
+		v.errorf(offset, "failed to parse chunk index: %s", err)
+		return
+	}
+	start := idx.ChunkStartOffset + idx.ChunkLength
+	end := start + idx.MessageIndexLength
+	channelIDs := make([]uint16, 0, len(idx.MessageIndexOffsets))
+	for channelID := range idx.MessageIndexOffsets {
+		channelIDs = append(channelIDs, channelID)
+	}
+	sort.Slice(channelIDs, func(i, j int) bool { return channelIDs[i] < channelIDs[j] })
+	for _, channelID := range channelIDs {
+		indexOffset := idx.MessageIndexOffsets[channelID]
+		indexChannelID, ok := v.messageIndexes[indexOffset]
+		if ok && indexChannelID == channelID && indexOffset >= start && indexOffset < end {
+			continue
+		}
+		err := &ErrMisplacedMessageIndex{
+			ChunkOffset: idx.ChunkStartOffset,
+			ChannelID:   channelID,
+			Offset:      indexOffset,
+		}
+		v.issues = append(v.issues, ValidationIssue{
+			Severity: ValidationSeverityError,
+			Offset:   offset,
+			Message:  err.Error(),
+			Err:      err,
+		})
+	}
+}
+
+// checkChunk validates the CRC and size of a chunk and the records within it.
+func (v *validator) checkChunk(record []byte, offset uint64) {
+	chunk, err := ParseChunk(record)
+	if err != nil {
+		v.errorf(offset, "failed to parse chunk: %s", err)
+		return
+	}
+	data, err := v.decompressor.decompress(chunk)
+	if err != nil {
+		v.errorf(offset, "failed to decompress chunk: %s", err)
+		return
+	}
+	if uint64(len(data)) != chunk.UncompressedSize {
+		v.errorf(
+			offset,
+			"chunk uncompressed size %d does not match declared size %d",
+			len(data),
+			chunk.UncompressedSize,
+		)
+		return
+	}
+	if chunk.UncompressedCRC != 0 {
+		if crc := crc32.ChecksumIEEE(data); crc != chunk.UncompressedCRC {
+			v.errorf(offset, "invalid chunk CRC: %x != %x", crc, chunk.UncompressedCRC)
+			return
+		}
+	}
+	lexer, err := NewLexer(bytes.NewReader(data), &LexerOptions{
+		SkipMagic:  true,
+		EmitChunks: true,
+	})
+	if err != nil {
+		v.errorf(offset, "failed to read chunk records: %s", err)
+		return
+	}
+	defer lexer.Close()
+	for {
+		tokenType, record, err := lexer.Next(nil)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				var lexErr *LexError
+				if errors.As(err, &lexErr) {
+					err = lexErr.Err
+				}
+				v.errorf(offset, "failed to read chunk records: %s", err)
+			}
+			return
+		}
+		switch tokenType {
+		case TokenSchema, TokenChannel, TokenMessage:
+			v.checkRecord(tokenType, record, offset, chunk)
+		case TokenChunk:
+			v.errorf(offset, "%s", ErrNestedChunk)
+		default:
+			v.errorf(offset, "%s record is not permitted in a chunk", tokenType)
+		}
+	}
+}
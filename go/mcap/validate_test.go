@@ -0,0 +1,468 @@
+package mcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeValidationTestFile(t *testing.T, logTimes ...uint64) []byte {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{
+		Chunked:     true,
+		ChunkSize:   1024,
+		Compression: CompressionZSTD,
+		IncludeCRC:  true,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{Profile: "ros1"}))
+	assert.Nil(t, writer.WriteSchema(&Schema{ID: 1, Name: "schema", Encoding: "ros1msg"}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/foo", MessageEncoding: "ros1"}))
+	for _, logTime := range logTimes {
+		assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, LogTime: logTime, Data: []byte("hello")}))
+	}
+	assert.Nil(t, writer.Close())
+	return buf.Bytes()
+}
+
+func messageRecord(channelID uint16, logTime uint64) []byte {
+	return flatten(
+		[]byte{byte(OpMessage)},
+		encodedUint64(2+4+8+8),
+		encodedUint16(channelID),
+		encodedUint32(0),
+		encodedUint64(logTime),
+		encodedUint64(logTime),
+	)
+}
+
+func channelRecord(channelID uint16, schemaID uint16) []byte {
+	body := flatten(
+		encodedUint16(channelID),
+		encodedUint16(schemaID),
+		prefixedString("/foo"),
+		prefixedString(""),
+		encodedUint32(0),
+	)
+	return flatten([]byte{byte(OpChannel)}, encodedUint64(uint64(len(body))), body)
+}
+
+func schemaRecord(schemaID uint16, data string) []byte {
+	body := flatten(
+		encodedUint16(schemaID),
+		prefixedString("schema"),
+		prefixedString("jsonschema"),
+		prefixedString(data),
+	)
+	return flatten([]byte{byte(OpSchema)}, encodedUint64(uint64(len(body))), body)
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		assertion string
+		input     []byte
+		opts      ValidateOptions
+		expected  []string
+	}{
+		{
+			"valid file",
+			writeValidationTestFile(t, 1, 2, 3),
+			ValidateOptions{CheckMonotonicLogTimes: true},
+			nil,
+		},
+		{
+			"out of order messages without monotonic check",
+			writeValidationTestFile(t, 3, 2, 1),
+			ValidateOptions{},
+			nil,
+		},
+		{
+			"out of order messages with monotonic check",
+			writeValidationTestFile(t, 3, 2),
+			ValidateOptions{CheckMonotonicLogTimes: true},
+			[]string{"message log time 2 on channel 1 is earlier than preceding log time 3"},
+		},
+		{
+			"bad magic",
+			[]byte("not an mcap file"),
+			ValidateOptions{},
+			[]string{"Invalid magic at start of file"},
+		},
+		{
+			"header not first",
+			file(channelRecord(1, 0), header(), dataEnd(), footer()),
+			ValidateOptions{},
+			[]string{"first record is channel, expected header"},
+		},
+		{
+			"missing footer",
+			file(header(), channelRecord(1, 0)),
+			ValidateOptions{},
+			[]string{"file does not end with a footer"},
+		},
+		{
+			"missing closing magic",
+			flatten(Magic, header(), dataEnd(), footer()),
+			ValidateOptions{},
+			[]string{"footer is not followed by closing magic"},
+		},
+		{
+			"undeclared references",
+			file(header(), channelRecord(1, 2), messageRecord(3, 0), dataEnd(), footer()),
+			ValidateOptions{},
+			[]string{
+				"channel 1 references undeclared schema 2",
+				"message references undeclared channel 3",
+			},
+		},
+		{
+			"nested chunk",
+			file(
+				header(),
+				chunk(t, CompressionZSTD, true, chunk(t, CompressionNone, true, channelRecord(1, 0))),
+				dataEnd(),
+				footer(),
+			),
+			ValidateOptions{},
+			[]string{"detected nested chunk"},
+		},
+		{
+			"message outside of chunk time range",
+			file(
+				header(),
+				chunk(t, CompressionLZ4, true, channelRecord(1, 0), messageRecord(1, 2e9)),
+				dataEnd(),
+				footer(),
+			),
+			ValidateOptions{},
+			[]string{"message log time 2000000000 is outside of chunk time range [0, 1000000000]"},
+		},
+		{
+			"identical redefinitions",
+			file(
+				header(),
+				chunk(t, CompressionZSTD, true, schemaRecord(1, "{}"), channelRecord(1, 1), messageRecord(1, 0)),
+				chunk(t, CompressionZSTD, true, schemaRecord(1, "{}"), channelRecord(1, 1), messageRecord(1, 0)),
+				dataEnd(),
+				footer(),
+			),
+			ValidateOptions{},
+			nil,
+		},
+		{
+			"conflicting redefinitions",
+			file(
+				header(),
+				schemaRecord(1, "{}"),
+				schemaRecord(2, "{}"),
+				channelRecord(1, 1),
+				schemaRecord(1, "{\"type\": \"object\"}"),
+				channelRecord(1, 2),
+				dataEnd(),
+				footer(),
+			),
+			ValidateOptions{},
+			[]string{
+				"schema 1 redefined with different content",
+				"channel 1 redefined with different content",
+			},
+		},
+		{
+			"missing data end",
+			file(header(), channelRecord(1, 0), footer()),
+			ValidateOptions{},
+			[]string{"file has no data end record"},
+		},
+		{
+			"duplicate data end",
+			file(header(), dataEnd(), dataEnd(), footer()),
+			ValidateOptions{},
+			[]string{"duplicate data end record"},
+		},
+		{
+			"summary record before data end",
+			file(header(), record(OpStatistics), dataEnd(), footer()),
+			ValidateOptions{},
+			[]string{"statistics record precedes data end"},
+		},
+		{
+			"truncated record",
+			file(header(), channelRecord(1, 0)[:12]),
+			ValidateOptions{},
+			[]string{"failed to read record: MCAP truncated in channel"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			issues, err := Validate(bytes.NewReader(c.input), c.opts)
+			assert.Nil(t, err)
+			assert.Equal(t, len(c.expected), len(issues), "unexpected issues: %v", issues)
+			for i, message := range c.expected {
+				if i < len(issues) {
+					assert.Contains(t, issues[i].Message, message)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateDuplicateID(t *testing.T) {
+	first := schemaRecord(1, "{}")
+	input := file(header(), first, schemaRecord(1, "{\"type\": \"object\"}"), dataEnd(), footer())
+	issues, err := Validate(bytes.NewReader(input), ValidateOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(issues))
+	var duplicate *ErrDuplicateID
+	assert.ErrorAs(t, issues[0].Err, &duplicate)
+	assert.Equal(t, OpSchema, duplicate.Opcode)
+	assert.Equal(t, uint16(1), duplicate.ID)
+	assert.Equal(t, uint64(len(Magic)+len(header())+len(first)), duplicate.Offset)
+}
+
+func TestValidateChunkIntegrity(t *testing.T) {
+	t.Run("corrupted chunk CRC", func(t *testing.T) {
+		badchunk := chunk(t, CompressionNone, true, channelRecord(1, 0), messageRecord(1, 0))
+		badchunk[len(badchunk)-1] = 0xff
+		input := file(header(), chunk(t, CompressionNone, true, channelRecord(1, 0)), badchunk, dataEnd(), footer())
+		issues, err := Validate(bytes.NewReader(input), ValidateOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(issues))
+		assert.Equal(t, ValidationSeverityError, issues[0].Severity)
+		assert.Equal(t, uint64(len(Magic)+len(header())+len(chunk(t, CompressionNone, true, channelRecord(1, 0)))), issues[0].Offset)
+		assert.Contains(t, issues[0].Message, "invalid chunk CRC")
+	})
+	t.Run("incorrect uncompressed size", func(t *testing.T) {
+		badchunk := chunk(t, CompressionZSTD, true, channelRecord(1, 0), messageRecord(1, 0))
+		putUint64(badchunk[1+8+8+8:], 10)
+		issues, err := Validate(bytes.NewReader(file(header(), badchunk, dataEnd(), footer())), ValidateOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(issues))
+		assert.Contains(t, issues[0].Message, "does not match declared size 10")
+	})
+}
+
+func TestValidateMessageIndexPlacement(t *testing.T) {
+	data := writeValidationTestFile(t, 1, 2, 3)
+	issues, err := Validate(bytes.NewReader(data), ValidateOptions{})
+	assert.Nil(t, err)
+	assert.Empty(t, issues)
+
+	// locate the chunk, its message index, and the chunk index
+	recordLength := func(offset int) int {
+		return 9 + int(binary.LittleEndian.Uint64(data[offset+1:]))
+	}
+	var chunkOffset, chunkIndexOffset int
+	for offset := len(Magic); offset < len(data)-len(Magic); offset += recordLength(offset) {
+		switch OpCode(data[offset]) {
+		case OpChunk:
+			chunkOffset = offset
+		case OpChunkIndex:
+			chunkIndexOffset = offset
+		}
+	}
+	chunkLength := recordLength(chunkOffset)
+	indexOffset := chunkOffset + chunkLength
+	assert.Equal(t, OpMessageIndex, OpCode(data[indexOffset]))
+	indexLength := recordLength(indexOffset)
+
+	// move the message index ahead of the chunk, and update the chunk index
+	// to match
+	moved := flatten(
+		data[:chunkOffset],
+		data[indexOffset:indexOffset+indexLength],
+		data[chunkOffset:indexOffset],
+		data[indexOffset+indexLength:],
+	)
+	body := moved[chunkIndexOffset+9:]
+	putUint64(body[16:], uint64(chunkOffset+indexLength))
+	putUint64(body[8+8+8+8+4+2:], uint64(chunkOffset))
+
+	issues, err = Validate(bytes.NewReader(moved), ValidateOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(issues))
+	var misplaced *ErrMisplacedMessageIndex
+	assert.ErrorAs(t, issues[0].Err, &misplaced)
+	assert.Equal(t, uint64(chunkOffset+indexLength), misplaced.ChunkOffset)
+	assert.Equal(t, uint16(1), misplaced.ChannelID)
+	assert.Equal(t, uint64(chunkOffset), misplaced.Offset)
+	assert.Equal(t, uint64(chunkIndexOffset), issues[0].Offset)
+}
+
+type topicPrefixValidator struct{}
+
+func (topicPrefixValidator) ValidateSchema(*Schema) error { return nil }
+
+func (topicPrefixValidator) ValidateChannel(channel *Channel, _ *Schema) error {
+	if !strings.HasPrefix(channel.Topic, "/") {
+		return fmt.Errorf("topic %q is not absolute", channel.Topic)
+	}
+	return nil
+}
+
+func TestValidateProfile(t *testing.T) {
+	RegisterProfileValidator("test-topics", topicPrefixValidator{})
+	writeFile := func(profile string, schema *Schema, channels ...*Channel) []byte {
+		buf := &bytes.Buffer{}
+		writer, err := NewWriter(buf, &WriterOptions{Chunked: true, ChunkSize: 1024})
+		assert.Nil(t, err)
+		assert.Nil(t, writer.WriteHeader(&Header{Profile: profile}))
+		assert.Nil(t, writer.WriteSchema(schema))
+		for _, channel := range channels {
+			assert.Nil(t, writer.WriteChannel(channel))
+		}
+		assert.Nil(t, writer.Close())
+		return buf.Bytes()
+	}
+	cases := []struct {
+		assertion string
+		input     []byte
+		expected  []string
+	}{
+		{
+			"conforming ros2 file",
+			writeFile(
+				"ros2",
+				&Schema{ID: 1, Encoding: "ros2msg"},
+				&Channel{ID: 1, SchemaID: 1, Topic: "/a", MessageEncoding: "cdr"},
+			),
+			nil,
+		},
+		{
+			"nonconforming ros2 file",
+			writeFile(
+				"ros2",
+				&Schema{ID: 1, Encoding: "jsonschema"},
+				&Channel{ID: 1, SchemaID: 1, Topic: "/a", MessageEncoding: "json"},
+				&Channel{ID: 2, Topic: "/b", MessageEncoding: "cdr"},
+			),
+			[]string{
+				`schema 1 does not conform to ros2 profile: schema encoding "jsonschema"`,
+				`channel 1 does not conform to ros2 profile: message encoding "json" is not "cdr"`,
+				"channel 2 does not conform to ros2 profile: channel has no schema",
+			},
+		},
+		{
+			"unregistered profile",
+			writeFile(
+				"other",
+				&Schema{ID: 1, Encoding: "jsonschema"},
+				&Channel{ID: 1, SchemaID: 1, Topic: "/a", MessageEncoding: "json"},
+			),
+			nil,
+		},
+		{
+			"registered validator",
+			writeFile(
+				"test-topics",
+				&Schema{ID: 1},
+				&Channel{ID: 1, SchemaID: 1, Topic: "/a"},
+				&Channel{ID: 2, SchemaID: 1, Topic: "b"},
+			),
+			[]string{`channel 2 does not conform to test-topics profile: topic "b" is not absolute`},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			issues, err := Validate(bytes.NewReader(c.input), ValidateOptions{})
+			assert.Nil(t, err)
+			assert.Equal(t, len(c.expected), len(issues), "unexpected issues: %v", issues)
+			for i, message := range c.expected {
+				if i < len(issues) {
+					assert.Contains(t, issues[i].Message, message)
+					assert.NotNil(t, issues[i].Err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateCrossCheckStatistics(t *testing.T) {
+	write := func(t *testing.T, tamper func(*Statistics)) []byte {
+		buf := &bytes.Buffer{}
+		writer, err := NewWriter(buf, &WriterOptions{
+			Chunked:     true,
+			ChunkSize:   1024,
+			Compression: CompressionLZ4,
+		})
+		assert.Nil(t, err)
+		assert.Nil(t, writer.WriteHeader(&Header{Profile: "ros1"}))
+		assert.Nil(t, writer.WriteSchema(&Schema{ID: 1, Name: "schema", Encoding: "ros1msg"}))
+		assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/foo", MessageEncoding: "ros1"}))
+		for _, logTime := range []uint64{1, 2, 3} {
+			assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, LogTime: logTime, Data: []byte("hello")}))
+		}
+		assert.Nil(t, writer.WriteAttachment(&Attachment{Name: "a", DataSize: 1, Data: bytes.NewReader([]byte("a"))}))
+		assert.Nil(t, writer.WriteMetadata(&Metadata{Name: "m"}))
+		tamper(writer.Statistics)
+		assert.Nil(t, writer.Close())
+		return buf.Bytes()
+	}
+	cases := []struct {
+		assertion string
+		tamper    func(*Statistics)
+		expected  []string
+	}{
+		{
+			"correct statistics",
+			func(*Statistics) {},
+			nil,
+		},
+		{
+			"wrong message counts",
+			func(s *Statistics) {
+				s.MessageCount = 1000
+				s.ChannelMessageCounts[1] = 998
+			},
+			[]string{
+				"statistics declares 1000 messages, found 3",
+				"statistics declares 998 messages on channel 1, found 3",
+			},
+		},
+		{
+			"wrong record counts",
+			func(s *Statistics) {
+				s.AttachmentCount = 2
+				s.MetadataCount = 0
+				// the writer counts its final chunk on close
+				s.ChunkCount = 4
+			},
+			[]string{
+				"statistics declares 2 attachments, found 1",
+				"statistics declares 0 metadata records, found 1",
+				"statistics declares 5 chunks, found 1",
+			},
+		},
+		{
+			"wrong message times",
+			func(s *Statistics) {
+				s.MessageStartTime = 0
+				s.MessageEndTime = 10
+			},
+			[]string{
+				"statistics declares message start time 0, found 1",
+				"statistics declares message end time 10, found 3",
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			data := write(t, c.tamper)
+			issues, err := Validate(bytes.NewReader(data), ValidateOptions{CrossCheckStatistics: true})
+			assert.Nil(t, err)
+			var messages []string
+			for _, issue := range issues {
+				assert.Equal(t, ValidationSeverityError, issue.Severity)
+				messages = append(messages, issue.Message)
+			}
+			assert.Equal(t, c.expected, messages)
+
+			issues, err = Validate(bytes.NewReader(data), ValidateOptions{})
+			assert.Nil(t, err)
+			assert.Empty(t, issues)
+		})
+	}
+}
@@ -45,3 +45,12 @@ func (w *writeSizer) ResetCRC() {
 		w.crc.Reset()
 	}
 }
+
+// resume continues counting from size, and the checksum from crc, as if
+// size bytes with that checksum had already been written.
+func (w *writeSizer) resume(size uint64, crc uint32) {
+	w.size = size
+	if w.crc != nil {
+		w.crc.Seed(crc)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"math"
 	"sort"
+	"time"
 
 	"github.com/klauspost/compress/zstd"
 	"github.com/pierrec/lz4/v4"
@@ -30,6 +31,7 @@ type Writer struct {
 	// MetadataIndexes created over the course of the recording.
 	MetadataIndexes []*MetadataIndex
 
+	stats            *StatisticsBuilder
 	channelIDs       []uint16
 	schemaIDs        []uint16
 	channels         map[uint16]*Channel
@@ -42,6 +44,9 @@ type Writer struct {
 	uncompressed     *bytes.Buffer
 	compressed       *bytes.Buffer
 	compressedWriter *countingCRCWriter
+	// compression is the chunk compression format in use. It is
+	// CompressionAuto until the first chunk is written.
+	compression CompressionFormat
 
 	currentChunkStartTime    uint64
 	currentChunkEndTime      uint64
@@ -49,6 +54,16 @@ type Writer struct {
 
 	opts *WriterOptions
 
+	// content and canonical IDs of schemas and channels, for deduplication.
+	schemasByContent  map[schemaContent]uint16
+	schemaAliases     map[uint16]uint16
+	channelsByContent map[channelContent]uint16
+	channelAliases    map[uint16]uint16
+
+	// omitDataSectionCRC is set when appending to a file without a data
+	// section CRC, which cannot be continued.
+	omitDataSectionCRC bool
+
 	closed bool
 }
 
@@ -104,6 +119,9 @@ func (w *Writer) WriteFooter(f *Footer) error {
 // identified within a file by their schema ID. A Schema record must occur at
 // least once in the file prior to any Channel Info referring to its ID.
 func (w *Writer) WriteSchema(s *Schema) (err error) {
+	if w.dedupSchema(s) {
+		return nil
+	}
 	msglen := 2 + 4 + len(s.Name) + 4 + len(s.Encoding) + 4 + len(s.Data)
 	w.ensureSized(msglen)
 	offset := putUint16(w.msg, s.ID)
@@ -121,7 +139,7 @@ func (w *Writer) WriteSchema(s *Schema) (err error) {
 	if _, ok := w.schemas[s.ID]; !ok {
 		w.schemaIDs = append(w.schemaIDs, s.ID)
 		w.schemas[s.ID] = s
-		w.Statistics.SchemaCount++
+		w.stats.ObserveSchema(s.ID)
 	}
 	return nil
 }
@@ -131,12 +149,21 @@ func (w *Writer) WriteSchema(s *Schema) (err error) {
 // Info record must occur at least once in the file prior to any message
 // referring to its channel ID.
 func (w *Writer) WriteChannel(c *Channel) error {
-	if c.SchemaID > 0 {
-		if _, ok := w.schemas[c.SchemaID]; !ok {
+	schemaID := w.CanonicalSchemaID(c.SchemaID)
+	if schemaID > 0 {
+		if _, ok := w.schemas[schemaID]; !ok {
 			return ErrUnknownSchema
 		}
 	}
 	userdata := makePrefixedMap(c.Metadata)
+	if w.dedupChannel(c, schemaID, userdata) {
+		return nil
+	}
+	if schemaID != c.SchemaID {
+		remapped := *c
+		remapped.SchemaID = schemaID
+		c = &remapped
+	}
 	msglen := (2 +
 		4 + len(c.Topic) +
 		4 + len(c.MessageEncoding) +
@@ -161,7 +188,7 @@ func (w *Writer) WriteChannel(c *Channel) error {
 		}
 	}
 	if _, ok := w.channels[c.ID]; !ok {
-		w.Statistics.ChannelCount++
+		w.stats.ObserveChannel(c.ID)
 		w.channels[c.ID] = c
 		w.channelIDs = append(w.channelIDs, c.ID)
 	}
@@ -173,26 +200,31 @@ func (w *Writer) WriteChannel(c *Channel) error {
 // match that of the channel info record corresponding to the message's channel
 // ID.
 func (w *Writer) WriteMessage(m *Message) error {
-	if w.channels[m.ChannelID] == nil {
+	channelID := w.CanonicalChannelID(m.ChannelID)
+	if w.channels[channelID] == nil {
 		return fmt.Errorf("unrecognized channel %d", m.ChannelID)
 	}
 	msglen := 2 + 4 + 8 + 8 + len(m.Data)
 	w.ensureSized(msglen)
-	offset := putUint16(w.msg, m.ChannelID)
+	offset := putUint16(w.msg, channelID)
 	offset += putUint32(w.msg[offset:], m.Sequence)
 	offset += putUint64(w.msg[offset:], m.LogTime)
 	offset += putUint64(w.msg[offset:], m.PublishTime)
 	offset += copy(w.msg[offset:], m.Data)
-	w.Statistics.ChannelMessageCounts[m.ChannelID]++
-	w.Statistics.MessageCount++
+	w.stats.ObserveMessage(channelID, m.LogTime)
 	if w.opts.Chunked && !w.closed {
-		idx, ok := w.messageIndexes[m.ChannelID]
+		if w.currentChunkMessageCount > 0 && w.exceedsChunkDuration(m.LogTime) {
+			if err := w.flushActiveChunk(); err != nil {
+				return err
+			}
+		}
+		idx, ok := w.messageIndexes[channelID]
 		if !ok {
 			idx = &MessageIndex{
-				ChannelID: m.ChannelID,
+				ChannelID: channelID,
 				Records:   nil,
 			}
-			w.messageIndexes[m.ChannelID] = idx
+			w.messageIndexes[channelID] = idx
 		}
 		idx.Add(m.LogTime, uint64(w.compressedWriter.Size()))
 		_, err := w.writeRecord(w.compressedWriter, OpMessage, w.msg[:offset])
@@ -206,7 +238,8 @@ func (w *Writer) WriteMessage(m *Message) error {
 		if m.LogTime < w.currentChunkStartTime {
 			w.currentChunkStartTime = m.LogTime
 		}
-		if w.compressedWriter.Size() > w.opts.ChunkSize {
+		if w.compressedWriter.Size() > w.opts.ChunkSize ||
+			(w.opts.ChunkMessageLimit > 0 && w.currentChunkMessageCount >= uint64(w.opts.ChunkMessageLimit)) {
 			err := w.flushActiveChunk()
 			if err != nil {
 				return err
@@ -218,13 +251,23 @@ func (w *Writer) WriteMessage(m *Message) error {
 			return err
 		}
 	}
-	if m.LogTime > w.Statistics.MessageEndTime {
-		w.Statistics.MessageEndTime = m.LogTime
+	return nil
+}
+
+// exceedsChunkDuration reports whether adding a message logged at logTime to
+// the active chunk would make it span more than the chunk duration limit.
+func (w *Writer) exceedsChunkDuration(logTime uint64) bool {
+	if w.opts.ChunkDurationLimit <= 0 {
+		return false
+	}
+	start, end := w.currentChunkStartTime, w.currentChunkEndTime
+	if logTime < start {
+		start = logTime
 	}
-	if m.LogTime < w.Statistics.MessageStartTime || w.Statistics.MessageCount <= 1 {
-		w.Statistics.MessageStartTime = m.LogTime
+	if logTime > end {
+		end = logTime
 	}
-	return nil
+	return end-start > uint64(w.opts.ChunkDurationLimit)
 }
 
 // WriteMessageIndex writes a message index record to the output. A Message
@@ -248,7 +291,10 @@ func (w *Writer) WriteMessageIndex(idx *MessageIndex) error {
 
 // WriteAttachment writes an attachment to the output. Attachment records
 // contain auxiliary artifacts such as text, core dumps, calibration data, or
-// other arbitrary data. Attachment records must not appear within a chunk.
+// other arbitrary data. Attachment records must not appear within a chunk, so
+// they are written directly to the data section even while a chunk is being
+// accumulated, and their CRC is computed over the data as it is copied. An
+// index of each attachment is kept for the summary section.
 func (w *Writer) WriteAttachment(a *Attachment) error {
 	bufferLen := 1 + // opcode
 		8 + // record length
@@ -302,7 +348,7 @@ func (w *Writer) WriteAttachment(a *Attachment) error {
 		Name:       a.Name,
 		MediaType:  a.MediaType,
 	})
-	w.Statistics.AttachmentCount++
+	w.stats.ObserveAttachment()
 	return nil
 }
 
@@ -355,9 +401,10 @@ func (w *Writer) WriteStatistics(s *Statistics) error {
 }
 
 // WriteMetadata writes a metadata record to the output. A metadata record
-// contains arbitrary user data in key-value pairs.
+// contains arbitrary user data in key-value pairs. Like attachments, metadata
+// records are written outside of chunks and indexed in the summary section.
 func (w *Writer) WriteMetadata(m *Metadata) error {
-	data := makePrefixedMap(m.Metadata)
+	data := makeOrderedPrefixedMap(m.Metadata, m.Keys)
 	msglen := 4 + len(m.Name) + 4 + len(data)
 	w.ensureSized(msglen)
 	offset := putPrefixedString(w.msg, m.Name)
@@ -372,7 +419,20 @@ func (w *Writer) WriteMetadata(m *Metadata) error {
 		Length: uint64(c),
 		Name:   m.Name,
 	})
-	w.Statistics.MetadataCount++
+	w.stats.ObserveMetadata()
+	return err
+}
+
+// WriteRaw writes a record with the given opcode and content to the output
+// verbatim, outside of any chunk, as for passing through a TokenUnknown record
+// read with LexerOptions.EmitUnknownRecords. The record is not indexed or
+// counted in statistics, so it should not be used for opcodes the Writer
+// otherwise writes.
+func (w *Writer) WriteRaw(opcode OpCode, data []byte) error {
+	if opcode == OpReserved {
+		return ErrInvalidZeroOpcode
+	}
+	_, err := w.writeRecord(w.w, opcode, data)
 	return err
 }
 
@@ -425,9 +485,14 @@ func (w *Writer) flushActiveChunk() error {
 		return err
 	}
 	crc := w.compressedWriter.CRC()
-	compressedlen := w.compressed.Len()
 	uncompressedlen := w.compressedWriter.Size()
-	msglen := 8 + 8 + 8 + 4 + 4 + len(w.opts.Compression) + 8 + compressedlen
+	if w.compression == CompressionAuto {
+		if err := w.chooseCompression(); err != nil {
+			return err
+		}
+	}
+	compressedlen := w.compressed.Len()
+	msglen := 8 + 8 + 8 + 4 + 4 + len(w.compression) + 8 + compressedlen
 	chunkStartOffset := w.w.Size()
 	var start, end uint64
 	if w.currentChunkMessageCount != 0 {
@@ -452,7 +517,7 @@ func (w *Writer) flushActiveChunk() error {
 	offset += putUint64(w.chunk[offset:], end)
 	offset += putUint64(w.chunk[offset:], uint64(uncompressedlen))
 	offset += putUint32(w.chunk[offset:], crc)
-	offset += putPrefixedString(w.chunk[offset:], string(w.opts.Compression))
+	offset += putPrefixedString(w.chunk[offset:], string(w.compression))
 	offset += putUint64(w.chunk[offset:], uint64(w.compressed.Len()))
 	offset += copy(w.chunk[offset:recordlen], w.compressed.Bytes())
 	_, err = w.w.Write(w.chunk[:offset])
@@ -489,28 +554,78 @@ func (w *Writer) flushActiveChunk() error {
 		ChunkLength:         chunkEndOffset - chunkStartOffset,
 		MessageIndexOffsets: messageIndexOffsets,
 		MessageIndexLength:  messageIndexLength,
-		Compression:         w.opts.Compression,
+		Compression:         w.compression,
 		CompressedSize:      uint64(compressedlen),
 		UncompressedSize:    uint64(uncompressedlen),
 	})
 	for _, idx := range w.messageIndexes {
 		idx.Reset()
 	}
-	w.Statistics.ChunkCount++
+	w.stats.ObserveChunk(start, end)
 	w.currentChunkStartTime = math.MaxUint64
 	w.currentChunkEndTime = 0
 	w.currentChunkMessageCount = 0
 	return nil
 }
 
+// chooseCompression selects the compression format for the file when
+// CompressionAuto is in use. The uncompressed records of the first chunk are
+// compressed with each candidate format, and the format producing the
+// smallest output is used for this and every later chunk. The compressed
+// buffer is left holding the chosen format's output.
+func (w *Writer) chooseCompression() error {
+	var best ResettableWriteCloser
+	var bestOutput *bytes.Buffer
+	for _, compression := range []CompressionFormat{CompressionLZ4, CompressionZSTD} {
+		output := &bytes.Buffer{}
+		compressor, err := newChunkCompressor(compression, w.opts.CompressionLevel, output)
+		if err != nil {
+			return err
+		}
+		if _, err := compressor.Write(w.compressed.Bytes()); err != nil {
+			return fmt.Errorf("failed to compress chunk with %s: %w", compression, err)
+		}
+		if err := compressor.Close(); err != nil {
+			return fmt.Errorf("failed to compress chunk with %s: %w", compression, err)
+		}
+		if bestOutput == nil || output.Len() < bestOutput.Len() {
+			best = compressor
+			bestOutput = output
+			w.compression = compression
+		}
+	}
+	w.compressed.Reset()
+	w.compressed.Write(bestOutput.Bytes())
+	w.compressedWriter = newCountingCRCWriter(best, w.opts.IncludeCRC)
+	return nil
+}
+
+// ChosenCompression returns the compression format used for the file's
+// chunks. With CompressionAuto, it returns CompressionAuto until the first
+// chunk is written and the format selected thereafter.
+func (w *Writer) ChosenCompression() CompressionFormat {
+	return w.compression
+}
+
 func makePrefixedMap(m map[string]string) []byte {
+	return makeOrderedPrefixedMap(m, nil)
+}
+
+// makeOrderedPrefixedMap serializes m with its entries in the order given by
+// keys. If keys does not list exactly the keys of m, entries are written in
+// sorted key order.
+func makeOrderedPrefixedMap(m map[string]string, keys []string) []byte {
 	maplen := 0
 	mapkeys := make([]string, 0, len(m))
 	for k, v := range m {
 		maplen += 4 + len(k) + 4 + len(v)
 		mapkeys = append(mapkeys, k)
 	}
-	sort.Strings(mapkeys)
+	if isKeyOrdering(m, keys) {
+		mapkeys = keys
+	} else {
+		sort.Strings(mapkeys)
+	}
 	buf := make([]byte, maplen+4)
 	offset := putUint32(buf, uint32(maplen))
 	for _, k := range mapkeys {
@@ -521,6 +636,21 @@ func makePrefixedMap(m map[string]string) []byte {
 	return buf
 }
 
+// isKeyOrdering reports whether keys lists each key of m exactly once.
+func isKeyOrdering(m map[string]string, keys []string) bool {
+	if len(keys) != len(m) {
+		return false
+	}
+	seen := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if _, ok := m[k]; !ok || seen[k] {
+			return false
+		}
+		seen[k] = true
+	}
+	return true
+}
+
 // WriteChunkIndex writes a chunk index record to the output.
 func (w *Writer) WriteChunkIndex(idx *ChunkIndex) error {
 	messageIndexLength := len(idx.MessageIndexOffsets) * (2 + 8)
@@ -654,6 +784,11 @@ func (w *Writer) writeSummarySection() ([]*SummaryOffset, error) {
 }
 
 // Close the writer by closing the active chunk and writing the summary section.
+// The summary section holds, in order and each as one group, the schemas,
+// channels, statistics, chunk indexes, attachment indexes and metadata
+// indexes, except those disabled in WriterOptions. It is followed by a summary
+// offset record locating each group, and by the footer, which records the
+// start of both and the CRC of the summary section if IncludeCRC is set.
 func (w *Writer) Close() error {
 	if w.opts.Chunked {
 		err := w.flushActiveChunk()
@@ -662,8 +797,12 @@ func (w *Writer) Close() error {
 		}
 	}
 	w.closed = true
+	dataSectionCRC := w.w.Checksum()
+	if w.omitDataSectionCRC {
+		dataSectionCRC = 0
+	}
 	err := w.WriteDataEnd(&DataEnd{
-		DataSectionCRC: w.w.Checksum(),
+		DataSectionCRC: dataSectionCRC,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to write data end: %w", err)
@@ -772,15 +911,32 @@ func (c *customCompressor) Compression() CompressionFormat {
 type WriterOptions struct {
 	// IncludeCRC specifies whether to compute CRC checksums in the output.
 	IncludeCRC bool
-	// Chunked specifies whether the file should be chunk-compressed.
+	// Chunked specifies whether the file should be chunk-compressed. If false,
+	// records are written directly to the data section and the compression
+	// options are ignored.
 	Chunked bool
 	// ChunkSize specifies a target chunk size for compressed chunks. This size
 	// may be exceeded, for instance in the case of oversized messages.
 	ChunkSize int64
+	// ChunkMessageLimit, if positive, is the maximum number of messages in a
+	// chunk. A chunk is written once it holds this many messages.
+	ChunkMessageLimit int
+	// ChunkDurationLimit, if positive, is the maximum span of message log
+	// times in a chunk. A chunk is written before a message that would make it
+	// span more than this, and the message starts the next chunk.
+	//
+	// ChunkSize, ChunkMessageLimit and ChunkDurationLimit may be combined, in
+	// which case a chunk is written as soon as any one of them is reached.
+	ChunkDurationLimit time.Duration
 	// Compression indicates the compression format to use for chunk compression.
+	// CompressionAuto compresses the first chunk with both zstd and lz4 and
+	// uses whichever produces the smaller output for the whole file, at the
+	// cost of compressing that chunk twice. The format chosen is reported by
+	// Writer.ChosenCompression.
 	Compression CompressionFormat
 	// CompressionLevel controls the speed vs. compression ratio tradeoff. The
 	// exact interpretation of this value depends on the compression format.
+	// The zero value, CompressionLevelDefault, favors speed.
 	CompressionLevel CompressionLevel
 
 	// SkipMessageIndexing skips the message and chunk indexes for a chunked
@@ -817,6 +973,19 @@ type WriterOptions struct {
 	// the file. This may be useful for writing a partial section of records.
 	SkipMagic bool
 
+	// DedupSchemas causes a schema identical in name, encoding and data to one
+	// already written, but with a different ID, to be skipped. Channels
+	// written with the ID of the skipped schema refer to the one already
+	// written instead. The ID used is reported by Writer.CanonicalSchemaID.
+	DedupSchemas bool
+
+	// DedupChannels causes a channel identical in schema, topic, message
+	// encoding and metadata to one already written, but with a different ID,
+	// to be skipped. Messages written with the ID of the skipped channel are
+	// written on the one already written instead. The ID used is reported by
+	// Writer.CanonicalChannelID.
+	DedupChannels bool
+
 	// Compressor is a custom compressor. If supplied it will take precedence
 	// over the built-in ones.
 	Compressor CustomCompressor
@@ -854,6 +1023,31 @@ func encoderLevelFromZstd(level CompressionLevel) zstd.EncoderLevel {
 	}
 }
 
+// newChunkCompressor returns a compressor for one of the built-in chunk
+// compression formats, writing to buf.
+func newChunkCompressor(
+	compression CompressionFormat,
+	level CompressionLevel,
+	buf *bytes.Buffer,
+) (ResettableWriteCloser, error) {
+	switch compression {
+	case CompressionZSTD:
+		zw, err := zstd.NewWriter(buf, zstd.WithEncoderLevel(encoderLevelFromZstd(level)))
+		if err != nil {
+			return nil, err
+		}
+		return zw, nil
+	case CompressionLZ4:
+		lzw := lz4.NewWriter(buf)
+		_ = lzw.Apply(lz4.CompressionLevelOption(encoderLevelFromLZ4(level)))
+		return lzw, nil
+	case CompressionNone:
+		return bufCloser{buf}, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression")
+	}
+}
+
 // NewWriter returns a new MCAP writer.
 func NewWriter(w io.Writer, opts *WriterOptions) (*Writer, error) {
 	writer := newWriteSizer(w, opts.IncludeCRC)
@@ -875,27 +1069,22 @@ func NewWriter(w io.Writer, opts *WriterOptions) (*Writer, error) {
 			}
 			opts.Compressor.Compressor().Reset(&compressed)
 			compressedWriter = newCountingCRCWriter(opts.Compressor.Compressor(), opts.IncludeCRC)
-		case opts.Compression == CompressionZSTD:
-			level := encoderLevelFromZstd(opts.CompressionLevel)
-			zw, err := zstd.NewWriter(&compressed, zstd.WithEncoderLevel(level))
+		case opts.Compression == CompressionAuto:
+			// the first chunk is accumulated uncompressed, to be compressed
+			// once the format is chosen.
+			compressedWriter = newCountingCRCWriter(bufCloser{&compressed}, opts.IncludeCRC)
+		default:
+			compressor, err := newChunkCompressor(opts.Compression, opts.CompressionLevel, &compressed)
 			if err != nil {
 				return nil, err
 			}
-			compressedWriter = newCountingCRCWriter(zw, opts.IncludeCRC)
-		case opts.Compression == CompressionLZ4:
-			level := encoderLevelFromLZ4(opts.CompressionLevel)
-			lzw := lz4.NewWriter(&compressed)
-			_ = lzw.Apply(lz4.CompressionLevelOption(level))
-			compressedWriter = newCountingCRCWriter(lzw, opts.IncludeCRC)
-		case opts.Compression == CompressionNone:
-			compressedWriter = newCountingCRCWriter(bufCloser{&compressed}, opts.IncludeCRC)
-		default:
-			return nil, fmt.Errorf("unsupported compression")
+			compressedWriter = newCountingCRCWriter(compressor, opts.IncludeCRC)
 		}
 		if opts.ChunkSize == 0 {
 			opts.ChunkSize = 1024 * 1024
 		}
 	}
+	stats := NewStatisticsBuilder()
 	return &Writer{
 		w:                        writer,
 		buf:                      make([]byte, 32),
@@ -905,14 +1094,16 @@ func NewWriter(w io.Writer, opts *WriterOptions) (*Writer, error) {
 		uncompressed:             &bytes.Buffer{},
 		compressed:               &compressed,
 		compressedWriter:         compressedWriter,
+		compression:              opts.Compression,
 		currentChunkStartTime:    math.MaxUint64,
 		currentChunkEndTime:      0,
 		currentChunkMessageCount: 0,
-		Statistics: &Statistics{
-			ChannelMessageCounts: make(map[uint16]uint64),
-			MessageStartTime:     0,
-			MessageEndTime:       0,
-		},
-		opts: opts,
+		stats:                    stats,
+		Statistics:               stats.stats,
+		opts:                     opts,
+		schemasByContent:         make(map[schemaContent]uint16),
+		schemaAliases:            make(map[uint16]uint16),
+		channelsByContent:        make(map[channelContent]uint16),
+		channelAliases:           make(map[uint16]uint16),
 	}, nil
 }
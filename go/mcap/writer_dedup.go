@@ -0,0 +1,70 @@
+package mcap
+
+// CanonicalSchemaID returns the ID under which the schema written with the
+// given ID was recorded. This differs from id only if WriterOptions.DedupSchemas
+// is set and the schema was identical to one already written with another ID.
+// Channels written with the schema ID are remapped by the writer, so callers
+// need only use this to track the IDs in the output.
+func (w *Writer) CanonicalSchemaID(id uint16) uint16 {
+	if canonical, ok := w.schemaAliases[id]; ok {
+		return canonical
+	}
+	return id
+}
+
+// CanonicalChannelID returns the ID under which the channel written with the
+// given ID was recorded. This differs from id only if
+// WriterOptions.DedupChannels is set and the channel was identical to one
+// already written with another ID. Messages written with the channel ID are
+// remapped by the writer, so callers need only use this to track the IDs in
+// the output.
+func (w *Writer) CanonicalChannelID(id uint16) uint16 {
+	if canonical, ok := w.channelAliases[id]; ok {
+		return canonical
+	}
+	return id
+}
+
+// dedupSchema reports whether s is identical to a schema already written with
+// another ID, recording the other ID as its canonical ID if so. Otherwise the
+// content of s is recorded for later schemas to be compared against.
+func (w *Writer) dedupSchema(s *Schema) bool {
+	if !w.opts.DedupSchemas {
+		return false
+	}
+	key := schemaContent{s.Name, s.Encoding, string(s.Data)}
+	if id, ok := w.schemasByContent[key]; ok {
+		if id == s.ID {
+			return false
+		}
+		w.schemaAliases[s.ID] = id
+		return true
+	}
+	w.schemasByContent[key] = s.ID
+	return false
+}
+
+// dedupChannel reports whether c, with its schema ID already made canonical,
+// is identical to a channel already written with another ID, recording the
+// other ID as its canonical ID if so. Otherwise the content of c is recorded
+// for later channels to be compared against.
+func (w *Writer) dedupChannel(c *Channel, schemaID uint16, metadata []byte) bool {
+	if !w.opts.DedupChannels {
+		return false
+	}
+	key := channelContent{
+		schemaID:        schemaID,
+		topic:           c.Topic,
+		messageEncoding: c.MessageEncoding,
+		metadata:        string(metadata),
+	}
+	if id, ok := w.channelsByContent[key]; ok {
+		if id == c.ID {
+			return false
+		}
+		w.channelAliases[c.ID] = id
+		return true
+	}
+	w.channelsByContent[key] = c.ID
+	return false
+}
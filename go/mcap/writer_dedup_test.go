@@ -0,0 +1,101 @@
+package mcap
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriterDedup(t *testing.T) {
+	cases := []struct {
+		assertion        string
+		dedupSchemas     bool
+		dedupChannels    bool
+		schemaIDs        []uint16
+		channelIDs       []uint16
+		messageChannels  map[uint16]uint64
+		canonicalSchema  uint16
+		canonicalChannel uint16
+	}{
+		{
+			"no deduplication",
+			false,
+			false,
+			[]uint16{1, 2, 3},
+			[]uint16{1, 2, 3},
+			map[uint16]uint64{1: 1, 2: 1, 3: 1},
+			2,
+			2,
+		},
+		{
+			"schemas deduplicated",
+			true,
+			false,
+			[]uint16{1, 3},
+			[]uint16{1, 2, 3},
+			map[uint16]uint64{1: 1, 2: 1, 3: 1},
+			1,
+			2,
+		},
+		{
+			"schemas and channels deduplicated",
+			true,
+			true,
+			[]uint16{1, 3},
+			[]uint16{1, 3},
+			map[uint16]uint64{1: 2, 3: 1},
+			1,
+			1,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			writer, err := NewWriter(buf, &WriterOptions{
+				Chunked:       true,
+				DedupSchemas:  c.dedupSchemas,
+				DedupChannels: c.dedupChannels,
+			})
+			assert.Nil(t, err)
+			assert.Nil(t, writer.WriteHeader(&Header{}))
+			for _, schema := range []*Schema{
+				{ID: 1, Name: "foo", Encoding: "ros1msg", Data: []byte("string data")},
+				{ID: 2, Name: "foo", Encoding: "ros1msg", Data: []byte("string data")},
+				{ID: 3, Name: "foo", Encoding: "ros1msg", Data: []byte("int32 data")},
+			} {
+				assert.Nil(t, writer.WriteSchema(schema))
+			}
+			for _, channel := range []*Channel{
+				{ID: 1, SchemaID: 1, Topic: "/foo", MessageEncoding: "ros1", Metadata: map[string]string{"a": "b"}},
+				{ID: 2, SchemaID: 2, Topic: "/foo", MessageEncoding: "ros1", Metadata: map[string]string{"a": "b"}},
+				{ID: 3, SchemaID: 3, Topic: "/foo", MessageEncoding: "ros1", Metadata: map[string]string{"a": "b"}},
+			} {
+				assert.Nil(t, writer.WriteChannel(channel))
+			}
+			for _, channelID := range []uint16{1, 2, 3} {
+				assert.Nil(t, writer.WriteMessage(&Message{ChannelID: channelID}))
+			}
+			assert.Equal(t, c.canonicalSchema, writer.CanonicalSchemaID(2))
+			assert.Equal(t, c.canonicalChannel, writer.CanonicalChannelID(2))
+			assert.Nil(t, writer.Close())
+
+			reader, err := NewReader(bytes.NewReader(buf.Bytes()))
+			assert.Nil(t, err)
+			info, err := reader.Info()
+			assert.Nil(t, err)
+			schemaIDs := []uint16{}
+			for id := range info.Schemas {
+				schemaIDs = append(schemaIDs, id)
+			}
+			assert.ElementsMatch(t, c.schemaIDs, schemaIDs)
+			channelIDs := []uint16{}
+			for id, channel := range info.Channels {
+				channelIDs = append(channelIDs, id)
+				assert.Contains(t, info.Schemas, channel.SchemaID)
+			}
+			assert.ElementsMatch(t, c.channelIDs, channelIDs)
+			assert.Equal(t, c.messageChannels, info.Statistics.ChannelMessageCounts)
+		})
+	}
+}
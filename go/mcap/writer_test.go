@@ -3,7 +3,9 @@ package mcap
 import (
 	"bytes"
 	"crypto/md5"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"testing"
 	"time"
@@ -251,6 +253,140 @@ func TestChunkedReadWrite(t *testing.T) {
 	}
 }
 
+func TestCompressionLevelRoundTrip(t *testing.T) {
+	payload := bytes.Repeat([]byte("compressible "), 100)
+	for _, compression := range []CompressionFormat{
+		CompressionZSTD,
+		CompressionLZ4,
+		CompressionNone,
+	} {
+		for _, level := range []CompressionLevel{
+			CompressionLevelDefault,
+			CompressionLevelFastest,
+			CompressionLevelBetter,
+			CompressionLevelBest,
+		} {
+			t.Run(fmt.Sprintf("%s level %d", compression, level), func(t *testing.T) {
+				buf := &bytes.Buffer{}
+				w, err := NewWriter(buf, &WriterOptions{
+					Chunked:          true,
+					ChunkSize:        1024,
+					Compression:      compression,
+					CompressionLevel: level,
+					IncludeCRC:       true,
+				})
+				assert.Nil(t, err)
+				assert.Nil(t, w.WriteHeader(&Header{}))
+				assert.Nil(t, w.WriteSchema(&Schema{ID: 1}))
+				assert.Nil(t, w.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/test"}))
+				for i := 0; i < 10; i++ {
+					assert.Nil(t, w.WriteMessage(&Message{ChannelID: 1, LogTime: uint64(i), Data: payload}))
+				}
+				assert.Nil(t, w.Close())
+
+				lexer, err := NewLexer(bytes.NewReader(buf.Bytes()), &LexerOptions{
+					ValidateChunkCRCs: true,
+				})
+				assert.Nil(t, err)
+				messageCount := 0
+				for {
+					tokenType, record, err := lexer.Next(nil)
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					assert.Nil(t, err)
+					if tokenType == TokenMessage {
+						msg, err := ParseMessage(record)
+						assert.Nil(t, err)
+						assert.Equal(t, uint64(messageCount), msg.LogTime)
+						assert.Equal(t, payload, msg.Data)
+						messageCount++
+					}
+				}
+				assert.Equal(t, 10, messageCount)
+			})
+		}
+	}
+}
+
+func TestCompressionAuto(t *testing.T) {
+	payload := bytes.Repeat([]byte("compressible "), 100)
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(buf, &WriterOptions{
+		Chunked:     true,
+		ChunkSize:   1024,
+		Compression: CompressionAuto,
+		IncludeCRC:  true,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, CompressionAuto, w.ChosenCompression())
+	assert.Nil(t, w.WriteHeader(&Header{}))
+	assert.Nil(t, w.WriteSchema(&Schema{ID: 1}))
+	assert.Nil(t, w.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/test"}))
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, w.WriteMessage(&Message{ChannelID: 1, LogTime: uint64(i), Data: payload}))
+	}
+	assert.Nil(t, w.Close())
+
+	chosen := w.ChosenCompression()
+	assert.Contains(t, []CompressionFormat{CompressionZSTD, CompressionLZ4}, chosen)
+	assert.Greater(t, len(w.ChunkIndexes), 1)
+	for _, idx := range w.ChunkIndexes {
+		assert.Equal(t, chosen, idx.Compression)
+		assert.Less(t, idx.CompressedSize, idx.UncompressedSize)
+	}
+
+	lexer, err := NewLexer(bytes.NewReader(buf.Bytes()), &LexerOptions{ValidateChunkCRCs: true})
+	assert.Nil(t, err)
+	messageCount := 0
+	for {
+		tokenType, record, err := lexer.Next(nil)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.Nil(t, err)
+		if tokenType == TokenMessage {
+			msg, err := ParseMessage(record)
+			assert.Nil(t, err)
+			assert.Equal(t, uint64(messageCount), msg.LogTime)
+			assert.Equal(t, payload, msg.Data)
+			messageCount++
+		}
+	}
+	assert.Equal(t, 10, messageCount)
+}
+
+func TestUnchunkedOutputHasNoChunks(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(buf, &WriterOptions{
+		Chunked:     false,
+		Compression: CompressionZSTD,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, w.WriteHeader(&Header{}))
+	assert.Nil(t, w.WriteSchema(&Schema{ID: 1}))
+	assert.Nil(t, w.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/test"}))
+	assert.Nil(t, w.WriteMessage(&Message{ChannelID: 1, Data: []byte("hello")}))
+	assert.Nil(t, w.Close())
+
+	lexer, err := NewLexer(bytes.NewReader(buf.Bytes()), &LexerOptions{EmitChunks: true})
+	assert.Nil(t, err)
+	messageCount := 0
+	for {
+		tokenType, _, err := lexer.Next(nil)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.Nil(t, err)
+		assert.NotEqual(t, TokenChunk, tokenType)
+		assert.NotEqual(t, TokenChunkIndex, tokenType)
+		if tokenType == TokenMessage {
+			messageCount++
+		}
+	}
+	assert.Equal(t, 1, messageCount)
+}
+
 func TestChunkBoundaryIndexing(t *testing.T) {
 	buf := &bytes.Buffer{}
 	// Set a small chunk size so that every message will land in its own chunk.
@@ -681,6 +817,63 @@ func TestWriteAttachment(t *testing.T) {
 	}
 }
 
+func TestAttachmentsAndMetadataRoundTrip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{Chunked: true, ChunkSize: 1024})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	assert.Nil(t, writer.WriteSchema(&Schema{ID: 1}))
+	assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1, Topic: "/foo"}))
+	assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, LogTime: 1}))
+	// written while a chunk is open, the attachment must still land outside it
+	assert.Nil(t, writer.WriteAttachment(&Attachment{
+		LogTime:   2,
+		Name:      "map.png",
+		MediaType: "image/png",
+		DataSize:  4,
+		Data:      bytes.NewReader([]byte{1, 2, 3, 4}),
+	}))
+	assert.Nil(t, writer.WriteMetadata(&Metadata{Name: "run", Metadata: map[string]string{"a": "b"}}))
+	assert.Nil(t, writer.WriteMessage(&Message{ChannelID: 1, LogTime: 3}))
+	assert.Nil(t, writer.Close())
+	data := buf.Bytes()
+
+	reader, err := NewReader(bytes.NewReader(data))
+	assert.Nil(t, err)
+	attachments, err := reader.Attachments()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(attachments))
+	idx := attachments[0]
+	assert.Equal(t, "map.png", idx.Name)
+	assert.Equal(t, "image/png", idx.MediaType)
+	assert.Equal(t, uint64(4), idx.DataSize)
+	record := data[idx.Offset : idx.Offset+idx.Length]
+	assert.Equal(t, OpAttachment, OpCode(record[0]))
+	attachment, err := ParseAttachment(record[9:])
+	assert.Nil(t, err)
+	content, err := io.ReadAll(attachment.Data)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1, 2, 3, 4}, content)
+	assert.Equal(t, crc32.ChecksumIEEE(record[9:len(record)-4]), attachment.CRC)
+
+	metadata, err := reader.MetadataEntries()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(metadata))
+	record = data[metadata[0].Offset : metadata[0].Offset+metadata[0].Length]
+	assert.Equal(t, OpMetadata, OpCode(record[0]))
+	parsed, err := ParseMetadata(record[9:])
+	assert.Nil(t, err)
+	assert.Equal(t, "run", parsed.Name)
+	assert.Equal(t, map[string]string{"a": "b"}, parsed.Metadata)
+
+	info, err := reader.Info()
+	assert.Nil(t, err)
+	for _, chunkIndex := range info.ChunkIndexes {
+		chunkEnd := chunkIndex.ChunkStartOffset + chunkIndex.ChunkLength
+		assert.False(t, idx.Offset >= chunkIndex.ChunkStartOffset && idx.Offset < chunkEnd)
+	}
+}
+
 func assertReadable(t *testing.T, rs io.ReadSeeker) {
 	reader, err := NewReader(rs)
 	assert.Nil(t, err)
@@ -740,3 +933,206 @@ func TestBYOCompressor(t *testing.T) {
 	assertReadable(t, bytes.NewReader(buf.Bytes()))
 	assert.Positive(t, blockCount)
 }
+
+func TestWriteMetadataPreservesKeyOrder(t *testing.T) {
+	cases := []struct {
+		assertion string
+		keys      []string
+		expected  []string
+	}{
+		{
+			"no keys supplied",
+			nil,
+			[]string{"a", "b", "c"},
+		},
+		{
+			"ordered keys",
+			[]string{"c", "a", "b"},
+			[]string{"c", "a", "b"},
+		},
+		{
+			"incomplete keys",
+			[]string{"c", "a"},
+			[]string{"a", "b", "c"},
+		},
+		{
+			"duplicate keys",
+			[]string{"c", "c", "a"},
+			[]string{"a", "b", "c"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			writer, err := NewWriter(buf, &WriterOptions{})
+			assert.Nil(t, err)
+			assert.Nil(t, writer.WriteHeader(&Header{}))
+			assert.Nil(t, writer.WriteMetadata(&Metadata{
+				Name:     "metadata",
+				Metadata: map[string]string{"a": "1", "b": "2", "c": "3"},
+				Keys:     c.keys,
+			}))
+			assert.Nil(t, writer.Close())
+			lexer, err := NewLexer(buf)
+			assert.Nil(t, err)
+			for {
+				tokenType, record, err := lexer.Next(nil)
+				assert.Nil(t, err)
+				if tokenType == TokenMetadata {
+					metadata, err := ParseMetadata(record)
+					assert.Nil(t, err)
+					assert.Equal(t, c.expected, metadata.Keys)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestWriteRawRoundTrip(t *testing.T) {
+	unknown := func(opcode OpCode, body string) []byte {
+		return flatten([]byte{byte(opcode)}, encodedUint64(uint64(len(body))), []byte(body))
+	}
+	input := file(
+		header(),
+		channelRecord(1, 0),
+		messageRecord(1, 1),
+		unknown(0x80, "future record"),
+		messageRecord(1, 2),
+		unknown(0xff, ""),
+		record(OpDataEnd),
+		footer(),
+	)
+
+	type token struct {
+		opcode OpCode
+		record string
+	}
+	lex := func(t *testing.T, data []byte) []token {
+		lexer, err := NewLexer(bytes.NewReader(data), &LexerOptions{EmitUnknownRecords: true})
+		assert.Nil(t, err)
+		defer lexer.Close()
+		tokens := []token{}
+		for {
+			tokenType, record, err := lexer.Next(nil)
+			if errors.Is(err, io.EOF) {
+				return tokens
+			}
+			assert.Nil(t, err)
+			switch tokenType {
+			case TokenMessage, TokenUnknown:
+				tokens = append(tokens, token{lexer.LastOpcode(), string(record)})
+			}
+		}
+	}
+	original := lex(t, input)
+	assert.Equal(t, 4, len(original))
+	assert.Equal(t, token{0x80, "future record"}, original[1])
+	assert.Equal(t, token{0xff, ""}, original[3])
+
+	// copy the file through a writer, passing unknown records through.
+	lexer, err := NewLexer(bytes.NewReader(input), &LexerOptions{EmitUnknownRecords: true})
+	assert.Nil(t, err)
+	defer lexer.Close()
+	buf := &bytes.Buffer{}
+	writer, err := NewWriter(buf, &WriterOptions{})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.WriteHeader(&Header{}))
+	for {
+		tokenType, record, err := lexer.Next(nil)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.Nil(t, err)
+		switch tokenType {
+		case TokenChannel:
+			channel, err := ParseChannel(record)
+			assert.Nil(t, err)
+			assert.Nil(t, writer.WriteChannel(channel))
+		case TokenMessage:
+			message, err := ParseMessage(record)
+			assert.Nil(t, err)
+			assert.Nil(t, writer.WriteMessage(message))
+		case TokenUnknown:
+			assert.Nil(t, writer.WriteRaw(lexer.LastOpcode(), record))
+		}
+	}
+	assert.Nil(t, writer.Close())
+	assert.Equal(t, original, lex(t, buf.Bytes()))
+
+	t.Run("skipped by default", func(t *testing.T) {
+		lexer, err := NewLexer(bytes.NewReader(buf.Bytes()))
+		assert.Nil(t, err)
+		defer lexer.Close()
+		for {
+			tokenType, _, err := lexer.Next(nil)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			assert.Nil(t, err)
+			assert.NotEqual(t, TokenUnknown, tokenType)
+		}
+	})
+	t.Run("reserved opcode", func(t *testing.T) {
+		assert.ErrorIs(t, writer.WriteRaw(OpReserved, nil), ErrInvalidZeroOpcode)
+	})
+}
+
+func TestChunkLimits(t *testing.T) {
+	type chunkBounds struct {
+		start, end uint64
+	}
+	cases := []struct {
+		assertion string
+		opts      WriterOptions
+		expected  []chunkBounds
+	}{
+		{
+			"message limit",
+			WriterOptions{ChunkMessageLimit: 4},
+			[]chunkBounds{{0, 30}, {40, 70}, {80, 90}},
+		},
+		{
+			"duration limit",
+			WriterOptions{ChunkDurationLimit: 25},
+			[]chunkBounds{{0, 20}, {30, 50}, {60, 80}, {90, 90}},
+		},
+		{
+			"first limit reached wins",
+			WriterOptions{ChunkMessageLimit: 2, ChunkDurationLimit: 25},
+			[]chunkBounds{{0, 10}, {20, 30}, {40, 50}, {60, 70}, {80, 90}},
+		},
+		{
+			"size limit with message limit",
+			WriterOptions{ChunkSize: 1, ChunkMessageLimit: 4},
+			[]chunkBounds{
+				{0, 0}, {10, 10}, {20, 20}, {30, 30}, {40, 40},
+				{50, 50}, {60, 60}, {70, 70}, {80, 80}, {90, 90},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.assertion, func(t *testing.T) {
+			opts := c.opts
+			opts.Chunked = true
+			opts.Compression = CompressionNone
+			writer, err := NewWriter(&bytes.Buffer{}, &opts)
+			assert.Nil(t, err)
+			assert.Nil(t, writer.WriteHeader(&Header{}))
+			assert.Nil(t, writer.WriteSchema(&Schema{ID: 1}))
+			assert.Nil(t, writer.WriteChannel(&Channel{ID: 1, SchemaID: 1}))
+			for i := 0; i < 10; i++ {
+				assert.Nil(t, writer.WriteMessage(&Message{
+					ChannelID: 1,
+					LogTime:   uint64(i * 10),
+				}))
+			}
+			assert.Nil(t, writer.Close())
+			bounds := []chunkBounds{}
+			for _, idx := range writer.ChunkIndexes {
+				bounds = append(bounds, chunkBounds{idx.MessageStartTime, idx.MessageEndTime})
+			}
+			assert.Equal(t, c.expected, bounds)
+		})
+	}
+}